@@ -22,7 +22,9 @@ func New(cfg model.ClientConfiguration) *Client {
 	}
 }
 
-// BuildTrustChain takes in a given leaf and trust anchor Entity Identifier pair and then attempts to construct a Trust Chain for the given values
+// BuildTrustChain takes in a given leaf and trust anchor Entity Identifier pair and then attempts to construct a Trust Chain for the given values.
+// When no Trust Chain can be built the error is a *model.TrustChainError describing every path attempted and the step
+// at which each failed
 func (c *Client) BuildTrustChain(ctx context.Context, targetLeafEntityIdentifier, targetTrustAnchorEntityIdentifier string) (parsedSignedTrustChain []string, parsedTrustChain []model.EntityStatement, expiry *int64, err error) {
 	parsedLeafEntityIdentifier, err := model.ValidateEntityIdentifier(targetLeafEntityIdentifier)
 	if err != nil {
@@ -48,3 +50,20 @@ func (c *Client) ResolveMetadata(ctx context.Context, subject string, trustChain
 	}
 	return resolved.Metadata, nil
 }
+
+// ResolveMetadataWithTrace behaves as ResolveMetadata, additionally returning a trace of how the metadata policies
+// within the trust chain were combined and applied to the subject's metadata. The trace is returned alongside any
+// error encountered while resolving so that policy failures can be diagnosed
+func (c *Client) ResolveMetadataWithTrace(ctx context.Context, subject string, trustChain []string) (*model.Metadata, *model.PolicyTrace, error) {
+	parsedSubject, err := model.ValidateEntityIdentifier(subject)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid subject entity identifier: %s", err.Error())
+	}
+
+	trace := &model.PolicyTrace{}
+	resolved, err := trust_chain.ResolveMetadataWithTrace(ctx, c.cfg.Configuration, *parsedSubject, trustChain, trace)
+	if err != nil {
+		return nil, trace, err
+	}
+	return resolved.Metadata, trace, nil
+}
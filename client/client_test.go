@@ -2,6 +2,7 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -78,6 +79,29 @@ func TestClient_BuildTrustChain(t *testing.T) {
 				//todo: validate returned signed response - the client needs basic methods to retrieve an entity configuration / subordinate statement anyway
 			},
 		},
+		"every path attempted is reported when no trust chain can be built": {
+			subject:     fmt.Sprintf("%s/leaf", testServerURL),
+			trustAnchor: fmt.Sprintf("%s/other", testServerURL),
+			validate: func(t *testing.T, result []model.EntityStatement, signedResult []string, expiry *int64, err error) {
+				var trustChainErr *model.TrustChainError
+				if !errors.As(err, &trustChainErr) {
+					t.Fatalf("expected a *model.TrustChainError, got %v", err)
+				}
+				if !errors.Is(err, model.ErrInvalidTrustAnchor) {
+					t.Errorf("expected the error to remain an invalid trust anchor error, got %q", err.Error())
+				}
+				if len(trustChainErr.Paths) != 1 {
+					t.Fatalf("expected 1 path to be attempted, got %d", len(trustChainErr.Paths))
+				}
+				failure := trustChainErr.Paths[0].Failure()
+				if failure == nil || failure.Entity != model.EntityIdentifier(testServerURL+"/ta") || failure.Cause != model.ChainFailureDeadEnd {
+					t.Errorf("expected the path to end at the trust anchor, got %+v", failure)
+				}
+				if len(trustChainErr.Paths[0].Hops) != 5 {
+					t.Errorf("expected the leaf, both intermediates and the trust anchor to be walked through, got %+v", trustChainErr.Paths[0].Hops)
+				}
+			},
+		},
 	}
 
 	for name, tt := range tests {
@@ -199,3 +223,44 @@ func TestClient_ResolveMetadata(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_ResolveMetadataWithTrace(t *testing.T) {
+	testServer := server_test.TestServer(t)
+	testServerURL := testServer.URL
+
+	client := New(model.ClientConfiguration{Configuration: model.Configuration{HttpClient: testServer.Client()}})
+	chain, _, _, err := client.BuildTrustChain(t.Context(), testServerURL+"/leaf", testServerURL+"/ta")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+
+	result, trace, err := client.ResolveMetadataWithTrace(t.Context(), testServerURL+"/leaf", chain)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if result == nil || trace == nil {
+		t.Fatal("expected result and trace to be non-nil")
+	}
+
+	expected := &model.ParameterTrace{
+		EntityType: "openid_provider",
+		Parameter:  "contacts",
+		Contributions: []model.PolicyContribution{
+			{
+				Issuer:         model.EntityIdentifier(testServerURL + "/int1"),
+				Subject:        model.EntityIdentifier(testServerURL + "/leaf"),
+				TracedOperator: model.TracedOperator{Operator: "add", Value: []any{"ops@swamid.se"}},
+			},
+			{
+				Issuer:         model.EntityIdentifier(testServerURL + "/ta"),
+				Subject:        model.EntityIdentifier(testServerURL + "/int2"),
+				TracedOperator: model.TracedOperator{Operator: "add", Value: []any{"ops@edugain.geant.org"}},
+			},
+		},
+		Merged: []model.TracedOperator{{Operator: "add", Value: []any{"ops@swamid.se", "ops@edugain.geant.org"}}},
+		Steps:  []model.PolicyStep{{Operator: "add", After: []any{"ops@swamid.se", "ops@edugain.geant.org"}}},
+	}
+	if diff := cmp.Diff(expected, trace.Parameter("openid_provider", "contacts")); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+}
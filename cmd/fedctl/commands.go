@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/MichaelFraser99/go-openid-federation/client"
+	"github.com/MichaelFraser99/go-openid-federation/config"
+	"github.com/MichaelFraser99/go-openid-federation/crawler"
+	"github.com/MichaelFraser99/go-openid-federation/internal/entity_configuration"
+	"github.com/MichaelFraser99/go-openid-federation/internal/subordinate_statement"
+	"github.com/MichaelFraser99/go-openid-federation/internal/trust_marks"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+func runEntity(ctx context.Context, cfg model.Configuration, env environment, args []string) error {
+	flags := flag.NewFlagSet("entity", flag.ContinueOnError)
+	raw := flags.Bool("raw", false, "print the compact JWT")
+	identifiers, err := parseCommandFlags(flags, args, "entity-id")
+	if err != nil {
+		return err
+	}
+
+	token, _, err := entity_configuration.Retrieve(ctx, cfg, identifiers[0])
+	if err != nil {
+		return fmt.Errorf("invalid entity configuration for %s: %w", identifiers[0], err)
+	}
+	return printStatements(env.stdout, *raw, *token)
+}
+
+func runSubordinate(ctx context.Context, cfg model.Configuration, env environment, args []string) error {
+	flags := flag.NewFlagSet("subordinate", flag.ContinueOnError)
+	raw := flags.Bool("raw", false, "print the compact JWT")
+	identifiers, err := parseCommandFlags(flags, args, "issuer", "subject")
+	if err != nil {
+		return err
+	}
+
+	_, issuer, err := entity_configuration.Retrieve(ctx, cfg, identifiers[0])
+	if err != nil {
+		return fmt.Errorf("invalid entity configuration for issuer %s: %w", identifiers[0], err)
+	}
+	token, _, err := subordinate_statement.Retrieve(ctx, cfg, *issuer, identifiers[1])
+	if err != nil {
+		return fmt.Errorf("invalid subordinate statement for %s: %w", identifiers[1], err)
+	}
+	return printStatements(env.stdout, *raw, *token)
+}
+
+func runChain(ctx context.Context, cfg model.Configuration, env environment, args []string) error {
+	flags := flag.NewFlagSet("chain", flag.ContinueOnError)
+	raw := flags.Bool("raw", false, "print the compact JWTs, one per line")
+	identifiers, err := parseCommandFlags(flags, args, "leaf", "trust-anchor")
+	if err != nil {
+		return err
+	}
+
+	trustChain, _, expiry, err := client.New(model.ClientConfiguration{Configuration: cfg}).BuildTrustChain(ctx, string(identifiers[0]), string(identifiers[1]))
+	if err != nil {
+		return fmt.Errorf("unable to build a trust chain from %s to %s: %w", identifiers[0], identifiers[1], err)
+	}
+	if *raw {
+		return printStatements(env.stdout, true, trustChain...)
+	}
+
+	statements := make([]decodedStatement, len(trustChain))
+	for i, token := range trustChain {
+		if statements[i], err = decode(token); err != nil {
+			return err
+		}
+	}
+	return printJSON(env.stdout, map[string]any{
+		"expires_at":  time.Unix(*expiry, 0).UTC().Format(time.RFC3339),
+		"trust_chain": statements,
+	})
+}
+
+func runResolve(ctx context.Context, cfg model.Configuration, env environment, args []string) error {
+	flags := flag.NewFlagSet("resolve", flag.ContinueOnError)
+	identifiers, err := parseCommandFlags(flags, args, "leaf", "trust-anchor")
+	if err != nil {
+		return err
+	}
+
+	c := client.New(model.ClientConfiguration{Configuration: cfg})
+	trustChain, _, _, err := c.BuildTrustChain(ctx, string(identifiers[0]), string(identifiers[1]))
+	if err != nil {
+		return fmt.Errorf("unable to build a trust chain from %s to %s: %w", identifiers[0], identifiers[1], err)
+	}
+	metadata, err := c.ResolveMetadata(ctx, string(identifiers[0]), trustChain)
+	if err != nil {
+		return fmt.Errorf("unable to resolve metadata for %s: %w", identifiers[0], err)
+	}
+	return printJSON(env.stdout, metadata)
+}
+
+func runTrustMark(ctx context.Context, cfg model.Configuration, env environment, args []string) error {
+	flags := flag.NewFlagSet("trust-mark", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	trustAnchor := flags.String("trust-anchor", "", "trust anchor whose trusted trust mark issuers are used")
+	subject := flags.String("subject", "", "entity the trust mark must have been issued to")
+	if err := flags.Parse(args); err != nil {
+		return usageError{message: err.Error()}
+	}
+	if flags.NArg() != 1 {
+		return usageError{message: fmt.Sprintf("expected 1 argument (trust-mark), got %d", flags.NArg())}
+	}
+	if *trustAnchor == "" {
+		return usageError{message: "-trust-anchor is required"}
+	}
+	parsedTrustAnchor, err := model.ValidateEntityIdentifier(*trustAnchor)
+	if err != nil {
+		return usageError{message: fmt.Sprintf("invalid trust-anchor: %s", err.Error())}
+	}
+	token := strings.TrimSpace(flags.Arg(0))
+
+	unverified, err := decode(token)
+	if err != nil {
+		return err
+	}
+	var claims model.TrustMark
+	if err = json.Unmarshal(unverified.Payload, &claims); err != nil {
+		return fmt.Errorf("malformed trust mark: %w", err)
+	}
+
+	_, trustAnchorConfiguration, err := entity_configuration.Retrieve(ctx, cfg, *parsedTrustAnchor)
+	if err != nil {
+		return fmt.Errorf("invalid entity configuration for trust anchor %s: %w", *parsedTrustAnchor, err)
+	}
+	issuers, ok := trustAnchorConfiguration.TrustMarkIssuers[claims.Type]
+	if !ok {
+		return fmt.Errorf("trust mark type %q is not recognised by trust anchor %s", claims.Type, *parsedTrustAnchor)
+	}
+	trustMark, err := trust_marks.Validate(ctx, cfg, token, issuers)
+	if err != nil {
+		return err
+	}
+	if *subject != "" && trustMark.Sub != *subject {
+		return fmt.Errorf("trust mark was issued to %s, not %s", trustMark.Sub, *subject)
+	}
+	return printStatements(env.stdout, false, token)
+}
+
+func runCrawl(ctx context.Context, cfg model.Configuration, env environment, args []string) error {
+	flags := flag.NewFlagSet("crawl", flag.ContinueOnError)
+	format := flags.String("format", "json", "output format - json or dot")
+	concurrency := flags.Int("concurrency", 8, "maximum number of requests made at once")
+	maxDepth := flags.Int("max-depth", 16, "maximum depth below the trust anchor to crawl")
+	identifiers, err := parseCommandFlags(flags, args, "trust-anchor")
+	if err != nil {
+		return err
+	}
+	if *format != "json" && *format != "dot" {
+		return usageError{message: fmt.Sprintf("unsupported format %q", *format)}
+	}
+
+	graph, err := crawler.New(crawler.Configuration{
+		Configuration: cfg,
+		Concurrency:   *concurrency,
+		MaxDepth:      *maxDepth,
+	}).Crawl(ctx, string(identifiers[0]))
+	if err != nil {
+		return err
+	}
+	if *format == "dot" {
+		return graph.WriteDOT(env.stdout)
+	}
+	return graph.WriteJSON(env.stdout)
+}
+
+func runCheck(ctx context.Context, cfg model.Configuration, env environment, args []string) error {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	concurrency := flags.Int("concurrency", 8, "maximum number of requests made at once")
+	maxDepth := flags.Int("max-depth", 16, "maximum depth below the trust anchor to crawl")
+	identifiers, err := parseCommandFlags(flags, args, "trust-anchor")
+	if err != nil {
+		return err
+	}
+
+	graph, err := crawler.New(crawler.Configuration{
+		Configuration: cfg,
+		Concurrency:   *concurrency,
+		MaxDepth:      *maxDepth,
+	}).Crawl(ctx, string(identifiers[0]))
+	if err != nil {
+		return err
+	}
+	findings := graph.Check(time.Now())
+	if err = printJSON(env.stdout, map[string]any{"findings": findings}); err != nil {
+		return err
+	}
+	errorCount := 0
+	for _, finding := range findings {
+		if finding.Severity == crawler.SeverityError {
+			errorCount++
+		}
+	}
+	if errorCount > 0 {
+		return fmt.Errorf("found %d error findings", errorCount)
+	}
+	return nil
+}
+
+// decodedStatement is the printed form of a signed statement
+type decodedStatement struct {
+	Header  json.RawMessage `json:"header"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// decode splits a compact JWT into its header and payload without verifying it
+func decode(token string) (decodedStatement, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return decodedStatement{}, fmt.Errorf("malformed JWT: expected 3 parts, got %d", len(parts))
+	}
+	var statement decodedStatement
+	for i, target := range []*json.RawMessage{&statement.Header, &statement.Payload} {
+		decoded, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return decodedStatement{}, fmt.Errorf("malformed JWT: %w", err)
+		}
+		if !json.Valid(decoded) {
+			return decodedStatement{}, fmt.Errorf("malformed JWT: part %d is not JSON", i+1)
+		}
+		*target = decoded
+	}
+	return statement, nil
+}
+
+func printStatements(w io.Writer, raw bool, tokens ...string) error {
+	if raw {
+		for _, token := range tokens {
+			if _, err := fmt.Fprintln(w, token); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, token := range tokens {
+		statement, err := decode(token)
+		if err != nil {
+			return err
+		}
+		if err = printJSON(w, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func runInit(_ context.Context, _ model.Configuration, env environment, args []string) error {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	var opts config.BootstrapOptions
+	var authorityHints entityIdentifiers
+	role := flags.String("role", "", "role of the new entity")
+	entityID := flags.String("entity-id", "", "entity identifier of the new entity")
+	flags.Var(&authorityHints, "authority-hint", "superior of the new entity - may be repeated")
+	flags.StringVar(&opts.Algorithm, "algorithm", "ES256", "signing algorithm of the generated key")
+	flags.StringVar(&opts.Directory, "dir", ".", "directory the key and configuration document are written to")
+	if err := flags.Parse(args); err != nil {
+		return usageError{message: err.Error()}
+	}
+	if flags.NArg() != 0 {
+		return usageError{message: fmt.Sprintf("unexpected arguments %s", strings.Join(flags.Args(), " "))}
+	}
+	if *role == "" || *entityID == "" {
+		return usageError{message: "-role and -entity-id are required"}
+	}
+	opts.Role = config.Role(*role)
+	opts.EntityIdentifier = model.EntityIdentifier(*entityID)
+	opts.AuthorityHints = authorityHints
+
+	result, err := config.Bootstrap(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.stderr, "wrote private key %s with key ID %s\n", result.KeyPath, result.KeyID)
+	fmt.Fprintf(env.stderr, "wrote configuration %s\n", result.ConfigurationPath)
+	fmt.Fprintln(env.stderr, "register the following public JWKS with each superior:")
+	return printJSON(env.stdout, result.PublicJWKS)
+}
+
+// entityIdentifiers is a repeatable flag of entity identifiers
+type entityIdentifiers []model.EntityIdentifier
+
+func (e *entityIdentifiers) String() string {
+	return fmt.Sprint([]model.EntityIdentifier(*e))
+}
+
+func (e *entityIdentifiers) Set(value string) error {
+	*e = append(*e, model.EntityIdentifier(value))
+	return nil
+}
@@ -0,0 +1,190 @@
+// Command fedctl inspects OpenID Federations from the command line.
+//
+// Usage:
+//
+//	fedctl [-timeout 30s] [-insecure] <command> [flags] [arguments]
+//
+// Commands:
+//
+//	entity <entity-id>                     fetch, validate and print an entity configuration
+//	subordinate <issuer> <subject>         fetch, validate and print a subordinate statement
+//	chain <leaf> <trust-anchor>            build, validate and print a trust chain
+//	resolve <leaf> <trust-anchor>          resolve a leaf's metadata with the chain's metadata policies applied
+//	trust-mark -trust-anchor <ta> <jwt>    validate a trust mark against the issuers trusted by a trust anchor
+//	crawl <trust-anchor>                   crawl a federation and print its topology as JSON or a DOT graph
+//	check <trust-anchor>                   crawl a federation and report inconsistencies between entities
+//	init -role <role> -entity-id <id>      generate a signing key and starter configuration for a new entity
+//
+// Statements are printed as pretty JSON by default, or as compact JWTs with -raw. fedctl exits with status 1 and the
+// reason on standard error when validation fails, and with status 2 when invoked incorrectly
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+const (
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], environment{stdout: os.Stdout, stderr: os.Stderr}))
+}
+
+// environment holds the streams and HTTP client used by commands, allowing them to be replaced in tests
+type environment struct {
+	stdout     io.Writer
+	stderr     io.Writer
+	httpClient *http.Client
+}
+
+// command is a fedctl subcommand, which parses its own flags from args
+type command struct {
+	usage       string
+	description string
+	run         func(ctx context.Context, cfg model.Configuration, env environment, args []string) error
+}
+
+var commands = map[string]command{
+	"entity": {
+		usage:       "entity [-raw] <entity-id>",
+		description: "fetch, validate and print an entity configuration",
+		run:         runEntity,
+	},
+	"subordinate": {
+		usage:       "subordinate [-raw] <issuer> <subject>",
+		description: "fetch, validate and print a subordinate statement",
+		run:         runSubordinate,
+	},
+	"chain": {
+		usage:       "chain [-raw] <leaf> <trust-anchor>",
+		description: "build, validate and print a trust chain",
+		run:         runChain,
+	},
+	"resolve": {
+		usage:       "resolve <leaf> <trust-anchor>",
+		description: "resolve a leaf's metadata with the chain's metadata policies applied",
+		run:         runResolve,
+	},
+	"trust-mark": {
+		usage:       "trust-mark -trust-anchor <trust-anchor> [-subject <entity-id>] <trust-mark>",
+		description: "validate a trust mark against the issuers trusted by a trust anchor",
+		run:         runTrustMark,
+	},
+	"crawl": {
+		usage:       "crawl [-format json|dot] [-concurrency 8] [-max-depth 16] <trust-anchor>",
+		description: "crawl a federation and print its topology as JSON or a DOT graph",
+		run:         runCrawl,
+	},
+	"check": {
+		usage:       "check [-concurrency 8] [-max-depth 16] <trust-anchor>",
+		description: "crawl a federation and report inconsistencies between entities",
+		run:         runCheck,
+	},
+	"init": {
+		usage:       "init -role <leaf|intermediate|trust_anchor|trust_mark_issuer> -entity-id <entity-id> [-authority-hint <entity-id>]... [-algorithm ES256] [-dir .]",
+		description: "generate a signing key and starter configuration for a new entity",
+		run:         runInit,
+	},
+}
+
+// usageError indicates that a command was invoked incorrectly
+type usageError struct {
+	message string
+}
+
+func (e usageError) Error() string {
+	return e.message
+}
+
+func run(ctx context.Context, args []string, env environment) int {
+	flags := flag.NewFlagSet("fedctl", flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	timeout := flags.Duration("timeout", 30*time.Second, "time allowed for the command to complete")
+	insecure := flags.Bool("insecure", false, "skip TLS certificate verification - for use against development federations only")
+	flags.Usage = func() { printUsage(env.stderr, flags) }
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		printUsage(env.stderr, flags)
+		return exitUsage
+	}
+
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(env.stderr, "fedctl: unknown command %q\n", name)
+		printUsage(env.stderr, flags)
+		return exitUsage
+	}
+
+	if env.httpClient == nil {
+		env.httpClient = &http.Client{}
+		if *insecure {
+			env.httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}} //nolint:gosec
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	if err := cmd.run(ctx, model.Configuration{HttpClient: env.httpClient}, env, flags.Args()[1:]); err != nil {
+		fmt.Fprintf(env.stderr, "fedctl %s: %s\n", name, err.Error())
+		var usage usageError
+		if errors.As(err, &usage) {
+			fmt.Fprintf(env.stderr, "usage: fedctl %s\n", cmd.usage)
+			return exitUsage
+		}
+		return exitFailure
+	}
+	return 0
+}
+
+func printUsage(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: fedctl [flags] <command> [flags] [arguments]")
+	fmt.Fprintln(w, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-12s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintln(w, "\nflags:")
+	flags.SetOutput(w)
+	flags.PrintDefaults()
+}
+
+// parseCommandFlags parses a command's flags, requiring exactly the named positional arguments
+func parseCommandFlags(flags *flag.FlagSet, args []string, positional ...string) ([]model.EntityIdentifier, error) {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		return nil, usageError{message: err.Error()}
+	}
+	if flags.NArg() != len(positional) {
+		return nil, usageError{message: fmt.Sprintf("expected %d arguments (%s), got %d", len(positional), strings.Join(positional, ", "), flags.NArg())}
+	}
+	identifiers := make([]model.EntityIdentifier, len(positional))
+	for i, name := range positional {
+		identifier, err := model.ValidateEntityIdentifier(flags.Arg(i))
+		if err != nil {
+			return nil, usageError{message: fmt.Sprintf("invalid %s: %s", name, err.Error())}
+		}
+		identifiers[i] = *identifier
+	}
+	return identifiers, nil
+}
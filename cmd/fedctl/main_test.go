@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jws"
+	"github.com/MichaelFraser99/go-jose/jwt"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/MichaelFraser99/go-openid-federation/server"
+	"github.com/MichaelFraser99/go-openid-federation/server_test"
+)
+
+type staticTrustMarkIssuers map[string][]model.EntityIdentifier
+
+func (s staticTrustMarkIssuers) ListTrustMarkIssuers(_ context.Context) (map[string][]model.EntityIdentifier, error) {
+	return s, nil
+}
+
+// trustMarkFederation serves a trust anchor at /ta and a trust mark issuer at /tmi, returning the server and a trust
+// mark of type 'https://tmi.example.com/certified' issued by /tmi to https://leaf.example.com
+func trustMarkFederation(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	tmiSigner, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	taSigner, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+
+	var tmi, ta *server.Server
+	mux := http.NewServeMux()
+	s := httptest.NewTLSServer(mux)
+	t.Cleanup(s.Close)
+
+	tmi = server.NewServer(model.ServerConfiguration{
+		EntityIdentifier:            model.EntityIdentifier(s.URL + "/tmi"),
+		SignerConfiguration:         model.SignerConfiguration{Signer: tmiSigner, KeyID: "tmi-key", Algorithm: "ES256"},
+		EntityConfigurationLifetime: time.Hour,
+	})
+	ta = server.NewServer(model.ServerConfiguration{
+		EntityIdentifier:            model.EntityIdentifier(s.URL + "/ta"),
+		SignerConfiguration:         model.SignerConfiguration{Signer: taSigner, KeyID: "ta-key", Algorithm: "ES256"},
+		EntityConfigurationLifetime: time.Hour,
+		TrustMarkIssuerRetriever: staticTrustMarkIssuers{
+			"https://tmi.example.com/certified": {model.EntityIdentifier(s.URL + "/tmi")},
+		},
+	})
+	for prefix, entity := range map[string]*server.Server{"/tmi": tmi, "/ta": ta} {
+		entityMux := http.NewServeMux()
+		entity.Configure(entityMux)
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, entityMux))
+	}
+
+	trustMark, err := jwt.New(tmiSigner, map[string]any{
+		"typ": "trust-mark+jwt",
+		"alg": "ES256",
+		"kid": "tmi-key",
+	}, map[string]any{
+		"iss":             s.URL + "/tmi",
+		"sub":             "https://leaf.example.com",
+		"trust_mark_type": "https://tmi.example.com/certified",
+		"iat":             time.Now().Unix(),
+		"exp":             time.Now().Add(time.Hour).Unix(),
+	}, jwt.Opts{Algorithm: josemodel.ES256})
+	if err != nil {
+		t.Fatalf("expected no error signing trust mark, got %q", err.Error())
+	}
+	return s, *trustMark
+}
+
+func TestRun(t *testing.T) {
+	testServer := server_test.TestServer(t)
+	testServerURL := testServer.URL
+	trustMarkServer, trustMark := trustMarkFederation(t)
+	initDirectory := t.TempDir()
+
+	tests := map[string]struct {
+		args           []string
+		client         *http.Client
+		expectedCode   int
+		expectedStdout []string
+		expectedStderr string
+	}{
+		"entity configuration": {
+			args:           []string{"entity", testServerURL + "/leaf"},
+			expectedStdout: []string{`"typ": "entity-statement+jwt"`, `"iss": "` + testServerURL + `/leaf"`},
+		},
+		"raw entity configuration": {
+			args:           []string{"entity", "-raw", testServerURL + "/leaf"},
+			expectedStdout: []string{"eyJ"},
+		},
+		"unreachable entity": {
+			args:           []string{"entity", testServerURL + "/missing"},
+			expectedCode:   exitFailure,
+			expectedStderr: "fedctl entity: invalid entity configuration for " + testServerURL + "/missing: non-200 response",
+		},
+		"subordinate statement": {
+			args:           []string{"subordinate", testServerURL + "/int1", testServerURL + "/leaf"},
+			expectedStdout: []string{`"iss": "` + testServerURL + `/int1"`, `"sub": "` + testServerURL + `/leaf"`},
+		},
+		"trust chain": {
+			args:           []string{"chain", testServerURL + "/leaf", testServerURL + "/ta"},
+			expectedStdout: []string{`"expires_at"`, `"trust_chain"`, `"iss": "` + testServerURL + `/ta"`},
+		},
+		"no trust chain": {
+			args:           []string{"chain", testServerURL + "/leaf", testServerURL + "/int2-unknown"},
+			expectedCode:   exitFailure,
+			expectedStderr: "fedctl chain: unable to build a trust chain from " + testServerURL + "/leaf to " + testServerURL + "/int2-unknown",
+		},
+		"resolve": {
+			args:           []string{"resolve", testServerURL + "/leaf", testServerURL + "/ta"},
+			expectedStdout: []string{`"openid_provider"`},
+		},
+		"valid trust mark": {
+			args:           []string{"trust-mark", "-trust-anchor", trustMarkServer.URL + "/ta", "-subject", "https://leaf.example.com", trustMark},
+			client:         trustMarkServer.Client(),
+			expectedStdout: []string{`"trust_mark_type": "https://tmi.example.com/certified"`},
+		},
+		"trust mark issued to another entity": {
+			args:           []string{"trust-mark", "-trust-anchor", trustMarkServer.URL + "/ta", "-subject", "https://other.example.com", trustMark},
+			client:         trustMarkServer.Client(),
+			expectedCode:   exitFailure,
+			expectedStderr: "fedctl trust-mark: trust mark was issued to https://leaf.example.com, not https://other.example.com",
+		},
+		"trust mark type not recognised by the trust anchor": {
+			args:           []string{"trust-mark", "-trust-anchor", testServerURL + "/ta", trustMark},
+			expectedCode:   exitFailure,
+			expectedStderr: `fedctl trust-mark: trust mark type "https://tmi.example.com/certified" is not recognised by trust anchor`,
+		},
+		"malformed trust mark": {
+			args:           []string{"trust-mark", "-trust-anchor", testServerURL + "/ta", "not-a-jwt"},
+			expectedCode:   exitFailure,
+			expectedStderr: "fedctl trust-mark: malformed JWT: expected 3 parts, got 1",
+		},
+		"missing trust anchor flag": {
+			args:           []string{"trust-mark", trustMark},
+			expectedCode:   exitUsage,
+			expectedStderr: "-trust-anchor is required",
+		},
+		"invalid entity identifier": {
+			args:           []string{"entity", "http://insecure.example.com"},
+			expectedCode:   exitUsage,
+			expectedStderr: "fedctl entity: invalid entity-id",
+		},
+		"wrong number of arguments": {
+			args:           []string{"chain", testServerURL + "/leaf"},
+			expectedCode:   exitUsage,
+			expectedStderr: "expected 2 arguments (leaf, trust-anchor), got 1",
+		},
+		"crawl": {
+			args:           []string{"crawl", trustMarkServer.URL + "/ta"},
+			client:         trustMarkServer.Client(),
+			expectedStdout: []string{`"trust_anchor": "` + trustMarkServer.URL + `/ta"`, `"trust_anchor"`},
+		},
+		"crawl as a DOT graph": {
+			args:           []string{"crawl", "-format", "dot", trustMarkServer.URL + "/ta"},
+			client:         trustMarkServer.Client(),
+			expectedStdout: []string{"digraph federation {"},
+		},
+		"crawl with an unsupported format": {
+			args:           []string{"crawl", "-format", "svg", trustMarkServer.URL + "/ta"},
+			expectedCode:   exitUsage,
+			expectedStderr: `unsupported format "svg"`,
+		},
+		"consistent federation": {
+			args:           []string{"check", trustMarkServer.URL + "/ta"},
+			client:         trustMarkServer.Client(),
+			expectedStdout: []string{`"findings": []`},
+		},
+		"inconsistent federation": {
+			args:           []string{"check", testServerURL + "/int1"},
+			expectedCode:   exitFailure,
+			expectedStdout: []string{`"code": "invalid_listing"`},
+			expectedStderr: "fedctl check: found 1 error findings",
+		},
+		"init": {
+			args:           []string{"init", "-role", "leaf", "-entity-id", "https://leaf.example.com", "-authority-hint", "https://ta.example.com", "-dir", initDirectory},
+			expectedStdout: []string{`"keys"`, `"use": "sig"`},
+			expectedStderr: "register the following public JWKS with each superior",
+		},
+		"init without a role": {
+			args:           []string{"init", "-entity-id", "https://leaf.example.com"},
+			expectedCode:   exitUsage,
+			expectedStderr: "-role and -entity-id are required",
+		},
+		"unknown command": {
+			args:           []string{"explode"},
+			expectedCode:   exitUsage,
+			expectedStderr: `fedctl: unknown command "explode"`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := tt.client
+			if client == nil {
+				client = testServer.Client()
+			}
+			var stdout, stderr bytes.Buffer
+			code := run(context.Background(), tt.args, environment{stdout: &stdout, stderr: &stderr, httpClient: client})
+
+			if code != tt.expectedCode {
+				t.Fatalf("expected exit code %d, got %d with stderr %q", tt.expectedCode, code, stderr.String())
+			}
+			for _, expected := range tt.expectedStdout {
+				if !strings.Contains(stdout.String(), expected) {
+					t.Errorf("expected stdout to contain %q, got %q", expected, stdout.String())
+				}
+			}
+			if !strings.Contains(stderr.String(), tt.expectedStderr) {
+				t.Errorf("expected stderr to contain %q, got %q", tt.expectedStderr, stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Command federation-lambda runs a federation entity behind an API gateway proxy integration on AWS Lambda, using
+// the configuration document named by $FEDERATION_CONFIG. It is built as the 'bootstrap' executable of a custom
+// runtime by the Makefile's build target. As with federation-server, subordinate policies are checked against the
+// policies of the entity's superiors at startup and when subordinate files change, and are refused when
+// 'intermediate.refuse_policy_conflicts' is set
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/MichaelFraser99/go-openid-federation/config"
+	"github.com/MichaelFraser99/go-openid-federation/server"
+	"github.com/MichaelFraser99/go-openid-federation/serverless"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context) error {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+	configPath := os.Getenv("FEDERATION_CONFIG")
+	if configPath == "" {
+		return fmt.Errorf("$FEDERATION_CONFIG must name the configuration document")
+	}
+	entity, err := config.Load(ctx, configPath, config.Options{Logger: logger})
+	if err != nil {
+		return err
+	}
+	federationServer := server.NewServer(entity.ServerConfiguration)
+	if err = entity.CheckPolicies(ctx, federationServer, logger); err != nil {
+		return err
+	}
+	if entity.Subordinates != nil {
+		go entity.Subordinates.Watch(ctx)
+	}
+
+	mux := http.NewServeMux()
+	federationServer.Configure(mux)
+
+	runtime := &serverless.Runtime{
+		API:     os.Getenv("AWS_LAMBDA_RUNTIME_API"),
+		Adapter: serverless.New(mux),
+	}
+	logger.InfoContext(ctx, "serving federation entity", slog.String("role", string(entity.Role)))
+	return runtime.Run(ctx)
+}
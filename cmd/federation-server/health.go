@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/MichaelFraser99/go-openid-federation/server"
+	"github.com/MichaelFraser99/go-openid-federation/store/dirstore"
+)
+
+const (
+	readinessTimeout     = 5 * time.Second
+	superiorCheckTimeout = 15 * time.Second
+	superiorReportTTL    = 30 * time.Second // superiorReportTTL bounds how often requests to the health endpoints make the entity contact its superiors
+)
+
+// healthHandler serves the liveness and readiness endpoints
+type healthHandler struct {
+	server       *server.Server
+	subordinates *dirstore.Store // subordinates is nil when the entity has no subordinates directory
+	shuttingDown atomic.Bool
+	superiors    cachedReport
+	policies     cachedReport
+}
+
+// cachedReport holds the most recent response of a check which contacts the entity's superiors. The health endpoints
+// are unauthenticated, so responses are reused for superiorReportTTL and concurrent requests wait for a single check
+type cachedReport struct {
+	mu       sync.Mutex
+	status   int
+	response healthResponse
+	expires  time.Time
+}
+
+// get returns the cached response, running check in its place once the cached response has expired. The check is
+// not cancelled with the request that triggered it, as its result is shared with other requests
+func (c *cachedReport) get(r *http.Request, check func(ctx context.Context) (int, healthResponse)) (int, healthResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().Before(c.expires) {
+		return c.status, c.response
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), superiorCheckTimeout)
+	defer cancel()
+	c.status, c.response = check(ctx)
+	c.expires = time.Now().Add(superiorReportTTL)
+	return c.status, c.response
+}
+
+type healthResponse struct {
+	Status                   string                             `json:"status"`
+	Error                    string                             `json:"error,omitempty"`
+	RejectedSubordinateFiles map[string]string                  `json:"rejected_subordinate_files,omitempty"`
+	Superiors                []server.SuperiorCheck             `json:"superiors,omitempty"`
+	PolicyConflicts          []server.SubordinatePolicyConflict `json:"policy_conflicts,omitempty"`
+	UncheckedSuperiors       map[model.EntityIdentifier]string  `json:"unchecked_superiors,omitempty"`
+}
+
+func (h *healthHandler) Configure(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", h.Live)
+	mux.HandleFunc("GET /readyz", h.Ready)
+	mux.HandleFunc("GET /healthz/superiors", h.Superiors)
+	mux.HandleFunc("GET /healthz/policies", h.Policies)
+}
+
+// Live reports that the process is running and able to serve requests
+func (h *healthHandler) Live(w http.ResponseWriter, _ *http.Request) {
+	respond(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// Ready reports whether the entity can serve its entity configuration, which requires its signer to be available.
+// Subordinate files rejected by the subordinate store are listed but do not fail the check, as the last valid version
+// of each continues to be served
+func (h *healthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	if h.shuttingDown.Load() {
+		respond(w, http.StatusServiceUnavailable, healthResponse{Status: "shutting_down"})
+		return
+	}
+
+	response := healthResponse{Status: "ok"}
+	if h.subordinates != nil {
+		for file, err := range h.subordinates.Rejected() {
+			if response.RejectedSubordinateFiles == nil {
+				response.RejectedSubordinateFiles = map[string]string{}
+			}
+			response.RejectedSubordinateFiles[file] = err.Error()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if _, err := h.server.EntityConfiguration(ctx); err != nil {
+		response.Status = "unavailable"
+		response.Error = err.Error()
+		respond(w, http.StatusServiceUnavailable, response)
+		return
+	}
+	respond(w, http.StatusOK, response)
+}
+
+// Superiors reports whether each of the entity's authority hints currently vouches for it. Unlike readiness this
+// depends on other entities, so it is intended for monitoring rather than for routing decisions. The report is cached
+// for superiorReportTTL
+func (h *healthHandler) Superiors(w http.ResponseWriter, r *http.Request) {
+	status, response := h.superiors.get(r, func(ctx context.Context) (int, healthResponse) {
+		report, err := h.server.SelfCheck(ctx)
+		if err != nil {
+			return http.StatusServiceUnavailable, healthResponse{Status: "unavailable", Error: err.Error()}
+		}
+		if !report.Healthy {
+			return http.StatusServiceUnavailable, healthResponse{Status: "unhealthy", Superiors: report.Superiors}
+		}
+		return http.StatusOK, healthResponse{Status: "ok", Superiors: report.Superiors}
+	})
+	respond(w, status, response)
+}
+
+// Policies reports whether the metadata policy configured for each subordinate can be combined with the policies the
+// entity's superiors publish for it. Superiors that cannot be reached are listed with a status of "unchecked", as their
+// availability is reported by Superiors. The report is cached for superiorReportTTL
+func (h *healthHandler) Policies(w http.ResponseWriter, r *http.Request) {
+	status, response := h.policies.get(r, func(ctx context.Context) (int, healthResponse) {
+		report, err := h.server.CheckPolicyCompatibility(ctx)
+		if err != nil {
+			return http.StatusServiceUnavailable, healthResponse{Status: "unavailable", Error: err.Error()}
+		}
+		response := healthResponse{Status: "ok", PolicyConflicts: report.Conflicts, UncheckedSuperiors: report.UncheckedSuperiors}
+		switch {
+		case !report.Compatible:
+			response.Status = "incompatible"
+			return http.StatusServiceUnavailable, response
+		case len(report.UncheckedSuperiors) > 0:
+			response.Status = "unchecked"
+		}
+		return http.StatusOK, response
+	})
+	respond(w, status, response)
+}
+
+func respond(w http.ResponseWriter, status int, response healthResponse) {
+	body, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
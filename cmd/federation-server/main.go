@@ -0,0 +1,162 @@
+// Command federation-server runs a federation entity - a leaf, intermediate, trust anchor or trust mark issuer - from
+// a configuration document, as described by the config package.
+//
+// Usage:
+//
+//	federation-server -config federation.yaml [-addr :8080] [-tls-cert cert.pem -tls-key key.pem]
+//
+// Alongside the federation endpoints the server exposes /healthz, which reports whether the process is running,
+// /readyz, which reports whether the entity can currently serve its entity configuration, and /healthz/superiors, which
+// reports whether each authority hint's subordinate statement still vouches for the entity. The superiors are also
+// checked once at startup, with any problems logged. Reports which contact the entity's superiors are cached briefly.
+//
+// The metadata policy configured for each subordinate is combined with the policies the authority hints publish for
+// the entity at startup and whenever a subordinate file changes, with conflicts logged and reported by /healthz/policies.
+// When 'intermediate.refuse_policy_conflicts' is set the server refuses to start with conflicting policies, and
+// changed subordinate files with conflicting policies are rejected. On SIGINT or SIGTERM the server stops reporting ready and
+// completes in-flight requests before exiting
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/MichaelFraser99/go-openid-federation/config"
+	"github.com/MichaelFraser99/go-openid-federation/server"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+type options struct {
+	configPath      string
+	addr            string
+	tlsCert         string
+	tlsKey          string
+	shutdownTimeout time.Duration
+	logLevel        slog.Level
+}
+
+func parseOptions(args []string, output io.Writer) (*options, error) {
+	opts := &options{}
+	flags := flag.NewFlagSet("federation-server", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&opts.configPath, "config", os.Getenv("FEDERATION_CONFIG"), "path to the configuration document - defaults to $FEDERATION_CONFIG")
+	flags.StringVar(&opts.addr, "addr", ":8080", "address to listen on")
+	flags.StringVar(&opts.tlsCert, "tls-cert", "", "path to a PEM encoded TLS certificate - serves plain HTTP when unset")
+	flags.StringVar(&opts.tlsKey, "tls-key", "", "path to the PEM encoded private key for -tls-cert")
+	flags.DurationVar(&opts.shutdownTimeout, "shutdown-timeout", 15*time.Second, "time allowed for in-flight requests to complete on shutdown")
+	flags.TextVar(&opts.logLevel, "log-level", slog.LevelInfo, "minimum level of logged messages")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if opts.configPath == "" {
+		return nil, fmt.Errorf("a configuration document must be provided with -config or $FEDERATION_CONFIG")
+	}
+	if (opts.tlsCert == "") != (opts.tlsKey == "") {
+		return nil, fmt.Errorf("-tls-cert and -tls-key must be provided together")
+	}
+	return opts, nil
+}
+
+func run(ctx context.Context, args []string, output io.Writer) error {
+	opts, err := parseOptions(args, output)
+	if err != nil {
+		return err
+	}
+	logger := slog.New(slog.NewJSONHandler(output, &slog.HandlerOptions{Level: opts.logLevel}))
+
+	entity, err := config.Load(ctx, opts.configPath, config.Options{Logger: logger})
+	if err != nil {
+		return err
+	}
+
+	federationServer := server.NewServer(entity.ServerConfiguration)
+	if err = entity.CheckPolicies(ctx, federationServer, logger); err != nil {
+		return err
+	}
+	if entity.Subordinates != nil {
+		go entity.Subordinates.Watch(ctx)
+	}
+	health := &healthHandler{server: federationServer, subordinates: entity.Subordinates}
+
+	mux := http.NewServeMux()
+	federationServer.Configure(mux)
+	health.Configure(mux)
+
+	listener, err := net.Listen("tcp", opts.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", opts.addr, err)
+	}
+	go checkSuperiors(ctx, federationServer, logger)
+	return serve(ctx, &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}, listener, opts, health, logger, entity.Role)
+}
+
+// checkSuperiors logs whether the entity's superiors vouch for it, so that problems are noticed before relying parties
+// encounter them
+func checkSuperiors(ctx context.Context, federationServer *server.Server, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(ctx, superiorCheckTimeout)
+	defer cancel()
+	report, err := federationServer.SelfCheck(ctx)
+	switch {
+	case err != nil:
+		logger.ErrorContext(ctx, "unable to check superiors", slog.String("error", err.Error()))
+	case report.Healthy:
+		logger.InfoContext(ctx, "superiors vouch for this entity", slog.Int("superiors", len(report.Superiors)))
+	default:
+		logger.WarnContext(ctx, "one or more superiors do not vouch for this entity - see /healthz/superiors")
+	}
+}
+
+// serve runs the HTTP server until the context is cancelled, then shuts it down gracefully
+func serve(ctx context.Context, httpServer *http.Server, listener net.Listener, opts *options, health *healthHandler, logger *slog.Logger, role config.Role) error {
+	errs := make(chan error, 1)
+	go func() {
+		logger.InfoContext(ctx, "serving federation entity", slog.String("role", string(role)), slog.String("addr", listener.Addr().String()), slog.Bool("tls", opts.tlsCert != ""))
+		if opts.tlsCert != "" {
+			errs <- httpServer.ServeTLS(listener, opts.tlsCert, opts.tlsKey)
+		} else {
+			errs <- httpServer.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("server stopped unexpectedly: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down", slog.Duration("timeout", opts.shutdownTimeout))
+	health.shuttingDown.Store(true)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down gracefully: %w", err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
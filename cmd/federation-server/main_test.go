@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/MichaelFraser99/go-openid-federation/server"
+)
+
+func testServer(t *testing.T, keyID string) *server.Server {
+	t.Helper()
+	return newTestServer(t, keyID, unreachable{})
+}
+
+func newTestServer(t *testing.T, keyID string, transport http.RoundTripper) *server.Server {
+	t.Helper()
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	return server.NewServer(model.ServerConfiguration{
+		// the trust anchor is never reachable, so checks against it fail without touching the network
+		Configuration:               model.Configuration{HttpClient: &http.Client{Transport: transport}},
+		EntityIdentifier:            "https://leaf.example.com",
+		AuthorityHints:              []model.EntityIdentifier{"https://trust-anchor.example.com"},
+		SignerConfiguration:         model.SignerConfiguration{Signer: signer, KeyID: keyID, Algorithm: "ES256"},
+		EntityConfigurationLifetime: time.Hour,
+	})
+}
+
+type unreachable struct {
+	requests *atomic.Int32 // requests counts the requests made, when set
+}
+
+func (u unreachable) RoundTrip(r *http.Request) (*http.Response, error) {
+	if u.requests != nil {
+		u.requests.Add(1)
+	}
+	return nil, fmt.Errorf("%s is unreachable", r.URL.Host)
+}
+
+func TestParseOptions(t *testing.T) {
+	tests := map[string]struct {
+		args          []string
+		expectedError string
+	}{
+		"valid":             {args: []string{"-config", "federation.yaml", "-addr", ":8443", "-tls-cert", "cert.pem", "-tls-key", "key.pem"}},
+		"missing config":    {args: []string{"-addr", ":8443"}, expectedError: "a configuration document must be provided"},
+		"incomplete tls":    {args: []string{"-config", "federation.yaml", "-tls-cert", "cert.pem"}, expectedError: "-tls-cert and -tls-key must be provided together"},
+		"invalid log level": {args: []string{"-config", "federation.yaml", "-log-level", "loud"}, expectedError: "invalid value"},
+		"unknown flag":      {args: []string{"-config", "federation.yaml", "-verbose"}, expectedError: "flag provided but not defined"},
+		"invalid duration":  {args: []string{"-config", "federation.yaml", "-shutdown-timeout", "soon"}, expectedError: "invalid value"},
+		"debug log level":   {args: []string{"-config", "federation.yaml", "-log-level", "debug"}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("FEDERATION_CONFIG", "")
+			_, err := parseOptions(tt.args, io.Discard)
+			if tt.expectedError == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	tests := map[string]struct {
+		keyID          string
+		shuttingDown   bool
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		"live": {
+			keyID:          "key-1",
+			path:           "/healthz",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+		"ready": {
+			keyID:          "key-1",
+			path:           "/readyz",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+		"not ready when the entity configuration cannot be signed": {
+			path:           "/readyz",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "unavailable",
+		},
+		"not ready when shutting down": {
+			keyID:          "key-1",
+			shuttingDown:   true,
+			path:           "/readyz",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "shutting_down",
+		},
+		"superiors unhealthy when the trust anchor cannot be reached": {
+			keyID:          "key-1",
+			path:           "/healthz/superiors",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "unhealthy",
+		},
+		"superiors cannot be checked when the entity configuration cannot be signed": {
+			path:           "/healthz/superiors",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "unavailable",
+		},
+		"policies unchecked when the trust anchor cannot be reached": {
+			keyID:          "key-1",
+			path:           "/healthz/policies",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "unchecked",
+		},
+		"live when shutting down": {
+			keyID:          "key-1",
+			shuttingDown:   true,
+			path:           "/healthz",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			health := &healthHandler{server: testServer(t, tt.keyID)}
+			health.shuttingDown.Store(tt.shuttingDown)
+			mux := http.NewServeMux()
+			health.Configure(mux)
+
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			var response healthResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if response.Status != tt.expectedBody {
+				t.Errorf("expected status %q, got %q", tt.expectedBody, response.Status)
+			}
+		})
+	}
+}
+
+func TestHealthHandler_CachesSuperiorReports(t *testing.T) {
+	for _, path := range []string{"/healthz/superiors", "/healthz/policies"} {
+		t.Run(path, func(t *testing.T) {
+			var requests atomic.Int32
+			health := &healthHandler{server: newTestServer(t, "key-1", unreachable{requests: &requests})}
+			mux := http.NewServeMux()
+			health.Configure(mux)
+
+			var statuses []int
+			for range 3 {
+				recorder := httptest.NewRecorder()
+				mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+				statuses = append(statuses, recorder.Code)
+			}
+			if requests.Load() != 1 {
+				t.Errorf("expected the trust anchor to be contacted once, got %d requests", requests.Load())
+			}
+			if statuses[0] != statuses[1] || statuses[1] != statuses[2] {
+				t.Errorf("expected every request to receive the cached response, got statuses %v", statuses)
+			}
+		})
+	}
+}
+
+func TestServe_GracefulShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+
+	federationServer := testServer(t, "key-1")
+	health := &healthHandler{server: federationServer}
+	mux := http.NewServeMux()
+	federationServer.Configure(mux)
+	health.Configure(mux)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, &http.Server{Handler: mux}, listener, &options{shutdownTimeout: 5 * time.Second}, health, slog.New(slog.NewTextHandler(io.Discard, nil)), "leaf")
+	}()
+
+	response, err := http.Get("http://" + listener.Addr().String() + "/.well-known/openid-federation")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", response.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+	if !health.shuttingDown.Load() {
+		t.Error("expected readiness to report shutting down")
+	}
+}
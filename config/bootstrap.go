@@ -0,0 +1,227 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/internal/signing"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+const (
+	defaultKeyFile           = "signing.pem"
+	defaultConfigurationFile = "federation.yaml"
+	rsaKeySize               = 3072
+)
+
+type BootstrapOptions struct {
+	Role              Role
+	EntityIdentifier  model.EntityIdentifier
+	AuthorityHints    []model.EntityIdentifier // AuthorityHints are required for leaf and intermediate entities, and not permitted for trust anchors
+	Algorithm         string                   // Algorithm of the generated key - defaults to ES256
+	Directory         string                   // Directory the key and configuration document are written to - defaults to the working directory
+	KeyFile           string                   // KeyFile names the private key file within Directory - defaults to 'signing.pem'
+	ConfigurationFile string                   // ConfigurationFile names the configuration document within Directory - defaults to 'federation.yaml'
+}
+
+// BootstrapResult describes the files written by Bootstrap
+type BootstrapResult struct {
+	KeyID             string
+	KeyPath           string
+	ConfigurationPath string
+	PublicJWKS        josemodel.Jwks // PublicJWKS holds the public key a superior needs to register the entity
+}
+
+// Bootstrap generates a signing key for a new entity and writes it alongside a starter configuration document for the
+// chosen role, which can be run as is with Load. Existing files are never overwritten
+func Bootstrap(opts BootstrapOptions) (*BootstrapResult, error) {
+	if err := validateBootstrapOptions(&opts); err != nil {
+		return nil, err
+	}
+
+	privateKey, err := GenerateKey(opts.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	publicJWK, err := PublicJWK(privateKey, opts.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(opts.Directory, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	result := &BootstrapResult{
+		KeyID:             publicJWK["kid"].(string),
+		KeyPath:           filepath.Join(opts.Directory, opts.KeyFile),
+		ConfigurationPath: filepath.Join(opts.Directory, opts.ConfigurationFile),
+		PublicJWKS:        josemodel.Jwks{Keys: []map[string]any{publicJWK}},
+	}
+	// the configuration document is checked first so that a failure leaves no orphaned key behind
+	if _, err = os.Stat(result.ConfigurationPath); !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("refusing to overwrite existing file %s", result.ConfigurationPath)
+	}
+	if err = WritePrivateKey(result.KeyPath, privateKey); err != nil {
+		return nil, err
+	}
+	if err = writeFile(result.ConfigurationPath, []byte(StarterDocument(opts)), 0o644); err != nil {
+		return nil, err
+	}
+	if opts.Role == RoleIntermediate || opts.Role == RoleTrustAnchor {
+		if err = os.MkdirAll(filepath.Join(opts.Directory, "subordinates"), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create subordinates directory: %w", err)
+		}
+	}
+	return result, nil
+}
+
+func validateBootstrapOptions(opts *BootstrapOptions) error {
+	if opts.Algorithm == "" {
+		opts.Algorithm = josemodel.ES256.String()
+	}
+	if opts.Directory == "" {
+		opts.Directory = "."
+	}
+	if opts.KeyFile == "" {
+		opts.KeyFile = defaultKeyFile
+	}
+	if opts.ConfigurationFile == "" {
+		opts.ConfigurationFile = defaultConfigurationFile
+	}
+	if _, err := model.ValidateEntityIdentifier(string(opts.EntityIdentifier)); err != nil {
+		return fmt.Errorf("invalid entity identifier: %w", err)
+	}
+	for _, hint := range opts.AuthorityHints {
+		if _, err := model.ValidateEntityIdentifier(string(hint)); err != nil {
+			return fmt.Errorf("invalid authority hint: %w", err)
+		}
+	}
+	switch opts.Role {
+	case RoleLeaf, RoleIntermediate:
+		if len(opts.AuthorityHints) == 0 {
+			return fmt.Errorf("at least one authority hint is required for role %s", opts.Role)
+		}
+	case RoleTrustAnchor:
+		if len(opts.AuthorityHints) > 0 {
+			return fmt.Errorf("authority hints are not permitted for role %s", opts.Role)
+		}
+	case RoleTrustMarkIssuer:
+	default:
+		return fmt.Errorf("role must be one of %s, %s, %s or %s", RoleLeaf, RoleIntermediate, RoleTrustAnchor, RoleTrustMarkIssuer)
+	}
+	return nil
+}
+
+// GenerateKey generates a private key for the given signing algorithm
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+	switch josemodel.GetAlgorithm(algorithm) {
+	case josemodel.ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case josemodel.ES384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case josemodel.ES512:
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case josemodel.RS256, josemodel.RS384, josemodel.RS512, josemodel.PS256, josemodel.PS384, josemodel.PS512:
+		return rsa.GenerateKey(rand.Reader, rsaKeySize)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+}
+
+// PublicJWK returns the public JWK for a private key, with its 'kid' set to the key's RFC 7638 thumbprint - the key ID
+// Load assigns to keys without an explicit one
+func PublicJWK(privateKey crypto.Signer, algorithm string) (map[string]any, error) {
+	if _, err := keyAlgorithm(privateKey, algorithm); err != nil {
+		return nil, err
+	}
+	publicJWK, err := jwk.PublicJwk(privateKey.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	kid, err := signing.Thumbprint(*publicJWK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute key thumbprint: %w", err)
+	}
+	(*publicJWK)["kid"] = kid
+	(*publicJWK)["alg"] = algorithm
+	(*publicJWK)["use"] = "sig"
+	return *publicJWK, nil
+}
+
+// WritePrivateKey writes a private key as a PKCS #8 PEM file readable only by its owner. An existing file is never
+// overwritten
+func WritePrivateKey(path string, privateKey crypto.Signer) error {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to encode private key: %w", err)
+	}
+	return writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("refusing to overwrite existing file %s", path)
+		}
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if _, err = file.Write(data); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// StarterDocument returns a configuration document for a new entity of the given role, signing with the key written
+// to opts.KeyFile
+func StarterDocument(opts BootstrapOptions) string {
+	keyFile := opts.KeyFile
+	if keyFile == "" {
+		keyFile = defaultKeyFile
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Starter configuration for a %s entity - see the config package for every available setting\n", opts.Role)
+	fmt.Fprintf(&b, "role: %s\n", opts.Role)
+	fmt.Fprintf(&b, "entity_identifier: %s\n", opts.EntityIdentifier)
+	fmt.Fprintf(&b, "signing_key:\n  file: %s\n", keyFile)
+	if len(opts.AuthorityHints) > 0 {
+		b.WriteString("authority_hints:\n")
+		for _, hint := range opts.AuthorityHints {
+			fmt.Fprintf(&b, "  - %s\n", hint)
+		}
+	}
+	b.WriteString("entity_configuration_lifetime: 24h\n")
+	b.WriteString("metadata:\n  federation_entity:\n    organization_name: ${ORGANIZATION_NAME:-Example Organization}\n")
+
+	switch opts.Role {
+	case RoleIntermediate, RoleTrustAnchor:
+		b.WriteString("intermediate:\n")
+		b.WriteString("  subordinate_statement_lifetime: 24h\n")
+		b.WriteString("  # one JSON or YAML file per subordinate, reloaded while the server runs\n")
+		b.WriteString("  subordinates_directory: subordinates\n")
+	case RoleTrustMarkIssuer:
+		b.WriteString("trust_mark_issuer:\n")
+		b.WriteString("  trust_mark_lifetime: 720h\n")
+		b.WriteString("  # entities entitled to each trust mark type\n")
+		b.WriteString("  entitlements: {}\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+func TestBootstrap(t *testing.T) {
+	tests := map[string]struct {
+		opts BootstrapOptions
+	}{
+		"leaf": {
+			opts: BootstrapOptions{
+				Role:             RoleLeaf,
+				EntityIdentifier: "https://leaf.example.com",
+				AuthorityHints:   []model.EntityIdentifier{"https://intermediate.example.com"},
+			},
+		},
+		"intermediate with an RSA key": {
+			opts: BootstrapOptions{
+				Role:             RoleIntermediate,
+				EntityIdentifier: "https://intermediate.example.com",
+				AuthorityHints:   []model.EntityIdentifier{"https://trust-anchor.example.com"},
+				Algorithm:        "PS256",
+			},
+		},
+		"trust anchor": {
+			opts: BootstrapOptions{
+				Role:             RoleTrustAnchor,
+				EntityIdentifier: "https://trust-anchor.example.com",
+				Algorithm:        "ES384",
+			},
+		},
+		"trust mark issuer": {
+			opts: BootstrapOptions{
+				Role:             RoleTrustMarkIssuer,
+				EntityIdentifier: "https://tmi.example.com",
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.opts.Directory = filepath.Join(t.TempDir(), "entity")
+			result, err := Bootstrap(tt.opts)
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+
+			info, err := os.Stat(result.KeyPath)
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if info.Mode().Perm() != 0o600 {
+				t.Errorf("expected private key permissions 0600, got %o", info.Mode().Perm())
+			}
+			if len(result.PublicJWKS.Keys) != 1 || result.PublicJWKS.Keys[0]["kid"] != result.KeyID {
+				t.Errorf("expected the public JWKS to hold the key with ID %s, got %v", result.KeyID, result.PublicJWKS.Keys)
+			}
+			if _, ok := result.PublicJWKS.Keys[0]["d"]; ok {
+				t.Error("expected the public JWKS not to contain private key material")
+			}
+
+			// the starter document must load as is, with the thumbprint key ID
+			entity, err := Load(context.Background(), result.ConfigurationPath, Options{LookupEnv: lookup(nil)})
+			if err != nil {
+				t.Fatalf("expected no error loading the starter document, got %q", err.Error())
+			}
+			if entity.Role != tt.opts.Role {
+				t.Errorf("expected role %s, got %s", tt.opts.Role, entity.Role)
+			}
+			if entity.ServerConfiguration.SignerConfiguration.KeyID != result.KeyID {
+				t.Errorf("expected key ID %s, got %s", result.KeyID, entity.ServerConfiguration.SignerConfiguration.KeyID)
+			}
+			if (*entity.ServerConfiguration.EntityConfiguration.Metadata.FederationMetadata)["organization_name"] != "Example Organization" {
+				t.Errorf("expected the default organization name to be used")
+			}
+		})
+	}
+}
+
+func TestBootstrap_Errors(t *testing.T) {
+	tests := map[string]struct {
+		opts          BootstrapOptions
+		existingFile  string
+		expectedError string
+	}{
+		"unknown role": {
+			opts:          BootstrapOptions{Role: "observer", EntityIdentifier: "https://leaf.example.com"},
+			expectedError: "role must be one of",
+		},
+		"leaf without authority hints": {
+			opts:          BootstrapOptions{Role: RoleLeaf, EntityIdentifier: "https://leaf.example.com"},
+			expectedError: "at least one authority hint is required for role leaf",
+		},
+		"trust anchor with authority hints": {
+			opts:          BootstrapOptions{Role: RoleTrustAnchor, EntityIdentifier: "https://ta.example.com", AuthorityHints: []model.EntityIdentifier{"https://other.example.com"}},
+			expectedError: "authority hints are not permitted for role trust_anchor",
+		},
+		"invalid entity identifier": {
+			opts:          BootstrapOptions{Role: RoleTrustAnchor, EntityIdentifier: "http://ta.example.com"},
+			expectedError: "invalid entity identifier",
+		},
+		"unsupported algorithm": {
+			opts:          BootstrapOptions{Role: RoleTrustAnchor, EntityIdentifier: "https://ta.example.com", Algorithm: "HS256"},
+			expectedError: `unsupported algorithm "HS256"`,
+		},
+		"existing key": {
+			opts:          BootstrapOptions{Role: RoleTrustAnchor, EntityIdentifier: "https://ta.example.com"},
+			existingFile:  defaultKeyFile,
+			expectedError: "refusing to overwrite existing file",
+		},
+		"existing configuration": {
+			opts:          BootstrapOptions{Role: RoleTrustAnchor, EntityIdentifier: "https://ta.example.com"},
+			existingFile:  defaultConfigurationFile,
+			expectedError: "refusing to overwrite existing file",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.opts.Directory = t.TempDir()
+			if tt.existingFile != "" {
+				if err := os.WriteFile(filepath.Join(tt.opts.Directory, tt.existingFile), []byte("existing"), 0o600); err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+			}
+			_, err := Bootstrap(tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+			}
+			if tt.existingFile != "" {
+				contents, _ := os.ReadFile(filepath.Join(tt.opts.Directory, tt.existingFile))
+				if string(contents) != "existing" {
+					t.Errorf("expected %s to be left untouched", tt.existingFile)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,430 @@
+// Package config builds a complete server configuration from a declarative JSON or YAML document, allowing a federation
+// entity to be run without writing Go. A minimal document for an intermediate entity looks as follows:
+//
+//	entity_identifier: https://intermediate.example.com
+//	signing_key:
+//	  file: keys/signing.pem
+//	authority_hints:
+//	  - https://trust-anchor.example.com
+//	metadata:
+//	  federation_entity:
+//	    organization_name: ${ORGANIZATION_NAME}
+//	intermediate:
+//	  subordinates_directory: subordinates
+//
+// String values may reference environment variables as ${NAME}, or ${NAME:-default} to fall back to a default when
+// the variable is unset or empty. Relative file paths are resolved against the directory holding the document
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/MichaelFraser99/go-openid-federation/internal/documents"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/MichaelFraser99/go-openid-federation/store/dirstore"
+)
+
+// Role identifies the part an entity plays within a federation
+type Role string
+
+const (
+	RoleLeaf            Role = "leaf"              // RoleLeaf entities issue no statements about other entities
+	RoleIntermediate    Role = "intermediate"      // RoleIntermediate entities issue statements about their subordinates and name their superiors as authority hints
+	RoleTrustAnchor     Role = "trust_anchor"      // RoleTrustAnchor entities issue statements about their subordinates and have no superiors
+	RoleTrustMarkIssuer Role = "trust_mark_issuer" // RoleTrustMarkIssuer entities issue trust marks
+)
+
+const defaultLifetime = 24 * time.Hour
+
+// Entity is a federation entity built from a configuration document
+type Entity struct {
+	Role                Role
+	ServerConfiguration model.ServerConfiguration
+	Subordinates        *dirstore.Store // Subordinates serves the subordinates read from 'intermediate.subordinates_directory', and is nil when no directory is configured. Changes to the directory are only picked up while Subordinates.Watch is running
+}
+
+type Options struct {
+	LookupEnv     func(name string) (string, bool) // LookupEnv resolves environment variable references - defaults to os.LookupEnv
+	BaseDirectory string                           // BaseDirectory is used to resolve relative file paths - Load defaults this to the directory holding the document
+	Logger        *slog.Logger                     // Logger is set on the server configuration and any subordinate store
+}
+
+type document struct {
+	Role                             string                   `json:"role"`
+	EntityIdentifier                 string                   `json:"entity_identifier"`
+	SigningKey                       *keyDocument             `json:"signing_key"`
+	AuthorityHints                   []string                 `json:"authority_hints"`
+	TrustMarks                       []trustMarkDocument      `json:"trust_marks"`
+	Metadata                         json.RawMessage          `json:"metadata"`
+	JWKs                             json.RawMessage          `json:"jwks"`
+	EntityConfigurationLifetime      string                   `json:"entity_configuration_lifetime"`
+	EntityConfigurationRefreshMargin string                   `json:"entity_configuration_refresh_margin"`
+	TrustMarkIssuers                 map[string][]string      `json:"trust_mark_issuers"`
+	Intermediate                     *intermediateDocument    `json:"intermediate"`
+	TrustMarkIssuer                  *trustMarkIssuerDocument `json:"trust_mark_issuer"`
+}
+
+type trustMarkDocument struct {
+	TrustMarkType string `json:"trust_mark_type"`
+	TrustMark     string `json:"trust_mark"`
+}
+
+type intermediateDocument struct {
+	SubordinateStatementLifetime string                     `json:"subordinate_statement_lifetime"`
+	SubordinateCacheTime         string                     `json:"subordinate_cache_time"`
+	SubordinatesDirectory        string                     `json:"subordinates_directory"`
+	PollInterval                 string                     `json:"poll_interval"`
+	SigningKeys                  []keyDocument              `json:"signing_keys"`
+	ExtendedListing              *extendedListingDocument   `json:"extended_listing"`
+	SubordinateStatus            *subordinateStatusDocument `json:"subordinate_status"`
+	ResolveTrace                 *resolveTraceDocument      `json:"resolve_trace"`
+	RefusePolicyConflicts        bool                       `json:"refuse_policy_conflicts"`
+}
+
+type extendedListingDocument struct {
+	Enabled   bool `json:"enabled"`
+	SizeLimit int  `json:"size_limit"`
+}
+
+type subordinateStatusDocument struct {
+	Enabled          bool   `json:"enabled"`
+	ResponseLifetime string `json:"response_lifetime"`
+}
+
+type resolveTraceDocument struct {
+	Enabled bool `json:"enabled"`
+}
+
+type trustMarkIssuerDocument struct {
+	TrustMarkLifetime string              `json:"trust_mark_lifetime"`
+	Entitlements      map[string][]string `json:"entitlements"`
+}
+
+// Load reads and builds the configuration document at the given path
+func Load(ctx context.Context, path string, opts Options) (*Entity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration: %w", err)
+	}
+	if opts.BaseDirectory == "" {
+		opts.BaseDirectory = filepath.Dir(path)
+	}
+	return Parse(ctx, data, opts)
+}
+
+// Parse builds a configuration document. Problems with the document are returned as a *ValidationError listing every
+// offending field
+func Parse(ctx context.Context, data []byte, opts Options) (*Entity, error) {
+	if opts.LookupEnv == nil {
+		opts.LookupEnv = os.LookupEnv
+	}
+
+	tree, err := documents.Parse(data)
+	if err != nil {
+		return nil, &ValidationError{Errors: []FieldError{{Message: fmt.Sprintf("failed to parse document: %s", err.Error())}}}
+	}
+	if tree == nil {
+		return nil, &ValidationError{Errors: []FieldError{{Message: "document is empty"}}}
+	}
+
+	errors := &errorCollector{}
+	tree = substitute(tree, "", opts.LookupEnv, errors)
+	var doc document
+	decode(tree, reflect.ValueOf(&doc).Elem(), "", errors)
+	if err = errors.err(); err != nil {
+		return nil, err
+	}
+
+	b := builder{ctx: ctx, opts: opts, errors: errors}
+	entity := b.build(doc)
+	if err = errors.err(); err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
+
+type builder struct {
+	ctx    context.Context
+	opts   Options
+	errors *errorCollector
+}
+
+func (b *builder) build(doc document) *Entity {
+	cfg := model.ServerConfiguration{
+		Configuration:                    model.Configuration{Logger: b.opts.Logger},
+		EntityConfigurationLifetime:      b.duration("entity_configuration_lifetime", doc.EntityConfigurationLifetime, defaultLifetime),
+		EntityConfigurationRefreshMargin: b.duration("entity_configuration_refresh_margin", doc.EntityConfigurationRefreshMargin, 0),
+	}
+
+	if doc.EntityIdentifier == "" {
+		b.errors.add("entity_identifier", "required")
+	} else if identifier := b.entityIdentifier("entity_identifier", doc.EntityIdentifier); identifier != nil {
+		cfg.EntityIdentifier = *identifier
+	}
+
+	if doc.SigningKey == nil {
+		b.errors.add("signing_key", "required")
+	} else if signer := b.signer("signing_key", *doc.SigningKey); signer != nil {
+		cfg.SignerConfiguration = *signer
+	}
+
+	for i, hint := range doc.AuthorityHints {
+		if identifier := b.entityIdentifier(fmt.Sprintf("authority_hints[%d]", i), hint); identifier != nil {
+			cfg.AuthorityHints = append(cfg.AuthorityHints, *identifier)
+		}
+	}
+
+	for i, trustMark := range doc.TrustMarks {
+		path := fmt.Sprintf("trust_marks[%d]", i)
+		if trustMark.TrustMarkType == "" {
+			b.errors.add(join(path, "trust_mark_type"), "required")
+		}
+		if trustMark.TrustMark == "" {
+			b.errors.add(join(path, "trust_mark"), "required")
+		}
+		cfg.TrustMarks = append(cfg.TrustMarks, model.TrustMarkHolder{TrustMarkType: trustMark.TrustMarkType, TrustMark: trustMark.TrustMark})
+	}
+
+	if len(doc.Metadata) > 0 {
+		var metadata model.Metadata
+		if err := json.Unmarshal(doc.Metadata, &metadata); err != nil {
+			b.errors.add("metadata", "%s", err.Error())
+		}
+		cfg.EntityConfiguration.Metadata = &metadata
+	}
+
+	if len(doc.JWKs) > 0 {
+		if err := json.Unmarshal(doc.JWKs, &cfg.EntityConfiguration.JWKs); err != nil {
+			b.errors.add("jwks", "%s", err.Error())
+		}
+		for i, key := range cfg.EntityConfiguration.JWKs.Keys {
+			if kid, ok := key["kid"].(string); !ok || kid == "" {
+				b.errors.add(fmt.Sprintf("jwks.keys[%d]", i), "missing required member 'kid'")
+			}
+		}
+	}
+
+	if len(doc.TrustMarkIssuers) > 0 {
+		issuers := trustMarkIssuers{}
+		for trustMarkType, identifiers := range doc.TrustMarkIssuers {
+			issuers[trustMarkType] = b.entityIdentifiers(join("trust_mark_issuers", trustMarkType), identifiers)
+		}
+		cfg.TrustMarkIssuerRetriever = issuers
+	}
+
+	entity := &Entity{}
+	if doc.Intermediate != nil {
+		entity.Subordinates = b.intermediate(*doc.Intermediate, &cfg)
+	}
+	if doc.TrustMarkIssuer != nil {
+		cfg.TrustMarkRetriever = b.trustMarkIssuer(*doc.TrustMarkIssuer, cfg)
+		// the trust mark endpoints are advertised within the federation entity metadata
+		if cfg.EntityConfiguration.Metadata == nil {
+			cfg.EntityConfiguration.Metadata = &model.Metadata{}
+		}
+		if cfg.EntityConfiguration.Metadata.FederationMetadata == nil {
+			cfg.EntityConfiguration.Metadata.FederationMetadata = &model.FederationMetadata{}
+		}
+	}
+
+	entity.Role = b.role(doc)
+	entity.ServerConfiguration = cfg
+	return entity
+}
+
+// role validates the configured role against the sections of the document, or infers the role when none is configured
+func (b *builder) role(doc document) Role {
+	role := Role(doc.Role)
+	switch role {
+	case "":
+		switch {
+		case doc.Intermediate != nil && len(doc.AuthorityHints) > 0:
+			return RoleIntermediate
+		case doc.Intermediate != nil:
+			return RoleTrustAnchor
+		case doc.TrustMarkIssuer != nil:
+			return RoleTrustMarkIssuer
+		default:
+			return RoleLeaf
+		}
+	case RoleLeaf:
+		if doc.Intermediate != nil {
+			b.errors.add("intermediate", "not permitted for role %s", role)
+		}
+		if len(doc.AuthorityHints) == 0 {
+			b.errors.add("authority_hints", "required for role %s", role)
+		}
+	case RoleIntermediate:
+		if doc.Intermediate == nil {
+			b.errors.add("intermediate", "required for role %s", role)
+		}
+		if len(doc.AuthorityHints) == 0 {
+			b.errors.add("authority_hints", "required for role %s", role)
+		}
+	case RoleTrustAnchor:
+		if doc.Intermediate == nil {
+			b.errors.add("intermediate", "required for role %s", role)
+		}
+		if len(doc.AuthorityHints) > 0 {
+			b.errors.add("authority_hints", "not permitted for role %s", role)
+		}
+	case RoleTrustMarkIssuer:
+		if doc.TrustMarkIssuer == nil {
+			b.errors.add("trust_mark_issuer", "required for role %s", role)
+		}
+	default:
+		b.errors.add("role", "must be one of %s, %s, %s or %s", RoleLeaf, RoleIntermediate, RoleTrustAnchor, RoleTrustMarkIssuer)
+	}
+	return role
+}
+
+func (b *builder) intermediate(doc intermediateDocument, cfg *model.ServerConfiguration) *dirstore.Store {
+	cfg.IntermediateConfiguration = &model.IntermediateConfiguration{
+		SubordinateStatementLifetime: b.duration("intermediate.subordinate_statement_lifetime", doc.SubordinateStatementLifetime, defaultLifetime),
+		SubordinateCacheTime:         b.duration("intermediate.subordinate_cache_time", doc.SubordinateCacheTime, 0),
+		RefusePolicyConflicts:        doc.RefusePolicyConflicts,
+	}
+
+	if doc.ExtendedListing != nil {
+		if doc.ExtendedListing.SizeLimit < 0 {
+			b.errors.add("intermediate.extended_listing.size_limit", "must not be negative")
+		}
+		cfg.Extensions.ExtendedListing = model.ExtendedListingConfiguration{
+			Enabled:   doc.ExtendedListing.Enabled,
+			SizeLimit: doc.ExtendedListing.SizeLimit,
+		}
+	}
+	if doc.SubordinateStatus != nil {
+		cfg.Extensions.SubordinateStatus.Enabled = doc.SubordinateStatus.Enabled
+		if doc.SubordinateStatus.ResponseLifetime != "" {
+			cfg.Extensions.SubordinateStatus.ResponseLifetime = model.Pointer(b.duration("intermediate.subordinate_status.response_lifetime", doc.SubordinateStatus.ResponseLifetime, 0))
+		}
+	}
+
+	if doc.ResolveTrace != nil {
+		cfg.Extensions.ResolveTrace.Enabled = doc.ResolveTrace.Enabled
+	}
+
+	signers := map[string]*model.SignerConfiguration{}
+	for i, key := range doc.SigningKeys {
+		path := fmt.Sprintf("intermediate.signing_keys[%d]", i)
+		signer := b.signer(path, key)
+		if signer == nil {
+			continue
+		}
+		if _, ok := signers[signer.KeyID]; ok {
+			b.errors.add(path, "duplicate key ID %q", signer.KeyID)
+			continue
+		}
+		signers[signer.KeyID] = signer
+	}
+
+	if doc.SubordinatesDirectory == "" {
+		if doc.PollInterval != "" {
+			b.errors.add("intermediate.poll_interval", "only permitted alongside 'subordinates_directory'")
+		}
+		if len(doc.SigningKeys) > 0 {
+			b.errors.add("intermediate.signing_keys", "only permitted alongside 'subordinates_directory'")
+		}
+		return nil
+	}
+
+	pollInterval := b.duration("intermediate.poll_interval", doc.PollInterval, 0)
+	if len(b.errors.errors) > 0 {
+		return nil // the directory is only loaded once the rest of the document is known to be valid
+	}
+
+	intermediateConfiguration := cfg.IntermediateConfiguration
+	store, err := dirstore.New(b.ctx, b.path(doc.SubordinatesDirectory), dirstore.Configuration{
+		PollInterval: pollInterval,
+		SignerResolver: func(_ context.Context, keyID string) (*model.SignerConfiguration, error) {
+			signer, ok := signers[keyID]
+			if !ok {
+				return nil, fmt.Errorf("no key with ID %q is configured in 'intermediate.signing_keys'", keyID)
+			}
+			return signer, nil
+		},
+		OnChange: func(_ []model.EntityIdentifier) {
+			intermediateConfiguration.FlushCache()
+		},
+		Logger: b.opts.Logger,
+	})
+	if err != nil {
+		b.errors.add("intermediate.subordinates_directory", "%s", err.Error())
+		return nil
+	}
+	cfg.MetadataRetriever = store
+	return store
+}
+
+func (b *builder) trustMarkIssuer(doc trustMarkIssuerDocument, cfg model.ServerConfiguration) *trustMarkIssuer {
+	issuer := &trustMarkIssuer{
+		issuer:       cfg.EntityIdentifier,
+		signer:       cfg.SignerConfiguration,
+		lifetime:     b.duration("trust_mark_issuer.trust_mark_lifetime", doc.TrustMarkLifetime, 0),
+		entitlements: map[string][]model.EntityIdentifier{},
+	}
+	for trustMarkType, identifiers := range doc.Entitlements {
+		issuer.entitlements[trustMarkType] = b.entityIdentifiers(join("trust_mark_issuer.entitlements", trustMarkType), identifiers)
+	}
+	return issuer
+}
+
+func (b *builder) signer(path string, key keyDocument) *model.SignerConfiguration {
+	signer, err := loadSigner(key, b.opts.BaseDirectory)
+	if err != nil {
+		b.errors.add(path, "%s", err.Error())
+		return nil
+	}
+	return signer
+}
+
+func (b *builder) entityIdentifier(path, value string) *model.EntityIdentifier {
+	identifier, err := model.ValidateEntityIdentifier(value)
+	if err != nil {
+		b.errors.add(path, "%s", err.Error())
+		return nil
+	}
+	return identifier
+}
+
+func (b *builder) entityIdentifiers(path string, values []string) []model.EntityIdentifier {
+	var identifiers []model.EntityIdentifier
+	for i, value := range values {
+		if identifier := b.entityIdentifier(fmt.Sprintf("%s[%d]", path, i), value); identifier != nil && !slices.Contains(identifiers, *identifier) {
+			identifiers = append(identifiers, *identifier)
+		}
+	}
+	return identifiers
+}
+
+func (b *builder) duration(path, value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		b.errors.add(path, "expected a duration such as '24h' or '90m', got %q", value)
+		return fallback
+	}
+	if duration < 0 {
+		b.errors.add(path, "must not be negative")
+		return fallback
+	}
+	return duration
+}
+
+func (b *builder) path(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(b.opts.BaseDirectory, path)
+}
@@ -0,0 +1,400 @@
+package config
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeKeyFiles(t *testing.T, directory string) (pemPath string, jwkPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected no error generating key, got %q", err.Error())
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("expected no error encoding key, got %q", err.Error())
+	}
+	pemPath = filepath.Join(directory, "signing.pem")
+	if err = os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("expected no error writing key, got %q", err.Error())
+	}
+
+	privateJWK, err := jwk.PrivateJwk(key)
+	if err != nil {
+		t.Fatalf("expected no error encoding key, got %q", err.Error())
+	}
+	(*privateJWK)["kid"] = "jwk-key"
+	b, err := json.Marshal(privateJWK)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	jwkPath = filepath.Join(directory, "signing.jwk")
+	if err = os.WriteFile(jwkPath, b, 0o600); err != nil {
+		t.Fatalf("expected no error writing key, got %q", err.Error())
+	}
+	return pemPath, jwkPath
+}
+
+func lookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestLoad(t *testing.T) {
+	directory := t.TempDir()
+	writeKeyFiles(t, directory)
+	if err := os.Mkdir(filepath.Join(directory, "subordinates"), 0o700); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+
+	document := `entity_identifier: https://intermediate.example.com
+signing_key:
+  file: signing.pem
+authority_hints:
+  - https://trust-anchor.example.com
+metadata:
+  federation_entity:
+    organization_name: ${ORGANIZATION_NAME}
+    contacts:
+      - ${CONTACT:-ops@example.com}
+entity_configuration_lifetime: 12h
+intermediate:
+  subordinates_directory: subordinates
+  subordinate_cache_time: 5m
+  extended_listing:
+    enabled: ${EXTENDED_LISTING}
+    size_limit: 100
+  resolve_trace:
+    enabled: true
+  refuse_policy_conflicts: true
+`
+	path := filepath.Join(directory, "federation.yaml")
+	if err := os.WriteFile(path, []byte(document), 0o600); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+
+	entity, err := Load(context.Background(), path, Options{LookupEnv: lookup(map[string]string{
+		"ORGANIZATION_NAME": "Example Org",
+		"EXTENDED_LISTING":  "true",
+	})})
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+
+	if entity.Role != RoleIntermediate {
+		t.Errorf("expected role %s, got %s", RoleIntermediate, entity.Role)
+	}
+	if entity.Subordinates == nil {
+		t.Fatal("expected a subordinate store to be configured")
+	}
+	cfg := entity.ServerConfiguration
+	if cfg.EntityIdentifier != "https://intermediate.example.com" {
+		t.Errorf("unexpected entity identifier %s", cfg.EntityIdentifier)
+	}
+	if diff := cmp.Diff([]model.EntityIdentifier{"https://trust-anchor.example.com"}, cfg.AuthorityHints); diff != "" {
+		t.Errorf("authority hints mismatch (-expected +got):\n%s", diff)
+	}
+	if cfg.EntityConfigurationLifetime != 12*time.Hour {
+		t.Errorf("expected entity configuration lifetime of 12h, got %s", cfg.EntityConfigurationLifetime)
+	}
+	if cfg.SignerConfiguration.Algorithm != "ES256" || len(cfg.SignerConfiguration.KeyID) != 43 {
+		t.Errorf("expected an ES256 signer with a thumbprint key ID, got %s %q", cfg.SignerConfiguration.Algorithm, cfg.SignerConfiguration.KeyID)
+	}
+	if cfg.IntermediateConfiguration == nil || cfg.IntermediateConfiguration.SubordinateCacheTime != 5*time.Minute {
+		t.Errorf("expected a subordinate cache time of 5m")
+	}
+	if !cfg.Extensions.ExtendedListing.Enabled || cfg.Extensions.ExtendedListing.SizeLimit != 100 {
+		t.Errorf("expected extended listing to be enabled with a size limit of 100, got %+v", cfg.Extensions.ExtendedListing)
+	}
+	if !cfg.Extensions.ResolveTrace.Enabled {
+		t.Errorf("expected the resolve trace debug mode to be enabled")
+	}
+	if !cfg.IntermediateConfiguration.RefusePolicyConflicts {
+		t.Errorf("expected subordinate policy conflicts to be refused")
+	}
+	if cfg.MetadataRetriever != entity.Subordinates {
+		t.Errorf("expected the subordinate store to be used as the metadata retriever")
+	}
+
+	federationMetadata := *cfg.EntityConfiguration.Metadata.FederationMetadata
+	if federationMetadata["organization_name"] != "Example Org" {
+		t.Errorf("expected substituted organization name, got %v", federationMetadata["organization_name"])
+	}
+	if diff := cmp.Diff([]any{"ops@example.com"}, federationMetadata["contacts"]); diff != "" {
+		t.Errorf("contacts mismatch (-expected +got):\n%s", diff)
+	}
+}
+
+func TestParse_Roles(t *testing.T) {
+	directory := t.TempDir()
+	writeKeyFiles(t, directory)
+
+	tests := map[string]struct {
+		document     string
+		expectedRole Role
+	}{
+		"leaf": {
+			document: `{
+				"entity_identifier": "https://leaf.example.com",
+				"signing_key": {"file": "signing.pem"},
+				"authority_hints": ["https://intermediate.example.com"]
+			}`,
+			expectedRole: RoleLeaf,
+		},
+		"trust anchor": {
+			document: `{
+				"entity_identifier": "https://trust-anchor.example.com",
+				"signing_key": {"file": "signing.pem"},
+				"intermediate": {}
+			}`,
+			expectedRole: RoleTrustAnchor,
+		},
+		"trust mark issuer": {
+			document: `{
+				"entity_identifier": "https://tmi.example.com",
+				"signing_key": {"file": "signing.jwk"},
+				"authority_hints": ["https://trust-anchor.example.com"],
+				"trust_mark_issuer": {
+					"trust_mark_lifetime": "720h",
+					"entitlements": {"https://tmi.example.com/certified": ["https://leaf.example.com"]}
+				}
+			}`,
+			expectedRole: RoleTrustMarkIssuer,
+		},
+		"explicit role": {
+			document: `{
+				"role": "trust_anchor",
+				"entity_identifier": "https://trust-anchor.example.com",
+				"signing_key": {"file": "signing.pem"},
+				"intermediate": {}
+			}`,
+			expectedRole: RoleTrustAnchor,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			entity, err := Parse(context.Background(), []byte(tt.document), Options{BaseDirectory: directory})
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if entity.Role != tt.expectedRole {
+				t.Errorf("expected role %s, got %s", tt.expectedRole, entity.Role)
+			}
+		})
+	}
+}
+
+func TestParse_TrustMarkIssuer(t *testing.T) {
+	directory := t.TempDir()
+	writeKeyFiles(t, directory)
+
+	entity, err := Parse(context.Background(), []byte(`{
+		"entity_identifier": "https://tmi.example.com",
+		"signing_key": {"file": "signing.jwk"},
+		"trust_mark_issuer": {
+			"entitlements": {"https://tmi.example.com/certified": ["https://leaf.example.com"]}
+		}
+	}`), Options{BaseDirectory: directory})
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if entity.ServerConfiguration.SignerConfiguration.KeyID != "jwk-key" {
+		t.Errorf("expected the JWK's key ID to be used, got %q", entity.ServerConfiguration.SignerConfiguration.KeyID)
+	}
+	if entity.ServerConfiguration.EntityConfiguration.Metadata.FederationMetadata == nil {
+		t.Errorf("expected federation entity metadata to be present for the trust mark endpoints")
+	}
+
+	ctx := context.Background()
+	retriever := entity.ServerConfiguration.TrustMarkRetriever
+	trustMark, err := retriever.IssueTrustMark(ctx, "https://tmi.example.com/certified", "https://leaf.example.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	status, err := retriever.GetTrustMarkStatus(ctx, *trustMark)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if *status != trustMarkStatusActive {
+		t.Errorf("expected status %s, got %s", trustMarkStatusActive, *status)
+	}
+}
+
+func TestTrustMarkIssuer_RemoteSigner(t *testing.T) {
+	key, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	issuer := &trustMarkIssuer{
+		issuer:       "https://tmi.example.com",
+		signer:       model.SignerConfiguration{RemoteSigner: model.NewCryptoRemoteSigner(key, "remote-key", "ES256")},
+		entitlements: map[string][]model.EntityIdentifier{"https://tmi.example.com/certified": {"https://leaf.example.com"}},
+	}
+
+	ctx := context.Background()
+	trustMark, err := issuer.IssueTrustMark(ctx, "https://tmi.example.com/certified", "https://leaf.example.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	status, err := issuer.GetTrustMarkStatus(ctx, *trustMark)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if *status != trustMarkStatusActive {
+		t.Errorf("expected status %s, got %s", trustMarkStatusActive, *status)
+	}
+}
+
+func TestParse_ValidationErrors(t *testing.T) {
+	directory := t.TempDir()
+	writeKeyFiles(t, directory)
+
+	tests := map[string]struct {
+		document       string
+		env            map[string]string
+		expectedErrors []FieldError
+	}{
+		"missing required fields": {
+			document: `{"authority_hints": ["https://intermediate.example.com"]}`,
+			expectedErrors: []FieldError{
+				{Path: "entity_identifier", Message: "required"},
+				{Path: "signing_key", Message: "required"},
+			},
+		},
+		"field paths": {
+			document: `
+entity_identifier: https://leaf.example.com
+signing_key:
+  file: signing.pem
+authority_hints:
+  - https://intermediate.example.com
+  - http://insecure.example.com
+entity_configuration_lifetime: forever
+`,
+			expectedErrors: []FieldError{
+				{Path: "entity_configuration_lifetime", Message: `expected a duration such as '24h' or '90m', got "forever"`},
+				{Path: "authority_hints[1]", Message: "entity identifiers must use the https scheme"},
+			},
+		},
+		"unknown and mistyped fields": {
+			document: `{
+				"entity_identifier": "https://leaf.example.com",
+				"signing_key": {"file": "signing.pem", "passphrase": "secret"},
+				"authority_hints": "https://intermediate.example.com",
+				"intermediate": {"extended_listing": {"size_limit": 1.5}}
+			}`,
+			expectedErrors: []FieldError{
+				{Path: "authority_hints", Message: "expected an array, got a string"},
+				{Path: "intermediate.extended_listing.size_limit", Message: "expected an integer, got 1.5"},
+				{Path: "signing_key.passphrase", Message: "unknown field"},
+			},
+		},
+		"unset environment variable": {
+			document: `{
+				"entity_identifier": "${ENTITY_IDENTIFIER}",
+				"signing_key": {"file": "signing.pem"},
+				"authority_hints": ["https://intermediate.example.com"]
+			}`,
+			expectedErrors: []FieldError{
+				{Path: "entity_identifier", Message: "environment variable ENTITY_IDENTIFIER is not set"},
+			},
+		},
+		"role mismatch": {
+			document: `{
+				"role": "intermediate",
+				"entity_identifier": "https://intermediate.example.com",
+				"signing_key": {"file": "signing.pem"}
+			}`,
+			expectedErrors: []FieldError{
+				{Path: "intermediate", Message: "required for role intermediate"},
+				{Path: "authority_hints", Message: "required for role intermediate"},
+			},
+		},
+		"invalid signing key": {
+			document: `{
+				"entity_identifier": "https://leaf.example.com",
+				"signing_key": {"file": "signing.pem", "algorithm": "RS256"},
+				"authority_hints": ["https://intermediate.example.com"],
+				"intermediate": {"signing_keys": [{"pem": "not a key"}]}
+			}`,
+			expectedErrors: []FieldError{
+				{Path: "signing_key", Message: "algorithm RS256 cannot be used with the provided key"},
+				{Path: "intermediate.signing_keys[0]", Message: "no PEM encoded key found"},
+				{Path: "intermediate.signing_keys", Message: "only permitted alongside 'subordinates_directory'"},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(context.Background(), []byte(tt.document), Options{BaseDirectory: directory, LookupEnv: lookup(tt.env)})
+			if err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a validation error, got %q", err.Error())
+			}
+			if diff := cmp.Diff(tt.expectedErrors, validationErr.Errors); diff != "" {
+				t.Errorf("errors mismatch (-expected +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExpand(t *testing.T) {
+	env := lookup(map[string]string{"HOST": "example.com", "EMPTY": ""})
+
+	tests := map[string]struct {
+		value         string
+		expected      string
+		expectedError string
+	}{
+		"no references":      {value: "https://example.com", expected: "https://example.com"},
+		"reference":          {value: "https://${HOST}/federation", expected: "https://example.com/federation"},
+		"default when unset": {value: "${PORT:-8443}", expected: "8443"},
+		"default when empty": {value: "${EMPTY:-fallback}", expected: "fallback"},
+		"escaped":            {value: "$${HOST} is ${HOST}", expected: "${HOST} is example.com"},
+		"unset":              {value: "${PORT}", expectedError: "environment variable PORT is not set"},
+		"unterminated":       {value: "${HOST", expectedError: "unterminated environment variable reference"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := expand(tt.value, env)
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Fatalf("expected error %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
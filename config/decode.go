@@ -0,0 +1,261 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FieldError describes a problem with a single field of a configuration document
+type FieldError struct {
+	Path    string // Path locates the field within the document, such as 'intermediate.subordinate_cache_time' or 'authority_hints[1]'
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationError holds every problem found with a configuration document
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldError := range e.Errors {
+		messages[i] = fieldError.Error()
+	}
+	return "invalid configuration:\n\t" + strings.Join(messages, "\n\t")
+}
+
+// errorCollector accumulates field errors so every problem with a document is reported at once
+type errorCollector struct {
+	errors []FieldError
+}
+
+func (c *errorCollector) add(path, format string, args ...any) {
+	c.errors = append(c.errors, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *errorCollector) err() error {
+	if len(c.errors) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: c.errors}
+}
+
+func join(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// substitute replaces ${NAME} references within every string of the document with the value of the named environment
+// variable. A default may be given as ${NAME:-default}, and a literal '${' is written as '$${'
+func substitute(value any, path string, lookup func(string) (string, bool), errors *errorCollector) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, element := range v {
+			v[key] = substitute(element, join(path, key), lookup, errors)
+		}
+		return v
+	case []any:
+		for i, element := range v {
+			v[i] = substitute(element, fmt.Sprintf("%s[%d]", path, i), lookup, errors)
+		}
+		return v
+	case string:
+		substituted, err := expand(v, lookup)
+		if err != nil {
+			errors.add(path, "%s", err.Error())
+			return v
+		}
+		return substituted
+	default:
+		return v
+	}
+}
+
+func expand(value string, lookup func(string) (string, bool)) (string, error) {
+	var builder strings.Builder
+	for {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			builder.WriteString(value)
+			return builder.String(), nil
+		}
+		if start > 0 && value[start-1] == '$' {
+			builder.WriteString(value[:start-1] + "${")
+			value = value[start+2:]
+			continue
+		}
+		end := strings.Index(value[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated environment variable reference")
+		}
+		reference := value[start+2 : start+end]
+		name, fallback, hasFallback := strings.Cut(reference, ":-")
+		if name == "" {
+			return "", fmt.Errorf("empty environment variable reference")
+		}
+		resolved, ok := lookup(name)
+		if !ok || (resolved == "" && hasFallback) {
+			if !hasFallback {
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+			resolved = fallback
+		}
+		builder.WriteString(value[:start] + resolved)
+		value = value[start+end+1:]
+	}
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// decode assigns a parsed document to the value pointed to by target, following the json tags of target's fields.
+// Unlike encoding/json, every mismatch is reported against the path of the offending field, unknown fields are
+// rejected, and strings are accepted for boolean and numeric fields so values can be supplied through substitution.
+// json.RawMessage fields receive the re-encoded subtree, to be parsed later by the relevant model type
+func decode(value any, target reflect.Value, path string, errors *errorCollector) {
+	if value == nil {
+		return
+	}
+
+	if target.Type() == rawMessageType {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			errors.add(path, "%s", err.Error())
+			return
+		}
+		target.SetBytes(raw)
+		return
+	}
+
+	switch target.Kind() {
+	case reflect.Pointer:
+		element := reflect.New(target.Type().Elem())
+		decode(value, element.Elem(), path, errors)
+		target.Set(element)
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok {
+			errors.add(path, "expected an object, got %s", describe(value))
+			return
+		}
+		fields := map[string]reflect.Value{}
+		for i := range target.NumField() {
+			name, _, _ := strings.Cut(target.Type().Field(i).Tag.Get("json"), ",")
+			fields[name] = target.Field(i)
+		}
+		for _, key := range sortedKeys(object) {
+			field, ok := fields[key]
+			if !ok {
+				errors.add(join(path, key), "unknown field")
+				continue
+			}
+			decode(object[key], field, join(path, key), errors)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]any)
+		if !ok {
+			errors.add(path, "expected an object, got %s", describe(value))
+			return
+		}
+		result := reflect.MakeMapWithSize(target.Type(), len(object))
+		for _, key := range sortedKeys(object) {
+			element := reflect.New(target.Type().Elem()).Elem()
+			decode(object[key], element, join(path, key), errors)
+			result.SetMapIndex(reflect.ValueOf(key), element)
+		}
+		target.Set(result)
+	case reflect.Slice:
+		array, ok := value.([]any)
+		if !ok {
+			errors.add(path, "expected an array, got %s", describe(value))
+			return
+		}
+		result := reflect.MakeSlice(target.Type(), len(array), len(array))
+		for i, element := range array {
+			decode(element, result.Index(i), fmt.Sprintf("%s[%d]", path, i), errors)
+		}
+		target.Set(result)
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			errors.add(path, "expected a string, got %s", describe(value))
+			return
+		}
+		target.SetString(s)
+	case reflect.Bool:
+		switch v := value.(type) {
+		case bool:
+			target.SetBool(v)
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errors.add(path, "expected a boolean, got %q", v)
+				return
+			}
+			target.SetBool(b)
+		default:
+			errors.add(path, "expected a boolean, got %s", describe(value))
+		}
+	case reflect.Int:
+		switch v := value.(type) {
+		case int:
+			target.SetInt(int64(v))
+		case float64:
+			if v != math.Trunc(v) {
+				errors.add(path, "expected an integer, got %v", v)
+				return
+			}
+			target.SetInt(int64(v))
+		case string:
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				errors.add(path, "expected an integer, got %q", v)
+				return
+			}
+			target.SetInt(int64(i))
+		default:
+			errors.add(path, "expected an integer, got %s", describe(value))
+		}
+	default:
+		errors.add(path, "unsupported field type %s", target.Type())
+	}
+}
+
+func sortedKeys(object map[string]any) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func describe(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "an array"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case int, float64:
+		return "a number"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/internal/signing"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+// keyDocument describes a private signing key, provided either as a file holding a PEM or JWK encoded key, or inline
+type keyDocument struct {
+	File      string          `json:"file"`
+	PEM       string          `json:"pem"`
+	JWK       json.RawMessage `json:"jwk"`
+	KeyID     string          `json:"key_id"`
+	Algorithm string          `json:"algorithm"`
+}
+
+// loadSigner builds a signer configuration from a key document. Unless set explicitly, the key ID is taken from the
+// JWK's 'kid' or is otherwise the key's RFC 7638 thumbprint, and the algorithm is inferred from the key type
+func loadSigner(document keyDocument, baseDirectory string) (*model.SignerConfiguration, error) {
+	var sources []string
+	for source, set := range map[string]bool{"file": document.File != "", "pem": document.PEM != "", "jwk": len(document.JWK) > 0} {
+		if set {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) != 1 {
+		return nil, fmt.Errorf("exactly one of 'file', 'pem' or 'jwk' must be set")
+	}
+
+	var (
+		key   crypto.PrivateKey
+		keyID = document.KeyID
+		err   error
+	)
+	switch {
+	case document.File != "":
+		path := document.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDirectory, path)
+		}
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", readErr)
+		}
+		if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+			key, keyID, err = parseJWK(data, keyID)
+		} else {
+			key, err = parsePEM(data)
+		}
+	case document.PEM != "":
+		key, err = parsePEM([]byte(document.PEM))
+	default:
+		key, keyID, err = parseJWK(document.JWK, keyID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	algorithm, err := keyAlgorithm(privateKey, document.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	// crypto.Signer implementations produce ASN.1 encoded ECDSA signatures, so the key is wrapped in a signer producing
+	// signatures in the form required by JWS
+	signer, err := jws.GetSignerFromPrivateKey(josemodel.GetAlgorithm(algorithm), privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signer: %w", err)
+	}
+
+	if keyID == "" {
+		publicJWK, err := jwk.PublicJwk(signer.Public())
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode public key: %w", err)
+		}
+		if keyID, err = signing.Thumbprint(*publicJWK); err != nil {
+			return nil, fmt.Errorf("failed to compute key thumbprint: %w", err)
+		}
+	}
+
+	return &model.SignerConfiguration{Signer: signer, KeyID: keyID, Algorithm: algorithm}, nil
+}
+
+func parsePEM(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key found")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PKCS #8 private key: %w", err)
+		}
+		return key, nil
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse EC private key: %w", err)
+		}
+		return key, nil
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RSA private key: %w", err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+// parseJWK parses a private JWK, returning its 'kid' when no key ID has been set explicitly
+func parseJWK(data []byte, keyID string) (key crypto.PrivateKey, kid string, err error) {
+	var privateJWK map[string]any
+	if err = json.Unmarshal(data, &privateJWK); err != nil {
+		return nil, "", fmt.Errorf("failed to parse JWK: %w", err)
+	}
+	if keyID == "" {
+		keyID, _ = privateJWK["kid"].(string)
+	}
+
+	// the JWK parser can panic on malformed keys
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to parse JWK: malformed key: %v", r)
+		}
+	}()
+	if key, err = jwk.PrivateFromJwk(privateJWK); err != nil {
+		return nil, "", fmt.Errorf("failed to parse JWK: %w", err)
+	}
+	return key, keyID, nil
+}
+
+// keyAlgorithm validates a configured algorithm against the key, or infers one when none is configured
+func keyAlgorithm(signer crypto.Signer, configured string) (string, error) {
+	var permitted []josemodel.Algorithm
+	switch public := signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		switch public.Curve {
+		case elliptic.P256():
+			permitted = []josemodel.Algorithm{josemodel.ES256}
+		case elliptic.P384():
+			permitted = []josemodel.Algorithm{josemodel.ES384}
+		case elliptic.P521():
+			permitted = []josemodel.Algorithm{josemodel.ES512}
+		default:
+			return "", fmt.Errorf("unsupported elliptic curve %s", public.Curve.Params().Name)
+		}
+	case *rsa.PublicKey:
+		permitted = []josemodel.Algorithm{josemodel.RS256, josemodel.RS384, josemodel.RS512, josemodel.PS256, josemodel.PS384, josemodel.PS512}
+	default:
+		return "", fmt.Errorf("unsupported key type %T", public)
+	}
+
+	if configured == "" {
+		return permitted[0].String(), nil
+	}
+	if !slices.Contains(permitted, josemodel.GetAlgorithm(configured)) {
+		return "", fmt.Errorf("algorithm %s cannot be used with the provided key", configured)
+	}
+	return configured, nil
+}
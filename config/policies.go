@@ -0,0 +1,43 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/MichaelFraser99/go-openid-federation/server"
+)
+
+const policyCheckTimeout = 15 * time.Second
+
+// CheckPolicies combines the metadata policy configured for each subordinate with the policies the entity's superiors
+// publish for it, logging any conflicts, and applies the server's subordinate validator to subordinate files changed
+// while Subordinates.Watch is running. When 'intermediate.refuse_policy_conflicts' is set an error is returned if the
+// configured policies conflict, and changed files with conflicting policies are rejected. It must be called before
+// Subordinates.Watch
+func (e *Entity) CheckPolicies(ctx context.Context, federationServer *server.Server, logger *slog.Logger) error {
+	checkCtx, cancel := context.WithTimeout(ctx, policyCheckTimeout)
+	defer cancel()
+	report, err := federationServer.CheckPolicyCompatibility(checkCtx)
+	switch {
+	case err != nil:
+		logger.ErrorContext(ctx, "unable to check subordinate policies", slog.String("error", err.Error()))
+	case report.Compatible:
+		logger.InfoContext(ctx, "subordinate policies are compatible with the policies of superiors", slog.Int("unchecked_superiors", len(report.UncheckedSuperiors)))
+	case e.refusePolicyConflicts():
+		return fmt.Errorf("refusing configuration: %w", report.Err())
+	default:
+		logger.WarnContext(ctx, "one or more subordinate policies conflict with the policies of superiors")
+	}
+
+	if e.Subordinates != nil {
+		e.Subordinates.SetValidator(federationServer.NewSubordinateValidator)
+	}
+	return nil
+}
+
+func (e *Entity) refusePolicyConflicts() bool {
+	intermediate := e.ServerConfiguration.IntermediateConfiguration
+	return intermediate != nil && intermediate.RefusePolicyConflicts
+}
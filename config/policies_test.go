@@ -0,0 +1,158 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/MichaelFraser99/go-openid-federation/model_test"
+	"github.com/MichaelFraser99/go-openid-federation/server"
+	"github.com/MichaelFraser99/go-openid-federation/store/dirstore"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEntity_CheckPolicies(t *testing.T) {
+	newKey := func(t *testing.T, keyID string) (josemodel.Jwks, model.SignerConfiguration) {
+		signer, err := jws.GetSigner(josemodel.ES256, nil)
+		if err != nil {
+			t.Fatalf("expected no error creating signer, got %q", err.Error())
+		}
+		publicJWK, err := jwk.PublicJwk(signer.Public())
+		if err != nil {
+			t.Fatalf("expected no error creating JWK, got %q", err.Error())
+		}
+		(*publicJWK)["kid"] = keyID
+		return josemodel.Jwks{Keys: []map[string]any{*publicJWK}}, model.SignerConfiguration{Signer: signer, KeyID: keyID, Algorithm: "ES256"}
+	}
+	writeSubordinate := func(t *testing.T, directory, name, entityID string, grantTypes map[string]any) {
+		jwks, _ := newKey(t, name+"-key")
+		b, err := json.Marshal(map[string]any{
+			"entity_id": entityID,
+			"jwks":      jwks,
+			"metadata_policy": map[string]any{
+				"openid_relying_party": map[string]any{"grant_types": grantTypes},
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+		if err = os.WriteFile(filepath.Join(directory, name), b, 0o600); err != nil {
+			t.Fatalf("expected no error writing %s, got %q", name, err.Error())
+		}
+	}
+
+	tests := map[string]struct {
+		refuse           bool
+		grantTypes       map[string]any
+		expectedError    string
+		expectedRejected []string
+	}{
+		"compatible policies are accepted and conflicting changes refused": {
+			refuse:           true,
+			grantTypes:       map[string]any{"subset_of": []any{"authorization_code"}},
+			expectedRejected: []string{"conflicting.json"},
+		},
+		"conflicting policies are refused": {
+			refuse:        true,
+			grantTypes:    map[string]any{"value": []any{"implicit"}},
+			expectedError: "refusing configuration: metadata policy for",
+		},
+		"conflicting policies are only reported when not refused": {
+			grantTypes: map[string]any{"value": []any{"implicit"}},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var fetches atomic.Int32
+			mux := http.NewServeMux()
+			s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/ta/fetch" {
+					fetches.Add(1)
+				}
+				mux.ServeHTTP(w, r)
+			}))
+			defer s.Close()
+
+			intJWKs, intSigner := newKey(t, "int-key")
+			_, taSigner := newKey(t, "ta-key")
+			taConfiguration := &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour}
+			_ = taConfiguration.AddSubordinate(model.EntityIdentifier(s.URL+"/int"), &model.SubordinateConfiguration{
+				JWKs: intJWKs,
+				Policies: model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+					"grant_types": {Metadata: []model.MetadataPolicyOperator{model_test.NewSubsetOf(t, []any{"authorization_code", "refresh_token"})}},
+				}},
+			})
+			taMux := http.NewServeMux()
+			server.NewServer(model.ServerConfiguration{
+				EntityIdentifier:            model.EntityIdentifier(s.URL + "/ta"),
+				SignerConfiguration:         taSigner,
+				EntityConfigurationLifetime: time.Hour,
+				IntermediateConfiguration:   taConfiguration,
+			}).Configure(taMux)
+			mux.Handle("/ta/", http.StripPrefix("/ta", taMux))
+
+			directory := t.TempDir()
+			writeSubordinate(t, directory, "leaf.json", s.URL+"/leaf", tt.grantTypes)
+			subordinates, err := dirstore.New(t.Context(), directory, dirstore.Configuration{})
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			entity := &Entity{
+				Role: RoleIntermediate,
+				ServerConfiguration: model.ServerConfiguration{
+					Configuration:               model.Configuration{HttpClient: s.Client()},
+					EntityIdentifier:            model.EntityIdentifier(s.URL + "/int"),
+					SignerConfiguration:         intSigner,
+					EntityConfigurationLifetime: time.Hour,
+					AuthorityHints:              []model.EntityIdentifier{model.EntityIdentifier(s.URL + "/ta")},
+					IntermediateConfiguration:   &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour, RefusePolicyConflicts: tt.refuse},
+					MetadataRetriever:           subordinates,
+				},
+				Subordinates: subordinates,
+			}
+
+			err = entity.CheckPolicies(t.Context(), server.NewServer(entity.ServerConfiguration), slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+
+			fetches.Store(0)
+			writeSubordinate(t, directory, "compatible.json", s.URL+"/compatible", map[string]any{"subset_of": []any{"refresh_token"}})
+			writeSubordinate(t, directory, "conflicting.json", s.URL+"/conflicting", map[string]any{"value": []any{"implicit"}})
+			if err = subordinates.Reload(t.Context()); err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if fetches.Load() != 1 {
+				t.Errorf("expected the superior's statement to be fetched once for the reload, got %d", fetches.Load())
+			}
+			var rejected []string
+			for file := range subordinates.Rejected() {
+				rejected = append(rejected, file)
+			}
+			slices.Sort(rejected)
+			if diff := cmp.Diff(tt.expectedRejected, rejected); diff != "" {
+				t.Errorf("rejected files mismatch (-expected +got):\n%s", diff)
+			}
+		})
+	}
+}
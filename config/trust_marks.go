@@ -0,0 +1,99 @@
+package config
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/internal/signing"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+const (
+	trustMarkStatusActive  = "active"
+	trustMarkStatusExpired = "expired"
+	trustMarkStatusRevoked = "revoked"
+	trustMarkStatusInvalid = "invalid"
+)
+
+var (
+	_ model.TrustMarkRetriever       = &trustMarkIssuer{}
+	_ model.TrustMarkIssuerRetriever = trustMarkIssuers{}
+)
+
+// trustMarkIssuer issues trust marks to the entities entitled to each trust mark type by the configuration document.
+// It holds no state: the status of a trust mark is derived from its signature, its expiry and whether its subject
+// remains entitled to the trust mark type, so removing an entitlement revokes every trust mark previously issued for it
+type trustMarkIssuer struct {
+	issuer       model.EntityIdentifier
+	signer       model.SignerConfiguration
+	lifetime     time.Duration
+	entitlements map[string][]model.EntityIdentifier
+}
+
+func (i *trustMarkIssuer) IssueTrustMark(ctx context.Context, trustMarkIdentifier string, entityIdentifier model.EntityIdentifier) (*string, error) {
+	if !slices.Contains(i.entitlements[trustMarkIdentifier], entityIdentifier) {
+		return nil, model.NewNotFoundError(fmt.Sprintf("entity %s is not entitled to trust mark type %s", entityIdentifier, trustMarkIdentifier))
+	}
+	iat := time.Now().UTC()
+	body := map[string]any{
+		"iss":             i.issuer,
+		"sub":             entityIdentifier,
+		"trust_mark_type": trustMarkIdentifier,
+		"iat":             iat.Unix(),
+	}
+	if i.lifetime > 0 {
+		body["exp"] = iat.Add(i.lifetime).Unix()
+	}
+	return signing.New(ctx, i.signer, "trust-mark+jwt", body)
+}
+
+func (i *trustMarkIssuer) GetTrustMarkStatus(ctx context.Context, trustMark string) (*string, error) {
+	publicJWK, err := signing.PublicJWK(ctx, i.signer)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := jwk.PublicFromJwk(publicJWK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse trust mark signing key: %w", err)
+	}
+	_, body, err := jws.VerifyCompactSerialization(trustMark, func() ([]crypto.PublicKey, error) {
+		return []crypto.PublicKey{publicKey}, nil
+	}, &josemodel.JoseOptions{})
+	if err != nil || body["iss"] != string(i.issuer) {
+		return model.Pointer(trustMarkStatusInvalid), nil
+	}
+
+	trustMarkType, _ := body["trust_mark_type"].(string)
+	sub, _ := body["sub"].(string)
+	if !slices.Contains(i.entitlements[trustMarkType], model.EntityIdentifier(sub)) {
+		return model.Pointer(trustMarkStatusRevoked), nil
+	}
+	if exp, ok := body["exp"].(float64); ok && time.Now().Unix() >= int64(exp) {
+		return model.Pointer(trustMarkStatusExpired), nil
+	}
+	return model.Pointer(trustMarkStatusActive), nil
+}
+
+func (i *trustMarkIssuer) ListTrustMarks(_ context.Context, trustMarkIdentifier string, identifier *model.EntityIdentifier) ([]model.EntityIdentifier, error) {
+	entities := []model.EntityIdentifier{}
+	for _, entity := range i.entitlements[trustMarkIdentifier] {
+		if identifier == nil || entity == *identifier {
+			entities = append(entities, entity)
+		}
+	}
+	return entities, nil
+}
+
+// trustMarkIssuers publishes the trust mark issuers listed by the configuration document
+type trustMarkIssuers map[string][]model.EntityIdentifier
+
+func (t trustMarkIssuers) ListTrustMarkIssuers(_ context.Context) (map[string][]model.EntityIdentifier, error) {
+	return maps.Clone(t), nil
+}
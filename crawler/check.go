@@ -0,0 +1,214 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MichaelFraser99/go-openid-federation/internal/entity_statement"
+	"github.com/MichaelFraser99/go-openid-federation/internal/signing"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+// Severity describes how serious a Finding is
+type Severity string
+
+const (
+	SeverityError   Severity = "error"   // SeverityError findings prevent trust chains from being built or resolved
+	SeverityWarning Severity = "warning" // SeverityWarning findings leave the federation inconsistent without breaking resolution
+)
+
+// FindingCode identifies the kind of problem a Finding reports
+type FindingCode string
+
+const (
+	FindingUnreachableEndpoint         FindingCode = "unreachable_endpoint"
+	FindingInvalidEntityConfiguration  FindingCode = "invalid_entity_configuration"
+	FindingInvalidSubordinateStatement FindingCode = "invalid_subordinate_statement"
+	FindingInvalidListing              FindingCode = "invalid_listing"
+	FindingKeyMismatch                 FindingCode = "key_mismatch"
+	FindingMissingAuthorityHint        FindingCode = "missing_authority_hint"
+	FindingExpiredStatement            FindingCode = "expired_statement"
+	FindingPolicyViolation             FindingCode = "policy_violation"
+)
+
+// Finding is a consistency problem found within a crawled federation
+type Finding struct {
+	Code     FindingCode            `json:"code"`
+	Severity Severity               `json:"severity"`
+	Entity   model.EntityIdentifier `json:"entity"`             // Entity is the entity the problem was found with
+	Superior model.EntityIdentifier `json:"superior,omitempty"` // Superior is set when the problem concerns the statement a superior issued about the entity
+	Message  string                 `json:"message"`
+}
+
+// Check reports the consistency problems within the graph as of the given time. For every subordinate it compares the
+// keys registered by each superior with the key signing the subordinate's entity configuration, confirms the
+// subordinate lists the superior in its authority hints, checks neither statement has expired and applies the metadata
+// policies along the shortest path from the trust anchor to the subordinate's metadata. Statements rejected as expired and
+// endpoints that could not be reached during the crawl are reported alongside. Findings are ordered by entity, superior
+// and code
+func (g *Graph) Check(now time.Time) []Finding {
+	findings := []Finding{}
+	add := func(code FindingCode, severity Severity, entity, superior model.EntityIdentifier, format string, args ...any) {
+		findings = append(findings, Finding{Code: code, Severity: severity, Entity: entity, Superior: superior, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, identifier := range g.Identifiers() {
+		entity := g.Entities[identifier]
+		if entity.EntityConfiguration == nil {
+			for _, err := range entity.Errors {
+				add(classify(err, FindingInvalidEntityConfiguration), SeverityError, identifier, "", "%s", err.Error())
+			}
+			continue
+		}
+		for _, err := range entity.Errors {
+			add(classify(err, FindingInvalidListing), SeverityError, identifier, "", "%s", err.Error())
+		}
+		if expired(entity.EntityConfiguration.Exp, now) {
+			add(FindingExpiredStatement, SeverityError, identifier, "", "entity configuration expired at %s", formatTime(entity.EntityConfiguration.Exp))
+		}
+	}
+
+	paths := g.shortestPaths()
+	for _, edge := range g.Edges {
+		if edge.SubordinateStatement == nil {
+			if edge.Err != nil {
+				add(classify(edge.Err, FindingInvalidSubordinateStatement), SeverityError, edge.Subordinate, edge.Superior, "%s", edge.Err.Error())
+			}
+			continue
+		}
+		if expired(edge.SubordinateStatement.Exp, now) {
+			add(FindingExpiredStatement, SeverityError, edge.Subordinate, edge.Superior, "subordinate statement expired at %s", formatTime(edge.SubordinateStatement.Exp))
+		}
+
+		subordinate := g.Entities[edge.Subordinate]
+		if subordinate.EntityConfiguration == nil {
+			continue // already reported against the subordinate
+		}
+		if err := compareKeys(edge, subordinate); err != nil {
+			add(FindingKeyMismatch, SeverityError, edge.Subordinate, edge.Superior, "%s", err.Error())
+		}
+		if !slices.Contains(subordinate.EntityConfiguration.AuthorityHints, edge.Superior) {
+			add(FindingMissingAuthorityHint, SeverityWarning, edge.Subordinate, edge.Superior, "entity configuration does not list %s in its authority hints", edge.Superior)
+		}
+		if path, ok := paths[edge.Superior]; ok {
+			if err := g.applyPolicies(append(slices.Clip(path), edge), subordinate); err != nil {
+				add(FindingPolicyViolation, SeverityError, edge.Subordinate, edge.Superior, "metadata does not satisfy the metadata policy of the chain through %s: %s", edge.Superior, err.Error())
+			}
+		}
+	}
+
+	slices.SortStableFunc(findings, func(a, b Finding) int {
+		if c := strings.Compare(string(a.Entity), string(b.Entity)); c != 0 {
+			return c
+		}
+		if c := strings.Compare(string(a.Superior), string(b.Superior)); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.Code), string(b.Code))
+	})
+	return findings
+}
+
+// classify distinguishes expired statements and failures to reach an endpoint from otherwise invalid responses
+func classify(err error, invalid FindingCode) FindingCode {
+	if errors.Is(err, model.ErrStatementExpired) {
+		return FindingExpiredStatement
+	}
+	var statementError *model.StatementError
+	if errors.As(err, &statementError) {
+		switch statementError.Cause {
+		case model.ChainFailureNetwork, model.ChainFailureHTTPStatus:
+			return FindingUnreachableEndpoint
+		}
+	}
+	return invalid
+}
+
+// compareKeys checks that the subordinate statement registers the key the subordinate signs its entity configuration
+// with, and that the registered key material matches the key the subordinate publishes
+func compareKeys(edge *Edge, subordinate *Entity) error {
+	keyID, _, _, err := entity_statement.ExtractDetails(subordinate.SignedEntityConfiguration)
+	if err != nil {
+		return fmt.Errorf("malformed entity configuration: %s", err.Error())
+	}
+	registered := findKey(edge.SubordinateStatement.JWKs.Keys, *keyID)
+	if registered == nil {
+		return fmt.Errorf("subordinate statement does not register key %q used to sign the entity configuration", *keyID)
+	}
+	published := findKey(subordinate.EntityConfiguration.JWKs.Keys, *keyID)
+	if published == nil {
+		return fmt.Errorf("entity configuration does not publish its signing key %q", *keyID)
+	}
+	registeredThumbprint, err := signing.Thumbprint(registered)
+	if err != nil {
+		return fmt.Errorf("registered key %q is malformed: %s", *keyID, err.Error())
+	}
+	publishedThumbprint, err := signing.Thumbprint(published)
+	if err != nil {
+		return fmt.Errorf("published key %q is malformed: %s", *keyID, err.Error())
+	}
+	if registeredThumbprint != publishedThumbprint {
+		return fmt.Errorf("registered key %q does not match the key signing the entity configuration", *keyID)
+	}
+	return nil
+}
+
+func findKey(keys []map[string]any, keyID string) map[string]any {
+	for _, key := range keys {
+		if key["kid"] == keyID {
+			return key
+		}
+	}
+	return nil
+}
+
+// shortestPaths returns, for each entity reachable from the trust anchor through retrieved subordinate statements,
+// the edges along its shortest path from the trust anchor. Ties are broken by edge order so the result is stable
+func (g *Graph) shortestPaths() map[model.EntityIdentifier][]*Edge {
+	paths := map[model.EntityIdentifier][]*Edge{g.TrustAnchor: nil}
+	for frontier := []model.EntityIdentifier{g.TrustAnchor}; len(frontier) > 0; {
+		var next []model.EntityIdentifier
+		for _, identifier := range frontier {
+			for _, edge := range g.Subordinates(identifier) {
+				if _, seen := paths[edge.Subordinate]; seen || edge.SubordinateStatement == nil {
+					continue
+				}
+				paths[edge.Subordinate] = append(slices.Clone(paths[identifier]), edge)
+				next = append(next, edge.Subordinate)
+			}
+		}
+		frontier = next
+	}
+	return paths
+}
+
+// applyPolicies combines the metadata policies along a path from the trust anchor and applies them to the
+// subordinate's metadata, as is done when resolving metadata from a trust chain
+func (g *Graph) applyPolicies(path []*Edge, subordinate *Entity) error {
+	chain := []model.EntityStatement{*subordinate.EntityConfiguration}
+	for i := len(path) - 1; i >= 0; i-- {
+		chain = append(chain, *path[i].SubordinateStatement)
+	}
+	chain = append(chain, *g.Entities[g.TrustAnchor].EntityConfiguration)
+
+	policy, err := model.ProcessAndExtractPolicy(chain)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return nil
+	}
+	_, err = model.ApplyPolicy(chain[0], *policy)
+	return err
+}
+
+func expired(exp int64, now time.Time) bool {
+	return !now.Before(time.Unix(exp, 0))
+}
+
+func formatTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,174 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestGraph_Check(t *testing.T) {
+	graph, id := crawlTestFederation(t)
+	ta, intermediate, leaf, leafB, missing := model.EntityIdentifier(id("/ta")), model.EntityIdentifier(id("/int")), model.EntityIdentifier(id("/leaf")), model.EntityIdentifier(id("/leaf-b")), model.EntityIdentifier(id("/missing"))
+
+	consistent := []Finding{
+		{Code: FindingPolicyViolation, Severity: SeverityError, Entity: leaf, Superior: intermediate},
+		{Code: FindingKeyMismatch, Severity: SeverityError, Entity: leafB, Superior: ta},
+		{Code: FindingUnreachableEndpoint, Severity: SeverityError, Entity: missing},
+		{Code: FindingMissingAuthorityHint, Severity: SeverityWarning, Entity: ta, Superior: intermediate},
+	}
+
+	tests := map[string]struct {
+		now              time.Time
+		expectedFindings []Finding
+	}{
+		"current": {
+			now:              time.Now(),
+			expectedFindings: consistent,
+		},
+		"after every statement has expired": {
+			now: time.Now().Add(2 * time.Hour),
+			expectedFindings: []Finding{
+				{Code: FindingExpiredStatement, Severity: SeverityError, Entity: intermediate},
+				{Code: FindingExpiredStatement, Severity: SeverityError, Entity: intermediate, Superior: ta},
+				{Code: FindingExpiredStatement, Severity: SeverityError, Entity: leaf},
+				{Code: FindingExpiredStatement, Severity: SeverityError, Entity: leaf, Superior: intermediate},
+				{Code: FindingPolicyViolation, Severity: SeverityError, Entity: leaf, Superior: intermediate},
+				{Code: FindingExpiredStatement, Severity: SeverityError, Entity: leafB},
+				{Code: FindingExpiredStatement, Severity: SeverityError, Entity: leafB, Superior: ta},
+				{Code: FindingKeyMismatch, Severity: SeverityError, Entity: leafB, Superior: ta},
+				{Code: FindingUnreachableEndpoint, Severity: SeverityError, Entity: missing},
+				{Code: FindingExpiredStatement, Severity: SeverityError, Entity: missing, Superior: ta},
+				{Code: FindingExpiredStatement, Severity: SeverityError, Entity: ta},
+				{Code: FindingExpiredStatement, Severity: SeverityError, Entity: ta, Superior: intermediate},
+				{Code: FindingMissingAuthorityHint, Severity: SeverityWarning, Entity: ta, Superior: intermediate},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			findings := graph.Check(tt.now)
+			if diff := cmp.Diff(tt.expectedFindings, findings, cmpopts.IgnoreFields(Finding{}, "Message")); diff != "" {
+				t.Errorf("findings mismatch (-expected +got):\n%s", diff)
+			}
+			for _, finding := range findings {
+				if finding.Message == "" {
+					t.Errorf("expected %s finding for %s to have a message", finding.Code, finding.Entity)
+				}
+			}
+		})
+	}
+}
+
+func TestGraph_Check_DoesNotModifyGraph(t *testing.T) {
+	graph, _ := crawlTestFederation(t)
+	var before, after bytes.Buffer
+	if err := graph.WriteJSON(&before); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	_ = graph.Check(time.Now())
+	if err := graph.WriteJSON(&after); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if diff := cmp.Diff(before.String(), after.String()); diff != "" {
+		t.Errorf("expected checking not to modify the graph (-before +after):\n%s", diff)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		expected FindingCode
+	}{
+		"an expired statement rejected while crawling": {
+			err: fmt.Errorf("invalid entity configuration for https://leaf.example.com: %w", &model.StatementError{
+				Cause: model.ChainFailureExpired,
+				Err:   fmt.Errorf("malformed 'metadata' claim: %w", model.ErrStatementExpired),
+			}),
+			expected: FindingExpiredStatement,
+		},
+		"an unreachable endpoint": {
+			err:      fmt.Errorf("invalid entity configuration for https://leaf.example.com: %w", &model.StatementError{Cause: model.ChainFailureNetwork, Err: errors.New("connection refused")}),
+			expected: FindingUnreachableEndpoint,
+		},
+		"an error status": {
+			err:      fmt.Errorf("failed to list subordinates: %w", &model.StatementError{Cause: model.ChainFailureHTTPStatus, StatusCode: 503, Err: errors.New("non-200 response")}),
+			expected: FindingUnreachableEndpoint,
+		},
+		"an invalid signature": {
+			err:      &model.StatementError{Cause: model.ChainFailureBadSignature, Err: errors.New("failed to verify JWT signature")},
+			expected: FindingInvalidEntityConfiguration,
+		},
+		"an unclassified error mentioning an error status": {
+			err:      errors.New("non-200 response"),
+			expected: FindingInvalidEntityConfiguration,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := classify(tt.err, FindingInvalidEntityConfiguration); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCompareKeys(t *testing.T) {
+	signingKey := newTestEntity(t, "signing-key").jwks.Keys[0]
+	otherKey := newTestEntity(t, "signing-key").jwks.Keys[0]
+	token := base64.RawURLEncoding.EncodeToString([]byte(`{"kid":"signing-key"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"https://leaf.example.com","sub":"https://leaf.example.com"}`)) + ".signature"
+
+	tests := map[string]struct {
+		registered    []map[string]any
+		published     []map[string]any
+		expectedError string
+	}{
+		"matching key": {
+			registered: []map[string]any{signingKey},
+			published:  []map[string]any{signingKey},
+		},
+		"signing key not registered": {
+			registered:    []map[string]any{{"kid": "old-key", "kty": "EC"}},
+			published:     []map[string]any{signingKey},
+			expectedError: `subordinate statement does not register key "signing-key" used to sign the entity configuration`,
+		},
+		"different key registered under the same key ID": {
+			registered:    []map[string]any{otherKey},
+			published:     []map[string]any{signingKey},
+			expectedError: `registered key "signing-key" does not match the key signing the entity configuration`,
+		},
+		"signing key not published": {
+			registered:    []map[string]any{signingKey},
+			published:     []map[string]any{{"kid": "other-key", "kty": "EC"}},
+			expectedError: `entity configuration does not publish its signing key "signing-key"`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := compareKeys(
+				&Edge{SubordinateStatement: &model.EntityStatement{JWKs: josemodel.Jwks{Keys: tt.registered}}},
+				&Entity{SignedEntityConfiguration: token, EntityConfiguration: &model.EntityStatement{JWKs: josemodel.Jwks{Keys: tt.published}}},
+			)
+			if tt.expectedError == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.expectedError {
+				t.Fatalf("expected error %q, got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+// Package crawler discovers the topology of a federation by walking down from its trust anchor
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/MichaelFraser99/go-openid-federation/internal/entity_configuration"
+	"github.com/MichaelFraser99/go-openid-federation/internal/subordinate_statement"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+const (
+	defaultConcurrency = 8
+	defaultMaxDepth    = 16
+)
+
+type Configuration struct {
+	model.Configuration
+	Concurrency int // Concurrency limits the number of requests made at once - defaults to 8
+	MaxDepth    int // MaxDepth limits how far below the trust anchor entities are crawled - defaults to 16
+}
+
+type Crawler struct {
+	cfg Configuration
+}
+
+func New(cfg Configuration) *Crawler {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	if cfg.MaxDepth <= 0 {
+		cfg.MaxDepth = defaultMaxDepth
+	}
+	return &Crawler{cfg: cfg}
+}
+
+// Crawl walks the federation below the given trust anchor one level at a time, listing the subordinates of every
+// intermediate and fetching and verifying their subordinate statements. Problems with individual entities are recorded
+// within the returned graph - an error is only returned if the trust anchor itself cannot be retrieved or the context
+// ends before the crawl completes
+func (c *Crawler) Crawl(ctx context.Context, trustAnchor string) (*Graph, error) {
+	parsedTrustAnchor, err := model.ValidateEntityIdentifier(trustAnchor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trust anchor entity identifier: %s", err.Error())
+	}
+
+	root := c.retrieveEntity(ctx, *parsedTrustAnchor, 0)
+	if root.EntityConfiguration == nil {
+		return nil, root.Errors[0]
+	}
+	graph := &Graph{
+		TrustAnchor: *parsedTrustAnchor,
+		Entities:    map[model.EntityIdentifier]*Entity{root.Identifier: root},
+	}
+
+	for depth, frontier := 0, []*Entity{root}; len(frontier) > 0; depth++ {
+		var superiors []*Entity
+		for _, entity := range frontier {
+			if isIntermediate(entity) {
+				superiors = append(superiors, entity)
+			}
+		}
+		if depth == c.cfg.MaxDepth {
+			for _, superior := range superiors {
+				superior.DepthLimited = true
+			}
+			break
+		}
+		c.cfg.LogInfo(ctx, "crawling federation level", slog.Int("depth", depth), slog.Int("superiors", len(superiors)))
+
+		c.parallel(ctx, len(superiors), func(i int) {
+			subordinates, err := listSubordinates(ctx, c.cfg.Configuration, *superiors[i].EntityConfiguration)
+			if err != nil {
+				superiors[i].Errors = append(superiors[i].Errors, err)
+				return
+			}
+			superiors[i].Subordinates = subordinates
+		})
+
+		var edges []*Edge
+		var discovered []model.EntityIdentifier
+		for _, superior := range superiors {
+			for _, subordinate := range superior.Subordinates {
+				edges = append(edges, &Edge{Superior: superior.Identifier, Subordinate: subordinate})
+				if _, ok := graph.Entities[subordinate]; !ok {
+					discovered = append(discovered, subordinate)
+				}
+			}
+		}
+		slices.Sort(discovered)
+		discovered = slices.Compact(discovered)
+
+		c.parallel(ctx, len(edges), func(i int) {
+			superior := graph.Entities[edges[i].Superior]
+			token, statement, err := subordinate_statement.Retrieve(ctx, c.cfg.Configuration, *superior.EntityConfiguration, edges[i].Subordinate)
+			if err != nil {
+				edges[i].Err = fmt.Errorf("invalid subordinate statement from %q: %w", edges[i].Superior, err)
+				return
+			}
+			edges[i].SignedSubordinateStatement = *token
+			edges[i].SubordinateStatement = statement
+		})
+
+		next := make([]*Entity, len(discovered))
+		c.parallel(ctx, len(discovered), func(i int) {
+			next[i] = c.retrieveEntity(ctx, discovered[i], depth+1)
+		})
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, entity := range next {
+			graph.Entities[entity.Identifier] = entity
+		}
+
+		for _, edge := range edges {
+			verify(edge, graph.Entities[edge.Subordinate])
+		}
+		graph.Edges = append(graph.Edges, edges...)
+		frontier = next
+	}
+
+	assignRoles(graph)
+	graph.sortEdges()
+	graph.findCycles()
+	return graph, nil
+}
+
+// retrieveEntity retrieves and validates an entity's configuration, recording any failure against the entity
+func (c *Crawler) retrieveEntity(ctx context.Context, identifier model.EntityIdentifier, depth int) *Entity {
+	entity := &Entity{Identifier: identifier, Depth: depth}
+	token, entityConfiguration, err := entity_configuration.Retrieve(ctx, c.cfg.Configuration, identifier)
+	if err != nil {
+		c.cfg.LogInfo(ctx, "failed to retrieve entity configuration", slog.String("subject", string(identifier)), slog.String("error", err.Error()))
+		entity.Errors = append(entity.Errors, fmt.Errorf("invalid entity configuration for %s: %w", identifier, err))
+		return entity
+	}
+	entity.SignedEntityConfiguration = *token
+	entity.EntityConfiguration = entityConfiguration
+	entity.EntityTypes = entityTypes(entityConfiguration.Metadata)
+	return entity
+}
+
+// parallel calls fn for each index from 0 to n, running at most the configured number of calls at once
+func (c *Crawler) parallel(ctx context.Context, n int, fn func(i int)) {
+	semaphore := make(chan struct{}, c.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := range n {
+		if ctx.Err() != nil {
+			break
+		}
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// verify checks that a subordinate statement registers the key the subordinate signs its own entity configuration with
+func verify(edge *Edge, subordinate *Entity) {
+	if edge.Err != nil {
+		return
+	}
+	if subordinate.EntityConfiguration == nil {
+		edge.Err = fmt.Errorf("unable to verify subordinate statement from %q without a valid entity configuration for %q", edge.Superior, edge.Subordinate)
+		return
+	}
+	if err := compareKeys(edge, subordinate); err != nil {
+		edge.Err = fmt.Errorf("subordinate statement from %q does not verify %q's entity configuration: %s", edge.Superior, edge.Subordinate, err.Error())
+		return
+	}
+	edge.Verified = true
+}
+
+func assignRoles(graph *Graph) {
+	trustMarkIssuers := map[model.EntityIdentifier]bool{}
+	for _, issuers := range graph.Entities[graph.TrustAnchor].EntityConfiguration.TrustMarkIssuers {
+		for _, issuer := range issuers {
+			trustMarkIssuers[issuer] = true
+		}
+	}
+	for identifier, entity := range graph.Entities {
+		switch {
+		case identifier == graph.TrustAnchor:
+			entity.Roles = append(entity.Roles, RoleTrustAnchor)
+		case isIntermediate(entity):
+			entity.Roles = append(entity.Roles, RoleIntermediate)
+		case entity.EntityConfiguration != nil:
+			entity.Roles = append(entity.Roles, RoleLeaf)
+		}
+		if trustMarkIssuers[identifier] {
+			entity.Roles = append(entity.Roles, RoleTrustMarkIssuer)
+		}
+	}
+}
+
+// isIntermediate reports whether an entity is able to issue subordinate statements
+func isIntermediate(entity *Entity) bool {
+	if entity.EntityConfiguration == nil {
+		return false
+	}
+	_, ok := federationEndpoint(*entity.EntityConfiguration, "federation_fetch_endpoint")
+	return ok
+}
+
+func entityTypes(metadata *model.Metadata) []string {
+	if metadata == nil {
+		return nil
+	}
+	var types []string
+	if metadata.FederationMetadata != nil {
+		types = append(types, "federation_entity")
+	}
+	if metadata.OpenIDConnectOpenIDProviderMetadata != nil {
+		types = append(types, "openid_provider")
+	}
+	if metadata.OpenIDRelyingPartyMetadata != nil {
+		types = append(types, "openid_relying_party")
+	}
+	return types
+}
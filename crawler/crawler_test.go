@@ -0,0 +1,274 @@
+package crawler
+
+import (
+	"context"
+	"crypto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/MichaelFraser99/go-openid-federation/server"
+	"github.com/google/go-cmp/cmp"
+)
+
+type staticTrustMarkIssuers map[string][]model.EntityIdentifier
+
+func (s staticTrustMarkIssuers) ListTrustMarkIssuers(_ context.Context) (map[string][]model.EntityIdentifier, error) {
+	return s, nil
+}
+
+type testEntity struct {
+	signer crypto.Signer
+	jwks   josemodel.Jwks
+}
+
+func newTestEntity(t *testing.T, keyID string) testEntity {
+	t.Helper()
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	publicJWK, err := jwk.PublicJwk(signer.Public())
+	if err != nil {
+		t.Fatalf("expected no error creating JWK, got %q", err.Error())
+	}
+	(*publicJWK)["kid"] = keyID
+	(*publicJWK)["alg"] = "ES256"
+	return testEntity{signer: signer, jwks: josemodel.Jwks{Keys: []map[string]any{*publicJWK}}}
+}
+
+// testFederation serves the following federation from a single TLS server, returning the server:
+//
+//	/ta      trust anchor listing /int, /leaf-b and /missing through a paginated extended listing, restricting relying
+//	         parties below /int to web applications and trusting /leaf-b to issue trust marks
+//	/int     intermediate listing /leaf, applying a metadata policy to it, and /ta, forming a cycle
+//	/leaf    native relying party
+//	/leaf-b  leaf whose subordinate statement from /ta registers a different key under the ID of its signing key
+//	/missing not served
+func testFederation(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	s := httptest.NewTLSServer(mux)
+	t.Cleanup(s.Close)
+	id := func(path string) model.EntityIdentifier { return model.EntityIdentifier(s.URL + path) }
+
+	ta, intermediate, leaf, leafB, unrelated := newTestEntity(t, "ta-key"), newTestEntity(t, "int-key"), newTestEntity(t, "leaf-key"), newTestEntity(t, "leaf-b-key"), newTestEntity(t, "unrelated-key")
+	impostor := newTestEntity(t, "leaf-b-key")
+
+	subsetOf, err := model.NewSubsetOf([]any{"authorization_code"})
+	if err != nil {
+		t.Fatalf("expected no error creating policy operator, got %q", err.Error())
+	}
+
+	oneOf, err := model.NewOneOf([]any{"web"})
+	if err != nil {
+		t.Fatalf("expected no error creating policy operator, got %q", err.Error())
+	}
+
+	taIntermediate := &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour}
+	_ = taIntermediate.AddSubordinate(id("/int"), &model.SubordinateConfiguration{
+		JWKs: intermediate.jwks,
+		Policies: model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+			"application_type": {Metadata: []model.MetadataPolicyOperator{oneOf}},
+		}},
+	})
+	_ = taIntermediate.AddSubordinate(id("/leaf-b"), &model.SubordinateConfiguration{JWKs: impostor.jwks})
+	_ = taIntermediate.AddSubordinate(id("/missing"), &model.SubordinateConfiguration{JWKs: unrelated.jwks})
+
+	intIntermediate := &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour}
+	_ = intIntermediate.AddSubordinate(id("/leaf"), &model.SubordinateConfiguration{
+		JWKs: leaf.jwks,
+		Policies: model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+			"grant_types": {Metadata: []model.MetadataPolicyOperator{subsetOf}},
+		}},
+	})
+	_ = intIntermediate.AddSubordinate(id("/ta"), &model.SubordinateConfiguration{JWKs: ta.jwks})
+
+	servers := map[string]*server.Server{
+		"/ta": server.NewServer(model.ServerConfiguration{
+			EntityIdentifier:            id("/ta"),
+			SignerConfiguration:         model.SignerConfiguration{Signer: ta.signer, KeyID: "ta-key", Algorithm: "ES256"},
+			EntityConfigurationLifetime: time.Hour,
+			IntermediateConfiguration:   taIntermediate,
+			Extensions:                  model.Extensions{ExtendedListing: model.ExtendedListingConfiguration{Enabled: true, SizeLimit: 1}},
+			TrustMarkIssuerRetriever:    staticTrustMarkIssuers{"https://example.com/certified": {id("/leaf-b")}},
+		}),
+		"/int": server.NewServer(model.ServerConfiguration{
+			EntityIdentifier:            id("/int"),
+			SignerConfiguration:         model.SignerConfiguration{Signer: intermediate.signer, KeyID: "int-key", Algorithm: "ES256"},
+			EntityConfigurationLifetime: time.Hour,
+			AuthorityHints:              []model.EntityIdentifier{id("/ta")},
+			IntermediateConfiguration:   intIntermediate,
+		}),
+		"/leaf": server.NewServer(model.ServerConfiguration{
+			EntityIdentifier:            id("/leaf"),
+			SignerConfiguration:         model.SignerConfiguration{Signer: leaf.signer, KeyID: "leaf-key", Algorithm: "ES256"},
+			EntityConfigurationLifetime: time.Hour,
+			AuthorityHints:              []model.EntityIdentifier{id("/int")},
+			EntityConfiguration: model.EntityStatement{Metadata: &model.Metadata{OpenIDRelyingPartyMetadata: &model.OpenIDRelyingPartyMetadata{
+				"redirect_uris":             []any{"https://rp.example.com/callback"},
+				"client_registration_types": []any{"automatic"},
+				"grant_types":               []any{"authorization_code", "refresh_token"},
+				"application_type":          "native",
+			}}},
+		}),
+		"/leaf-b": server.NewServer(model.ServerConfiguration{
+			EntityIdentifier:            id("/leaf-b"),
+			SignerConfiguration:         model.SignerConfiguration{Signer: leafB.signer, KeyID: "leaf-b-key", Algorithm: "ES256"},
+			EntityConfigurationLifetime: time.Hour,
+			AuthorityHints:              []model.EntityIdentifier{id("/ta")},
+		}),
+	}
+	for prefix, entity := range servers {
+		entityMux := http.NewServeMux()
+		entity.Configure(entityMux)
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, entityMux))
+	}
+	return s
+}
+
+func TestCrawler_Crawl(t *testing.T) {
+	federation := testFederation(t)
+	id := func(path string) model.EntityIdentifier { return model.EntityIdentifier(federation.URL + path) }
+
+	tests := map[string]struct {
+		maxDepth        int
+		expectedDepths  map[model.EntityIdentifier]int
+		expectedRoles   map[model.EntityIdentifier][]Role
+		expectedEdges   map[[2]model.EntityIdentifier]string // expectedEdges maps each edge to the expected error, empty when verified
+		expectedCycles  [][]model.EntityIdentifier
+		expectedLimited []model.EntityIdentifier
+	}{
+		"full crawl": {
+			expectedDepths: map[model.EntityIdentifier]int{id("/ta"): 0, id("/int"): 1, id("/leaf-b"): 1, id("/missing"): 1, id("/leaf"): 2},
+			expectedRoles: map[model.EntityIdentifier][]Role{
+				id("/ta"):      {RoleTrustAnchor},
+				id("/int"):     {RoleIntermediate},
+				id("/leaf"):    {RoleLeaf},
+				id("/leaf-b"):  {RoleLeaf, RoleTrustMarkIssuer},
+				id("/missing"): nil,
+			},
+			expectedEdges: map[[2]model.EntityIdentifier]string{
+				{id("/int"), id("/leaf")}:   "",
+				{id("/int"), id("/ta")}:     "",
+				{id("/ta"), id("/int")}:     "",
+				{id("/ta"), id("/leaf-b")}:  "does not match the key signing the entity configuration",
+				{id("/ta"), id("/missing")}: "without a valid entity configuration",
+			},
+			expectedCycles: [][]model.EntityIdentifier{{id("/ta"), id("/int"), id("/ta")}},
+		},
+		"depth limited": {
+			maxDepth:       1,
+			expectedDepths: map[model.EntityIdentifier]int{id("/ta"): 0, id("/int"): 1, id("/leaf-b"): 1, id("/missing"): 1},
+			expectedRoles: map[model.EntityIdentifier][]Role{
+				id("/ta"):      {RoleTrustAnchor},
+				id("/int"):     {RoleIntermediate},
+				id("/leaf-b"):  {RoleLeaf, RoleTrustMarkIssuer},
+				id("/missing"): nil,
+			},
+			expectedEdges: map[[2]model.EntityIdentifier]string{
+				{id("/ta"), id("/int")}:     "",
+				{id("/ta"), id("/leaf-b")}:  "does not match the key signing the entity configuration",
+				{id("/ta"), id("/missing")}: "without a valid entity configuration",
+			},
+			expectedLimited: []model.EntityIdentifier{id("/int")},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			graph, err := New(Configuration{
+				Configuration: model.Configuration{HttpClient: federation.Client()},
+				Concurrency:   2,
+				MaxDepth:      tt.maxDepth,
+			}).Crawl(context.Background(), string(id("/ta")))
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+
+			depths := map[model.EntityIdentifier]int{}
+			roles := map[model.EntityIdentifier][]Role{}
+			var limited []model.EntityIdentifier
+			for identifier, entity := range graph.Entities {
+				depths[identifier] = entity.Depth
+				roles[identifier] = entity.Roles
+				if entity.DepthLimited {
+					limited = append(limited, identifier)
+				}
+			}
+			if diff := cmp.Diff(tt.expectedDepths, depths); diff != "" {
+				t.Errorf("depth mismatch (-expected +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.expectedRoles, roles); diff != "" {
+				t.Errorf("role mismatch (-expected +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.expectedLimited, limited); diff != "" {
+				t.Errorf("depth limited mismatch (-expected +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.expectedCycles, graph.Cycles); diff != "" {
+				t.Errorf("cycle mismatch (-expected +got):\n%s", diff)
+			}
+
+			if len(graph.Edges) != len(tt.expectedEdges) {
+				t.Fatalf("expected %d edges, got %d", len(tt.expectedEdges), len(graph.Edges))
+			}
+			for _, edge := range graph.Edges {
+				expectedError, ok := tt.expectedEdges[[2]model.EntityIdentifier{edge.Superior, edge.Subordinate}]
+				if !ok {
+					t.Errorf("unexpected edge from %s to %s", edge.Superior, edge.Subordinate)
+					continue
+				}
+				if expectedError == "" {
+					if !edge.Verified || edge.Err != nil {
+						t.Errorf("expected edge from %s to %s to be verified, got %v", edge.Superior, edge.Subordinate, edge.Err)
+					}
+				} else if edge.Verified || edge.Err == nil || !strings.Contains(edge.Err.Error(), expectedError) {
+					t.Errorf("expected edge from %s to %s to fail with %q, got %v", edge.Superior, edge.Subordinate, expectedError, edge.Err)
+				}
+			}
+
+			if tt.maxDepth == 0 {
+				policy := graph.Subordinates(id("/int"))[0].MetadataPolicy()
+				if policy == nil || policy.OpenIDRelyingPartyMetadata["grant_types"].Metadata == nil {
+					t.Errorf("expected the metadata policy applied to %s to be recorded", id("/leaf"))
+				}
+				if diff := cmp.Diff([]string{"openid_relying_party"}, graph.Entities[id("/leaf")].EntityTypes); diff != "" {
+					t.Errorf("entity type mismatch (-expected +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestCrawler_Crawl_Errors(t *testing.T) {
+	federation := testFederation(t)
+
+	tests := map[string]struct {
+		trustAnchor   string
+		expectedError string
+	}{
+		"invalid trust anchor": {
+			trustAnchor:   "http://insecure.example.com",
+			expectedError: "invalid trust anchor entity identifier",
+		},
+		"unreachable trust anchor": {
+			trustAnchor:   federation.URL + "/missing",
+			expectedError: "invalid entity configuration for " + federation.URL + "/missing",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(Configuration{Configuration: model.Configuration{HttpClient: federation.Client()}}).Crawl(context.Background(), tt.trustAnchor)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+// Document is a JSON representation of a crawled federation intended to be compared between crawls. Values that
+// change on every crawl, such as issuance and expiry times and the signed statements themselves, are omitted and
+// every list is sorted
+type Document struct {
+	TrustAnchor model.EntityIdentifier     `json:"trust_anchor"`
+	Entities    []DocumentEntity           `json:"entities"`
+	Edges       []DocumentEdge             `json:"edges"`
+	Cycles      [][]model.EntityIdentifier `json:"cycles,omitempty"`
+}
+
+type DocumentEntity struct {
+	ID               model.EntityIdentifier              `json:"id"`
+	Depth            int                                 `json:"depth"`
+	Roles            []Role                              `json:"roles,omitempty"`
+	EntityTypes      []string                            `json:"entity_types,omitempty"`
+	AuthorityHints   []model.EntityIdentifier            `json:"authority_hints,omitempty"`
+	Keys             []map[string]any                    `json:"keys,omitempty"`
+	Metadata         *model.Metadata                     `json:"metadata,omitempty"`
+	TrustMarkTypes   []string                            `json:"trust_mark_types,omitempty"`
+	TrustMarkIssuers map[string][]model.EntityIdentifier `json:"trust_mark_issuers,omitempty"`
+	Subordinates     []model.EntityIdentifier            `json:"subordinates,omitempty"`
+	DepthLimited     bool                                `json:"depth_limited,omitempty"`
+	Errors           []string                            `json:"errors,omitempty"`
+}
+
+type DocumentEdge struct {
+	Superior       model.EntityIdentifier `json:"superior"`
+	Subordinate    model.EntityIdentifier `json:"subordinate"`
+	Verified       bool                   `json:"verified"`
+	Keys           []map[string]any       `json:"keys,omitempty"`
+	MetadataPolicy *model.MetadataPolicy  `json:"metadata_policy,omitempty"`
+	Constraints    any                    `json:"constraints,omitempty"`
+	Error          string                 `json:"error,omitempty"`
+}
+
+// Document returns the JSON representation of the graph
+func (g *Graph) Document() Document {
+	document := Document{
+		TrustAnchor: g.TrustAnchor,
+		Entities:    make([]DocumentEntity, 0, len(g.Entities)),
+		Edges:       make([]DocumentEdge, 0, len(g.Edges)),
+		Cycles:      g.Cycles,
+	}
+	for _, identifier := range g.Identifiers() {
+		entity := g.Entities[identifier]
+		documentEntity := DocumentEntity{
+			ID:           identifier,
+			Depth:        entity.Depth,
+			Roles:        entity.Roles,
+			EntityTypes:  entity.EntityTypes,
+			Subordinates: entity.Subordinates,
+			DepthLimited: entity.DepthLimited,
+			Errors:       errorStrings(entity.Errors...),
+		}
+		if ec := entity.EntityConfiguration; ec != nil {
+			documentEntity.AuthorityHints = slices.Sorted(slices.Values(ec.AuthorityHints))
+			documentEntity.Keys = sortedKeys(ec.JWKs.Keys)
+			documentEntity.Metadata = ec.Metadata
+			documentEntity.TrustMarkIssuers = ec.TrustMarkIssuers
+			for _, trustMark := range ec.TrustMarks {
+				documentEntity.TrustMarkTypes = append(documentEntity.TrustMarkTypes, trustMark.TrustMarkType)
+			}
+			slices.Sort(documentEntity.TrustMarkTypes)
+			documentEntity.TrustMarkTypes = slices.Compact(documentEntity.TrustMarkTypes)
+		}
+		document.Entities = append(document.Entities, documentEntity)
+	}
+	for _, edge := range g.Edges {
+		documentEdge := DocumentEdge{
+			Superior:       edge.Superior,
+			Subordinate:    edge.Subordinate,
+			Verified:       edge.Verified,
+			MetadataPolicy: edge.MetadataPolicy(),
+		}
+		if edge.SubordinateStatement != nil {
+			documentEdge.Keys = sortedKeys(edge.SubordinateStatement.JWKs.Keys)
+			documentEdge.Constraints = edge.SubordinateStatement.Constraints
+		}
+		if edge.Err != nil {
+			documentEdge.Error = edge.Err.Error()
+		}
+		document.Edges = append(document.Edges, documentEdge)
+	}
+	return document
+}
+
+// WriteJSON writes the graph's Document as indented JSON
+func (g *Graph) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(g.Document())
+}
+
+// entityTypeColours are the fill colours used for each entity type in DOT output, in order of precedence when an
+// entity publishes metadata for several types
+var entityTypeColours = []struct {
+	entityType, colour string
+}{
+	{"openid_provider", "#8dd3c7"},
+	{"openid_relying_party", "#ffffb3"},
+	{"federation_entity", "#bebada"},
+}
+
+const (
+	unknownEntityTypeColour = "#d9d9d9"
+	unverifiedEdgeColour    = "#e41a1c"
+)
+
+// WriteDOT writes the graph in the Graphviz DOT language. Entities are filled according to the entity types they
+// publish metadata for, edges are labelled with the metadata policy operators the superior applies, and edges that
+// could not be verified are drawn in red
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph federation {\n")
+	b.WriteString("  rankdir=TB;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+	b.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+
+	for _, identifier := range g.Identifiers() {
+		entity := g.Entities[identifier]
+		label := []string{string(identifier)}
+		if len(entity.Roles) > 0 {
+			label = append(label, joinStrings(entity.Roles, ", "))
+		}
+		if len(entity.EntityTypes) > 0 {
+			label = append(label, strings.Join(entity.EntityTypes, ", "))
+		}
+		attributes := fmt.Sprintf("label=%s, fillcolor=%s", dotQuote(strings.Join(label, "\n")), dotQuote(entityColour(entity)))
+		if identifier == g.TrustAnchor {
+			attributes += ", penwidth=2"
+		}
+		if entity.EntityConfiguration == nil {
+			attributes += ", style=\"rounded,filled,dashed\""
+		}
+		fmt.Fprintf(&b, "  %s [%s];\n", dotQuote(string(identifier)), attributes)
+	}
+
+	for _, edge := range g.Edges {
+		attributes := []string{}
+		if label := policyLabel(edge.MetadataPolicy()); label != "" {
+			attributes = append(attributes, "label="+dotQuote(label))
+		}
+		if !edge.Verified {
+			attributes = append(attributes, "color="+dotQuote(unverifiedEdgeColour), "style=dashed")
+		}
+		fmt.Fprintf(&b, "  %s -> %s", dotQuote(string(edge.Superior)), dotQuote(string(edge.Subordinate)))
+		if len(attributes) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attributes, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func entityColour(entity *Entity) string {
+	for _, candidate := range entityTypeColours {
+		if slices.Contains(entity.EntityTypes, candidate.entityType) {
+			return candidate.colour
+		}
+	}
+	return unknownEntityTypeColour
+}
+
+// policyLabel summarises a metadata policy as one line per claim, naming the operators applied to it
+func policyLabel(policy *model.MetadataPolicy) string {
+	if policy == nil {
+		return ""
+	}
+	var lines []string
+	for _, entityType := range []struct {
+		name     string
+		policies map[string]model.PolicyOperators
+	}{
+		{"federation_entity", policy.FederationMetadata},
+		{"openid_provider", policy.OpenIDConnectOpenIDProviderMetadata},
+		{"openid_relying_party", policy.OpenIDRelyingPartyMetadata},
+	} {
+		for _, claim := range slices.Sorted(maps.Keys(entityType.policies)) {
+			var operators []string
+			for _, operator := range entityType.policies[claim].Metadata {
+				operators = append(operators, operator.String())
+			}
+			slices.Sort(operators)
+			lines = append(lines, fmt.Sprintf("%s.%s: %s", entityType.name, claim, strings.Join(operators, ", ")))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dotQuote returns a DOT quoted string, escaping quotes and backslashes and writing newlines as DOT line breaks
+func dotQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + value + `"`
+}
+
+func sortedKeys(keys []map[string]any) []map[string]any {
+	sorted := slices.Clone(keys)
+	slices.SortStableFunc(sorted, func(a, b map[string]any) int {
+		aKid, _ := a["kid"].(string)
+		bKid, _ := b["kid"].(string)
+		return strings.Compare(aKid, bKid)
+	})
+	return sorted
+}
+
+func errorStrings(errs ...error) []string {
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return messages
+}
+
+func joinStrings[T ~string](values []T, separator string) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = string(value)
+	}
+	return strings.Join(parts, separator)
+}
@@ -0,0 +1,109 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func crawlTestFederation(t *testing.T) (*Graph, func(path string) string) {
+	t.Helper()
+	federation := testFederation(t)
+	graph, err := New(Configuration{Configuration: model.Configuration{HttpClient: federation.Client()}}).Crawl(context.Background(), federation.URL+"/ta")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	return graph, func(path string) string { return federation.URL + path }
+}
+
+func TestGraph_WriteJSON(t *testing.T) {
+	federation := testFederation(t)
+	crawl := func() []byte {
+		graph, err := New(Configuration{Configuration: model.Configuration{HttpClient: federation.Client()}}).Crawl(context.Background(), federation.URL+"/ta")
+		if err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+		var b bytes.Buffer
+		if err = graph.WriteJSON(&b); err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+		return b.Bytes()
+	}
+
+	first, second := crawl(), crawl()
+	if diff := cmp.Diff(string(first), string(second)); diff != "" {
+		t.Errorf("expected repeated crawls to export identically (-first +second):\n%s", diff)
+	}
+
+	var document map[string]any
+	if err := json.Unmarshal(first, &document); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	var ids []string
+	for _, entity := range document["entities"].([]any) {
+		ids = append(ids, entity.(map[string]any)["id"].(string))
+	}
+	expectedIDs := []string{federation.URL + "/int", federation.URL + "/leaf", federation.URL + "/leaf-b", federation.URL + "/missing", federation.URL + "/ta"}
+	if diff := cmp.Diff(expectedIDs, ids); diff != "" {
+		t.Errorf("entity mismatch (-expected +got):\n%s", diff)
+	}
+
+	edge := document["edges"].([]any)[0].(map[string]any)
+	expectedPolicy := map[string]any{"openid_relying_party": map[string]any{"grant_types": map[string]any{"subset_of": []any{"authorization_code"}}}}
+	if diff := cmp.Diff(expectedPolicy, edge["metadata_policy"]); diff != "" {
+		t.Errorf("metadata policy mismatch (-expected +got):\n%s", diff)
+	}
+	for _, volatile := range []string{`"iat"`, `"exp"`, "eyJ"} {
+		if bytes.Contains(first, []byte(volatile)) {
+			t.Errorf("expected the document not to contain %s", volatile)
+		}
+	}
+}
+
+func TestGraph_WriteDOT(t *testing.T) {
+	graph, id := crawlTestFederation(t)
+
+	var b bytes.Buffer
+	if err := graph.WriteDOT(&b); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	dot := b.String()
+
+	for _, expected := range []string{
+		"digraph federation {\n",
+		`"` + id("/leaf") + `" [label="` + id("/leaf") + `\nleaf\nopenid_relying_party", fillcolor="#ffffb3"];`,
+		`"` + id("/ta") + `" [label="` + id("/ta") + `\ntrust_anchor\nfederation_entity", fillcolor="#bebada", penwidth=2];`,
+		`"` + id("/missing") + `" [label="` + id("/missing") + `", fillcolor="#d9d9d9", style="rounded,filled,dashed"];`,
+		`"` + id("/int") + `" -> "` + id("/leaf") + `" [label="openid_relying_party.grant_types: subset_of"];`,
+		`"` + id("/ta") + `" -> "` + id("/leaf-b") + `" [color="#e41a1c", style=dashed];`,
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("expected DOT output to contain %q, got:\n%s", expected, dot)
+		}
+	}
+}
+
+func TestDotQuote(t *testing.T) {
+	tests := map[string]struct {
+		value    string
+		expected string
+	}{
+		"plain":      {value: "https://example.com", expected: `"https://example.com"`},
+		"quotes":     {value: `say "hi"`, expected: `"say \"hi\""`},
+		"backslash":  {value: `a\b`, expected: `"a\\b"`},
+		"line break": {value: "a\nb", expected: `"a\nb"`},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := dotQuote(tt.value); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package crawler
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+// Role is a role an entity plays within the crawled federation
+type Role string
+
+const (
+	RoleTrustAnchor     Role = "trust_anchor"
+	RoleIntermediate    Role = "intermediate"
+	RoleLeaf            Role = "leaf"
+	RoleTrustMarkIssuer Role = "trust_mark_issuer"
+)
+
+// Entity is a federation entity discovered during a crawl
+type Entity struct {
+	Identifier                model.EntityIdentifier
+	Depth                     int                      // Depth is the entity's distance from the trust anchor along the shortest discovered path
+	Roles                     []Role                   // Roles lists the roles the entity plays, in the order trust anchor, intermediate, leaf, trust mark issuer
+	EntityTypes               []string                 // EntityTypes lists the entity types the entity publishes metadata for, in lexical order
+	EntityConfiguration       *model.EntityStatement   // EntityConfiguration is nil when the entity's configuration could not be retrieved and validated
+	SignedEntityConfiguration string                   // SignedEntityConfiguration is the compact JWT the entity configuration was parsed from
+	Subordinates              []model.EntityIdentifier // Subordinates lists the immediate subordinates the entity published, in lexical order
+	DepthLimited              bool                     // DepthLimited is set on intermediates whose subordinates were not listed as the crawl's depth limit was reached
+	Errors                    []error                  // Errors holds the problems encountered retrieving the entity's configuration or listing its subordinates
+}
+
+// HasRole reports whether the entity plays the given role
+func (e *Entity) HasRole(role Role) bool {
+	return slices.Contains(e.Roles, role)
+}
+
+// Edge is the subordinate statement issued by a superior about one of its listed subordinates
+type Edge struct {
+	Superior                   model.EntityIdentifier
+	Subordinate                model.EntityIdentifier
+	SubordinateStatement       *model.EntityStatement // SubordinateStatement is nil when the statement could not be retrieved and validated
+	SignedSubordinateStatement string
+	Verified                   bool  // Verified is set once the statement's keys have been checked against the subordinate's own entity configuration
+	Err                        error // Err describes why the edge could not be verified
+}
+
+// MetadataPolicy returns the metadata policy the superior applies to the subordinate, or nil if there is none
+func (e *Edge) MetadataPolicy() *model.MetadataPolicy {
+	if e.SubordinateStatement == nil {
+		return nil
+	}
+	return e.SubordinateStatement.MetadataPolicy
+}
+
+// Graph is the topology of a federation as seen from its trust anchor
+type Graph struct {
+	TrustAnchor model.EntityIdentifier
+	Entities    map[model.EntityIdentifier]*Entity
+	Edges       []*Edge                    // Edges are ordered by superior and then subordinate
+	Cycles      [][]model.EntityIdentifier // Cycles lists each cycle found in the subordinate relationships, starting and ending with the same entity
+}
+
+// Identifiers returns the identifiers of all crawled entities in lexical order
+func (g *Graph) Identifiers() []model.EntityIdentifier {
+	return slices.Sorted(maps.Keys(g.Entities))
+}
+
+// Subordinates returns the edges from the given entity to its subordinates
+func (g *Graph) Subordinates(identifier model.EntityIdentifier) []*Edge {
+	var edges []*Edge
+	for _, edge := range g.Edges {
+		if edge.Superior == identifier {
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+// Superiors returns the edges from the entity's superiors to the given entity
+func (g *Graph) Superiors(identifier model.EntityIdentifier) []*Edge {
+	var edges []*Edge
+	for _, edge := range g.Edges {
+		if edge.Subordinate == identifier {
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+func (g *Graph) sortEdges() {
+	slices.SortFunc(g.Edges, func(a, b *Edge) int {
+		if c := strings.Compare(string(a.Superior), string(b.Superior)); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.Subordinate), string(b.Subordinate))
+	})
+}
+
+// findCycles walks the subordinate relationships depth first from the trust anchor, recording a cycle each time an
+// entity already on the current path is reached again. Edges must be sorted so that the result is stable
+func (g *Graph) findCycles() {
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := map[model.EntityIdentifier]int{}
+	var path []model.EntityIdentifier
+
+	var visit func(identifier model.EntityIdentifier)
+	visit = func(identifier model.EntityIdentifier) {
+		state[identifier] = onPath
+		path = append(path, identifier)
+		for _, edge := range g.Subordinates(identifier) {
+			switch state[edge.Subordinate] {
+			case onPath:
+				start := slices.Index(path, edge.Subordinate)
+				cycle := append(slices.Clone(path[start:]), edge.Subordinate)
+				g.Cycles = append(g.Cycles, cycle)
+			case unvisited:
+				visit(edge.Subordinate)
+			}
+		}
+		path = path[:len(path)-1]
+		state[identifier] = done
+	}
+	visit(g.TrustAnchor)
+}
@@ -0,0 +1,124 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+// listSubordinates returns the immediate subordinates published by an entity, preferring the extended listing
+// endpoint when the entity advertises one
+func listSubordinates(ctx context.Context, cfg model.Configuration, entityConfiguration model.EntityStatement) ([]model.EntityIdentifier, error) {
+	if extendedListEndpoint, ok := federationEndpoint(entityConfiguration, "federation_extended_list_endpoint"); ok {
+		return extendedList(ctx, cfg, entityConfiguration.Sub, extendedListEndpoint)
+	}
+	listEndpoint, ok := federationEndpoint(entityConfiguration, "federation_list_endpoint")
+	if !ok {
+		return nil, fmt.Errorf("%q does not advertise a federation list endpoint", entityConfiguration.Sub)
+	}
+
+	var identifiers []string
+	if err := getJSON(ctx, cfg, listEndpoint, nil, &identifiers); err != nil {
+		return nil, fmt.Errorf("failed to list %q's subordinates: %w", entityConfiguration.Sub, err)
+	}
+	return parseIdentifiers(identifiers)
+}
+
+// maxListingPages bounds the number of pages followed within a single extended listing, so a listing which never ends
+// cannot stall the crawl
+const maxListingPages = 10000
+
+// extendedList follows the pages of an extended listing until every subordinate has been returned
+func extendedList(ctx context.Context, cfg model.Configuration, issuer model.EntityIdentifier, endpoint string) ([]model.EntityIdentifier, error) {
+	var identifiers []string
+	query := url.Values{}
+	seen := map[model.EntityIdentifier]bool{}
+	for range maxListingPages {
+		var response model.ExtendedListingResponse
+		if err := getJSON(ctx, cfg, endpoint, query, &response); err != nil {
+			return nil, fmt.Errorf("failed to list %q's subordinates: %w", issuer, err)
+		}
+		for _, entity := range response.ImmediateSubordinateEntities {
+			identifier, ok := entity["id"].(string)
+			if !ok {
+				return nil, fmt.Errorf("%q's extended listing contains an entity without a valid 'id'", issuer)
+			}
+			identifiers = append(identifiers, identifier)
+		}
+		if response.NextEntityID == nil {
+			return parseIdentifiers(identifiers)
+		}
+		if seen[*response.NextEntityID] {
+			return nil, fmt.Errorf("%q's extended listing returned to %s", issuer, *response.NextEntityID)
+		}
+		seen[*response.NextEntityID] = true
+		query.Set("from_entity_id", string(*response.NextEntityID))
+	}
+	return nil, fmt.Errorf("%q's extended listing exceeded %d pages", issuer, maxListingPages)
+}
+
+func getJSON(ctx context.Context, cfg model.Configuration, endpoint string, query url.Values, target any) error {
+	if cfg.HttpClient == nil {
+		return fmt.Errorf("no http client present")
+	}
+	if len(query) > 0 {
+		separator := "?"
+		if strings.Contains(endpoint, "?") {
+			separator = "&"
+		}
+		endpoint += separator + query.Encode()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := cfg.HttpClient.Do(request)
+	if err != nil {
+		return &model.StatementError{Cause: model.ChainFailureNetwork, Err: err}
+	}
+	defer response.Body.Close() //nolint:errcheck
+
+	if response.StatusCode != http.StatusOK {
+		return &model.StatementError{Cause: model.ChainFailureHTTPStatus, StatusCode: response.StatusCode, Err: fmt.Errorf("non-200 response from %s: %s", endpoint, response.Status)}
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return &model.StatementError{Cause: model.ChainFailureNetwork, Err: fmt.Errorf("failed to read response body from %s: %s", endpoint, err.Error())}
+	}
+	if err = json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("malformed response from %s: %s", endpoint, err.Error())
+	}
+	return nil
+}
+
+// parseIdentifiers validates a listing, returning its identifiers in lexical order without duplicates
+func parseIdentifiers(identifiers []string) ([]model.EntityIdentifier, error) {
+	parsed := make([]model.EntityIdentifier, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		parsedIdentifier, err := model.ValidateEntityIdentifier(identifier)
+		if err != nil {
+			return nil, fmt.Errorf("listing contains an invalid entity identifier %q: %s", identifier, err.Error())
+		}
+		parsed = append(parsed, *parsedIdentifier)
+	}
+	slices.Sort(parsed)
+	return slices.Compact(parsed), nil
+}
+
+// federationEndpoint returns an endpoint advertised within an entity configuration's federation metadata
+func federationEndpoint(entityConfiguration model.EntityStatement, name string) (string, bool) {
+	if entityConfiguration.Metadata == nil || entityConfiguration.Metadata.FederationMetadata == nil {
+		return "", false
+	}
+	endpoint, ok := (*entityConfiguration.Metadata.FederationMetadata)[name].(string)
+	return endpoint, ok && endpoint != ""
+}
@@ -0,0 +1,76 @@
+package crawler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExtendedList(t *testing.T) {
+	tests := map[string]struct {
+		pages         map[string]model.ExtendedListingResponse // pages maps each 'from_entity_id' to the page served for it
+		expected      []model.EntityIdentifier
+		expectedError string
+	}{
+		"pages are followed until the last": {
+			pages: map[string]model.ExtendedListingResponse{
+				"":                      {ImmediateSubordinateEntities: []map[string]any{{"id": "https://b.example.com"}}, NextEntityID: model.Pointer(model.EntityIdentifier("https://c.example.com"))},
+				"https://c.example.com": {ImmediateSubordinateEntities: []map[string]any{{"id": "https://c.example.com"}, {"id": "https://a.example.com"}}},
+			},
+			expected: []model.EntityIdentifier{"https://a.example.com", "https://b.example.com", "https://c.example.com"},
+		},
+		"a page pointing to itself is rejected": {
+			pages: map[string]model.ExtendedListingResponse{
+				"":                      {ImmediateSubordinateEntities: []map[string]any{{"id": "https://a.example.com"}}, NextEntityID: model.Pointer(model.EntityIdentifier("https://b.example.com"))},
+				"https://b.example.com": {ImmediateSubordinateEntities: []map[string]any{{"id": "https://b.example.com"}}, NextEntityID: model.Pointer(model.EntityIdentifier("https://b.example.com"))},
+			},
+			expectedError: "returned to https://b.example.com",
+		},
+		"pages pointing to each other are rejected": {
+			pages: map[string]model.ExtendedListingResponse{
+				"":                      {ImmediateSubordinateEntities: []map[string]any{{"id": "https://a.example.com"}}, NextEntityID: model.Pointer(model.EntityIdentifier("https://b.example.com"))},
+				"https://b.example.com": {ImmediateSubordinateEntities: []map[string]any{{"id": "https://b.example.com"}}, NextEntityID: model.Pointer(model.EntityIdentifier("https://c.example.com"))},
+				"https://c.example.com": {ImmediateSubordinateEntities: []map[string]any{{"id": "https://c.example.com"}}, NextEntityID: model.Pointer(model.EntityIdentifier("https://b.example.com"))},
+			},
+			expectedError: "returned to https://b.example.com",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			requests := 0
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				page, ok := tt.pages[r.URL.Query().Get("from_entity_id")]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(page)
+			}))
+			t.Cleanup(s.Close)
+
+			identifiers, err := extendedList(t.Context(), model.Configuration{HttpClient: s.Client()}, "https://ta.example.com", s.URL)
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+				}
+				if requests > len(tt.pages) {
+					t.Errorf("expected each page to be requested at most once, got %d requests", requests)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if diff := cmp.Diff(tt.expected, identifiers); diff != "" {
+				t.Errorf("mismatch (-expected +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -5,4 +5,6 @@ go 1.25.3
 require (
 	github.com/MichaelFraser99/go-jose v0.9.1-0.20250426060539-3cb1fea798c8
 	github.com/google/go-cmp v0.7.0
+	github.com/mattn/go-sqlite3 v1.14.52
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,58 @@
+// Package documents parses configuration documents written in either JSON or YAML
+package documents
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Parse parses a JSON or YAML document into the values produced when decoding JSON into an any: maps keyed by
+// string, slices and scalars. As YAML is a superset of JSON, both formats are handled by the same parser
+func Parse(data []byte) (any, error) {
+	var document any
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return normalise(document)
+}
+
+// normalise rewrites the generic maps produced by the YAML decoder into maps with string keys, as required by JSON
+func normalise(value any) (any, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, element := range v {
+			normalised, err := normalise(element)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = normalised
+		}
+		return v, nil
+	case map[any]any:
+		normalised := make(map[string]any, len(v))
+		for key, element := range v {
+			stringKey, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported non-string key %v", key)
+			}
+			normalisedElement, err := normalise(element)
+			if err != nil {
+				return nil, err
+			}
+			normalised[stringKey] = normalisedElement
+		}
+		return normalised, nil
+	case []any:
+		for i, element := range v {
+			normalised, err := normalise(element)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = normalised
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
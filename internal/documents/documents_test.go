@@ -0,0 +1,54 @@
+package documents
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		document string
+		expected any
+		err      bool
+	}{
+		"json documents are parsed": {
+			document: `{"a": {"b": [1, "two", true]}}`,
+			expected: map[string]any{"a": map[string]any{"b": []any{1, "two", true}}},
+		},
+		"yaml documents are parsed": {
+			document: "a:\n  b:\n    - 1\n    - two\n    - true\n",
+			expected: map[string]any{"a": map[string]any{"b": []any{1, "two", true}}},
+		},
+		"nested maps with string keys are normalised": {
+			document: "a:\n  - b: {c: d}\n",
+			expected: map[string]any{"a": []any{map[string]any{"b": map[string]any{"c": "d"}}}},
+		},
+		"non-string keys are rejected": {
+			document: "a:\n  ? [1, 2]\n  : b\n",
+			err:      true,
+		},
+		"malformed documents are rejected": {
+			document: `{"a": `,
+			err:      true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			document, err := Parse([]byte(tt.document))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", document)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if diff := cmp.Diff(tt.expected, document); diff != "" {
+				t.Errorf("mismatch (-expected +got):\n%s", diff)
+			}
+		})
+	}
+}
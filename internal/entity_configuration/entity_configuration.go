@@ -5,9 +5,11 @@ import (
 	"crypto"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"slices"
 	"strings"
@@ -15,8 +17,8 @@ import (
 
 	"github.com/MichaelFraser99/go-jose/jwk"
 	"github.com/MichaelFraser99/go-jose/jws"
-	"github.com/MichaelFraser99/go-jose/jwt"
 	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/internal/signing"
 	"github.com/MichaelFraser99/go-openid-federation/model"
 )
 
@@ -34,22 +36,22 @@ func Retrieve(ctx context.Context, cfg model.Configuration, entityIdentifier mod
 
 	response, err := cfg.HttpClient.Do(request)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, &model.StatementError{Cause: model.ChainFailureNetwork, Err: err}
 	}
 	defer response.Body.Close() //nolint:errcheck
 
 	if response.StatusCode != http.StatusOK {
-		return nil, nil, fmt.Errorf("non-200 response from %q's entity configuration: %s", entityIdentifier, response.Status)
+		return nil, nil, &model.StatementError{Cause: model.ChainFailureHTTPStatus, StatusCode: response.StatusCode, Err: fmt.Errorf("non-200 response from %q's entity configuration: %s", entityIdentifier, response.Status)}
 	}
 	cfg.LogInfo(ctx, "entity configuration retrieved", slog.String("subject", string(entityIdentifier)))
 
 	if !strings.Contains(response.Header.Get("Content-Type"), "application/entity-statement+jwt") { //todo: check on this - not sure if it has to be an exact match or not...
-		return nil, nil, fmt.Errorf("invalid Content-Type response from %q's entity configuration: %s", entityIdentifier, response.Header.Get("Content-Type"))
+		return nil, nil, &model.StatementError{Cause: model.ChainFailureContentType, Err: fmt.Errorf("invalid Content-Type response from %q's entity configuration: %s", entityIdentifier, response.Header.Get("Content-Type"))}
 	}
 
 	responseBytes, err := io.ReadAll(response.Body)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read %q's entity configuration response body: %s", entityIdentifier, err.Error())
+		return nil, nil, &model.StatementError{Cause: model.ChainFailureNetwork, Err: fmt.Errorf("failed to read %q's entity configuration response body: %s", entityIdentifier, err.Error())}
 	}
 
 	entityConfiguration, err := Validate(ctx, entityIdentifier, string(responseBytes))
@@ -117,6 +119,7 @@ func Validate(ctx context.Context, entityIdentifier model.EntityIdentifier, enti
 		return nil, fmt.Errorf("invalid 'jwks' claim format: %s", err.Error())
 	}
 
+	signatureFailure := model.ChainFailureBadSignature
 	if _, _, err = jws.VerifyCompactSerialization(entityConfigurationJwt, func() ([]crypto.PublicKey, error) {
 		keys := map[string]map[string]any{}
 		for _, key := range parsedJwks.Keys {
@@ -136,6 +139,7 @@ func Validate(ctx context.Context, entityIdentifier model.EntityIdentifier, enti
 		}
 		selectedKey := keys[sKid]
 		if selectedKey == nil {
+			signatureFailure = model.ChainFailureKeyIDMismatch
 			return nil, fmt.Errorf("no matching key found in the included 'jwks'")
 		}
 		pubKey, err := jwk.PublicFromJwk(selectedKey)
@@ -144,14 +148,18 @@ func Validate(ctx context.Context, entityIdentifier model.EntityIdentifier, enti
 		}
 		return []crypto.PublicKey{pubKey}, nil
 	}, nil); err != nil {
-		return nil, fmt.Errorf("failed to verify JWT signature: %s", err.Error())
+		return nil, &model.StatementError{Cause: signatureFailure, Err: fmt.Errorf("failed to verify JWT signature: %s", err.Error())}
 	}
 	//todo: validate optional claims
 
 	var entityConfiguration model.EntityStatement
 	err = json.Unmarshal(body, &entityConfiguration)
 	if err != nil {
-		return nil, fmt.Errorf("malformed 'metadata' claim: %s", err.Error())
+		cause := model.ChainFailureInvalidStatement
+		if errors.Is(err, model.ErrStatementExpired) {
+			cause = model.ChainFailureExpired
+		}
+		return nil, &model.StatementError{Cause: cause, Err: fmt.Errorf("malformed 'metadata' claim: %w", err)}
 	}
 
 	if entityConfiguration.Iss != entityConfiguration.Sub {
@@ -180,6 +188,16 @@ func New(ctx context.Context, cfg model.ServerConfiguration) (*string, error) {
 
 	trimmed := strings.TrimSuffix(string(cfg.EntityIdentifier), "/")
 
+	// the configured metadata and key set are shared between concurrent calls so must be copied before modification
+	if cfg.EntityConfiguration.Metadata != nil {
+		metadata := *cfg.EntityConfiguration.Metadata
+		if metadata.FederationMetadata != nil {
+			metadata.FederationMetadata = josemodel.Pointer(maps.Clone(*metadata.FederationMetadata))
+		}
+		cfg.EntityConfiguration.Metadata = &metadata
+	}
+	cfg.EntityConfiguration.JWKs.Keys = slices.Clone(cfg.EntityConfiguration.JWKs.Keys)
+
 	if cfg.IntermediateConfiguration != nil { //if the entity can in theory issue subordinate statements, then we need to include the subordinate cfg
 		if cfg.EntityConfiguration.Metadata == nil || cfg.EntityConfiguration.Metadata.FederationMetadata == nil {
 			cfg.EntityConfiguration.Metadata = &model.Metadata{FederationMetadata: &model.FederationMetadata{}}
@@ -200,21 +218,19 @@ func New(ctx context.Context, cfg model.ServerConfiguration) (*string, error) {
 
 	cfg.EntityConfiguration.MetadataPolicy = nil // not permitted on entity statements
 
-	if cfg.SignerConfiguration.KeyID == "" {
-		return nil, fmt.Errorf("key ID cannot be empty")
-	}
-
-	publicJWK, err := jwk.PublicJwk(cfg.SignerConfiguration.Signer.Public())
+	publicJWK, err := signing.PublicJWK(ctx, cfg.SignerConfiguration)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert provided signer public key to a jwk: %s", err.Error())
+		return nil, err
+	}
+	signerKeyID := publicJWK["kid"].(string)
+	if signerKeyID == "" {
+		return nil, fmt.Errorf("key ID cannot be empty")
 	}
-	(*publicJWK)["kid"] = cfg.SignerConfiguration.KeyID
-	(*publicJWK)["alg"] = cfg.SignerConfiguration.Algorithm
 
 	cfg.EntityConfiguration.JWKs.Opts.EnforceUniqueKIDs = true
 	if cfg.EntityConfiguration.JWKs.Keys == nil {
 		cfg.EntityConfiguration.JWKs.Keys = []map[string]any{
-			*publicJWK,
+			publicJWK,
 		}
 	} else {
 		var keyIDs []string
@@ -227,25 +243,25 @@ func New(ctx context.Context, cfg model.ServerConfiguration) (*string, error) {
 				keyIDs = append(keyIDs, sKid)
 			}
 		}
-		if !slices.Contains(keyIDs, cfg.SignerConfiguration.KeyID) { //only add if key not already included
-			cfg.EntityConfiguration.JWKs.Keys = append(cfg.EntityConfiguration.JWKs.Keys, *publicJWK)
+		if !slices.Contains(keyIDs, signerKeyID) { //only add if key not already included
+			cfg.EntityConfiguration.JWKs.Keys = append(cfg.EntityConfiguration.JWKs.Keys, publicJWK)
 		}
 	}
 
 	if cfg.IntermediateConfiguration != nil {
-		subordinateSignerConfigurations, err := cfg.GetSubordinateJWKs(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get subordinate signer configurations: %s", err.Error())
-		}
-		for _, signerConfiguration := range subordinateSignerConfigurations {
-			apkJWK, err := jwk.PublicJwk(signerConfiguration.Signer.Public())
+		for signerConfiguration, err := range cfg.StreamSubordinateSigners(ctx) {
 			if err != nil {
-				return nil, fmt.Errorf("failed to convert override signer public key to a jwk: %s", err.Error())
+				return nil, fmt.Errorf("failed to get subordinate signer configurations: %s", err.Error())
+			}
+			apkJWK, err := signing.PublicJWK(ctx, signerConfiguration)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert override signer public key to a jwk: %w", err)
+			}
+			if apkJWK["kid"] == "" {
+				continue // an override key without a key ID cannot be referenced, so only statements it signs are affected
 			}
-			(*apkJWK)["kid"] = signerConfiguration.KeyID
-			(*apkJWK)["alg"] = signerConfiguration.Algorithm
 
-			_ = cfg.EntityConfiguration.JWKs.Add(*apkJWK) // just omit if it can't be added
+			_ = cfg.EntityConfiguration.JWKs.Add(apkJWK) // just omit if it can't be added
 		}
 	}
 
@@ -268,9 +284,5 @@ func New(ctx context.Context, cfg model.ServerConfiguration) (*string, error) {
 		return nil, fmt.Errorf("failed to deserialize entity cfg: %s", err.Error())
 	}
 
-	return jwt.New(cfg.SignerConfiguration.Signer, map[string]any{
-		"kid": cfg.SignerConfiguration.KeyID,
-		"typ": "entity-statement+jwt",
-		"alg": cfg.SignerConfiguration.Algorithm,
-	}, entityConfigurationMap, jwt.Opts{Algorithm: josemodel.GetAlgorithm(cfg.SignerConfiguration.Algorithm)})
+	return signing.New(ctx, cfg.SignerConfiguration, "entity-statement+jwt", entityConfigurationMap)
 }
@@ -3,14 +3,17 @@ package entity_configuration
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/MichaelFraser99/go-jose/jwk"
 	"github.com/MichaelFraser99/go-jose/jws"
 	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/internal/signing"
 	"github.com/MichaelFraser99/go-openid-federation/model"
 	"github.com/MichaelFraser99/go-openid-federation/model_test"
 	"github.com/MichaelFraser99/go-openid-federation/server_test"
@@ -96,6 +99,38 @@ func TestRetrieve(t *testing.T) {
 	}
 }
 
+func TestValidate_Expired(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	publicJWK, err := jwk.PublicJwk(signer.Public())
+	if err != nil {
+		t.Fatalf("expected no error creating JWK, got %q", err.Error())
+	}
+	(*publicJWK)["kid"] = "leaf-key"
+	now := time.Now()
+	token, err := signing.New(t.Context(), model.SignerConfiguration{Signer: signer, KeyID: "leaf-key", Algorithm: "ES256"}, "entity-statement+jwt", map[string]any{
+		"iss":  "https://leaf.example.com",
+		"sub":  "https://leaf.example.com",
+		"iat":  now.Add(-2 * time.Hour).Unix(),
+		"exp":  now.Add(-time.Hour).Unix(),
+		"jwks": map[string]any{"keys": []any{*publicJWK}},
+	})
+	if err != nil {
+		t.Fatalf("expected no error signing, got %q", err.Error())
+	}
+
+	_, err = Validate(t.Context(), "https://leaf.example.com", *token)
+	if !errors.Is(err, model.ErrStatementExpired) {
+		t.Fatalf("expected an expired statement error, got %v", err)
+	}
+	var statementError *model.StatementError
+	if !errors.As(err, &statementError) || statementError.Cause != model.ChainFailureExpired {
+		t.Errorf("expected the failure to be classified as %s, got %v", model.ChainFailureExpired, err)
+	}
+}
+
 func TestNew(t *testing.T) {
 	subjectIdentifier, err := model.ValidateEntityIdentifier("https://some-federation.com/some-path")
 	if err != nil {
@@ -284,7 +319,7 @@ func TestNew(t *testing.T) {
 						},
 					},
 				}
-				testConfiguration.IntermediateConfiguration.AddSubordinate(*leafIdentifier1, &model.SubordinateConfiguration{
+				_ = testConfiguration.IntermediateConfiguration.AddSubordinate(*leafIdentifier1, &model.SubordinateConfiguration{
 					Policies: model.MetadataPolicy{
 						OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
 							"key1": {Metadata: []model.MetadataPolicyOperator{
@@ -300,7 +335,7 @@ func TestNew(t *testing.T) {
 						Algorithm: "ES256",
 					},
 				})
-				testConfiguration.IntermediateConfiguration.AddSubordinate(*leafIdentifier2, &model.SubordinateConfiguration{
+				_ = testConfiguration.IntermediateConfiguration.AddSubordinate(*leafIdentifier2, &model.SubordinateConfiguration{
 					Policies: model.MetadataPolicy{
 						OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
 							"key1": {Metadata: []model.MetadataPolicyOperator{
@@ -314,7 +349,7 @@ func TestNew(t *testing.T) {
 						Algorithm: "ES256",
 					},
 				})
-				testConfiguration.IntermediateConfiguration.AddSubordinate(*leafIdentifier3, &model.SubordinateConfiguration{
+				_ = testConfiguration.IntermediateConfiguration.AddSubordinate(*leafIdentifier3, &model.SubordinateConfiguration{
 					Policies: model.MetadataPolicy{
 						OpenIDConnectOpenIDProviderMetadata: map[string]model.PolicyOperators{
 							"key1": {Metadata: []model.MetadataPolicyOperator{
@@ -398,6 +433,54 @@ func TestNew(t *testing.T) {
 				}
 			},
 		},
+		"an override signer without a key ID is omitted": {
+			serverConfiguration: func() model.ServerConfiguration {
+				testConfiguration := model.ServerConfiguration{
+					EntityIdentifier: *subjectIdentifier,
+					SignerConfiguration: model.SignerConfiguration{
+						KeyID:     (*signerPublicJWK)["kid"].(string),
+						Algorithm: "ES256",
+						Signer:    signer,
+					},
+					IntermediateConfiguration: &model.IntermediateConfiguration{},
+				}
+				_ = testConfiguration.IntermediateConfiguration.AddSubordinate(*leafIdentifier1, &model.SubordinateConfiguration{
+					SignerConfiguration: &model.SignerConfiguration{
+						Signer:    leafSigner,
+						Algorithm: "ES256",
+					},
+				})
+				return testConfiguration
+			},
+			validate: func(t *testing.T, expectedIdentifier model.EntityIdentifier, result *string, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				entityConfiguration, err := Validate(t.Context(), expectedIdentifier, *result)
+				if err != nil {
+					t.Fatalf("expected no error validating entity configuration, got %q", err.Error())
+				}
+				if len(entityConfiguration.JWKs.Keys) != 1 || entityConfiguration.JWKs.Keys[0]["kid"] != (*signerPublicJWK)["kid"] {
+					t.Errorf("expected only the entity's own key to be published, got %v", entityConfiguration.JWKs.Keys)
+				}
+			},
+		},
+		"an empty key ID is rejected": {
+			serverConfiguration: func() model.ServerConfiguration {
+				return model.ServerConfiguration{
+					EntityIdentifier: *subjectIdentifier,
+					SignerConfiguration: model.SignerConfiguration{
+						Algorithm: "ES256",
+						Signer:    signer,
+					},
+				}
+			},
+			validate: func(t *testing.T, expectedIdentifier model.EntityIdentifier, result *string, err error) {
+				if err == nil || err.Error() != "key ID cannot be empty" {
+					t.Errorf("expected error 'key ID cannot be empty', got %v", err)
+				}
+			},
+		},
 	}
 
 	for name, tt := range tests {
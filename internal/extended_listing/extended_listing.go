@@ -0,0 +1,118 @@
+package extended_listing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+var _ model.FilteringExtendedListingRetriever = Retriever{}
+
+// Retriever is the built-in ExtendedListingRetriever, listing the subordinates exposed through the configured Retriever
+// or registered in the IntermediateConfiguration. Entities are listed in lexical order of their identifiers, and are
+// streamed when the configured Retriever supports it so only a single page is held in memory. Filtering by update time
+// is rejected as unsupported when subordinates are exposed through a Retriever, as their update times are not known
+type Retriever struct {
+	cfg model.ServerConfiguration
+}
+
+func New(cfg model.ServerConfiguration) Retriever {
+	return Retriever{cfg: cfg}
+}
+
+func (r Retriever) GetExtendedSubordinates(ctx context.Context, from *model.EntityIdentifier, size int, claims []string) (*model.ExtendedListingResponse, error) {
+	return r.GetFilteredExtendedSubordinates(ctx, model.ExtendedListingRequest{From: from, Size: size, Claims: claims})
+}
+
+func (r Retriever) GetFilteredExtendedSubordinates(ctx context.Context, request model.ExtendedListingRequest) (*model.ExtendedListingResponse, error) {
+	size := request.Size
+	if limit := r.cfg.Extensions.ExtendedListing.SizeLimit; limit > 0 && (size <= 0 || size > limit) {
+		size = limit
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("extended listing size must be positive")
+	}
+	if r.cfg.MetadataRetriever != nil || r.cfg.IntermediateConfiguration == nil {
+		// Update times are only recorded for subordinates managed through the IntermediateConfiguration, so filtering
+		// subordinates exposed through a Retriever would silently omit them
+		switch {
+		case request.UpdatedAfter != nil:
+			return nil, model.NewUnsupportedParameterError("parameter 'updated_after' is not supported for the configured subordinates")
+		case request.UpdatedBefore != nil:
+			return nil, model.NewUnsupportedParameterError("parameter 'updated_before' is not supported for the configured subordinates")
+		}
+	}
+
+	response := &model.ExtendedListingResponse{ImmediateSubordinateEntities: []map[string]any{}}
+	first := true
+	for subordinate, err := range r.cfg.StreamSubordinates(ctx, request.From) {
+		if err != nil {
+			return nil, err
+		}
+		if first && request.From != nil && subordinate.Identifier != *request.From {
+			break
+		}
+		first = false
+
+		timestamps, known := r.timestamps(subordinate.Identifier)
+		if (request.UpdatedAfter != nil || request.UpdatedBefore != nil) && (!known || !request.UpdatedWithin(timestamps.Updated)) {
+			continue
+		}
+		if len(response.ImmediateSubordinateEntities) == size {
+			response.NextEntityID = &subordinate.Identifier
+			break
+		}
+
+		entity := project(subordinate.Configuration, request.Claims)
+		entity["id"] = string(subordinate.Identifier)
+		if request.AuditTimestamps && known {
+			entity["registered"] = timestamps.Registered.Unix()
+			entity["updated"] = timestamps.Updated.Unix()
+		}
+		response.ImmediateSubordinateEntities = append(response.ImmediateSubordinateEntities, entity)
+	}
+	if first && request.From != nil {
+		return nil, model.NewNotFoundError(fmt.Sprintf("unknown entity identifier: %s", *request.From))
+	}
+	return response, nil
+}
+
+// timestamps returns the audit timestamps recorded for a subordinate. These are only known for subordinates managed
+// through the IntermediateConfiguration
+func (r Retriever) timestamps(identifier model.EntityIdentifier) (model.SubordinateTimestamps, bool) {
+	if r.cfg.IntermediateConfiguration == nil {
+		return model.SubordinateTimestamps{}, false
+	}
+	return r.cfg.IntermediateConfiguration.SubordinateTimestamps(identifier)
+}
+
+// project returns the requested claims which can be derived from the subordinate configuration. Claims which are
+// unknown or hold no value for the subordinate are omitted
+func project(subordinate *model.SubordinateConfiguration, claims []string) map[string]any {
+	entity := map[string]any{}
+	if subordinate == nil {
+		return entity
+	}
+	for _, claim := range claims {
+		switch claim {
+		case "jwks":
+			if len(subordinate.JWKs.Keys) > 0 {
+				entity[claim] = subordinate.JWKs
+			}
+		case "metadata_policy":
+			if policies := subordinate.Policies; len(policies.FederationMetadata)+len(policies.OpenIDRelyingPartyMetadata)+len(policies.OpenIDConnectOpenIDProviderMetadata) > 0 {
+				entity[claim] = policies
+			}
+		case "metadata":
+			if subordinate.Metadata != nil {
+				entity[claim] = subordinate.Metadata
+			}
+		case "trust_marks":
+			if len(subordinate.TrustMarks) > 0 {
+				entity[claim] = subordinate.TrustMarks
+			}
+		}
+	}
+	return entity
+}
@@ -0,0 +1,251 @@
+package extended_listing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+// Mock Retriever for testing
+type mockRetriever struct {
+	subordinates map[model.EntityIdentifier]*model.SubordinateConfiguration
+}
+
+func (m mockRetriever) GetSubordinate(ctx context.Context, identifier model.EntityIdentifier) (*model.SubordinateConfiguration, error) {
+	return m.subordinates[identifier], nil
+}
+
+func (m mockRetriever) GetSubordinates(ctx context.Context) (map[model.EntityIdentifier]*model.SubordinateConfiguration, error) {
+	return m.subordinates, nil
+}
+
+func (m mockRetriever) GetSubordinateSigners(ctx context.Context) ([]model.SignerConfiguration, error) {
+	return nil, nil
+}
+
+func ids(response *model.ExtendedListingResponse) []string {
+	var identifiers []string
+	for _, entity := range response.ImmediateSubordinateEntities {
+		identifiers = append(identifiers, entity["id"].(string))
+	}
+	return identifiers
+}
+
+func TestRetriever_GetFilteredExtendedSubordinates(t *testing.T) {
+	intermediateConfiguration := func() *model.IntermediateConfiguration {
+		configuration := &model.IntermediateConfiguration{}
+		for _, identifier := range []model.EntityIdentifier{
+			"https://d-federation.com",
+			"https://b-federation.com",
+			"https://a-federation.com",
+			"https://c-federation.com",
+		} {
+			_ = configuration.AddSubordinate(identifier, &model.SubordinateConfiguration{
+				JWKs: josemodel.Jwks{Keys: []map[string]any{{"kty": "EC", "kid": "some-key"}}},
+				Metadata: &model.Metadata{
+					OpenIDRelyingPartyMetadata: &model.OpenIDRelyingPartyMetadata{"client_name": string(identifier)},
+				},
+			})
+		}
+		return configuration
+	}
+
+	tests := map[string]struct {
+		cfg      func() model.ServerConfiguration
+		request  model.ExtendedListingRequest
+		validate func(t *testing.T, response *model.ExtendedListingResponse, err error)
+	}{
+		"entities are listed in lexical order": {
+			request: model.ExtendedListingRequest{Size: 10},
+			validate: func(t *testing.T, response *model.ExtendedListingResponse, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if diff := cmp.Diff([]string{"https://a-federation.com", "https://b-federation.com", "https://c-federation.com", "https://d-federation.com"}, ids(response)); diff != "" {
+					t.Errorf("mismatch (-expected +got):\n%s", diff)
+				}
+				if response.NextEntityID != nil {
+					t.Errorf("expected no next entity, got %s", *response.NextEntityID)
+				}
+			},
+		},
+		"pages end with the identifier of the next entity": {
+			request: model.ExtendedListingRequest{Size: 2},
+			validate: func(t *testing.T, response *model.ExtendedListingResponse, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if diff := cmp.Diff([]string{"https://a-federation.com", "https://b-federation.com"}, ids(response)); diff != "" {
+					t.Errorf("mismatch (-expected +got):\n%s", diff)
+				}
+				if response.NextEntityID == nil || *response.NextEntityID != "https://c-federation.com" {
+					t.Errorf("expected next entity 'https://c-federation.com', got %v", response.NextEntityID)
+				}
+			},
+		},
+		"listing resumes from the requested entity": {
+			request: model.ExtendedListingRequest{Size: 2, From: model.Pointer(model.EntityIdentifier("https://c-federation.com"))},
+			validate: func(t *testing.T, response *model.ExtendedListingResponse, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if diff := cmp.Diff([]string{"https://c-federation.com", "https://d-federation.com"}, ids(response)); diff != "" {
+					t.Errorf("mismatch (-expected +got):\n%s", diff)
+				}
+				if response.NextEntityID != nil {
+					t.Errorf("expected no next entity, got %s", *response.NextEntityID)
+				}
+			},
+		},
+		"an unknown starting entity is not found": {
+			request: model.ExtendedListingRequest{Size: 2, From: model.Pointer(model.EntityIdentifier("https://e-federation.com"))},
+			validate: func(t *testing.T, response *model.ExtendedListingResponse, err error) {
+				if !errors.Is(err, model.ErrNotFound) {
+					t.Fatalf("expected a not found error, got %v", err)
+				}
+			},
+		},
+		"the configured size limit is enforced": {
+			cfg: func() model.ServerConfiguration {
+				return model.ServerConfiguration{
+					IntermediateConfiguration: intermediateConfiguration(),
+					Extensions:                model.Extensions{ExtendedListing: model.ExtendedListingConfiguration{SizeLimit: 3}},
+				}
+			},
+			request: model.ExtendedListingRequest{Size: 50},
+			validate: func(t *testing.T, response *model.ExtendedListingResponse, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if len(response.ImmediateSubordinateEntities) != 3 {
+					t.Errorf("expected 3 entities, got %d", len(response.ImmediateSubordinateEntities))
+				}
+				if response.NextEntityID == nil || *response.NextEntityID != "https://d-federation.com" {
+					t.Errorf("expected next entity 'https://d-federation.com', got %v", response.NextEntityID)
+				}
+			},
+		},
+		"requested claims are projected from subordinate data": {
+			request: model.ExtendedListingRequest{Size: 1, Claims: []string{"metadata", "jwks", "trust_marks", "some_unknown_claim"}},
+			validate: func(t *testing.T, response *model.ExtendedListingResponse, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				entity := response.ImmediateSubordinateEntities[0]
+				if len(entity) != 3 {
+					t.Errorf("expected id, metadata and jwks claims, got %v", entity)
+				}
+				metadata, ok := entity["metadata"].(*model.Metadata)
+				if !ok || (*metadata.OpenIDRelyingPartyMetadata)["client_name"] != "https://a-federation.com" {
+					t.Errorf("expected the subordinate metadata, got %v", entity["metadata"])
+				}
+				if jwks, ok := entity["jwks"].(josemodel.Jwks); !ok || len(jwks.Keys) != 1 {
+					t.Errorf("expected the subordinate jwks, got %v", entity["jwks"])
+				}
+			},
+		},
+		"audit timestamps are included when requested": {
+			request: model.ExtendedListingRequest{Size: 1, AuditTimestamps: true},
+			validate: func(t *testing.T, response *model.ExtendedListingResponse, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				entity := response.ImmediateSubordinateEntities[0]
+				if registered, ok := entity["registered"].(int64); !ok || registered == 0 {
+					t.Errorf("expected a registered timestamp, got %v", entity["registered"])
+				}
+				if updated, ok := entity["updated"].(int64); !ok || updated < entity["registered"].(int64) {
+					t.Errorf("expected an updated timestamp, got %v", entity["updated"])
+				}
+			},
+		},
+		"entities are filtered by update time": {
+			request: model.ExtendedListingRequest{Size: 10, UpdatedAfter: model.Pointer(time.Now().Add(time.Hour))},
+			validate: func(t *testing.T, response *model.ExtendedListingResponse, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if len(response.ImmediateSubordinateEntities) != 0 {
+					t.Errorf("expected no entities, got %v", ids(response))
+				}
+			},
+		},
+		"entities exposed through a retriever are listed": {
+			cfg: func() model.ServerConfiguration {
+				return model.ServerConfiguration{
+					IntermediateConfiguration: &model.IntermediateConfiguration{},
+					MetadataRetriever: mockRetriever{subordinates: map[model.EntityIdentifier]*model.SubordinateConfiguration{
+						"https://z-federation.com": {},
+						"https://y-federation.com": {},
+					}},
+				}
+			},
+			request: model.ExtendedListingRequest{Size: 10, AuditTimestamps: true},
+			validate: func(t *testing.T, response *model.ExtendedListingResponse, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if diff := cmp.Diff([]string{"https://y-federation.com", "https://z-federation.com"}, ids(response)); diff != "" {
+					t.Errorf("mismatch (-expected +got):\n%s", diff)
+				}
+				if _, ok := response.ImmediateSubordinateEntities[0]["registered"]; ok {
+					t.Error("expected no audit timestamps for entities without recorded timestamps")
+				}
+			},
+		},
+		"filtering entities exposed through a retriever by update time is unsupported": {
+			cfg: func() model.ServerConfiguration {
+				return model.ServerConfiguration{
+					IntermediateConfiguration: intermediateConfiguration(),
+					MetadataRetriever: mockRetriever{subordinates: map[model.EntityIdentifier]*model.SubordinateConfiguration{
+						"https://z-federation.com": {},
+					}},
+				}
+			},
+			request: model.ExtendedListingRequest{Size: 10, UpdatedBefore: model.Pointer(time.Now().Add(time.Hour))},
+			validate: func(t *testing.T, response *model.ExtendedListingResponse, err error) {
+				if !errors.Is(err, model.ErrUnsupportedParameter) {
+					t.Fatalf("expected an unsupported parameter error, got %v", err)
+				}
+				if !strings.Contains(err.Error(), "updated_before") {
+					t.Errorf("expected the error to name 'updated_before', got %q", err.Error())
+				}
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := model.ServerConfiguration{IntermediateConfiguration: intermediateConfiguration()}
+			if tt.cfg != nil {
+				cfg = tt.cfg()
+			}
+			response, err := New(cfg).GetFilteredExtendedSubordinates(t.Context(), tt.request)
+			tt.validate(t, response, err)
+		})
+	}
+}
+
+func TestRetriever_GetExtendedSubordinates(t *testing.T) {
+	configuration := &model.IntermediateConfiguration{}
+	for _, identifier := range []model.EntityIdentifier{"https://b-federation.com", "https://a-federation.com", "https://c-federation.com"} {
+		_ = configuration.AddSubordinate(identifier, &model.SubordinateConfiguration{})
+	}
+
+	response, err := New(model.ServerConfiguration{IntermediateConfiguration: configuration}).GetExtendedSubordinates(t.Context(), model.Pointer(model.EntityIdentifier("https://b-federation.com")), 1, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if diff := cmp.Diff([]string{"https://b-federation.com"}, ids(response)); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+	if response.NextEntityID == nil || *response.NextEntityID != "https://c-federation.com" {
+		t.Errorf("expected next entity 'https://c-federation.com', got %v", response.NextEntityID)
+	}
+}
@@ -0,0 +1,162 @@
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jwt"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+// Error is a failure reported by the underlying signer. Its message is a fixed description which is safe to return to
+// clients, as the signer's own error may describe internal key management systems - the latter is only available
+// through Unwrap, for logging. Error is matched by errors.Is against model.ErrTemporarilyUnavailable
+type Error struct {
+	description string
+	err         error
+}
+
+func (e *Error) Error() string {
+	return e.description
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{model.ErrTemporarilyUnavailable, e.err}
+}
+
+// Cause returns the error reported by the underlying signer
+func (e *Error) Cause() error {
+	return e.err
+}
+
+const (
+	signUnavailableError = "unable to sign statement at this time"
+	keyUnavailableError  = "unable to retrieve signing key at this time"
+)
+
+// New signs the provided body as a JWT of the given type using the provided signer configuration.
+// Failures reported by the underlying signer are returned as an *Error
+func New(ctx context.Context, signerConfiguration model.SignerConfiguration, typ string, body map[string]any) (*string, error) {
+	signer := signerConfiguration.AsRemoteSigner()
+
+	keyID, err := signer.KeyID(ctx)
+	if err != nil {
+		return nil, &Error{description: signUnavailableError, err: fmt.Errorf("failed to determine signing key ID: %w", err)}
+	}
+	if keyID == "" {
+		return nil, fmt.Errorf("key ID cannot be empty")
+	}
+
+	algorithm, err := signer.Algorithm(ctx)
+	if err != nil {
+		return nil, &Error{description: signUnavailableError, err: fmt.Errorf("failed to determine signing algorithm: %w", err)}
+	}
+
+	token, err := jwt.New(contextSigner{ctx: ctx, signer: signer}, map[string]any{
+		"kid": keyID,
+		"typ": typ,
+		"alg": algorithm,
+	}, body, jwt.Opts{Algorithm: josemodel.GetAlgorithm(algorithm)})
+	if err != nil {
+		return nil, &Error{description: signUnavailableError, err: fmt.Errorf("failed to sign %s: %w", typ, err)}
+	}
+	return token, nil
+}
+
+// PublicJWK returns the public JWK for the provided signer configuration with the 'kid' and 'alg' parameters populated.
+// The key ID is not validated - an empty key ID is left for the caller to handle. Failures reported by the underlying
+// signer are returned as an *Error
+func PublicJWK(ctx context.Context, signerConfiguration model.SignerConfiguration) (map[string]any, error) {
+	signer := signerConfiguration.AsRemoteSigner()
+
+	keyID, err := signer.KeyID(ctx)
+	if err != nil {
+		return nil, &Error{description: keyUnavailableError, err: fmt.Errorf("failed to determine signing key ID: %w", err)}
+	}
+
+	algorithm, err := signer.Algorithm(ctx)
+	if err != nil {
+		return nil, &Error{description: keyUnavailableError, err: fmt.Errorf("failed to determine signing algorithm: %w", err)}
+	}
+
+	publicJWK, err := signer.PublicJWK(ctx)
+	if err != nil {
+		return nil, &Error{description: keyUnavailableError, err: fmt.Errorf("failed to retrieve signer public key: %w", err)}
+	}
+
+	publicJWK = maps.Clone(publicJWK)
+	publicJWK["kid"] = keyID
+	publicJWK["alg"] = algorithm
+	return publicJWK, nil
+}
+
+// contextSigner binds a RemoteSigner to a context so that it can be used where a crypto.Signer is expected
+type contextSigner struct {
+	ctx    context.Context
+	signer model.RemoteSigner
+}
+
+func (c contextSigner) Public() crypto.PublicKey {
+	publicJWK, err := c.signer.PublicJWK(c.ctx)
+	if err != nil {
+		return nil
+	}
+	publicKey, err := jwk.PublicFromJwk(publicJWK)
+	if err != nil {
+		return nil
+	}
+	return publicKey
+}
+
+func (c contextSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return c.signer.Sign(c.ctx, digest, opts)
+}
+
+// thumbprintMembers lists the required members of each key type, which alone make up an RFC 7638 JWK thumbprint
+var thumbprintMembers = map[string][]string{
+	"EC":  {"crv", "kty", "x", "y"},
+	"RSA": {"e", "kty", "n"},
+	"OKP": {"crv", "kty", "x"},
+	"oct": {"k", "kty"},
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the provided JWK, base64url encoded
+func Thumbprint(key map[string]any) (string, error) {
+	kty, _ := key["kty"].(string)
+	members, ok := thumbprintMembers[kty]
+	if !ok {
+		return "", fmt.Errorf("unsupported key type %q", kty)
+	}
+
+	// members are listed in lexicographic order, and the encoded values are plain strings without escapes
+	var builder strings.Builder
+	builder.WriteString("{")
+	for i, member := range members {
+		value, ok := key[member].(string)
+		if !ok {
+			return "", fmt.Errorf("key is missing required member '%s'", member)
+		}
+		if i > 0 {
+			builder.WriteString(",")
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(`"` + member + `":`)
+		builder.Write(encoded)
+	}
+	builder.WriteString("}")
+
+	digest := sha256.Sum256([]byte(builder.String()))
+	return base64.RawURLEncoding.EncodeToString(digest[:]), nil
+}
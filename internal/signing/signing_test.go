@@ -0,0 +1,239 @@
+package signing
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jws"
+	"github.com/MichaelFraser99/go-jose/jwt"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+type testRemoteSigner struct {
+	model.RemoteSigner
+	signErr, keyIDErr error
+}
+
+func (t testRemoteSigner) Sign(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if t.signErr != nil {
+		return nil, t.signErr
+	}
+	return t.RemoteSigner.Sign(ctx, digest, opts)
+}
+
+func (t testRemoteSigner) KeyID(ctx context.Context) (string, error) {
+	if t.keyIDErr != nil {
+		return "", t.keyIDErr
+	}
+	return t.RemoteSigner.KeyID(ctx)
+}
+
+func TestNew(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	signerPublicJWK, err := jwk.PublicJwk(signer.Public())
+	if err != nil {
+		t.Fatalf("expected no error creating public JWK, got %q", err.Error())
+	}
+	keyID := (*signerPublicJWK)["kid"].(string)
+
+	tests := map[string]struct {
+		signerConfiguration model.SignerConfiguration
+		validate            func(t *testing.T, result *string, err error)
+	}{
+		"we can sign using a crypto signer": {
+			signerConfiguration: model.SignerConfiguration{
+				Signer:    signer,
+				KeyID:     keyID,
+				Algorithm: "ES256",
+			},
+			validate: func(t *testing.T, result *string, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				head, body, err := jwt.Validate(*result, func() ([]crypto.PublicKey, error) {
+					return []crypto.PublicKey{signer.Public()}, nil
+				}, &josemodel.JoseOptions{})
+				if err != nil {
+					t.Fatalf("expected no error validating token, got %q", err.Error())
+				}
+				if head["kid"] != keyID {
+					t.Errorf("expected kid %q, got %v", keyID, head["kid"])
+				}
+				if head["typ"] != "entity-statement+jwt" {
+					t.Errorf("expected typ 'entity-statement+jwt', got %v", head["typ"])
+				}
+				if head["alg"] != "ES256" {
+					t.Errorf("expected alg 'ES256', got %v", head["alg"])
+				}
+				if body["sub"] != "https://some-federation.com" {
+					t.Errorf("expected sub 'https://some-federation.com', got %v", body["sub"])
+				}
+			},
+		},
+		"we can sign using a remote signer": {
+			signerConfiguration: model.SignerConfiguration{
+				RemoteSigner: testRemoteSigner{RemoteSigner: model.NewCryptoRemoteSigner(signer, "some-remote-key-id", "ES256")},
+			},
+			validate: func(t *testing.T, result *string, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				head, _, err := jwt.Validate(*result, func() ([]crypto.PublicKey, error) {
+					return []crypto.PublicKey{signer.Public()}, nil
+				}, &josemodel.JoseOptions{})
+				if err != nil {
+					t.Fatalf("expected no error validating token, got %q", err.Error())
+				}
+				if head["kid"] != "some-remote-key-id" {
+					t.Errorf("expected kid 'some-remote-key-id', got %v", head["kid"])
+				}
+			},
+		},
+		"signer failures are reported as temporarily unavailable": {
+			signerConfiguration: model.SignerConfiguration{
+				RemoteSigner: testRemoteSigner{
+					RemoteSigner: model.NewCryptoRemoteSigner(signer, keyID, "ES256"),
+					signErr:      fmt.Errorf("kms unavailable"),
+				},
+			},
+			validate: func(t *testing.T, result *string, err error) {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if !errors.Is(err, model.ErrTemporarilyUnavailable) {
+					t.Errorf("expected a temporarily unavailable error, got %q", err.Error())
+				}
+				var signerErr *Error
+				if !errors.As(err, &signerErr) || !strings.Contains(signerErr.Cause().Error(), "kms unavailable") {
+					t.Errorf("expected the signer's error to be available as the cause, got %v", err)
+				}
+				if err.Error() != "unable to sign statement at this time" {
+					t.Errorf("expected the signer's error to be withheld from the message, got %q", err.Error())
+				}
+			},
+		},
+		"key ID failures are reported as temporarily unavailable": {
+			signerConfiguration: model.SignerConfiguration{
+				RemoteSigner: testRemoteSigner{
+					RemoteSigner: model.NewCryptoRemoteSigner(signer, keyID, "ES256"),
+					keyIDErr:     fmt.Errorf("kms unavailable"),
+				},
+			},
+			validate: func(t *testing.T, result *string, err error) {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if !errors.Is(err, model.ErrTemporarilyUnavailable) {
+					t.Errorf("expected a temporarily unavailable error, got %q", err.Error())
+				}
+				var signerErr *Error
+				if !errors.As(err, &signerErr) || !strings.Contains(signerErr.Cause().Error(), "kms unavailable") {
+					t.Errorf("expected the signer's error to be available as the cause, got %v", err)
+				}
+				if err.Error() != "unable to sign statement at this time" {
+					t.Errorf("expected the signer's error to be withheld from the message, got %q", err.Error())
+				}
+			},
+		},
+		"an empty key ID is rejected": {
+			signerConfiguration: model.SignerConfiguration{
+				Signer:    signer,
+				Algorithm: "ES256",
+			},
+			validate: func(t *testing.T, result *string, err error) {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if err.Error() != "key ID cannot be empty" {
+					t.Errorf("expected error 'key ID cannot be empty', got %q", err.Error())
+				}
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			result, err := New(t.Context(), tt.signerConfiguration, "entity-statement+jwt", map[string]any{
+				"sub": "https://some-federation.com",
+			})
+			tt.validate(t, result, err)
+		})
+	}
+}
+
+func TestPublicJWK(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+
+	result, err := PublicJWK(t.Context(), model.SignerConfiguration{
+		RemoteSigner: model.NewCryptoRemoteSigner(signer, "some-key-id", "ES256"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if result["kid"] != "some-key-id" {
+		t.Errorf("expected kid 'some-key-id', got %v", result["kid"])
+	}
+	if result["alg"] != "ES256" {
+		t.Errorf("expected alg 'ES256', got %v", result["alg"])
+	}
+	if _, err = jwk.PublicFromJwk(result); err != nil {
+		t.Errorf("expected a valid public JWK, got %q", err.Error())
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	tests := map[string]struct {
+		key      map[string]any
+		expected string
+		err      string
+	}{
+		"the RFC 7638 example key": {
+			key: map[string]any{
+				"kty": "RSA",
+				"n":   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+				"e":   "AQAB",
+				"alg": "RS256",
+				"kid": "2011-04-29",
+			},
+			expected: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs",
+		},
+		"unsupported key types are rejected": {
+			key: map[string]any{"kty": "unknown"},
+			err: `unsupported key type "unknown"`,
+		},
+		"keys missing required members are rejected": {
+			key: map[string]any{"kty": "EC", "crv": "P-256", "x": "x"},
+			err: "key is missing required member 'y'",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			thumbprint, err := Thumbprint(tt.key)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if thumbprint != tt.expected {
+				t.Errorf("expected thumbprint %q, got %q", tt.expected, thumbprint)
+			}
+		})
+	}
+}
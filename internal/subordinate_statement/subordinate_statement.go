@@ -5,6 +5,7 @@ import (
 	"crypto"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,8 +15,8 @@ import (
 
 	"github.com/MichaelFraser99/go-jose/jwk"
 	"github.com/MichaelFraser99/go-jose/jws"
-	"github.com/MichaelFraser99/go-jose/jwt"
 	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/internal/signing"
 	"github.com/MichaelFraser99/go-openid-federation/model"
 )
 
@@ -27,7 +28,7 @@ func Retrieve(ctx context.Context, cfg model.Configuration, issuer model.EntityS
 		return nil, nil, fmt.Errorf("the value of 'issuer' must be an entity configuration")
 	}
 	if issuer.Metadata == nil || issuer.Metadata.FederationMetadata == nil || (*issuer.Metadata.FederationMetadata)["federation_fetch_endpoint"] == nil {
-		return nil, nil, fmt.Errorf("issuer entity statement does not list a federation fetch endpoint within it's federation metadata")
+		return nil, nil, &model.StatementError{Cause: model.ChainFailureMissingEndpoint, Err: fmt.Errorf("issuer entity statement does not list a federation fetch endpoint within it's federation metadata")}
 	}
 
 	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%ssub=%s", func() string {
@@ -45,21 +46,21 @@ func Retrieve(ctx context.Context, cfg model.Configuration, issuer model.EntityS
 
 	response, err := cfg.HttpClient.Do(request)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, &model.StatementError{Cause: model.ChainFailureNetwork, Err: err}
 	}
 	defer response.Body.Close() //nolint:errcheck
 
 	if response.StatusCode != http.StatusOK {
-		return nil, nil, fmt.Errorf("non-200 response from %q's federation fetch endpoint: %s", issuer.Sub, response.Status)
+		return nil, nil, &model.StatementError{Cause: model.ChainFailureHTTPStatus, StatusCode: response.StatusCode, Err: fmt.Errorf("non-200 response from %q's federation fetch endpoint: %s", issuer.Sub, response.Status)}
 	}
 
 	if !strings.Contains(response.Header.Get("Content-Type"), "application/entity-statement+jwt") { //todo: check on this - not sure if it has to be an exact match or not...
-		return nil, nil, fmt.Errorf("invalid Content-Type response from %q's federation fetch response: %s", issuer.Sub, response.Header.Get("Content-Type"))
+		return nil, nil, &model.StatementError{Cause: model.ChainFailureContentType, Err: fmt.Errorf("invalid Content-Type response from %q's federation fetch response: %s", issuer.Sub, response.Header.Get("Content-Type"))}
 	}
 
 	responseBytes, err := io.ReadAll(response.Body)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read %q's federation fetch response body: %s", issuer.Sub, err.Error())
+		return nil, nil, &model.StatementError{Cause: model.ChainFailureNetwork, Err: fmt.Errorf("failed to read %q's federation fetch response body: %s", issuer.Sub, err.Error())}
 	}
 
 	subordinateStatement, err := Validate(issuer, string(responseBytes))
@@ -109,6 +110,7 @@ func Validate(issuer model.EntityStatement, subordinateStatementJwt string) (*mo
 		return nil, fmt.Errorf("malformed header claim 'kid'")
 	}
 
+	signatureFailure := model.ChainFailureBadSignature
 	if _, _, err = jws.VerifyCompactSerialization(subordinateStatementJwt, func() ([]crypto.PublicKey, error) {
 		keys := map[string]map[string]any{}
 		for _, key := range issuer.JWKs.Keys {
@@ -128,6 +130,7 @@ func Validate(issuer model.EntityStatement, subordinateStatementJwt string) (*mo
 		}
 		selectedKey := keys[sKid]
 		if selectedKey == nil {
+			signatureFailure = model.ChainFailureKeyIDMismatch
 			return nil, fmt.Errorf("no matching key found in the included 'jwks'")
 		}
 		pubKey, err := jwk.PublicFromJwk(selectedKey)
@@ -136,14 +139,18 @@ func Validate(issuer model.EntityStatement, subordinateStatementJwt string) (*mo
 		}
 		return []crypto.PublicKey{pubKey}, nil
 	}, nil); err != nil {
-		return nil, fmt.Errorf("failed to verify JWT signature: %s", err.Error())
+		return nil, &model.StatementError{Cause: signatureFailure, Err: fmt.Errorf("failed to verify JWT signature: %s", err.Error())}
 	}
 	//todo: validate optional claims
 
 	var subordinateStatement model.EntityStatement
 	err = json.Unmarshal(body, &subordinateStatement)
 	if err != nil {
-		return nil, fmt.Errorf("malformed 'metadata' claim: %s", err.Error())
+		cause := model.ChainFailureInvalidStatement
+		if errors.Is(err, model.ErrStatementExpired) {
+			cause = model.ChainFailureExpired
+		}
+		return nil, &model.StatementError{Cause: cause, Err: fmt.Errorf("malformed 'metadata' claim: %w", err)}
 	}
 
 	if subordinateStatement.Iss != issuer.Sub {
@@ -188,9 +195,8 @@ func New(ctx context.Context, subjectIdentifier model.EntityIdentifier, subordin
 		JWKs:           subjectSubordinateConfiguration.JWKs,
 		MetadataPolicy: &subjectSubordinateConfiguration.Policies,
 	}
-
-	if signerConfiguration.KeyID == "" {
-		return nil, fmt.Errorf("key ID cannot be empty")
+	if len(subjectSubordinateConfiguration.Constraints) > 0 {
+		subordinateStatement.Constraints = subjectSubordinateConfiguration.Constraints
 	}
 
 	subordinateStatementBytes, err := json.Marshal(subordinateStatement)
@@ -204,9 +210,5 @@ func New(ctx context.Context, subjectIdentifier model.EntityIdentifier, subordin
 		return nil, fmt.Errorf("failed to deserialize entity configuration: %s", err.Error())
 	}
 
-	return jwt.New(signerConfiguration.Signer, map[string]any{
-		"kid": signerConfiguration.KeyID,
-		"typ": "entity-statement+jwt",
-		"alg": signerConfiguration.Algorithm,
-	}, subordinateStatementMap, jwt.Opts{Algorithm: josemodel.GetAlgorithm(signerConfiguration.Algorithm)})
+	return signing.New(ctx, *signerConfiguration, "entity-statement+jwt", subordinateStatementMap)
 }
@@ -197,7 +197,7 @@ func TestNew(t *testing.T) {
 						},
 					},
 				}
-				testConfiguration.IntermediateConfiguration.AddSubordinate(*subjectIdentifier, &model.SubordinateConfiguration{
+				_ = testConfiguration.IntermediateConfiguration.AddSubordinate(*subjectIdentifier, &model.SubordinateConfiguration{
 					SignerConfiguration: &model.SignerConfiguration{
 						Signer:    leafSigner,
 						KeyID:     (*leafSignerPublicJWK)["kid"].(string),
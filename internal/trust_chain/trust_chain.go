@@ -2,6 +2,7 @@ package trust_chain
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"slices"
@@ -13,15 +14,19 @@ import (
 	"github.com/MichaelFraser99/go-openid-federation/model"
 )
 
+// BuildTrustChain walks up from the target leaf through its authority hints until the target trust anchor is found,
+// then retrieves the subordinate statements along the route. When no trust chain can be built a *model.TrustChainError
+// is returned recording every path attempted
 func BuildTrustChain(ctx context.Context, cfg model.Configuration, targetLeafEntityIdentifier, targetTrustAnchorEntityIdentifier model.EntityIdentifier) (trustChain []string, parsedTrustChain []model.EntityStatement, expiry *int64, err error) {
 	cfg.LogInfo(ctx, "building chain between entities", slog.String("leaf", string(targetLeafEntityIdentifier)), slog.String("trust_anchor", string(targetTrustAnchorEntityIdentifier)))
 	if targetLeafEntityIdentifier == targetTrustAnchorEntityIdentifier {
 		return nil, nil, nil, fmt.Errorf("target leaf entity identifier must not match target trust anchor entity identifier")
 	}
 
-	signedRoute, route, err := ChainUpOne(ctx, cfg, targetLeafEntityIdentifier, targetTrustAnchorEntityIdentifier, []model.EntityIdentifier{}, []model.EntityStatement{}, []string{})
+	walk := &chainWalk{}
+	signedRoute, route, err := chainUpOne(ctx, cfg, targetLeafEntityIdentifier, targetTrustAnchorEntityIdentifier, []model.EntityIdentifier{}, []model.EntityStatement{}, []string{}, walk)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, walk.error(targetLeafEntityIdentifier, targetTrustAnchorEntityIdentifier, err)
 	}
 
 	trustChain = []string{signedRoute[0]}
@@ -29,13 +34,18 @@ func BuildTrustChain(ctx context.Context, cfg model.Configuration, targetLeafEnt
 
 	exp := model.CalculateChainExpiration(route)
 	if time.Now().UTC().Equal(time.Unix(exp, 0).UTC()) || time.Now().UTC().After(time.Unix(exp, 0).UTC()) {
-		return nil, nil, nil, fmt.Errorf("trust chain expired")
+		err = fmt.Errorf("trust chain expired")
+		walk.fail(model.ChainHop{Entity: targetTrustAnchorEntityIdentifier, Step: model.ChainStepExpiry, Cause: model.ChainFailureExpired, Error: err.Error()})
+		return nil, nil, nil, walk.error(targetLeafEntityIdentifier, targetTrustAnchorEntityIdentifier, err)
 	}
 
 	for i := 0; i < len(route)-1; i++ {
 		signedResponse, subordinateStatement, err := subordinate_statement.Retrieve(ctx, cfg, route[i+1], route[i].Sub)
 		if err != nil {
-			return nil, nil, nil, fmt.Errorf("failed to retrieve subordinate statement: %s", err.Error())
+			hop := failedHop(route[i].Sub, model.ChainStepSubordinateStatement, err)
+			hop.Superior = route[i+1].Sub
+			walk.fail(hop)
+			return nil, nil, nil, walk.error(targetLeafEntityIdentifier, targetTrustAnchorEntityIdentifier, fmt.Errorf("failed to retrieve subordinate statement: %s", err.Error()))
 		}
 		trustChain = append(trustChain, *signedResponse)
 		parsedTrustChain = append(parsedTrustChain, *subordinateStatement)
@@ -45,17 +55,23 @@ func BuildTrustChain(ctx context.Context, cfg model.Configuration, targetLeafEnt
 }
 
 func ChainUpOne(ctx context.Context, cfg model.Configuration, subject, target model.EntityIdentifier, checked []model.EntityIdentifier, path []model.EntityStatement, signedPath []string) ([]string, []model.EntityStatement, error) {
+	return chainUpOne(ctx, cfg, subject, target, checked, path, signedPath, nil)
+}
+
+func chainUpOne(ctx context.Context, cfg model.Configuration, subject, target model.EntityIdentifier, checked []model.EntityIdentifier, path []model.EntityStatement, signedPath []string, walk *chainWalk) ([]string, []model.EntityStatement, error) {
 	cfg.LogInfo(ctx, "walking trust chain", slog.String("subject", string(subject)), slog.String("target", string(target)), slog.Any("checked", checked), slog.Any("path", path), slog.Any("signed_path", signedPath))
 
 	signedSubjectEntityStatement, subjectEntityStatement, err := entity_configuration.Retrieve(ctx, cfg, subject)
 	if err != nil {
 		cfg.LogInfo(ctx, "failed to retrieve leaf entity configuration", slog.String("subject", string(subject)), slog.String("target", string(target)), slog.String("error", err.Error()))
+		walk.fail(failedHop(subject, model.ChainStepEntityConfiguration, err))
 		return signedPath, path, model.NewNotFoundError(fmt.Sprintf("failed to retrieve leaf entity configuration: %s", subject))
 	}
 
 	path = append(path, *subjectEntityStatement)
 	signedPath = append(signedPath, *signedSubjectEntityStatement)
 	checked = append(checked, subject)
+	walk.enter(model.ChainHop{Entity: subject, Step: model.ChainStepEntityConfiguration})
 
 	if subjectEntityStatement.Iss == target {
 		cfg.LogInfo(ctx, "found target entity in trust chain", slog.String("subject", string(subject)), slog.String("target", string(target)))
@@ -77,21 +93,78 @@ func ChainUpOne(ctx context.Context, cfg model.Configuration, subject, target mo
 
 	if len(toCheck) == 0 {
 		cfg.LogInfo(ctx, "dead end in path traversal - no paths to check", slog.String("subject", string(subject)), slog.String("target", string(target)), slog.Any("checked", checked), slog.Any("path", path), slog.Any("signed_path", signedPath))
+		walk.fail(model.ChainHop{Entity: subject, Step: model.ChainStepAuthorityHints, Cause: model.ChainFailureDeadEnd, Error: "no authority hints left to check"})
+		walk.leave()
 		return signedPath[:len(signedPath)-1], path[:len(path)-1], model.NewInvalidTrustAnchorError("unable to build trust chain from specified 'sub' to specified 'trust_anchor'")
 	}
 
 	for _, trustIssuer := range toCheck {
 		cfg.LogInfo(ctx, "checking authority hint", slog.String("subject", string(subject)), slog.String("target", string(target)), slog.String("authority_hint", string(trustIssuer)))
-		signedPath, path, err = ChainUpOne(ctx, cfg, trustIssuer, target, checked, path, signedPath)
+		signedPath, path, err = chainUpOne(ctx, cfg, trustIssuer, target, checked, path, signedPath, walk)
 		if err == nil {
 			return signedPath, path, nil
 		}
 	}
 	cfg.LogInfo(ctx, "dead end in path traversal - all options checked", slog.String("subject", string(subject)), slog.String("target", string(target)), slog.Any("checked", checked), slog.Any("path", path), slog.Any("signed_path", signedPath))
+	walk.leave()
 	return signedPath[:len(signedPath)-1], path[:len(path)-1], model.NewInvalidTrustAnchorError("unable to build trust chain from specified 'sub' to specified 'trust_anchor'")
 }
 
+// chainWalk records the hops taken while walking up from the subject, so that every failed path can be reported. A
+// nil walk records nothing
+type chainWalk struct {
+	hops  []model.ChainHop // hops are the successful hops of the path currently being walked
+	paths []model.ChainPath
+}
+
+func (w *chainWalk) enter(hop model.ChainHop) {
+	if w != nil {
+		w.hops = append(w.hops, hop)
+	}
+}
+
+func (w *chainWalk) leave() {
+	if w != nil {
+		w.hops = w.hops[:len(w.hops)-1]
+	}
+}
+
+// fail records the current path as attempted, ending with the given failed hop
+func (w *chainWalk) fail(hop model.ChainHop) {
+	if w != nil {
+		w.paths = append(w.paths, model.ChainPath{Hops: append(slices.Clone(w.hops), hop)})
+	}
+}
+
+func (w *chainWalk) error(subject, trustAnchor model.EntityIdentifier, err error) error {
+	return &model.TrustChainError{Subject: subject, TrustAnchor: trustAnchor, Paths: w.paths, Err: err}
+}
+
+// failedHop builds the hop recording a failed step, classifying the failure when the error is a *model.StatementError.
+// Signature failures are attributed to the signature check rather than to the retrieval that preceded it
+func failedHop(entity model.EntityIdentifier, step model.ChainStep, err error) model.ChainHop {
+	hop := model.ChainHop{Entity: entity, Step: step, Cause: model.ChainFailureInvalidStatement, Error: err.Error()}
+	var statementErr *model.StatementError
+	if errors.As(err, &statementErr) {
+		hop.Cause = statementErr.Cause
+		hop.StatusCode = statementErr.StatusCode
+	}
+	switch hop.Cause {
+	case model.ChainFailureBadSignature, model.ChainFailureKeyIDMismatch:
+		hop.Step = model.ChainStepSignature
+	case model.ChainFailureMissingEndpoint:
+		hop.Step = model.ChainStepFetchEndpoint
+	}
+	return hop
+}
+
 func ResolveMetadata(ctx context.Context, cfg model.Configuration, issuerEntityIdentifier model.EntityIdentifier, trustChain []string) (*model.ResolveResponse, error) {
+	return ResolveMetadataWithTrace(ctx, cfg, issuerEntityIdentifier, trustChain, nil)
+}
+
+// ResolveMetadataWithTrace behaves as ResolveMetadata, recording how the chain's metadata policies were combined and
+// applied in trace when it is non-nil. The trace is populated up to the point of failure should resolution fail
+func ResolveMetadataWithTrace(ctx context.Context, cfg model.Configuration, issuerEntityIdentifier model.EntityIdentifier, trustChain []string, trace *model.PolicyTrace) (*model.ResolveResponse, error) {
 	cfg.LogInfo(ctx, "resolving metadata from trust chain", slog.String("issuer", string(issuerEntityIdentifier)), slog.Int("chain_length", len(trustChain)))
 
 	if len(trustChain) == 0 {
@@ -215,7 +288,7 @@ func ResolveMetadata(ctx context.Context, cfg model.Configuration, issuerEntityI
 	}
 
 	cfg.LogInfo(ctx, "processing and extracting metadata policy from chain", slog.Int("chain_length", len(processedChain)))
-	finalisedPolicy, err := model.ProcessAndExtractPolicy(processedChain)
+	finalisedPolicy, err := model.ProcessAndExtractPolicyWithTrace(processedChain, trace)
 	if err != nil {
 		cfg.LogInfo(ctx, "failed to process and extract policy", slog.String("error", err.Error()))
 		return nil, fmt.Errorf("failed to process and extract policy: %s", err.Error())
@@ -229,7 +302,7 @@ func ResolveMetadata(ctx context.Context, cfg model.Configuration, issuerEntityI
 	}
 
 	cfg.LogInfo(ctx, "applying policy to subject metadata", slog.String("subject", string(processedChain[0].Sub)))
-	applied, err := model.ApplyPolicy(processedChain[0], *finalisedPolicy)
+	applied, err := model.ApplyPolicyWithTrace(processedChain[0], *finalisedPolicy, trace)
 	if err != nil {
 		cfg.LogInfo(ctx, "failed to apply policy", slog.Any("metadata", processedChain[0]), slog.Any("policy", *finalisedPolicy), slog.String("error", err.Error()))
 		return nil, model.NewInvalidMetadataError("unresolvable metadata policy encountered")
@@ -4,11 +4,13 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,6 +18,7 @@ import (
 	"github.com/MichaelFraser99/go-jose/jwt"
 	josemodel "github.com/MichaelFraser99/go-jose/model"
 	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/google/go-cmp/cmp"
 )
 
 // Test helper to create entity statements
@@ -176,6 +179,204 @@ func TestBuildTrustChain(t *testing.T) {
 	}
 }
 
+func TestBuildTrustChain_Diagnostics(t *testing.T) {
+	leafKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	intermediateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	trustAnchorKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	closed := httptest.NewTLSServer(http.NotFoundHandler())
+	closed.Close()
+
+	sign := func(t *testing.T, signer crypto.Signer, kid string, body map[string]any) string {
+		t.Helper()
+		token, err := jwt.New(signer, map[string]any{"kid": kid, "typ": "entity-statement+jwt", "alg": "RS256"}, body, jwt.Opts{Algorithm: josemodel.RS256})
+		if err != nil {
+			t.Fatalf("failed to create entity statement: %v", err)
+		}
+		return *token
+	}
+	serve := func(contentType, token string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(token)) //nolint:errcheck
+		}
+	}
+	const statementType = "application/entity-statement+jwt"
+
+	type entities struct {
+		leaf, intermediate, trustAnchor, missing model.EntityIdentifier
+	}
+	tests := map[string]struct {
+		routes        func(t *testing.T, e entities) map[string]http.HandlerFunc
+		expectedError string
+		expectedPaths [][]model.ChainHop // expectedPaths hold entity paths relative to the test server, with errors omitted
+	}{
+		"an http status from a superior": {
+			routes: func(t *testing.T, e entities) map[string]http.HandlerFunc {
+				return map[string]http.HandlerFunc{
+					"/leaf/.well-known/openid-federation": serve(statementType, createEntityStatement(t, e.leaf, e.leaf, []model.EntityIdentifier{e.missing}, leafKey, false)),
+				}
+			},
+			expectedError: "unable to build trust chain",
+			expectedPaths: [][]model.ChainHop{{
+				{Entity: "/leaf", Step: model.ChainStepEntityConfiguration},
+				{Entity: "/missing", Step: model.ChainStepEntityConfiguration, Cause: model.ChainFailureHTTPStatus, StatusCode: http.StatusNotFound},
+			}},
+		},
+		"an unreachable superior and a dead end are both reported": {
+			routes: func(t *testing.T, e entities) map[string]http.HandlerFunc {
+				return map[string]http.HandlerFunc{
+					"/leaf/.well-known/openid-federation":         serve(statementType, createEntityStatement(t, e.leaf, e.leaf, []model.EntityIdentifier{model.EntityIdentifier(closed.URL), e.intermediate}, leafKey, false)),
+					"/intermediate/.well-known/openid-federation": serve(statementType, createEntityStatement(t, e.intermediate, e.intermediate, nil, intermediateKey, true)),
+				}
+			},
+			expectedError: "unable to build trust chain",
+			expectedPaths: [][]model.ChainHop{
+				{
+					{Entity: "/leaf", Step: model.ChainStepEntityConfiguration},
+					{Entity: model.EntityIdentifier(closed.URL), Step: model.ChainStepEntityConfiguration, Cause: model.ChainFailureNetwork},
+				},
+				{
+					{Entity: "/leaf", Step: model.ChainStepEntityConfiguration},
+					{Entity: "/intermediate", Step: model.ChainStepEntityConfiguration},
+					{Entity: "/intermediate", Step: model.ChainStepAuthorityHints, Cause: model.ChainFailureDeadEnd},
+				},
+			},
+		},
+		"a wrong content type": {
+			routes: func(t *testing.T, e entities) map[string]http.HandlerFunc {
+				return map[string]http.HandlerFunc{
+					"/leaf/.well-known/openid-federation": serve("application/json", createEntityStatement(t, e.leaf, e.leaf, []model.EntityIdentifier{e.trustAnchor}, leafKey, false)),
+				}
+			},
+			expectedError: "failed to retrieve leaf entity configuration",
+			expectedPaths: [][]model.ChainHop{{
+				{Entity: "/leaf", Step: model.ChainStepEntityConfiguration, Cause: model.ChainFailureContentType},
+			}},
+		},
+		"a bad signature": {
+			routes: func(t *testing.T, e entities) map[string]http.HandlerFunc {
+				statement := strings.Split(createEntityStatement(t, e.intermediate, e.intermediate, []model.EntityIdentifier{e.trustAnchor}, intermediateKey, true), ".")
+				forged := strings.Split(createEntityStatement(t, e.intermediate, e.intermediate, []model.EntityIdentifier{e.trustAnchor}, otherKey, true), ".")
+				return map[string]http.HandlerFunc{
+					"/leaf/.well-known/openid-federation":         serve(statementType, createEntityStatement(t, e.leaf, e.leaf, []model.EntityIdentifier{e.intermediate}, leafKey, false)),
+					"/intermediate/.well-known/openid-federation": serve(statementType, statement[0]+"."+statement[1]+"."+forged[2]),
+				}
+			},
+			expectedError: "unable to build trust chain",
+			expectedPaths: [][]model.ChainHop{{
+				{Entity: "/leaf", Step: model.ChainStepEntityConfiguration},
+				{Entity: "/intermediate", Step: model.ChainStepSignature, Cause: model.ChainFailureBadSignature},
+			}},
+		},
+		"a key ID mismatch": {
+			routes: func(t *testing.T, e entities) map[string]http.HandlerFunc {
+				publicJWK, _ := jwk.PublicJwk(leafKey.Public())
+				(*publicJWK)["kid"] = "test-key"
+				return map[string]http.HandlerFunc{
+					"/leaf/.well-known/openid-federation": serve(statementType, sign(t, leafKey, "rotated-key", map[string]any{
+						"iss":  string(e.leaf),
+						"sub":  string(e.leaf),
+						"iat":  time.Now().UTC().Unix(),
+						"exp":  time.Now().Add(time.Hour).UTC().Unix(),
+						"jwks": map[string]any{"keys": []any{*publicJWK}},
+					})),
+				}
+			},
+			expectedError: "failed to retrieve leaf entity configuration",
+			expectedPaths: [][]model.ChainHop{{
+				{Entity: "/leaf", Step: model.ChainStepSignature, Cause: model.ChainFailureKeyIDMismatch},
+			}},
+		},
+		"an expired subordinate statement": {
+			routes: func(t *testing.T, e entities) map[string]http.HandlerFunc {
+				subjectJWK, _ := jwk.PublicJwk(leafKey.Public())
+				(*subjectJWK)["kid"] = "test-key"
+				return map[string]http.HandlerFunc{
+					"/leaf/.well-known/openid-federation":         serve(statementType, createEntityStatement(t, e.leaf, e.leaf, []model.EntityIdentifier{e.trustAnchor}, leafKey, false)),
+					"/trust-anchor/.well-known/openid-federation": serve(statementType, createEntityStatement(t, e.trustAnchor, e.trustAnchor, nil, trustAnchorKey, true)),
+					"/trust-anchor/fetch": serve(statementType, sign(t, trustAnchorKey, "test-key", map[string]any{
+						"iss":  string(e.trustAnchor),
+						"sub":  string(e.leaf),
+						"iat":  time.Now().Add(-2 * time.Hour).UTC().Unix(),
+						"exp":  time.Now().Add(-time.Hour).UTC().Unix(),
+						"jwks": map[string]any{"keys": []any{*subjectJWK}},
+					})),
+				}
+			},
+			expectedError: "failed to retrieve subordinate statement",
+			expectedPaths: [][]model.ChainHop{{
+				{Entity: "/leaf", Step: model.ChainStepEntityConfiguration},
+				{Entity: "/trust-anchor", Step: model.ChainStepEntityConfiguration},
+				{Entity: "/leaf", Superior: "/trust-anchor", Step: model.ChainStepSubordinateStatement, Cause: model.ChainFailureExpired},
+			}},
+		},
+		"a superior without a fetch endpoint": {
+			routes: func(t *testing.T, e entities) map[string]http.HandlerFunc {
+				return map[string]http.HandlerFunc{
+					"/leaf/.well-known/openid-federation":         serve(statementType, createEntityStatement(t, e.leaf, e.leaf, []model.EntityIdentifier{e.trustAnchor}, leafKey, false)),
+					"/trust-anchor/.well-known/openid-federation": serve(statementType, createEntityStatement(t, e.trustAnchor, e.trustAnchor, nil, trustAnchorKey, false)),
+				}
+			},
+			expectedError: "failed to retrieve subordinate statement",
+			expectedPaths: [][]model.ChainHop{{
+				{Entity: "/leaf", Step: model.ChainStepEntityConfiguration},
+				{Entity: "/trust-anchor", Step: model.ChainStepEntityConfiguration},
+				{Entity: "/leaf", Superior: "/trust-anchor", Step: model.ChainStepFetchEndpoint, Cause: model.ChainFailureMissingEndpoint},
+			}},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			s := httptest.NewTLSServer(mux)
+			defer s.Close()
+
+			e := entities{
+				leaf:         model.EntityIdentifier(s.URL + "/leaf"),
+				intermediate: model.EntityIdentifier(s.URL + "/intermediate"),
+				trustAnchor:  model.EntityIdentifier(s.URL + "/trust-anchor"),
+				missing:      model.EntityIdentifier(s.URL + "/missing"),
+			}
+			for pattern, handler := range tt.routes(t, e) {
+				mux.Handle(pattern, handler)
+			}
+
+			_, _, _, err := BuildTrustChain(t.Context(), model.Configuration{HttpClient: s.Client()}, e.leaf, e.trustAnchor)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+			}
+			var trustChainErr *model.TrustChainError
+			if !errors.As(err, &trustChainErr) {
+				t.Fatalf("expected a *model.TrustChainError, got %T", err)
+			}
+			if trustChainErr.Subject != e.leaf || trustChainErr.TrustAnchor != e.trustAnchor {
+				t.Errorf("expected the error to name %s and %s, got %s and %s", e.leaf, e.trustAnchor, trustChainErr.Subject, trustChainErr.TrustAnchor)
+			}
+
+			var paths [][]model.ChainHop
+			for _, path := range trustChainErr.Paths {
+				if path.Failure() == nil || path.Failure().Error == "" {
+					t.Errorf("expected every path to end with a described failure, got %+v", path)
+				}
+				var hops []model.ChainHop
+				for _, hop := range path.Hops {
+					hop.Entity = model.EntityIdentifier(strings.TrimPrefix(string(hop.Entity), s.URL))
+					hop.Superior = model.EntityIdentifier(strings.TrimPrefix(string(hop.Superior), s.URL))
+					hop.Error = ""
+					hops = append(hops, hop)
+				}
+				paths = append(paths, hops)
+			}
+			if diff := cmp.Diff(tt.expectedPaths, paths); diff != "" {
+				t.Errorf("paths mismatch (-expected +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestChainUpOne(t *testing.T) {
 	leafID := model.EntityIdentifier("https://leaf.example.com")
 	trustAnchorID := model.EntityIdentifier("https://trust-anchor.example.com")
@@ -3,6 +3,7 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
 	"net/url"
 	"reflect"
 	"slices"
@@ -64,50 +65,64 @@ func sortByPriority(policies []MetadataPolicyOperator) []MetadataPolicyOperator
 	return policies
 }
 
+// ProcessAndExtractPolicy combines the metadata policies of the statements in a trust chain. The statements are not
+// modified
 func ProcessAndExtractPolicy(trustChain []EntityStatement) (*MetadataPolicy, error) {
+	return ProcessAndExtractPolicyWithTrace(trustChain, nil)
+}
+
+// ProcessAndExtractPolicyWithTrace behaves as ProcessAndExtractPolicy, additionally recording the operators each
+// statement contributes and the resulting merged policy in trace when it is non-nil
+func ProcessAndExtractPolicyWithTrace(trustChain []EntityStatement, trace *PolicyTrace) (*MetadataPolicy, error) {
 	if len(trustChain) == 1 {
-		return trustChain[0].MetadataPolicy, nil // self-asserting chain of 1
+		trace.contribute(trustChain[0])
+		trace.merged(trustChain[0].MetadataPolicy)
+		return trustChain[0].MetadataPolicy.Clone(), nil // self-asserting chain of 1
 	}
 	if len(trustChain) < 2 {
 		return nil, fmt.Errorf("trust chain must have at least 2 statements")
 	}
 
-	finalisedPolicy := trustChain[0]
+	finalisedPolicy := EntityStatement{MetadataPolicy: trustChain[0].MetadataPolicy.Clone()}
+	trace.contribute(trustChain[0])
 
 	for i := 1; i < len(trustChain); i++ {
 		metadataPolicy := trustChain[i].MetadataPolicy
 		if metadataPolicy == nil {
 			continue
 		}
+		trace.contribute(trustChain[i])
 
 		if finalisedPolicy.MetadataPolicy == nil {
-			finalisedPolicy.MetadataPolicy = metadataPolicy
+			finalisedPolicy.MetadataPolicy = metadataPolicy.Clone()
 			continue
 		}
 
 		var err error
-		if finalisedPolicy.MetadataPolicy.FederationMetadata, err = applyPolicy(finalisedPolicy.MetadataPolicy.FederationMetadata, metadataPolicy.FederationMetadata); err != nil {
+		if finalisedPolicy.MetadataPolicy.FederationMetadata, err = applyPolicy("federation_entity", finalisedPolicy.MetadataPolicy.FederationMetadata, metadataPolicy.FederationMetadata, trace); err != nil {
 			return nil, err
 		}
-		if finalisedPolicy.MetadataPolicy.OpenIDConnectOpenIDProviderMetadata, err = applyPolicy(finalisedPolicy.MetadataPolicy.OpenIDConnectOpenIDProviderMetadata, metadataPolicy.OpenIDConnectOpenIDProviderMetadata); err != nil {
+		if finalisedPolicy.MetadataPolicy.OpenIDConnectOpenIDProviderMetadata, err = applyPolicy("openid_provider", finalisedPolicy.MetadataPolicy.OpenIDConnectOpenIDProviderMetadata, metadataPolicy.OpenIDConnectOpenIDProviderMetadata, trace); err != nil {
 			return nil, err
 		}
-		if finalisedPolicy.MetadataPolicy.OpenIDRelyingPartyMetadata, err = applyPolicy(finalisedPolicy.MetadataPolicy.OpenIDRelyingPartyMetadata, metadataPolicy.OpenIDRelyingPartyMetadata); err != nil {
+		if finalisedPolicy.MetadataPolicy.OpenIDRelyingPartyMetadata, err = applyPolicy("openid_relying_party", finalisedPolicy.MetadataPolicy.OpenIDRelyingPartyMetadata, metadataPolicy.OpenIDRelyingPartyMetadata, trace); err != nil {
 			return nil, err
 		}
 	}
+	trace.merged(finalisedPolicy.MetadataPolicy)
 	return finalisedPolicy.MetadataPolicy, nil
 }
 
-func applyPolicy(existing, policy map[string]PolicyOperators) (map[string]PolicyOperators, error) {
+func applyPolicy(entityType string, existing, policy map[string]PolicyOperators, trace *PolicyTrace) (map[string]PolicyOperators, error) {
 	if policy != nil {
 		if existing == nil {
-			existing = policy
+			existing = maps.Clone(policy)
 		} else {
 			for k, policies := range existing {
 				if policyOp, found := policy[k]; found {
 					mergedPolicies, err := MergePolicyOperators(k, policyOp, policies)
 					if err != nil {
+						trace.fail(entityType, k, err)
 						return nil, err
 					}
 					existing[k] = PolicyOperators{Metadata: mergedPolicies}
@@ -192,15 +207,25 @@ func metadataPolicySetContains(policySet []MetadataPolicyOperator, policyType re
 	return nil, false
 }
 
+// ApplyPolicy returns a copy of the subject with the policy applied to its metadata. The subject is not modified
 func ApplyPolicy(subject EntityStatement, policy MetadataPolicy) (*EntityStatement, error) {
+	return ApplyPolicyWithTrace(subject, policy, nil)
+}
+
+// ApplyPolicyWithTrace behaves as ApplyPolicy, additionally recording the value of each parameter before and after
+// each operator is applied in trace when it is non-nil
+func ApplyPolicyWithTrace(subject EntityStatement, policy MetadataPolicy, trace *PolicyTrace) (*EntityStatement, error) {
 	if subject.Metadata == nil { //if no metadata
 		return &subject, nil
 	}
+	subject.Metadata = subject.Metadata.Clone()
 
 	if subject.Metadata.FederationMetadata != nil {
 		for k, operators := range policy.FederationMetadata {
 			for _, operator := range operators.Metadata {
-				resolved, err := operator.Resolve((*subject.Metadata.FederationMetadata)[k])
+				before := (*subject.Metadata.FederationMetadata)[k]
+				resolved, err := operator.Resolve(before)
+				trace.step("federation_entity", k, operator, before, resolved, err)
 				if err != nil {
 					return nil, err
 				}
@@ -217,6 +242,7 @@ func ApplyPolicy(subject EntityStatement, policy MetadataPolicy) (*EntityStateme
 		for k, operators := range policy.OpenIDRelyingPartyMetadata {
 			for _, operator := range operators.Metadata {
 				existing, ok := (*subject.Metadata.OpenIDRelyingPartyMetadata)[k]
+				before := existing
 				if k == "scope" {
 					// scope has special behaviour
 					if ok {
@@ -230,6 +256,7 @@ func ApplyPolicy(subject EntityStatement, policy MetadataPolicy) (*EntityStateme
 				}
 				resolved, err := operator.Resolve(existing)
 				if err != nil {
+					trace.step("openid_relying_party", k, operator, before, nil, err)
 					return nil, err
 				}
 				if k == "scope" {
@@ -248,6 +275,7 @@ func ApplyPolicy(subject EntityStatement, policy MetadataPolicy) (*EntityStateme
 						return nil, fmt.Errorf("scope must be a string or array of strings")
 					}
 				}
+				trace.step("openid_relying_party", k, operator, before, resolved, nil)
 				if resolved == nil {
 					delete(*subject.Metadata.OpenIDRelyingPartyMetadata, k)
 				} else {
@@ -260,7 +288,9 @@ func ApplyPolicy(subject EntityStatement, policy MetadataPolicy) (*EntityStateme
 	if subject.Metadata.OpenIDConnectOpenIDProviderMetadata != nil {
 		for k, operators := range policy.OpenIDConnectOpenIDProviderMetadata {
 			for _, operator := range operators.Metadata {
-				resolved, err := operator.Resolve((*subject.Metadata.OpenIDConnectOpenIDProviderMetadata)[k])
+				before := (*subject.Metadata.OpenIDConnectOpenIDProviderMetadata)[k]
+				resolved, err := operator.Resolve(before)
+				trace.step("openid_provider", k, operator, before, resolved, err)
 				if err != nil {
 					return nil, err
 				}
@@ -965,7 +965,7 @@ func TestApplyPolicyMergesNewPolicies(t *testing.T) {
 		},
 	}
 
-	result, err := applyPolicy(existing, policy)
+	result, err := applyPolicy("openid_relying_party", existing, policy, nil)
 	if err != nil {
 		t.Fatalf("applyPolicy failed: %v", err)
 	}
@@ -1006,7 +1006,7 @@ func TestApplyPolicyMergesExistingAndNewPolicies(t *testing.T) {
 		},
 	}
 
-	result, err := applyPolicy(existing, policy)
+	result, err := applyPolicy("openid_relying_party", existing, policy, nil)
 	if err != nil {
 		t.Fatalf("applyPolicy failed: %v", err)
 	}
@@ -1037,7 +1037,7 @@ func TestApplyPolicyWithNilExisting(t *testing.T) {
 		},
 	}
 
-	result, err := applyPolicy(nil, policy)
+	result, err := applyPolicy("openid_relying_party", nil, policy, nil)
 	if err != nil {
 		t.Fatalf("applyPolicy failed: %v", err)
 	}
@@ -1114,3 +1114,82 @@ func TestProcessAndExtractPolicyMergesAllLevels(t *testing.T) {
 		t.Errorf("expected 2 policies in OpenIDRelyingPartyMetadata, got %d", len(result.OpenIDRelyingPartyMetadata))
 	}
 }
+
+func TestProcessAndExtractPolicyDoesNotModifyChain(t *testing.T) {
+	leafEssential, _ := NewEssential(true)
+	intermediateAddOp, _ := NewAdd([]any{"scope1"})
+	taValueOp, _ := NewValue("required_value")
+	taAddOp, _ := NewAdd([]any{"scope2"})
+	trustChain := []EntityStatement{
+		{
+			Sub: "https://leaf.example.com",
+			Iss: "https://leaf.example.com",
+			MetadataPolicy: &MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]PolicyOperators{
+				"client_name": {Metadata: []MetadataPolicyOperator{leafEssential}},
+			}},
+		},
+		{
+			Sub: "https://leaf.example.com",
+			Iss: "https://intermediate.example.com",
+			MetadataPolicy: &MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]PolicyOperators{
+				"scope": {Metadata: []MetadataPolicyOperator{intermediateAddOp}},
+			}},
+		},
+		{
+			Sub: "https://intermediate.example.com",
+			Iss: "https://trust-anchor.example.com",
+			MetadataPolicy: &MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]PolicyOperators{
+				"client_name": {Metadata: []MetadataPolicyOperator{taValueOp}},
+				"scope":       {Metadata: []MetadataPolicyOperator{taAddOp}},
+			}},
+		},
+	}
+	parameters := func() [][]string {
+		var keys [][]string
+		for _, statement := range trustChain {
+			var statementKeys []string
+			for parameter, operators := range statement.MetadataPolicy.OpenIDRelyingPartyMetadata {
+				statementKeys = append(statementKeys, fmt.Sprintf("%s:%d", parameter, len(operators.Metadata)))
+			}
+			slices.Sort(statementKeys)
+			keys = append(keys, statementKeys)
+		}
+		return keys
+	}
+	before := parameters()
+
+	for _, chain := range [][]EntityStatement{trustChain, trustChain[1:], trustChain[:1]} {
+		result, err := ProcessAndExtractPolicy(chain)
+		if err != nil {
+			t.Fatalf("ProcessAndExtractPolicy failed: %v", err)
+		}
+		result.OpenIDRelyingPartyMetadata["grant_types"] = PolicyOperators{}
+	}
+	if diff := cmp.Diff(before, parameters()); diff != "" {
+		t.Errorf("expected the trust chain not to be modified (-before +after):\n%s", diff)
+	}
+}
+
+func TestApplyPolicyDoesNotModifySubject(t *testing.T) {
+	valueOp, _ := NewValue("required_value")
+	removeOp, _ := NewValue(nil)
+	policy := MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]PolicyOperators{
+		"client_name": {Metadata: []MetadataPolicyOperator{valueOp}},
+		"logo_uri":    {Metadata: []MetadataPolicyOperator{removeOp}},
+	}}
+	subject := EntityStatement{Metadata: &Metadata{OpenIDRelyingPartyMetadata: &OpenIDRelyingPartyMetadata{
+		"client_name": "original",
+		"logo_uri":    "https://rp.example.com/logo.png",
+	}}}
+
+	result, err := ApplyPolicy(subject, policy)
+	if err != nil {
+		t.Fatalf("ApplyPolicy failed: %v", err)
+	}
+	if diff := cmp.Diff(OpenIDRelyingPartyMetadata{"client_name": "required_value"}, *result.Metadata.OpenIDRelyingPartyMetadata); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(OpenIDRelyingPartyMetadata{"client_name": "original", "logo_uri": "https://rp.example.com/logo.png"}, *subject.Metadata.OpenIDRelyingPartyMetadata); diff != "" {
+		t.Errorf("expected the subject not to be modified (-expected +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,138 @@
+package model
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ListingFilter holds the filters defined for the subordinate listing endpoint. A zero value matches every subordinate
+type ListingFilter struct {
+	EntityTypes   []string // EntityTypes restricts results to subordinates holding metadata for any of the given entity types
+	TrustMarked   bool     // TrustMarked restricts results to subordinates holding at least one unexpired trust mark
+	TrustMarkType string   // TrustMarkType restricts results to subordinates holding an unexpired trust mark of the given type
+	Intermediate  *bool    // Intermediate restricts results to intermediate entities when true and to leaf entities when false
+}
+
+// FilteringRetriever may optionally be implemented by a Retriever able to apply a ListingFilter itself, such as through
+// a database query. When a Retriever does not implement it, subordinates are filtered in memory
+type FilteringRetriever interface {
+	ListSubordinates(ctx context.Context, filter ListingFilter) ([]EntityIdentifier, error)
+}
+
+// ListSubordinates returns the identifiers of all subordinates matching the given filter
+func (cfg *ServerConfiguration) ListSubordinates(ctx context.Context, filter ListingFilter) ([]EntityIdentifier, error) {
+	identifiers := []EntityIdentifier{}
+	for identifier, err := range cfg.StreamListing(ctx, filter) {
+		if err != nil {
+			return nil, err
+		}
+		identifiers = append(identifiers, identifier)
+	}
+	return identifiers, nil
+}
+
+// StreamListing yields the identifiers of all subordinates matching the given filter. Filtering is delegated to the
+// MetadataRetriever when it implements FilteringRetriever, and is otherwise applied to the streamed subordinates
+func (cfg *ServerConfiguration) StreamListing(ctx context.Context, filter ListingFilter) iter.Seq2[EntityIdentifier, error] {
+	if filteringRetriever, ok := cfg.MetadataRetriever.(FilteringRetriever); ok {
+		return func(yield func(EntityIdentifier, error) bool) {
+			identifiers, err := filteringRetriever.ListSubordinates(ctx, filter)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, identifier := range identifiers {
+				if !yield(identifier, nil) {
+					return
+				}
+			}
+		}
+	}
+
+	return func(yield func(EntityIdentifier, error) bool) {
+		now := time.Now()
+		for subordinate, err := range cfg.StreamSubordinates(ctx, nil) {
+			if err != nil {
+				yield("", err)
+				return
+			}
+			if filter.Matches(subordinate.Configuration, now) && !yield(subordinate.Identifier, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Matches reports whether a subordinate satisfies the filter based on the metadata and trust marks known for it
+func (f ListingFilter) Matches(subordinate *SubordinateConfiguration, now time.Time) bool {
+	if subordinate == nil {
+		return false
+	}
+
+	if len(f.EntityTypes) > 0 && !slices.ContainsFunc(f.EntityTypes, subordinate.Metadata.hasEntityType) {
+		return false
+	}
+
+	if f.TrustMarked || f.TrustMarkType != "" {
+		if !slices.ContainsFunc(subordinate.TrustMarks, func(holder TrustMarkHolder) bool {
+			return (f.TrustMarkType == "" || holder.TrustMarkType == f.TrustMarkType) && !trustMarkExpired(holder.TrustMark, now)
+		}) {
+			return false
+		}
+	}
+
+	if f.Intermediate != nil && *f.Intermediate != subordinate.Metadata.isIntermediate() {
+		return false
+	}
+	return true
+}
+
+func (m *Metadata) hasEntityType(entityType string) bool {
+	if m == nil {
+		return false
+	}
+	switch entityType {
+	case "federation_entity":
+		return m.FederationMetadata != nil
+	case "openid_relying_party":
+		return m.OpenIDRelyingPartyMetadata != nil
+	case "openid_provider":
+		return m.OpenIDConnectOpenIDProviderMetadata != nil
+	default:
+		return false
+	}
+}
+
+// isIntermediate reports whether the metadata describes an entity able to issue subordinate statements
+func (m *Metadata) isIntermediate() bool {
+	if m == nil || m.FederationMetadata == nil {
+		return false
+	}
+	_, ok := (*m.FederationMetadata)["federation_fetch_endpoint"]
+	return ok
+}
+
+// trustMarkExpired reports whether a trust mark carries an exp claim in the past. The trust mark signature is not
+// verified, trust marks recorded against a subordinate are expected to have been validated when they were recorded
+func trustMarkExpired(trustMark string, now time.Time) bool {
+	parts := strings.Split(trustMark, ".")
+	if len(parts) != 3 {
+		return true
+	}
+	decodedBody, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return true
+	}
+	var body struct {
+		Exp *int64 `json:"exp"`
+	}
+	if err = json.Unmarshal(decodedBody, &body); err != nil {
+		return true
+	}
+	return body.Exp != nil && now.After(time.Unix(*body.Exp, 0))
+}
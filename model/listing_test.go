@@ -0,0 +1,149 @@
+package model
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+type testFilteringRetriever struct {
+	testRetriever
+	filter *ListingFilter
+}
+
+func (r *testFilteringRetriever) ListSubordinates(ctx context.Context, filter ListingFilter) ([]EntityIdentifier, error) {
+	r.filter = &filter
+	return []EntityIdentifier{"https://some-federation.com/filtered"}, nil
+}
+
+func testTrustMark(t *testing.T, exp time.Time) string {
+	t.Helper()
+	body := base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, `{"exp":%d}`, exp.Unix()))
+	return "eyJhbGciOiJFUzI1NiJ9." + body + ".c2lnbmF0dXJl"
+}
+
+func TestListingFilter_Matches(t *testing.T) {
+	now := time.Now()
+	provider := &SubordinateConfiguration{
+		Metadata: &Metadata{OpenIDConnectOpenIDProviderMetadata: &OpenIDConnectOpenIDProviderMetadata{}},
+		TrustMarks: []TrustMarkHolder{
+			{TrustMarkType: "https://some-trust-mark.com/certified", TrustMark: testTrustMark(t, now.Add(time.Hour))},
+			{TrustMarkType: "https://some-trust-mark.com/expired", TrustMark: testTrustMark(t, now.Add(-time.Hour))},
+		},
+	}
+	intermediate := &SubordinateConfiguration{
+		Metadata: &Metadata{FederationMetadata: &FederationMetadata{"federation_fetch_endpoint": "https://some-federation.com/fetch"}},
+	}
+
+	tests := map[string]struct {
+		filter       ListingFilter
+		subordinate  *SubordinateConfiguration
+		expectsMatch bool
+	}{
+		"an empty filter matches any subordinate": {
+			subordinate:  &SubordinateConfiguration{},
+			expectsMatch: true,
+		},
+		"a subordinate with metadata for a requested entity type matches": {
+			filter:       ListingFilter{EntityTypes: []string{"openid_relying_party", "openid_provider"}},
+			subordinate:  provider,
+			expectsMatch: true,
+		},
+		"a subordinate without metadata for a requested entity type does not match": {
+			filter:      ListingFilter{EntityTypes: []string{"openid_relying_party"}},
+			subordinate: provider,
+		},
+		"a subordinate without known metadata does not match an entity type": {
+			filter:      ListingFilter{EntityTypes: []string{"federation_entity"}},
+			subordinate: &SubordinateConfiguration{},
+		},
+		"a subordinate holding an unexpired trust mark is trust marked": {
+			filter:       ListingFilter{TrustMarked: true},
+			subordinate:  provider,
+			expectsMatch: true,
+		},
+		"a subordinate without trust marks is not trust marked": {
+			filter:      ListingFilter{TrustMarked: true},
+			subordinate: intermediate,
+		},
+		"a subordinate holding an unexpired trust mark of the requested type matches": {
+			filter:       ListingFilter{TrustMarkType: "https://some-trust-mark.com/certified"},
+			subordinate:  provider,
+			expectsMatch: true,
+		},
+		"an expired trust mark of the requested type does not match": {
+			filter:      ListingFilter{TrustMarkType: "https://some-trust-mark.com/expired"},
+			subordinate: provider,
+		},
+		"a malformed trust mark does not match": {
+			filter: ListingFilter{TrustMarked: true},
+			subordinate: &SubordinateConfiguration{TrustMarks: []TrustMarkHolder{
+				{TrustMarkType: "https://some-trust-mark.com/certified", TrustMark: "not-a-jwt"},
+			}},
+		},
+		"a subordinate publishing a fetch endpoint is an intermediate": {
+			filter:       ListingFilter{Intermediate: Pointer(true)},
+			subordinate:  intermediate,
+			expectsMatch: true,
+		},
+		"a leaf is not an intermediate": {
+			filter:      ListingFilter{Intermediate: Pointer(true)},
+			subordinate: provider,
+		},
+		"a leaf matches when intermediates are excluded": {
+			filter:       ListingFilter{Intermediate: Pointer(false)},
+			subordinate:  provider,
+			expectsMatch: true,
+		},
+		"all filters must match": {
+			filter:      ListingFilter{EntityTypes: []string{"openid_provider"}, Intermediate: Pointer(true)},
+			subordinate: provider,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if matches := tt.filter.Matches(tt.subordinate, now); matches != tt.expectsMatch {
+				t.Errorf("expected match to be %t, got %t", tt.expectsMatch, matches)
+			}
+		})
+	}
+}
+
+func TestServerConfiguration_ListSubordinates(t *testing.T) {
+	t.Run("subordinates are filtered in memory", func(t *testing.T) {
+		cfg := &ServerConfiguration{IntermediateConfiguration: &IntermediateConfiguration{}}
+		_ = cfg.IntermediateConfiguration.AddSubordinate("https://some-federation.com/provider", &SubordinateConfiguration{
+			Metadata: &Metadata{OpenIDConnectOpenIDProviderMetadata: &OpenIDConnectOpenIDProviderMetadata{}},
+		})
+		_ = cfg.IntermediateConfiguration.AddSubordinate("https://some-federation.com/relying-party", &SubordinateConfiguration{
+			Metadata: &Metadata{OpenIDRelyingPartyMetadata: &OpenIDRelyingPartyMetadata{}},
+		})
+
+		result, err := cfg.ListSubordinates(t.Context(), ListingFilter{EntityTypes: []string{"openid_provider"}})
+		if err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+		if !slices.Equal(result, []EntityIdentifier{"https://some-federation.com/provider"}) {
+			t.Errorf("expected only the provider to be listed, got %v", result)
+		}
+	})
+	t.Run("filtering is delegated to a retriever supporting it", func(t *testing.T) {
+		retriever := &testFilteringRetriever{}
+		cfg := &ServerConfiguration{IntermediateConfiguration: &IntermediateConfiguration{}, MetadataRetriever: retriever}
+
+		result, err := cfg.ListSubordinates(t.Context(), ListingFilter{TrustMarked: true})
+		if err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+		if !slices.Equal(result, []EntityIdentifier{"https://some-federation.com/filtered"}) {
+			t.Errorf("expected the retriever's result to be returned, got %v", result)
+		}
+		if retriever.filter == nil || !retriever.filter.TrustMarked {
+			t.Errorf("expected the filter to be passed to the retriever, got %v", retriever.filter)
+		}
+	})
+}
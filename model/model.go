@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"reflect"
 	"slices"
+	"sync"
 	"time"
 
 	josemodel "github.com/MichaelFraser99/go-jose/model"
@@ -33,17 +35,18 @@ func (cfg *Configuration) LogError(ctx context.Context, msg string, args ...any)
 
 type ServerConfiguration struct {
 	Configuration
-	SignerConfiguration         SignerConfiguration
-	EntityIdentifier            EntityIdentifier
-	AuthorityHints              []EntityIdentifier
-	TrustMarks                  []TrustMarkHolder
-	EntityConfiguration         EntityStatement
-	EntityConfigurationLifetime time.Duration
-	IntermediateConfiguration   *IntermediateConfiguration
-	Extensions                  Extensions
-	MetadataRetriever           Retriever
-	TrustMarkIssuerRetriever    TrustMarkIssuerRetriever
-	TrustMarkRetriever          TrustMarkRetriever
+	SignerConfiguration              SignerConfiguration
+	EntityIdentifier                 EntityIdentifier
+	AuthorityHints                   []EntityIdentifier
+	TrustMarks                       []TrustMarkHolder
+	EntityConfiguration              EntityStatement
+	EntityConfigurationLifetime      time.Duration
+	EntityConfigurationRefreshMargin time.Duration // EntityConfigurationRefreshMargin determines how long before expiry a cached Entity Configuration is regenerated - defaults to a tenth of EntityConfigurationLifetime
+	IntermediateConfiguration        *IntermediateConfiguration
+	Extensions                       Extensions
+	MetadataRetriever                Retriever
+	TrustMarkIssuerRetriever         TrustMarkIssuerRetriever
+	TrustMarkRetriever               TrustMarkRetriever
 }
 
 type ClientConfiguration struct {
@@ -54,7 +57,10 @@ func (cfg *ServerConfiguration) GetSubordinates(ctx context.Context) (map[Entity
 	if cfg.MetadataRetriever != nil {
 		return cfg.MetadataRetriever.GetSubordinates(ctx)
 	}
-	return cfg.IntermediateConfiguration.subordinates, nil
+	if cfg.IntermediateConfiguration == nil {
+		return map[EntityIdentifier]*SubordinateConfiguration{}, nil
+	}
+	return cfg.IntermediateConfiguration.cache.snapshot(), nil
 }
 
 func (cfg *ServerConfiguration) GetSubordinateJWKs(ctx context.Context) ([]SignerConfiguration, error) {
@@ -76,25 +82,22 @@ func (cfg *ServerConfiguration) GetSubordinateJWKs(ctx context.Context) ([]Signe
 	return signers, nil
 }
 
+// GetSubordinate returns the configuration for the given subordinate, loading it from the MetadataRetriever and caching
+// it for SubordinateCacheTime when one is configured. Subordinates registered through AddSubordinate take precedence
+// over the MetadataRetriever and never expire. Safe for concurrent use
 func (cfg *ServerConfiguration) GetSubordinate(ctx context.Context, identifier EntityIdentifier) (*SubordinateConfiguration, error) {
-	if cfg.IntermediateConfiguration.subordinates == nil {
-		cfg.IntermediateConfiguration.subordinates = map[EntityIdentifier]*SubordinateConfiguration{}
+	if cfg.IntermediateConfiguration == nil {
+		return nil, fmt.Errorf("subordinate entity %s not found", identifier)
 	}
-
-	if cfg.IntermediateConfiguration.subordinates[identifier] != nil && time.Now().UTC().Before(time.Unix(cfg.IntermediateConfiguration.subordinates[identifier].CachedAt, 0).Add(cfg.IntermediateConfiguration.SubordinateCacheTime).UTC()) {
-		return cfg.IntermediateConfiguration.subordinates[identifier], nil
-	} else if cfg.MetadataRetriever != nil {
-		entity, err := cfg.MetadataRetriever.GetSubordinate(ctx, identifier)
-		if err != nil {
-			return nil, err
-		} else {
-			cfg.IntermediateConfiguration.subordinates[identifier] = entity
-			cfg.IntermediateConfiguration.subordinates[identifier].CachedAt = time.Now().UTC().Unix()
+	if cfg.MetadataRetriever == nil {
+		if subordinate, ok := cfg.IntermediateConfiguration.cache.get(identifier); ok {
+			return subordinate, nil
 		}
-		return cfg.IntermediateConfiguration.subordinates[identifier], nil
-	} else {
 		return nil, fmt.Errorf("subordinate entity %s not found", identifier)
 	}
+	return cfg.IntermediateConfiguration.cache.load(ctx, identifier, cfg.IntermediateConfiguration.SubordinateCacheTime, func(ctx context.Context) (*SubordinateConfiguration, error) {
+		return cfg.MetadataRetriever.GetSubordinate(ctx, identifier)
+	})
 }
 
 type Retriever interface {
@@ -117,6 +120,36 @@ type ExtendedListingRetriever interface {
 	GetExtendedSubordinates(ctx context.Context, from *EntityIdentifier, size int, claims []string) (*ExtendedListingResponse, error)
 }
 
+// FilteringExtendedListingRetriever is an ExtendedListingRetriever that also supports the 'updated_after',
+// 'updated_before' and 'audit_timestamps' parameters. When the configured retriever does not implement it, requests
+// using those parameters are rejected as unsupported. Implementations unable to honour a parameter for the entities
+// they list should return an error created with NewUnsupportedParameterError rather than a partial listing
+type FilteringExtendedListingRetriever interface {
+	ExtendedListingRetriever
+	GetFilteredExtendedSubordinates(ctx context.Context, request ExtendedListingRequest) (*ExtendedListingResponse, error)
+}
+
+// ExtendedListingRequest holds the parameters of an extended subordinate listing request
+type ExtendedListingRequest struct {
+	From            *EntityIdentifier // From is the identifier of the first entity to return, nil when listing from the start
+	Size            int               // Size is the maximum number of entities to return
+	Claims          []string          // Claims lists the additional claims requested for each entity
+	UpdatedAfter    *time.Time        // UpdatedAfter restricts results to entities updated after the given time
+	UpdatedBefore   *time.Time        // UpdatedBefore restricts results to entities updated before the given time
+	AuditTimestamps bool              // AuditTimestamps requests the 'registered' and 'updated' claims for each entity
+}
+
+// UpdatedWithin reports whether an entity last updated at the given time falls within the requested update range
+func (r ExtendedListingRequest) UpdatedWithin(updated time.Time) bool {
+	if r.UpdatedAfter != nil && !updated.After(*r.UpdatedAfter) {
+		return false
+	}
+	if r.UpdatedBefore != nil && !updated.Before(*r.UpdatedBefore) {
+		return false
+	}
+	return true
+}
+
 type SubordinateStatusRetriever interface {
 	GetSubordinateStatus(ctx context.Context, sub EntityIdentifier) (*SubordinateStatusResponse, error)
 }
@@ -124,6 +157,15 @@ type SubordinateStatusRetriever interface {
 type Extensions struct {
 	ExtendedListing   ExtendedListingConfiguration
 	SubordinateStatus SubordinateStatusConfiguration
+	ResolveTrace      ResolveTraceConfiguration
+}
+
+// ResolveTraceConfiguration controls the debug mode of the resolve endpoint. When enabled, requests including the
+// parameter trace=true receive an unsigned JSON response carrying a PolicyTrace alongside either the resolved claims or
+// the error that prevented resolution. Traces reveal the metadata policies of every statement in the chain, so this
+// is intended for use within test federations
+type ResolveTraceConfiguration struct {
+	Enabled bool
 }
 
 type SubordinateStatusConfiguration struct {
@@ -139,35 +181,171 @@ type ExtendedListingConfiguration struct {
 }
 
 type IntermediateConfiguration struct {
-	subordinates                 map[EntityIdentifier]*SubordinateConfiguration
+	cache                        subordinateCache
+	events                       subordinateEventLog
+	listeners                    []*SubordinateChangeListener
+	listenersMu                  sync.RWMutex
+	validator                    *SubordinateValidator // validator is guarded by validatorMu
+	validatorMu                  sync.RWMutex
 	SubordinateStatementLifetime time.Duration
-	SubordinateCacheTime         time.Duration
+	RefusePolicyConflicts        bool          // RefusePolicyConflicts requests that the validator installed by the server rejects subordinate policies conflicting with a superior's policy, rather than only logging the conflict
+	SubordinateCacheTime         time.Duration // SubordinateCacheTime determines how long subordinates loaded from a MetadataRetriever are cached. Subordinates registered through AddSubordinate are not subject to it and are served until updated, removed or flushed - they previously expired once SubordinateCacheTime had elapsed
+}
+
+// SubordinateChangeListener is notified whenever the configuration held for a subordinate changes.
+// A nil identifier indicates that any subordinate may have changed
+type SubordinateChangeListener func(identifier *EntityIdentifier)
+
+// SubordinateValidator checks configuration for a subordinate before it is registered or updated. A returned error
+// rejects the change
+type SubordinateValidator func(ctx context.Context, identifier EntityIdentifier, subordinate *SubordinateConfiguration) error
+
+// OnSubordinateChange registers a listener to be notified of subordinate configuration changes, allowing
+// anything derived from subordinate configuration (such as signed statements) to be invalidated. The returned function
+// removes the listener, and must be called once the listener is no longer needed so that anything it references can be
+// released
+func (i *IntermediateConfiguration) OnSubordinateChange(listener SubordinateChangeListener) (unsubscribe func()) {
+	registered := &listener
+	i.listenersMu.Lock()
+	defer i.listenersMu.Unlock()
+	i.listeners = append(i.listeners, registered)
+
+	return func() {
+		i.listenersMu.Lock()
+		defer i.listenersMu.Unlock()
+		i.listeners = slices.DeleteFunc(i.listeners, func(candidate *SubordinateChangeListener) bool {
+			return candidate == registered
+		})
+	}
+}
+
+// SetSubordinateValidator installs a check applied by AddSubordinate and UpdateSubordinate, and by ValidateSubordinate,
+// replacing any validator installed before it. The returned function removes the validator if it is still installed
+func (i *IntermediateConfiguration) SetSubordinateValidator(validator SubordinateValidator) (remove func()) {
+	installed := &validator
+	i.validatorMu.Lock()
+	defer i.validatorMu.Unlock()
+	i.validator = installed
+
+	return func() {
+		i.validatorMu.Lock()
+		defer i.validatorMu.Unlock()
+		if i.validator == installed {
+			i.validator = nil
+		}
+	}
 }
 
+// ValidateSubordinate applies the installed SubordinateValidator to configuration for a subordinate, returning nil when
+// no validator is installed. Stores persisting subordinates outside the IntermediateConfiguration can call it to apply
+// the same checks before a change is stored
+func (i *IntermediateConfiguration) ValidateSubordinate(ctx context.Context, identifier EntityIdentifier, subordinateConfiguration *SubordinateConfiguration) error {
+	i.validatorMu.RLock()
+	validator := i.validator
+	i.validatorMu.RUnlock()
+	if validator == nil {
+		return nil
+	}
+	if err := (*validator)(ctx, identifier, subordinateConfiguration); err != nil {
+		return fmt.Errorf("subordinate %s rejected: %w", identifier, err)
+	}
+	return nil
+}
+
+func (i *IntermediateConfiguration) notifySubordinateChange(identifier *EntityIdentifier) {
+	i.listenersMu.RLock()
+	defer i.listenersMu.RUnlock()
+	for _, listener := range i.listeners {
+		(*listener)(identifier)
+	}
+}
+
+// FlushCache discards all subordinate configuration, including subordinates registered through AddSubordinate
 func (i *IntermediateConfiguration) FlushCache() {
-	i.subordinates = map[EntityIdentifier]*SubordinateConfiguration{}
+	i.cache.flush()
+	i.notifySubordinateChange(nil)
 }
 
-func (i *IntermediateConfiguration) AddSubordinate(identifier EntityIdentifier, subordinateConfiguration *SubordinateConfiguration) {
-	if i.subordinates == nil {
-		i.subordinates = map[EntityIdentifier]*SubordinateConfiguration{}
+// AddSubordinate registers configuration for a subordinate, recording a registration event for new subordinates and a
+// metadata update event for existing ones. Returns the error of the installed SubordinateValidator if it rejects the
+// configuration, in which case nothing is registered. The configuration must not be modified after it has been added
+func (i *IntermediateConfiguration) AddSubordinate(identifier EntityIdentifier, subordinateConfiguration *SubordinateConfiguration) error {
+	if err := i.ValidateSubordinate(context.Background(), identifier, subordinateConfiguration); err != nil {
+		return err
 	}
 	subordinateConfiguration.JWKs.Opts.EnforceUniqueKIDs = true
-	subordinateConfiguration.CachedAt = time.Now().UTC().Unix()
 
-	i.subordinates[identifier] = subordinateConfiguration
+	if i.cache.set(identifier, subordinateConfiguration, 0) {
+		i.events.record(identifier, SubordinateEventMetadataUpdate)
+	} else {
+		i.events.record(identifier, SubordinateEventRegistration)
+	}
+	i.notifySubordinateChange(&identifier)
+	return nil
+}
+
+// UpdateSubordinate replaces the configuration of a subordinate registered through AddSubordinate and records a
+// metadata update event. Returns a not found error if the subordinate is not registered - configuration loaded from a
+// MetadataRetriever is owned by the retriever and is only cached here. Returns the error of the installed
+// SubordinateValidator if it rejects the configuration, leaving the registered configuration in place. The
+// configuration must not be modified after it has been added
+func (i *IntermediateConfiguration) UpdateSubordinate(identifier EntityIdentifier, subordinateConfiguration *SubordinateConfiguration) error {
+	if !i.cache.registered(identifier) {
+		return NewNotFoundError(fmt.Sprintf("unknown entity identifier: %s", identifier))
+	}
+	if err := i.ValidateSubordinate(context.Background(), identifier, subordinateConfiguration); err != nil {
+		return err
+	}
+	subordinateConfiguration.JWKs.Opts.EnforceUniqueKIDs = true
+
+	if !i.cache.replaceRegistered(identifier, subordinateConfiguration) {
+		return NewNotFoundError(fmt.Sprintf("unknown entity identifier: %s", identifier))
+	}
+	i.events.record(identifier, SubordinateEventMetadataUpdate)
+	i.notifySubordinateChange(&identifier)
+	return nil
+}
+
+// RemoveSubordinate removes a subordinate registered through AddSubordinate and records a revocation event. Returns a
+// not found error if the subordinate is not registered, as configuration loaded from a MetadataRetriever would be
+// reloaded on next use. Where a MetadataRetriever is configured and still returns the subordinate, it continues to be
+// served from the retriever once removed
+func (i *IntermediateConfiguration) RemoveSubordinate(identifier EntityIdentifier) error {
+	if !i.cache.removeRegistered(identifier) {
+		return NewNotFoundError(fmt.Sprintf("unknown entity identifier: %s", identifier))
+	}
+	i.events.record(identifier, SubordinateEventRevocation)
+	i.notifySubordinateChange(&identifier)
+	return nil
+}
+
+// ListSubordinates returns the identifiers of all subordinates currently held, in lexical order
+func (i *IntermediateConfiguration) ListSubordinates() []EntityIdentifier {
+	return slices.Sorted(maps.Keys(i.cache.snapshot()))
 }
 
 type SubordinateConfiguration struct {
-	CachedAt            int64
+	CachedAt            int64 // Deprecated: cache expiry is tracked internally and CachedAt is no longer read or written. Subordinates registered through AddSubordinate no longer expire after SubordinateCacheTime
 	Policies            MetadataPolicy
 	JWKs                josemodel.Jwks
 	SignerConfiguration *SignerConfiguration // SignerConfiguration allows consumers to specify override private key material for a given subordinate entity
+	Metadata            *Metadata            // Metadata holds the subordinate's known metadata, used when filtering subordinate listings
+	TrustMarks          []TrustMarkHolder    // TrustMarks holds the trust marks known to have been issued to the subordinate, used when filtering subordinate listings
+	Constraints         map[string]any       // Constraints holds the trust chain constraints published in the subordinate's statement
 }
 
 type SignerConfiguration struct {
 	Signer           crypto.Signer
 	KeyID, Algorithm string
+	RemoteSigner     RemoteSigner // RemoteSigner takes precedence over Signer, KeyID and Algorithm when set, allowing signing to be delegated to an external key store
+}
+
+// AsRemoteSigner returns the configured RemoteSigner, or a RemoteSigner wrapping the configured crypto.Signer when none is set
+func (s SignerConfiguration) AsRemoteSigner() RemoteSigner {
+	if s.RemoteSigner != nil {
+		return s.RemoteSigner
+	}
+	return NewCryptoRemoteSigner(s.Signer, s.KeyID, s.Algorithm)
 }
 
 type ExtendedListingResponse struct {
@@ -240,7 +418,7 @@ func (e *EntityStatement) UnmarshalJSON(data []byte) error {
 		return fmt.Errorf("'exp' claim is malformed")
 	} else {
 		if time.Now().UTC().Unix() > int64(iExp) {
-			return fmt.Errorf("entity statement has expired")
+			return ErrStatementExpired
 		}
 		e.Exp = int64(iExp)
 	}
@@ -302,6 +480,19 @@ func (e *EntityStatement) UnmarshalJSON(data []byte) error {
 		}
 		e.MetadataPolicy = &metadataPolicy
 	}
+
+	if trustMarkIssuers, ok := jsonMap["trust_mark_issuers"]; ok {
+		bytes, err := json.Marshal(trustMarkIssuers)
+		if err != nil {
+			return fmt.Errorf("malformed 'trust_mark_issuers' claim: invalid JSON")
+		}
+		var parsedTrustMarkIssuers map[string][]EntityIdentifier
+		err = json.Unmarshal(bytes, &parsedTrustMarkIssuers)
+		if err != nil {
+			return fmt.Errorf("invalid 'trust_mark_issuers' claim: %s", err.Error())
+		}
+		e.TrustMarkIssuers = parsedTrustMarkIssuers
+	}
 	return nil
 }
 
@@ -341,6 +532,50 @@ type MetadataPolicy struct {
 	OpenIDConnectOpenIDProviderMetadata map[string]PolicyOperators `json:"openid_provider,omitempty"`
 }
 
+// Clone returns a copy of the policy whose maps can be modified without affecting the original. The operators are
+// shared, as they are never modified once created
+func (m *MetadataPolicy) Clone() *MetadataPolicy {
+	if m == nil {
+		return nil
+	}
+	return &MetadataPolicy{
+		FederationMetadata:                  maps.Clone(m.FederationMetadata),
+		OpenIDRelyingPartyMetadata:          maps.Clone(m.OpenIDRelyingPartyMetadata),
+		OpenIDConnectOpenIDProviderMetadata: maps.Clone(m.OpenIDConnectOpenIDProviderMetadata),
+	}
+}
+
+// Validate checks that the operators set against each claim can be combined with one another
+func (m MetadataPolicy) Validate() error {
+	for entityType, policies := range m.byEntityType() {
+		for claim, operators := range policies {
+			if err := validatePoliciesCanCombine(operators.Metadata); err != nil {
+				return fmt.Errorf("invalid %s policy for claim '%s': %w", entityType, claim, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Clone returns a copy of the metadata whose maps can be modified without affecting the original. Parameter values
+// are shared, as policy application replaces values rather than modifying them
+func (m *Metadata) Clone() *Metadata {
+	if m == nil {
+		return nil
+	}
+	cloned := &Metadata{}
+	if m.FederationMetadata != nil {
+		cloned.FederationMetadata = Pointer(maps.Clone(*m.FederationMetadata))
+	}
+	if m.OpenIDRelyingPartyMetadata != nil {
+		cloned.OpenIDRelyingPartyMetadata = Pointer(maps.Clone(*m.OpenIDRelyingPartyMetadata))
+	}
+	if m.OpenIDConnectOpenIDProviderMetadata != nil {
+		cloned.OpenIDConnectOpenIDProviderMetadata = Pointer(maps.Clone(*m.OpenIDConnectOpenIDProviderMetadata))
+	}
+	return cloned
+}
+
 func (m *Metadata) UnmarshalJSON(data []byte) error {
 	var bytesMap map[string]any
 	err := json.Unmarshal(data, &bytesMap)
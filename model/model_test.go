@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -255,3 +257,149 @@ func TestMetadata_UnmarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestEntityStatement_UnmarshalJSON_TrustMarkIssuers(t *testing.T) {
+	statement := func(trustMarkIssuers string) string {
+		claims := fmt.Sprintf(`"iss": "https://ta.example.com", "sub": "https://ta.example.com", "iat": 1700000000, "exp": %d, "jwks": {"keys": []}`, time.Now().Add(time.Hour).Unix())
+		if trustMarkIssuers != "" {
+			claims += `, "trust_mark_issuers": ` + trustMarkIssuers
+		}
+		return "{" + claims + "}"
+	}
+
+	tests := map[string]struct {
+		trustMarkIssuers string
+		expected         map[string][]EntityIdentifier
+		expectedError    string
+	}{
+		"an absent claim": {},
+		"issuers are parsed by trust mark type": {
+			trustMarkIssuers: `{"https://ta.example.com/certified": ["https://tmi.example.com", "https://other-tmi.example.com"], "https://ta.example.com/open": []}`,
+			expected: map[string][]EntityIdentifier{
+				"https://ta.example.com/certified": {"https://tmi.example.com", "https://other-tmi.example.com"},
+				"https://ta.example.com/open":      {},
+			},
+		},
+		"a claim that is not an object": {
+			trustMarkIssuers: `["https://tmi.example.com"]`,
+			expectedError:    "invalid 'trust_mark_issuers' claim",
+		},
+		"issuers that are not a list": {
+			trustMarkIssuers: `{"https://ta.example.com/certified": "https://tmi.example.com"}`,
+			expectedError:    "invalid 'trust_mark_issuers' claim",
+		},
+		"an issuer that is not a string": {
+			trustMarkIssuers: `{"https://ta.example.com/certified": [1]}`,
+			expectedError:    "invalid 'trust_mark_issuers' claim",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var e EntityStatement
+			err := json.Unmarshal([]byte(statement(tt.trustMarkIssuers)), &e)
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if diff := cmp.Diff(tt.expected, e.TrustMarkIssuers); diff != "" {
+				t.Errorf("mismatch (-expected +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExtendedListingRequest_UpdatedWithin(t *testing.T) {
+	after := time.Unix(1700000000, 0)
+	before := time.Unix(1700086400, 0)
+
+	tests := map[string]struct {
+		request  ExtendedListingRequest
+		updated  time.Time
+		expected bool
+	}{
+		"any time is within an unbounded range": {
+			updated:  after,
+			expected: true,
+		},
+		"a time after updated_after is within range": {
+			request:  ExtendedListingRequest{UpdatedAfter: &after},
+			updated:  after.Add(time.Second),
+			expected: true,
+		},
+		"a time equal to updated_after is out of range": {
+			request: ExtendedListingRequest{UpdatedAfter: &after},
+			updated: after,
+		},
+		"a time before updated_before is within range": {
+			request:  ExtendedListingRequest{UpdatedAfter: &after, UpdatedBefore: &before},
+			updated:  before.Add(-time.Second),
+			expected: true,
+		},
+		"a time equal to updated_before is out of range": {
+			request: ExtendedListingRequest{UpdatedBefore: &before},
+			updated: before,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if result := tt.request.UpdatedWithin(tt.updated); result != tt.expected {
+				t.Errorf("expected %t, got %t", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestMetadataPolicy_Validate(t *testing.T) {
+	add, err := NewAdd([]any{"authorization_code"})
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	subsetOf, err := NewSubsetOf([]any{"authorization_code", "refresh_token"})
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	oneOf, err := NewOneOf([]any{"authorization_code"})
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+
+	tests := map[string]struct {
+		policy   MetadataPolicy
+		expected string
+	}{
+		"an empty policy is valid": {},
+		"compatible operators are valid": {
+			policy: MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]PolicyOperators{
+				"grant_types": {Metadata: []MetadataPolicyOperator{*add, *subsetOf}},
+			}},
+		},
+		"conflicting operators are invalid": {
+			policy: MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]PolicyOperators{
+				"grant_types": {Metadata: []MetadataPolicyOperator{*add, *oneOf}},
+			}},
+			expected: "invalid openid_relying_party policy for claim 'grant_types': cannot merge policy of type 'add' with policy of type 'one_of'",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if tt.expected == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.expected {
+				t.Fatalf("expected error %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
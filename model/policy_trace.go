@@ -0,0 +1,135 @@
+package model
+
+import (
+	"slices"
+	"strings"
+)
+
+// PolicyTrace records how the metadata policies within a trust chain were combined and applied to the subject's
+// metadata. It is populated by ProcessAndExtractPolicyWithTrace and ApplyPolicyWithTrace and is intended for diagnosing
+// metadata that cannot be resolved, such as identifying which superior's operator removed a value
+type PolicyTrace struct {
+	Parameters []*ParameterTrace `json:"parameters"` // Parameters are ordered by entity type and then parameter name
+}
+
+// ParameterTrace records the processing of the policy for a single metadata parameter
+type ParameterTrace struct {
+	EntityType    string               `json:"entity_type"`
+	Parameter     string               `json:"parameter"`
+	Contributions []PolicyContribution `json:"contributions,omitempty"` // Contributions are the operators declared by each statement, in trust chain order
+	Merged        []TracedOperator     `json:"merged,omitempty"`        // Merged is the combined operator set in the order it is applied
+	Steps         []PolicyStep         `json:"steps,omitempty"`         // Steps record the parameter value either side of each merged operator
+	Error         string               `json:"error,omitempty"`         // Error is set when the contributed operators could not be combined
+}
+
+// TracedOperator is a policy operator and its value
+type TracedOperator struct {
+	Operator string `json:"operator"`
+	Value    any    `json:"value"`
+}
+
+// PolicyContribution is an operator declared within the metadata policy of a single statement
+type PolicyContribution struct {
+	Issuer  EntityIdentifier `json:"iss"`
+	Subject EntityIdentifier `json:"sub"`
+	TracedOperator
+}
+
+// PolicyStep is the application of a single operator to the subject's metadata. A nil value indicates the parameter
+// was absent
+type PolicyStep struct {
+	Operator string `json:"operator"`
+	Before   any    `json:"before"`
+	After    any    `json:"after"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Parameter returns the trace for the given entity type and metadata parameter, or nil if no policy applied to it
+func (t *PolicyTrace) Parameter(entityType, parameter string) *ParameterTrace {
+	if t == nil {
+		return nil
+	}
+	if i, found := t.search(entityType, parameter); found {
+		return t.Parameters[i]
+	}
+	return nil
+}
+
+func (t *PolicyTrace) search(entityType, parameter string) (int, bool) {
+	return slices.BinarySearchFunc(t.Parameters, [2]string{entityType, parameter}, func(p *ParameterTrace, target [2]string) int {
+		if c := strings.Compare(p.EntityType, target[0]); c != 0 {
+			return c
+		}
+		return strings.Compare(p.Parameter, target[1])
+	})
+}
+
+// parameter returns the trace for the given entity type and metadata parameter, adding it if not yet present
+func (t *PolicyTrace) parameter(entityType, parameter string) *ParameterTrace {
+	i, found := t.search(entityType, parameter)
+	if !found {
+		t.Parameters = slices.Insert(t.Parameters, i, &ParameterTrace{EntityType: entityType, Parameter: parameter})
+	}
+	return t.Parameters[i]
+}
+
+func (t *PolicyTrace) contribute(statement EntityStatement) {
+	if t == nil || statement.MetadataPolicy == nil {
+		return
+	}
+	for entityType, policies := range statement.MetadataPolicy.byEntityType() {
+		for parameter, operators := range policies {
+			trace := t.parameter(entityType, parameter)
+			for _, operator := range sortByPriority(slices.Clone(operators.Metadata)) {
+				trace.Contributions = append(trace.Contributions, PolicyContribution{
+					Issuer:         statement.Iss,
+					Subject:        statement.Sub,
+					TracedOperator: TracedOperator{Operator: operator.String(), Value: operator.OperatorValue()},
+				})
+			}
+		}
+	}
+}
+
+func (t *PolicyTrace) merged(policy *MetadataPolicy) {
+	if t == nil || policy == nil {
+		return
+	}
+	for entityType, policies := range policy.byEntityType() {
+		for parameter, operators := range policies {
+			trace := t.parameter(entityType, parameter)
+			trace.Merged = nil
+			for _, operator := range operators.Metadata {
+				trace.Merged = append(trace.Merged, TracedOperator{Operator: operator.String(), Value: operator.OperatorValue()})
+			}
+		}
+	}
+}
+
+func (t *PolicyTrace) fail(entityType, parameter string, err error) {
+	if t == nil {
+		return
+	}
+	t.parameter(entityType, parameter).Error = err.Error()
+}
+
+func (t *PolicyTrace) step(entityType, parameter string, operator MetadataPolicyOperator, before, after any, err error) {
+	if t == nil {
+		return
+	}
+	step := PolicyStep{Operator: operator.String(), Before: before, After: after}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	trace := t.parameter(entityType, parameter)
+	trace.Steps = append(trace.Steps, step)
+}
+
+// byEntityType returns the policy for each entity type keyed by its metadata type identifier
+func (m MetadataPolicy) byEntityType() map[string]map[string]PolicyOperators {
+	return map[string]map[string]PolicyOperators{
+		"federation_entity":    m.FederationMetadata,
+		"openid_relying_party": m.OpenIDRelyingPartyMetadata,
+		"openid_provider":      m.OpenIDConnectOpenIDProviderMetadata,
+	}
+}
@@ -0,0 +1,150 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPolicyTrace(t *testing.T) {
+	operator := func(t *testing.T, operator MetadataPolicyOperator, err error) MetadataPolicyOperator {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("expected no error creating policy operator, got %q", err.Error())
+		}
+		return operator
+	}
+	subsetOf := func(t *testing.T, values ...any) MetadataPolicyOperator {
+		o, err := NewSubsetOf(values)
+		return operator(t, *o, err)
+	}
+	value := func(t *testing.T, v any) MetadataPolicyOperator {
+		o, err := NewValue(v)
+		return operator(t, *o, err)
+	}
+	supersetOf := func(t *testing.T, values ...any) MetadataPolicyOperator {
+		o, err := NewSupersetOf(values)
+		return operator(t, *o, err)
+	}
+	statement := func(iss, sub EntityIdentifier, policy map[string]PolicyOperators) EntityStatement {
+		return EntityStatement{Iss: iss, Sub: sub, MetadataPolicy: &MetadataPolicy{OpenIDRelyingPartyMetadata: policy}}
+	}
+
+	tests := map[string]struct {
+		chain         func(t *testing.T) []EntityStatement
+		expectedError string
+		expected      *ParameterTrace
+	}{
+		"operators are traced from each statement through to the resolved value": {
+			chain: func(t *testing.T) []EntityStatement {
+				return []EntityStatement{
+					{Iss: "https://leaf.example.com", Sub: "https://leaf.example.com"},
+					statement("https://int.example.com", "https://leaf.example.com", map[string]PolicyOperators{
+						"redirect_uris": {Metadata: []MetadataPolicyOperator{subsetOf(t, "https://leaf.example.com/cb", "https://leaf.example.com/other")}},
+					}),
+					statement("https://ta.example.com", "https://int.example.com", map[string]PolicyOperators{
+						"redirect_uris": {Metadata: []MetadataPolicyOperator{subsetOf(t, "https://leaf.example.com/cb")}},
+					}),
+				}
+			},
+			expected: &ParameterTrace{
+				EntityType: "openid_relying_party",
+				Parameter:  "redirect_uris",
+				Contributions: []PolicyContribution{
+					{Issuer: "https://int.example.com", Subject: "https://leaf.example.com", TracedOperator: TracedOperator{Operator: "subset_of", Value: []any{"https://leaf.example.com/cb", "https://leaf.example.com/other"}}},
+					{Issuer: "https://ta.example.com", Subject: "https://int.example.com", TracedOperator: TracedOperator{Operator: "subset_of", Value: []any{"https://leaf.example.com/cb"}}},
+				},
+				Merged: []TracedOperator{{Operator: "subset_of", Value: []any{"https://leaf.example.com/cb"}}},
+				Steps: []PolicyStep{{
+					Operator: "subset_of",
+					Before:   []any{"https://leaf.example.com/cb", "https://leaf.example.com/old"},
+					After:    []any{"https://leaf.example.com/cb"},
+				}},
+			},
+		},
+		"the failing operator is identified when the policy cannot be applied": {
+			chain: func(t *testing.T) []EntityStatement {
+				return []EntityStatement{
+					{Iss: "https://leaf.example.com", Sub: "https://leaf.example.com"},
+					statement("https://int.example.com", "https://leaf.example.com", map[string]PolicyOperators{
+						"redirect_uris": {Metadata: []MetadataPolicyOperator{subsetOf(t, "https://leaf.example.com/cb", "https://leaf.example.com/new")}},
+					}),
+					statement("https://ta.example.com", "https://int.example.com", map[string]PolicyOperators{
+						"redirect_uris": {Metadata: []MetadataPolicyOperator{supersetOf(t, "https://leaf.example.com/new")}},
+					}),
+				}
+			},
+			expectedError: "provided metadata is not a superset of the defined operator values",
+			expected: &ParameterTrace{
+				EntityType: "openid_relying_party",
+				Parameter:  "redirect_uris",
+				Contributions: []PolicyContribution{
+					{Issuer: "https://int.example.com", Subject: "https://leaf.example.com", TracedOperator: TracedOperator{Operator: "subset_of", Value: []any{"https://leaf.example.com/cb", "https://leaf.example.com/new"}}},
+					{Issuer: "https://ta.example.com", Subject: "https://int.example.com", TracedOperator: TracedOperator{Operator: "superset_of", Value: []any{"https://leaf.example.com/new"}}},
+				},
+				Merged: []TracedOperator{
+					{Operator: "subset_of", Value: []any{"https://leaf.example.com/cb", "https://leaf.example.com/new"}},
+					{Operator: "superset_of", Value: []any{"https://leaf.example.com/new"}},
+				},
+				Steps: []PolicyStep{
+					{Operator: "subset_of", Before: []any{"https://leaf.example.com/cb", "https://leaf.example.com/old"}, After: []any{"https://leaf.example.com/cb"}},
+					{Operator: "superset_of", Before: []any{"https://leaf.example.com/cb"}, Error: "provided metadata is not a superset of the defined operator values"},
+				},
+			},
+		},
+		"conflicting operators are recorded against the parameter": {
+			chain: func(t *testing.T) []EntityStatement {
+				return []EntityStatement{
+					{Iss: "https://leaf.example.com", Sub: "https://leaf.example.com"},
+					statement("https://int.example.com", "https://leaf.example.com", map[string]PolicyOperators{
+						"redirect_uris": {Metadata: []MetadataPolicyOperator{value(t, []any{"https://leaf.example.com/cb"})}},
+					}),
+					statement("https://ta.example.com", "https://int.example.com", map[string]PolicyOperators{
+						"redirect_uris": {Metadata: []MetadataPolicyOperator{value(t, []any{"https://leaf.example.com/other"})}},
+					}),
+				}
+			},
+			expectedError: "merging [https://leaf.example.com/other] and [https://leaf.example.com/cb] not possible",
+			expected: &ParameterTrace{
+				EntityType: "openid_relying_party",
+				Parameter:  "redirect_uris",
+				Contributions: []PolicyContribution{
+					{Issuer: "https://int.example.com", Subject: "https://leaf.example.com", TracedOperator: TracedOperator{Operator: "value", Value: []any{"https://leaf.example.com/cb"}}},
+					{Issuer: "https://ta.example.com", Subject: "https://int.example.com", TracedOperator: TracedOperator{Operator: "value", Value: []any{"https://leaf.example.com/other"}}},
+				},
+				Error: "merging [https://leaf.example.com/other] and [https://leaf.example.com/cb] not possible",
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			chain := tt.chain(t)
+			chain[0].Metadata = &Metadata{OpenIDRelyingPartyMetadata: &OpenIDRelyingPartyMetadata{
+				"redirect_uris": []any{"https://leaf.example.com/cb", "https://leaf.example.com/old"},
+			}}
+
+			trace := &PolicyTrace{}
+			policy, err := ProcessAndExtractPolicyWithTrace(chain, trace)
+			if err == nil {
+				_, err = ApplyPolicyWithTrace(chain[0], *policy, trace)
+			}
+			if tt.expectedError == "" && err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if tt.expectedError != "" && (err == nil || err.Error() != tt.expectedError) {
+				t.Fatalf("expected error %q, got %v", tt.expectedError, err)
+			}
+			if diff := cmp.Diff(tt.expected, trace.Parameter("openid_relying_party", "redirect_uris")); diff != "" {
+				t.Errorf("mismatch (-expected +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPolicyTrace_Parameter(t *testing.T) {
+	var trace *PolicyTrace
+	if trace.Parameter("openid_relying_party", "redirect_uris") != nil {
+		t.Error("expected a nil trace to have no parameters")
+	}
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+)
+
+var (
+	_ RemoteSigner = cryptoRemoteSigner{}
+)
+
+// RemoteSigner is a context-aware signer, intended for key material held outside the process such as in a KMS or HSM.
+// Implementations are consulted for their key ID, algorithm and public key on every signing operation so rotated keys
+// are picked up without restarting the server.
+//
+// Sign receives the digest produced for the reported algorithm and must return the signature in its JWS encoding
+// (for example R || S for ECDSA algorithms rather than ASN.1 DER)
+type RemoteSigner interface {
+	Sign(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+	PublicJWK(ctx context.Context) (map[string]any, error)
+	KeyID(ctx context.Context) (string, error)
+	Algorithm(ctx context.Context) (string, error)
+}
+
+// NewCryptoRemoteSigner adapts a synchronous crypto.Signer with a fixed key ID and algorithm to the RemoteSigner interface
+func NewCryptoRemoteSigner(signer crypto.Signer, keyID, algorithm string) RemoteSigner {
+	return cryptoRemoteSigner{
+		signer:    signer,
+		keyID:     keyID,
+		algorithm: algorithm,
+	}
+}
+
+type cryptoRemoteSigner struct {
+	signer           crypto.Signer
+	keyID, algorithm string
+}
+
+func (c cryptoRemoteSigner) Sign(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if c.signer == nil {
+		return nil, fmt.Errorf("no signer configured")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.signer.Sign(rand.Reader, digest, opts)
+}
+
+func (c cryptoRemoteSigner) PublicJWK(ctx context.Context) (map[string]any, error) {
+	if c.signer == nil {
+		return nil, fmt.Errorf("no signer configured")
+	}
+	publicJWK, err := jwk.PublicJwk(c.signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return *publicJWK, nil
+}
+
+func (c cryptoRemoteSigner) KeyID(ctx context.Context) (string, error) {
+	return c.keyID, nil
+}
+
+func (c cryptoRemoteSigner) Algorithm(ctx context.Context) (string, error) {
+	return c.algorithm, nil
+}
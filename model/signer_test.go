@@ -0,0 +1,119 @@
+package model
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+)
+
+func TestSignerConfiguration_AsRemoteSigner(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	signerPublicJWK, err := jwk.PublicJwk(signer.Public())
+	if err != nil {
+		t.Fatalf("expected no error creating public JWK, got %q", err.Error())
+	}
+
+	tests := map[string]struct {
+		configuration SignerConfiguration
+		validate      func(t *testing.T, remoteSigner RemoteSigner)
+	}{
+		"a crypto signer is adapted to a remote signer": {
+			configuration: SignerConfiguration{
+				Signer:    signer,
+				KeyID:     "some-key-id",
+				Algorithm: "ES256",
+			},
+			validate: func(t *testing.T, remoteSigner RemoteSigner) {
+				keyID, err := remoteSigner.KeyID(t.Context())
+				if err != nil {
+					t.Fatalf("expected no error retrieving key ID, got %q", err.Error())
+				}
+				if keyID != "some-key-id" {
+					t.Errorf("expected key ID 'some-key-id', got %q", keyID)
+				}
+				algorithm, err := remoteSigner.Algorithm(t.Context())
+				if err != nil {
+					t.Fatalf("expected no error retrieving algorithm, got %q", err.Error())
+				}
+				if algorithm != "ES256" {
+					t.Errorf("expected algorithm 'ES256', got %q", algorithm)
+				}
+				publicJWK, err := remoteSigner.PublicJWK(t.Context())
+				if err != nil {
+					t.Fatalf("expected no error retrieving public JWK, got %q", err.Error())
+				}
+				if publicJWK["x"] != (*signerPublicJWK)["x"] || publicJWK["y"] != (*signerPublicJWK)["y"] {
+					t.Errorf("expected public JWK to match signer public key, got %v", publicJWK)
+				}
+				digest := sha256.Sum256([]byte("some-content"))
+				signature, err := remoteSigner.Sign(t.Context(), digest[:], crypto.SHA256)
+				if err != nil {
+					t.Fatalf("expected no error signing, got %q", err.Error())
+				}
+				if len(signature) == 0 {
+					t.Error("expected a non-empty signature")
+				}
+			},
+		},
+		"a cancelled context prevents signing": {
+			configuration: SignerConfiguration{
+				Signer:    signer,
+				KeyID:     "some-key-id",
+				Algorithm: "ES256",
+			},
+			validate: func(t *testing.T, remoteSigner RemoteSigner) {
+				ctx, cancel := context.WithCancel(t.Context())
+				cancel()
+				digest := sha256.Sum256([]byte("some-content"))
+				if _, err := remoteSigner.Sign(ctx, digest[:], crypto.SHA256); err == nil {
+					t.Fatal("expected an error signing with a cancelled context")
+				}
+			},
+		},
+		"a configured remote signer takes precedence": {
+			configuration: SignerConfiguration{
+				Signer:       signer,
+				KeyID:        "some-key-id",
+				Algorithm:    "ES256",
+				RemoteSigner: NewCryptoRemoteSigner(signer, "some-remote-key-id", "ES256"),
+			},
+			validate: func(t *testing.T, remoteSigner RemoteSigner) {
+				keyID, err := remoteSigner.KeyID(t.Context())
+				if err != nil {
+					t.Fatalf("expected no error retrieving key ID, got %q", err.Error())
+				}
+				if keyID != "some-remote-key-id" {
+					t.Errorf("expected key ID 'some-remote-key-id', got %q", keyID)
+				}
+			},
+		},
+		"a missing signer is reported on use": {
+			configuration: SignerConfiguration{
+				KeyID:     "some-key-id",
+				Algorithm: "ES256",
+			},
+			validate: func(t *testing.T, remoteSigner RemoteSigner) {
+				if _, err := remoteSigner.PublicJWK(t.Context()); err == nil {
+					t.Error("expected an error retrieving the public JWK of a missing signer")
+				}
+				if _, err := remoteSigner.Sign(t.Context(), []byte("digest"), crypto.SHA256); err == nil {
+					t.Error("expected an error signing with a missing signer")
+				}
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.validate(t, tt.configuration.AsRemoteSigner())
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"context"
+	"iter"
+	"maps"
+	"slices"
+)
+
+// Subordinate pairs a subordinate's identifier with its configuration
+type Subordinate struct {
+	Identifier    EntityIdentifier
+	Configuration *SubordinateConfiguration
+}
+
+// StreamingRetriever may optionally be implemented by a Retriever holding too many subordinates to load at once.
+// When implemented, it is used in place of GetSubordinates and GetSubordinateSigners when listing subordinates and
+// publishing subordinate signer keys
+type StreamingRetriever interface {
+	// StreamSubordinates yields subordinates in lexical order of their identifiers, starting from the given cursor
+	// inclusive or from the first subordinate when the cursor is nil. An error ends the sequence
+	StreamSubordinates(ctx context.Context, cursor *EntityIdentifier) iter.Seq2[Subordinate, error]
+}
+
+// StreamSubordinates yields subordinates in lexical order of their identifiers, starting from the given cursor inclusive.
+// Subordinates are streamed from the MetadataRetriever when it implements StreamingRetriever, and are otherwise loaded at
+// once and sorted
+func (cfg *ServerConfiguration) StreamSubordinates(ctx context.Context, cursor *EntityIdentifier) iter.Seq2[Subordinate, error] {
+	if streamingRetriever, ok := cfg.MetadataRetriever.(StreamingRetriever); ok {
+		return streamingRetriever.StreamSubordinates(ctx, cursor)
+	}
+
+	return func(yield func(Subordinate, error) bool) {
+		subordinates, err := cfg.GetSubordinates(ctx)
+		if err != nil {
+			yield(Subordinate{}, err)
+			return
+		}
+		for _, identifier := range slices.Sorted(maps.Keys(subordinates)) {
+			if cursor != nil && identifier < *cursor {
+				continue
+			}
+			if !yield(Subordinate{Identifier: identifier, Configuration: subordinates[identifier]}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// StreamSubordinateSigners yields the signer configuration overrides held for subordinates
+func (cfg *ServerConfiguration) StreamSubordinateSigners(ctx context.Context) iter.Seq2[SignerConfiguration, error] {
+	if _, ok := cfg.MetadataRetriever.(StreamingRetriever); !ok {
+		return func(yield func(SignerConfiguration, error) bool) {
+			signers, err := cfg.GetSubordinateJWKs(ctx)
+			if err != nil {
+				yield(SignerConfiguration{}, err)
+				return
+			}
+			for _, signer := range signers {
+				if !yield(signer, nil) {
+					return
+				}
+			}
+		}
+	}
+
+	return func(yield func(SignerConfiguration, error) bool) {
+		for subordinate, err := range cfg.StreamSubordinates(ctx, nil) {
+			if err != nil {
+				yield(SignerConfiguration{}, err)
+				return
+			}
+			if subordinate.Configuration == nil || subordinate.Configuration.SignerConfiguration == nil {
+				continue
+			}
+			if !yield(*subordinate.Configuration.SignerConfiguration, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type testStreamingRetriever struct {
+	testRetriever
+	identifiers []EntityIdentifier
+	failAfter   int
+}
+
+func (r *testStreamingRetriever) StreamSubordinates(ctx context.Context, cursor *EntityIdentifier) iter.Seq2[Subordinate, error] {
+	return func(yield func(Subordinate, error) bool) {
+		for i, identifier := range r.identifiers {
+			if r.failAfter > 0 && i == r.failAfter {
+				yield(Subordinate{}, fmt.Errorf("retriever unavailable"))
+				return
+			}
+			if cursor != nil && identifier < *cursor {
+				continue
+			}
+			subordinate := &SubordinateConfiguration{}
+			if i%2 == 0 {
+				subordinate.SignerConfiguration = &SignerConfiguration{KeyID: string(identifier)}
+			}
+			if !yield(Subordinate{Identifier: identifier, Configuration: subordinate}, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestServerConfiguration_StreamSubordinates(t *testing.T) {
+	collect := func(t *testing.T, seq iter.Seq2[Subordinate, error]) ([]EntityIdentifier, error) {
+		t.Helper()
+		var identifiers []EntityIdentifier
+		for subordinate, err := range seq {
+			if err != nil {
+				return identifiers, err
+			}
+			identifiers = append(identifiers, subordinate.Identifier)
+		}
+		return identifiers, nil
+	}
+
+	t.Run("subordinates held in memory are streamed in lexical order from the cursor", func(t *testing.T) {
+		cfg := &ServerConfiguration{IntermediateConfiguration: &IntermediateConfiguration{}}
+		for _, identifier := range []EntityIdentifier{"https://c.com", "https://a.com", "https://b.com"} {
+			_ = cfg.IntermediateConfiguration.AddSubordinate(identifier, &SubordinateConfiguration{})
+		}
+
+		identifiers, err := collect(t, cfg.StreamSubordinates(t.Context(), nil))
+		if err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+		if diff := cmp.Diff([]EntityIdentifier{"https://a.com", "https://b.com", "https://c.com"}, identifiers); diff != "" {
+			t.Errorf("mismatch (-expected +got):\n%s", diff)
+		}
+
+		identifiers, err = collect(t, cfg.StreamSubordinates(t.Context(), Pointer(EntityIdentifier("https://b.com"))))
+		if err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+		if diff := cmp.Diff([]EntityIdentifier{"https://b.com", "https://c.com"}, identifiers); diff != "" {
+			t.Errorf("mismatch (-expected +got):\n%s", diff)
+		}
+	})
+	t.Run("subordinates are streamed from a retriever supporting it", func(t *testing.T) {
+		retriever := &testStreamingRetriever{identifiers: []EntityIdentifier{"https://a.com", "https://b.com", "https://c.com"}}
+		cfg := &ServerConfiguration{IntermediateConfiguration: &IntermediateConfiguration{}, MetadataRetriever: retriever}
+
+		identifiers, err := collect(t, cfg.StreamSubordinates(t.Context(), Pointer(EntityIdentifier("https://b.com"))))
+		if err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+		if diff := cmp.Diff([]EntityIdentifier{"https://b.com", "https://c.com"}, identifiers); diff != "" {
+			t.Errorf("mismatch (-expected +got):\n%s", diff)
+		}
+	})
+	t.Run("retriever errors end the stream", func(t *testing.T) {
+		retriever := &testStreamingRetriever{identifiers: []EntityIdentifier{"https://a.com", "https://b.com", "https://c.com"}, failAfter: 2}
+		cfg := &ServerConfiguration{IntermediateConfiguration: &IntermediateConfiguration{}, MetadataRetriever: retriever}
+
+		identifiers, err := collect(t, cfg.StreamSubordinates(t.Context(), nil))
+		if err == nil || err.Error() != "retriever unavailable" {
+			t.Fatalf("expected error 'retriever unavailable', got %v", err)
+		}
+		if len(identifiers) != 2 {
+			t.Errorf("expected 2 subordinates before the error, got %d", len(identifiers))
+		}
+	})
+	t.Run("subordinate signers are streamed from a retriever supporting it", func(t *testing.T) {
+		retriever := &testStreamingRetriever{identifiers: []EntityIdentifier{"https://a.com", "https://b.com", "https://c.com"}}
+		cfg := &ServerConfiguration{IntermediateConfiguration: &IntermediateConfiguration{}, MetadataRetriever: retriever}
+
+		var keyIDs []string
+		for signer, err := range cfg.StreamSubordinateSigners(t.Context()) {
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			keyIDs = append(keyIDs, signer.KeyID)
+		}
+		if diff := cmp.Diff([]string{"https://a.com", "https://c.com"}, keyIDs); diff != "" {
+			t.Errorf("mismatch (-expected +got):\n%s", diff)
+		}
+	})
+	t.Run("filtered listings are streamed", func(t *testing.T) {
+		retriever := &testStreamingRetriever{identifiers: []EntityIdentifier{"https://a.com", "https://b.com", "https://c.com"}}
+		cfg := &ServerConfiguration{IntermediateConfiguration: &IntermediateConfiguration{}, MetadataRetriever: retriever}
+
+		var identifiers []EntityIdentifier
+		for identifier, err := range cfg.StreamListing(t.Context(), ListingFilter{}) {
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			identifiers = append(identifiers, identifier)
+			break
+		}
+		if diff := cmp.Diff([]EntityIdentifier{"https://a.com"}, identifiers); diff != "" {
+			t.Errorf("mismatch (-expected +got):\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// subordinateCache holds subordinate configuration for an IntermediateConfiguration and is safe for concurrent use.
+// Entries loaded from a Retriever carry their own TTL. Registered entries have none and never expire - they are served
+// in preference to the Retriever until they are updated, removed or flushed. Concurrent misses for the same subject
+// share a single Retriever call
+type subordinateCache struct {
+	mu         sync.Mutex
+	entries    map[EntityIdentifier]subordinateCacheEntry
+	loads      map[EntityIdentifier]*subordinateLoad
+	generation uint64
+}
+
+type subordinateCacheEntry struct {
+	configuration *SubordinateConfiguration
+	cachedAt      time.Time
+	ttl           time.Duration // a zero ttl marks a registered entry
+}
+
+// valid reports whether the entry may be served. Registered entries are always valid
+func (e subordinateCacheEntry) valid(now time.Time) bool {
+	return e.ttl == 0 || now.Before(e.cachedAt.Add(e.ttl))
+}
+
+// subordinateLoad tracks an in-flight Retriever call, done is closed once configuration and err are set
+type subordinateLoad struct {
+	done          chan struct{}
+	configuration *SubordinateConfiguration
+	err           error
+}
+
+func (c *subordinateCache) get(identifier EntityIdentifier) (*SubordinateConfiguration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[identifier]
+	if !ok || !entry.valid(time.Now()) {
+		return nil, false
+	}
+	return entry.configuration, true
+}
+
+// set stores configuration for the given subject, reporting whether an entry was replaced
+func (c *subordinateCache) set(identifier EntityIdentifier, configuration *SubordinateConfiguration, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, replaced := c.entries[identifier]
+	c.store(identifier, configuration, ttl)
+	c.generation++
+	return replaced
+}
+
+// registered reports whether a registered entry is held for the given subject
+func (c *subordinateCache) registered(identifier EntityIdentifier) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[identifier]
+	return ok && entry.ttl == 0
+}
+
+// replaceRegistered replaces the configuration of a registered entry for the given subject, reporting whether one was
+// present. Entries loaded from a Retriever are left untouched
+func (c *subordinateCache) replaceRegistered(identifier EntityIdentifier, configuration *SubordinateConfiguration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[identifier]; !ok || entry.ttl != 0 {
+		return false
+	}
+	c.store(identifier, configuration, 0)
+	c.generation++
+	return true
+}
+
+// removeRegistered discards the registered entry for the given subject, reporting whether one was present. Entries
+// loaded from a Retriever are left untouched
+func (c *subordinateCache) removeRegistered(identifier EntityIdentifier) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[identifier]; !ok || entry.ttl != 0 {
+		return false
+	}
+	delete(c.entries, identifier)
+	c.generation++
+	return true
+}
+
+// store must be called with mu held
+func (c *subordinateCache) store(identifier EntityIdentifier, configuration *SubordinateConfiguration, ttl time.Duration) {
+	if c.entries == nil {
+		c.entries = map[EntityIdentifier]subordinateCacheEntry{}
+	}
+	c.entries[identifier] = subordinateCacheEntry{
+		configuration: configuration,
+		cachedAt:      time.Now(),
+		ttl:           ttl,
+	}
+}
+
+func (c *subordinateCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+	c.generation++
+}
+
+// snapshot returns a copy of all unexpired entries
+func (c *subordinateCache) snapshot() map[EntityIdentifier]*SubordinateConfiguration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	subordinates := make(map[EntityIdentifier]*SubordinateConfiguration, len(c.entries))
+	for identifier, entry := range c.entries {
+		if entry.valid(now) {
+			subordinates[identifier] = entry.configuration
+		}
+	}
+	return subordinates
+}
+
+// load returns the cached configuration for the given subject, calling retrieve on a miss. Only one call to retrieve is
+// made per subject at a time, with concurrent callers waiting on its result. Loaded configuration is cached for ttl,
+// unless the cache is modified while the load is in progress - so a registered entry is never replaced by a load
+func (c *subordinateCache) load(ctx context.Context, identifier EntityIdentifier, ttl time.Duration, retrieve func(ctx context.Context) (*SubordinateConfiguration, error)) (*SubordinateConfiguration, error) {
+	for {
+		c.mu.Lock()
+		if entry, ok := c.entries[identifier]; ok && entry.valid(time.Now()) {
+			c.mu.Unlock()
+			return entry.configuration, nil
+		}
+
+		if inFlight, ok := c.loads[identifier]; ok {
+			c.mu.Unlock()
+			select {
+			case <-inFlight.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			// the leading caller's context ending should not fail callers whose own context is still live
+			if isContextError(inFlight.err) && ctx.Err() == nil {
+				continue
+			}
+			return inFlight.configuration, inFlight.err
+		}
+
+		current := &subordinateLoad{done: make(chan struct{})}
+		if c.loads == nil {
+			c.loads = map[EntityIdentifier]*subordinateLoad{}
+		}
+		c.loads[identifier] = current
+		generation := c.generation
+		c.mu.Unlock()
+
+		current.configuration, current.err = retrieve(ctx)
+
+		c.mu.Lock()
+		delete(c.loads, identifier)
+		if current.err == nil && ttl > 0 && generation == c.generation {
+			c.store(identifier, current.configuration, ttl)
+		}
+		c.mu.Unlock()
+		close(current.done)
+
+		return current.configuration, current.err
+	}
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
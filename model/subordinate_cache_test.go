@@ -0,0 +1,306 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testRetriever struct {
+	calls          atomic.Int64
+	getSubordinate func(ctx context.Context, identifier EntityIdentifier) (*SubordinateConfiguration, error)
+}
+
+func (r *testRetriever) GetSubordinate(ctx context.Context, identifier EntityIdentifier) (*SubordinateConfiguration, error) {
+	r.calls.Add(1)
+	return r.getSubordinate(ctx, identifier)
+}
+
+func (r *testRetriever) GetSubordinates(ctx context.Context) (map[EntityIdentifier]*SubordinateConfiguration, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *testRetriever) GetSubordinateSigners(ctx context.Context) ([]SignerConfiguration, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func TestServerConfiguration_GetSubordinate(t *testing.T) {
+	identifier := EntityIdentifier("https://some-federation.com/some-path")
+
+	tests := map[string]struct {
+		cacheTime time.Duration
+		retriever func(t *testing.T) *testRetriever
+		validate  func(t *testing.T, cfg *ServerConfiguration, retriever *testRetriever)
+	}{
+		"registered subordinates are served without a retriever regardless of cache time": {
+			validate: func(t *testing.T, cfg *ServerConfiguration, retriever *testRetriever) {
+				registered := &SubordinateConfiguration{}
+				_ = cfg.IntermediateConfiguration.AddSubordinate(identifier, registered)
+				time.Sleep(10 * time.Millisecond)
+				result, err := cfg.GetSubordinate(t.Context(), identifier)
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if result != registered {
+					t.Error("expected the registered subordinate configuration to be returned")
+				}
+			},
+		},
+		"unknown subordinates are not found without a retriever": {
+			validate: func(t *testing.T, cfg *ServerConfiguration, retriever *testRetriever) {
+				_, err := cfg.GetSubordinate(t.Context(), identifier)
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if err.Error() != "subordinate entity https://some-federation.com/some-path not found" {
+					t.Errorf("unexpected error %q", err.Error())
+				}
+			},
+		},
+		"retrieved subordinates are cached until their ttl elapses": {
+			cacheTime: 100 * time.Millisecond,
+			retriever: func(t *testing.T) *testRetriever {
+				return &testRetriever{getSubordinate: func(ctx context.Context, identifier EntityIdentifier) (*SubordinateConfiguration, error) {
+					return &SubordinateConfiguration{}, nil
+				}}
+			},
+			validate: func(t *testing.T, cfg *ServerConfiguration, retriever *testRetriever) {
+				first, err := cfg.GetSubordinate(t.Context(), identifier)
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				second, err := cfg.GetSubordinate(t.Context(), identifier)
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if first != second || retriever.calls.Load() != 1 {
+					t.Fatalf("expected the cached subordinate to be served, retriever called %d times", retriever.calls.Load())
+				}
+				time.Sleep(150 * time.Millisecond)
+				third, err := cfg.GetSubordinate(t.Context(), identifier)
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if third == first || retriever.calls.Load() != 2 {
+					t.Errorf("expected the expired subordinate to be reloaded, retriever called %d times", retriever.calls.Load())
+				}
+			},
+		},
+		"retriever errors are not cached": {
+			cacheTime: 1 * time.Minute,
+			retriever: func(t *testing.T) *testRetriever {
+				return &testRetriever{getSubordinate: func(ctx context.Context, identifier EntityIdentifier) (*SubordinateConfiguration, error) {
+					return nil, NewNotFoundError("subordinate not found")
+				}}
+			},
+			validate: func(t *testing.T, cfg *ServerConfiguration, retriever *testRetriever) {
+				for range 2 {
+					if _, err := cfg.GetSubordinate(t.Context(), identifier); !errors.Is(err, ErrNotFound) {
+						t.Fatalf("expected a not found error, got %v", err)
+					}
+				}
+				if retriever.calls.Load() != 2 {
+					t.Errorf("expected 2 retriever calls, got %d", retriever.calls.Load())
+				}
+			},
+		},
+		"concurrent misses for the same subject make a single retriever call": {
+			cacheTime: 1 * time.Minute,
+			retriever: func(t *testing.T) *testRetriever {
+				release := make(chan struct{})
+				time.AfterFunc(100*time.Millisecond, func() { close(release) })
+				return &testRetriever{getSubordinate: func(ctx context.Context, identifier EntityIdentifier) (*SubordinateConfiguration, error) {
+					<-release
+					return &SubordinateConfiguration{}, nil
+				}}
+			},
+			validate: func(t *testing.T, cfg *ServerConfiguration, retriever *testRetriever) {
+				results := make([]*SubordinateConfiguration, 20)
+				var wg sync.WaitGroup
+				for i := range results {
+					wg.Go(func() {
+						result, err := cfg.GetSubordinate(t.Context(), identifier)
+						if err != nil {
+							t.Errorf("expected no error, got %q", err.Error())
+						}
+						results[i] = result
+					})
+				}
+				wg.Wait()
+				if retriever.calls.Load() != 1 {
+					t.Errorf("expected 1 retriever call, got %d", retriever.calls.Load())
+				}
+				for _, result := range results {
+					if result == nil || result != results[0] {
+						t.Fatal("expected all callers to receive the same subordinate configuration")
+					}
+				}
+			},
+		},
+		"a cancelled loading caller does not fail concurrent callers": {
+			cacheTime: 1 * time.Minute,
+			retriever: func(t *testing.T) *testRetriever {
+				return &testRetriever{getSubordinate: func(ctx context.Context, identifier EntityIdentifier) (*SubordinateConfiguration, error) {
+					select {
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					case <-time.After(50 * time.Millisecond):
+						return &SubordinateConfiguration{}, nil
+					}
+				}}
+			},
+			validate: func(t *testing.T, cfg *ServerConfiguration, retriever *testRetriever) {
+				ctx, cancel := context.WithCancel(t.Context())
+				leaderDone := make(chan error)
+				go func() {
+					_, err := cfg.GetSubordinate(ctx, identifier)
+					leaderDone <- err
+				}()
+				for retriever.calls.Load() == 0 {
+					time.Sleep(time.Millisecond)
+				}
+
+				followerDone := make(chan error)
+				go func() {
+					_, err := cfg.GetSubordinate(t.Context(), identifier)
+					followerDone <- err
+				}()
+				time.Sleep(10 * time.Millisecond)
+				cancel()
+
+				if err := <-leaderDone; !errors.Is(err, context.Canceled) {
+					t.Errorf("expected the cancelled caller to receive a cancellation error, got %v", err)
+				}
+				if err := <-followerDone; err != nil {
+					t.Errorf("expected no error for the concurrent caller, got %q", err.Error())
+				}
+			},
+		},
+		"registered subordinates in front of a retriever can be updated and removed after the cache time": {
+			cacheTime: 50 * time.Millisecond,
+			retriever: func(t *testing.T) *testRetriever {
+				return &testRetriever{getSubordinate: func(ctx context.Context, identifier EntityIdentifier) (*SubordinateConfiguration, error) {
+					return &SubordinateConfiguration{}, nil
+				}}
+			},
+			validate: func(t *testing.T, cfg *ServerConfiguration, retriever *testRetriever) {
+				registered := &SubordinateConfiguration{}
+				_ = cfg.IntermediateConfiguration.AddSubordinate(identifier, registered)
+				time.Sleep(100 * time.Millisecond)
+				if result, _ := cfg.GetSubordinate(t.Context(), identifier); result != registered {
+					t.Fatal("expected the registered subordinate configuration to be served after the cache time")
+				}
+
+				updated := &SubordinateConfiguration{}
+				if err := cfg.IntermediateConfiguration.UpdateSubordinate(identifier, updated); err != nil {
+					t.Fatalf("expected no error updating the subordinate, got %q", err.Error())
+				}
+				time.Sleep(100 * time.Millisecond)
+				if result, _ := cfg.GetSubordinate(t.Context(), identifier); result != updated {
+					t.Fatal("expected the updated subordinate configuration to be served after the cache time")
+				}
+				if err := cfg.IntermediateConfiguration.RemoveSubordinate(identifier); err != nil {
+					t.Fatalf("expected no error removing the subordinate, got %q", err.Error())
+				}
+				if result, _ := cfg.GetSubordinate(t.Context(), identifier); result == updated || retriever.calls.Load() != 1 {
+					t.Errorf("expected the subordinate to be loaded from the retriever once removed, retriever called %d times", retriever.calls.Load())
+				}
+			},
+		},
+		"flushing during a load prevents the loaded configuration from being cached": {
+			cacheTime: 1 * time.Minute,
+			retriever: func(t *testing.T) *testRetriever {
+				return &testRetriever{getSubordinate: func(ctx context.Context, identifier EntityIdentifier) (*SubordinateConfiguration, error) {
+					time.Sleep(50 * time.Millisecond)
+					return &SubordinateConfiguration{}, nil
+				}}
+			},
+			validate: func(t *testing.T, cfg *ServerConfiguration, retriever *testRetriever) {
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					_, _ = cfg.GetSubordinate(t.Context(), identifier)
+				}()
+				for retriever.calls.Load() == 0 {
+					time.Sleep(time.Millisecond)
+				}
+				cfg.IntermediateConfiguration.FlushCache()
+				<-done
+
+				if _, err := cfg.GetSubordinate(t.Context(), identifier); err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if retriever.calls.Load() != 2 {
+					t.Errorf("expected 2 retriever calls, got %d", retriever.calls.Load())
+				}
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := &ServerConfiguration{
+				IntermediateConfiguration: &IntermediateConfiguration{
+					SubordinateCacheTime: tt.cacheTime,
+				},
+			}
+			var retriever *testRetriever
+			if tt.retriever != nil {
+				retriever = tt.retriever(t)
+				cfg.MetadataRetriever = retriever
+			}
+			tt.validate(t, cfg, retriever)
+		})
+	}
+}
+
+func TestIntermediateConfiguration_ConcurrentUse(t *testing.T) {
+	cfg := &ServerConfiguration{
+		IntermediateConfiguration: &IntermediateConfiguration{
+			SubordinateCacheTime: 1 * time.Minute,
+		},
+	}
+	var notifications atomic.Int64
+	cfg.IntermediateConfiguration.OnSubordinateChange(func(identifier *EntityIdentifier) {
+		notifications.Add(1)
+	})
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		identifier := EntityIdentifier(fmt.Sprintf("https://some-federation.com/%d", i))
+		wg.Go(func() {
+			for range 50 {
+				_ = cfg.IntermediateConfiguration.AddSubordinate(identifier, &SubordinateConfiguration{})
+			}
+		})
+		wg.Go(func() {
+			for range 50 {
+				_, _ = cfg.GetSubordinate(t.Context(), identifier)
+			}
+		})
+		wg.Go(func() {
+			for range 50 {
+				subordinates, err := cfg.GetSubordinates(t.Context())
+				if err != nil {
+					t.Errorf("expected no error, got %q", err.Error())
+				}
+				for range subordinates {
+				}
+			}
+		})
+	}
+	wg.Go(func() {
+		for range 10 {
+			cfg.IntermediateConfiguration.FlushCache()
+		}
+	})
+	wg.Wait()
+
+	if notifications.Load() != 510 {
+		t.Errorf("expected 510 change notifications, got %d", notifications.Load())
+	}
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	SubordinateEventRegistration   = "registration"
+	SubordinateEventMetadataUpdate = "metadata_update"
+	SubordinateEventRevocation     = "revocation"
+)
+
+// subordinateEventLog records the lifecycle events of subordinates managed through an IntermediateConfiguration.
+// Events are retained after a subordinate is removed so that its revocation can still be reported
+type subordinateEventLog struct {
+	mu     sync.RWMutex
+	events map[EntityIdentifier][]SubordinateStatusEvent
+}
+
+func (l *subordinateEventLog) record(identifier EntityIdentifier, event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.events == nil {
+		l.events = map[EntityIdentifier][]SubordinateStatusEvent{}
+	}
+	l.events[identifier] = append(l.events[identifier], SubordinateStatusEvent{
+		Iat:   time.Now().UTC().Unix(),
+		Event: event,
+	})
+}
+
+func (l *subordinateEventLog) get(identifier EntityIdentifier) ([]SubordinateStatusEvent, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	events, ok := l.events[identifier]
+	return slices.Clone(events), ok
+}
+
+// timestamps derives when a subordinate was registered and last updated from its recorded events. A subordinate which
+// was removed and later added again is considered registered at the time it was added again
+func (l *subordinateEventLog) timestamps(identifier EntityIdentifier) (SubordinateTimestamps, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var timestamps SubordinateTimestamps
+	for _, event := range l.events[identifier] {
+		iat := time.Unix(event.Iat, 0).UTC()
+		if event.Event == SubordinateEventRegistration {
+			timestamps.Registered = iat
+		}
+		timestamps.Updated = iat
+	}
+	return timestamps, !timestamps.Registered.IsZero()
+}
+
+// SubordinateTimestamps records when a subordinate was registered and last updated
+type SubordinateTimestamps struct {
+	Registered time.Time
+	Updated    time.Time
+}
+
+// SubordinateTimestamps returns when a subordinate managed through AddSubordinate, UpdateSubordinate and
+// RemoveSubordinate was registered and last updated, for use as the extended listing audit timestamps
+func (i *IntermediateConfiguration) SubordinateTimestamps(identifier EntityIdentifier) (SubordinateTimestamps, bool) {
+	return i.events.timestamps(identifier)
+}
+
+// GetSubordinateStatus returns the events recorded for a subordinate by AddSubordinate, UpdateSubordinate and
+// RemoveSubordinate, allowing an IntermediateConfiguration to act as a SubordinateStatusRetriever
+func (i *IntermediateConfiguration) GetSubordinateStatus(ctx context.Context, sub EntityIdentifier) (*SubordinateStatusResponse, error) {
+	events, ok := i.events.get(sub)
+	if !ok {
+		return nil, NewNotFoundError(fmt.Sprintf("unknown entity identifier: %s", sub))
+	}
+	return &SubordinateStatusResponse{Events: events}, nil
+}
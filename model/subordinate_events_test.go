@@ -0,0 +1,261 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIntermediateConfiguration_SubordinateLifecycle(t *testing.T) {
+	first := EntityIdentifier("https://some-federation.com/first")
+	second := EntityIdentifier("https://some-federation.com/second")
+
+	tests := map[string]struct {
+		validate func(t *testing.T, configuration *IntermediateConfiguration, changes *[]*EntityIdentifier)
+	}{
+		"adding a subordinate records a registration event": {
+			validate: func(t *testing.T, configuration *IntermediateConfiguration, changes *[]*EntityIdentifier) {
+				_ = configuration.AddSubordinate(first, &SubordinateConfiguration{})
+				validateEvents(t, configuration, first, SubordinateEventRegistration)
+				if len(*changes) != 1 || *(*changes)[0] != first {
+					t.Errorf("expected a single change notification for %s", first)
+				}
+			},
+		},
+		"updating a subordinate replaces its configuration and records a metadata update event": {
+			validate: func(t *testing.T, configuration *IntermediateConfiguration, changes *[]*EntityIdentifier) {
+				_ = configuration.AddSubordinate(first, &SubordinateConfiguration{})
+				updated := &SubordinateConfiguration{}
+				if err := configuration.UpdateSubordinate(first, updated); err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				result, err := (&ServerConfiguration{IntermediateConfiguration: configuration}).GetSubordinate(t.Context(), first)
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if result != updated {
+					t.Error("expected the updated subordinate configuration to be returned")
+				}
+				if !updated.JWKs.Opts.EnforceUniqueKIDs {
+					t.Error("expected unique key IDs to be enforced on the updated configuration")
+				}
+				validateEvents(t, configuration, first, SubordinateEventRegistration, SubordinateEventMetadataUpdate)
+				if len(*changes) != 2 {
+					t.Errorf("expected 2 change notifications, got %d", len(*changes))
+				}
+			},
+		},
+		"updating an unknown subordinate fails": {
+			validate: func(t *testing.T, configuration *IntermediateConfiguration, changes *[]*EntityIdentifier) {
+				err := configuration.UpdateSubordinate(first, &SubordinateConfiguration{})
+				if !errors.Is(err, ErrNotFound) {
+					t.Fatalf("expected a not found error, got %v", err)
+				}
+				if len(configuration.ListSubordinates()) != 0 {
+					t.Error("expected no subordinate to be added by a failed update")
+				}
+				if len(*changes) != 0 {
+					t.Errorf("expected no change notifications, got %d", len(*changes))
+				}
+			},
+		},
+		"removing a subordinate records a revocation event which outlives the subordinate": {
+			validate: func(t *testing.T, configuration *IntermediateConfiguration, changes *[]*EntityIdentifier) {
+				_ = configuration.AddSubordinate(first, &SubordinateConfiguration{})
+				_ = configuration.AddSubordinate(second, &SubordinateConfiguration{})
+				if err := configuration.RemoveSubordinate(first); err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if _, err := (&ServerConfiguration{IntermediateConfiguration: configuration}).GetSubordinate(t.Context(), first); err == nil {
+					t.Error("expected the removed subordinate not to be found")
+				}
+				if !slices.Equal(configuration.ListSubordinates(), []EntityIdentifier{second}) {
+					t.Errorf("expected only %s to be listed, got %v", second, configuration.ListSubordinates())
+				}
+				validateEvents(t, configuration, first, SubordinateEventRegistration, SubordinateEventRevocation)
+				if len(*changes) != 3 || *(*changes)[2] != first {
+					t.Errorf("expected the removal of %s to be notified", first)
+				}
+			},
+		},
+		"removing an unknown subordinate fails": {
+			validate: func(t *testing.T, configuration *IntermediateConfiguration, changes *[]*EntityIdentifier) {
+				if err := configuration.RemoveSubordinate(first); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("expected a not found error, got %v", err)
+				}
+				if _, err := configuration.GetSubordinateStatus(t.Context(), first); !errors.Is(err, ErrNotFound) {
+					t.Errorf("expected no status to be recorded, got %v", err)
+				}
+			},
+		},
+		"subordinates loaded from a retriever cannot be updated or removed": {
+			validate: func(t *testing.T, configuration *IntermediateConfiguration, changes *[]*EntityIdentifier) {
+				configuration.SubordinateCacheTime = time.Minute
+				loaded := &SubordinateConfiguration{}
+				cfg := &ServerConfiguration{
+					IntermediateConfiguration: configuration,
+					MetadataRetriever: &testRetriever{getSubordinate: func(ctx context.Context, identifier EntityIdentifier) (*SubordinateConfiguration, error) {
+						return loaded, nil
+					}},
+				}
+				if _, err := cfg.GetSubordinate(t.Context(), first); err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+
+				if err := configuration.UpdateSubordinate(first, &SubordinateConfiguration{}); !errors.Is(err, ErrNotFound) {
+					t.Errorf("expected a not found error updating a loaded subordinate, got %v", err)
+				}
+				if err := configuration.RemoveSubordinate(first); !errors.Is(err, ErrNotFound) {
+					t.Errorf("expected a not found error removing a loaded subordinate, got %v", err)
+				}
+				if result, err := cfg.GetSubordinate(t.Context(), first); err != nil || result != loaded {
+					t.Errorf("expected the loaded subordinate to still be served, got %v, %v", result, err)
+				}
+				if _, err := configuration.GetSubordinateStatus(t.Context(), first); !errors.Is(err, ErrNotFound) {
+					t.Errorf("expected no events to be recorded, got %v", err)
+				}
+				if len(*changes) != 0 {
+					t.Errorf("expected no change notifications, got %d", len(*changes))
+				}
+			},
+		},
+		"subordinates are listed in lexical order": {
+			validate: func(t *testing.T, configuration *IntermediateConfiguration, changes *[]*EntityIdentifier) {
+				_ = configuration.AddSubordinate(second, &SubordinateConfiguration{})
+				_ = configuration.AddSubordinate(first, &SubordinateConfiguration{})
+				if !slices.Equal(configuration.ListSubordinates(), []EntityIdentifier{first, second}) {
+					t.Errorf("expected subordinates in lexical order, got %v", configuration.ListSubordinates())
+				}
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			configuration := &IntermediateConfiguration{}
+			var changes []*EntityIdentifier
+			configuration.OnSubordinateChange(func(identifier *EntityIdentifier) {
+				changes = append(changes, identifier)
+			})
+			tt.validate(t, configuration, &changes)
+		})
+	}
+}
+
+func validateEvents(t *testing.T, configuration *IntermediateConfiguration, identifier EntityIdentifier, expected ...string) {
+	t.Helper()
+	status, err := configuration.GetSubordinateStatus(t.Context(), identifier)
+	if err != nil {
+		t.Fatalf("expected no error retrieving subordinate status, got %q", err.Error())
+	}
+	var events []string
+	for _, event := range status.Events {
+		if event.Iat == 0 {
+			t.Errorf("expected event %q to have an iat", event.Event)
+		}
+		events = append(events, event.Event)
+	}
+	if !slices.Equal(events, expected) {
+		t.Errorf("expected events %v, got %v", expected, events)
+	}
+}
+
+func TestIntermediateConfiguration_SubordinateTimestamps(t *testing.T) {
+	identifier := EntityIdentifier("https://some-federation.com/some-path")
+	configuration := &IntermediateConfiguration{}
+
+	if _, ok := configuration.SubordinateTimestamps(identifier); ok {
+		t.Fatal("expected no timestamps for an unknown subordinate")
+	}
+
+	before := time.Now().Truncate(time.Second)
+	_ = configuration.AddSubordinate(identifier, &SubordinateConfiguration{})
+	registered, ok := configuration.SubordinateTimestamps(identifier)
+	if !ok {
+		t.Fatal("expected timestamps for a registered subordinate")
+	}
+	if registered.Registered.Before(before) || !registered.Updated.Equal(registered.Registered) {
+		t.Errorf("expected registered and updated to be the registration time, got %+v", registered)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if err := configuration.UpdateSubordinate(identifier, &SubordinateConfiguration{}); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	updated, _ := configuration.SubordinateTimestamps(identifier)
+	if !updated.Registered.Equal(registered.Registered) {
+		t.Errorf("expected the registration time to be unchanged, got %v", updated.Registered)
+	}
+	if !updated.Updated.After(registered.Updated) {
+		t.Errorf("expected the update time to advance, got %v", updated.Updated)
+	}
+}
+
+func TestIntermediateConfiguration_OnSubordinateChange(t *testing.T) {
+	configuration := &IntermediateConfiguration{}
+	var first, second int
+	unsubscribe := configuration.OnSubordinateChange(func(identifier *EntityIdentifier) { first++ })
+	configuration.OnSubordinateChange(func(identifier *EntityIdentifier) { second++ })
+
+	_ = configuration.AddSubordinate("https://some-federation.com/some-path", &SubordinateConfiguration{})
+	unsubscribe()
+	unsubscribe()
+	configuration.FlushCache()
+
+	if first != 1 {
+		t.Errorf("expected the removed listener to be notified only before it was removed, got %d notifications", first)
+	}
+	if second != 2 {
+		t.Errorf("expected the remaining listener to be notified of both changes, got %d notifications", second)
+	}
+}
+
+func TestIntermediateConfiguration_SetSubordinateValidator(t *testing.T) {
+	rejected := EntityIdentifier("https://rejected-federation.com")
+	accepted := EntityIdentifier("https://some-federation.com/some-path")
+	var validated []EntityIdentifier
+	configuration := &IntermediateConfiguration{}
+	remove := configuration.SetSubordinateValidator(func(ctx context.Context, identifier EntityIdentifier, subordinate *SubordinateConfiguration) error {
+		validated = append(validated, identifier)
+		if identifier == rejected || subordinate.Metadata != nil {
+			return errors.New("not allowed")
+		}
+		return nil
+	})
+
+	if err := configuration.AddSubordinate(rejected, &SubordinateConfiguration{}); err == nil {
+		t.Fatal("expected the rejected subordinate not to be added")
+	}
+	if _, ok := configuration.SubordinateTimestamps(rejected); ok {
+		t.Error("expected no events to be recorded for the rejected subordinate")
+	}
+	if err := configuration.AddSubordinate(accepted, &SubordinateConfiguration{}); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if err := configuration.UpdateSubordinate(accepted, &SubordinateConfiguration{Metadata: &Metadata{}}); err == nil {
+		t.Fatal("expected the rejected update to fail")
+	}
+	if subordinate, _ := configuration.cache.get(accepted); subordinate == nil || subordinate.Metadata != nil {
+		t.Errorf("expected the registered configuration to be kept, got %v", subordinate)
+	}
+	if err := configuration.UpdateSubordinate(rejected, &SubordinateConfiguration{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+	if !slices.Equal([]EntityIdentifier{rejected, accepted, accepted}, validated) {
+		t.Errorf("expected only changes to registered subordinates to be validated, got %v", validated)
+	}
+
+	replaced := configuration.SetSubordinateValidator(func(context.Context, EntityIdentifier, *SubordinateConfiguration) error {
+		return errors.New("replaced")
+	})
+	remove()
+	if err := configuration.ValidateSubordinate(t.Context(), accepted, &SubordinateConfiguration{}); err == nil {
+		t.Error("expected removing a replaced validator to leave its replacement installed")
+	}
+	replaced()
+	if err := configuration.AddSubordinate(rejected, &SubordinateConfiguration{}); err != nil {
+		t.Errorf("expected no validation once the validator was removed, got %q", err.Error())
+	}
+}
@@ -0,0 +1,90 @@
+package model
+
+import "errors"
+
+// ErrStatementExpired is returned when parsing an entity statement whose 'exp' claim has passed
+var ErrStatementExpired = errors.New("entity statement has expired")
+
+// ChainStep identifies what was being done with an entity when building a trust chain
+type ChainStep string
+
+const (
+	ChainStepEntityConfiguration  ChainStep = "entity_configuration"  // ChainStepEntityConfiguration is the retrieval of an entity's Entity Configuration
+	ChainStepSignature            ChainStep = "signature"             // ChainStepSignature is the verification of a statement's signature
+	ChainStepFetchEndpoint        ChainStep = "fetch_endpoint"        // ChainStepFetchEndpoint is locating a superior's federation fetch endpoint
+	ChainStepSubordinateStatement ChainStep = "subordinate_statement" // ChainStepSubordinateStatement is the retrieval of the statement a superior issues about its subordinate
+	ChainStepAuthorityHints       ChainStep = "authority_hints"       // ChainStepAuthorityHints is the selection of the superiors to walk up to next
+	ChainStepExpiry               ChainStep = "expiry"                // ChainStepExpiry is the check that the completed trust chain has not expired
+)
+
+// ChainFailureCause classifies why a step failed
+type ChainFailureCause string
+
+const (
+	ChainFailureNetwork          ChainFailureCause = "network_error"
+	ChainFailureHTTPStatus       ChainFailureCause = "http_status"
+	ChainFailureContentType      ChainFailureCause = "content_type"
+	ChainFailureBadSignature     ChainFailureCause = "bad_signature"
+	ChainFailureKeyIDMismatch    ChainFailureCause = "kid_mismatch" // ChainFailureKeyIDMismatch is a statement signed with a key not present in the issuer's key set
+	ChainFailureExpired          ChainFailureCause = "expired"
+	ChainFailureMissingEndpoint  ChainFailureCause = "missing_endpoint"
+	ChainFailureInvalidStatement ChainFailureCause = "invalid_statement" // ChainFailureInvalidStatement is a statement that is malformed or whose claims do not match the entity requested
+	ChainFailureDeadEnd          ChainFailureCause = "dead_end"          // ChainFailureDeadEnd is an entity with no authority hints left to walk up through
+)
+
+// StatementError is a failure to retrieve or validate an entity statement, classified by its cause. Its message is
+// that of the underlying error
+type StatementError struct {
+	Cause      ChainFailureCause
+	StatusCode int // StatusCode is set when Cause is ChainFailureHTTPStatus
+	Err        error
+}
+
+func (e *StatementError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// ChainHop records a single step taken while walking from the subject towards the trust anchor. A hop without a Cause
+// succeeded
+type ChainHop struct {
+	Entity     EntityIdentifier  `json:"entity"`
+	Superior   EntityIdentifier  `json:"superior,omitempty"` // Superior is the issuer of the statement retrieved for ChainStepFetchEndpoint and ChainStepSubordinateStatement hops
+	Step       ChainStep         `json:"step"`
+	Cause      ChainFailureCause `json:"cause,omitempty"`
+	StatusCode int               `json:"status_code,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// ChainPath is a single route attempted from the subject towards the trust anchor, ending with the hop that failed
+type ChainPath struct {
+	Hops []ChainHop `json:"hops"`
+}
+
+// Failure returns the hop at which the path failed, or nil if every hop succeeded
+func (p ChainPath) Failure() *ChainHop {
+	if len(p.Hops) == 0 || p.Hops[len(p.Hops)-1].Cause == "" {
+		return nil
+	}
+	return &p.Hops[len(p.Hops)-1]
+}
+
+// TrustChainError is returned when a trust chain cannot be built, reporting every path attempted. Its message is that
+// of the underlying error, which continues to be matched by errors.Is against the sentinel errors of this package
+type TrustChainError struct {
+	Subject     EntityIdentifier `json:"subject"`
+	TrustAnchor EntityIdentifier `json:"trust_anchor"`
+	Paths       []ChainPath      `json:"paths"`
+	Err         error            `json:"-"`
+}
+
+func (e *TrustChainError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TrustChainError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,194 @@
+// Package policy builds metadata policies fluently, checking that the operators set against each claim can be combined
+// as each operator is added:
+//
+//	metadataPolicy, err := policy.For("openid_relying_party").
+//		Claim("grant_types").SubsetOf("authorization_code", "refresh_token").Essential(true).
+//		Claim("application_type").OneOf("web", "native").
+//		Build()
+//
+// The first problem encountered is returned by Build, and any operators set after it are ignored
+package policy
+
+import (
+	"fmt"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+// Builder accumulates a metadata policy across one or more entity types
+type Builder struct {
+	policy model.MetadataPolicy
+	err    error
+}
+
+// EntityType sets the policy for the claims of a single entity type
+type EntityType struct {
+	builder *Builder
+	name    string
+}
+
+// Claim sets the operators for a single claim
+type Claim struct {
+	entityType *EntityType
+	name       string
+}
+
+func New() *Builder {
+	return &Builder{}
+}
+
+// For starts a new policy with the claims of the given entity type
+func For(entityType string) *EntityType {
+	return New().For(entityType)
+}
+
+// For sets the policy for the claims of the given entity type - one of federation_entity, openid_relying_party or
+// openid_provider
+func (b *Builder) For(entityType string) *EntityType {
+	if b.err == nil && policies(&b.policy, entityType) == nil {
+		b.err = fmt.Errorf("unsupported entity type %q", entityType)
+	}
+	return &EntityType{builder: b, name: entityType}
+}
+
+// Build returns the metadata policy, or the first problem encountered while building it. The returned policy does not
+// share state with the builder
+func (b *Builder) Build() (model.MetadataPolicy, error) {
+	if b.err != nil {
+		return model.MetadataPolicy{}, b.err
+	}
+	return *b.policy.Clone(), nil
+}
+
+func (e *EntityType) Claim(name string) *Claim {
+	return &Claim{entityType: e, name: name}
+}
+
+func (e *EntityType) For(entityType string) *EntityType {
+	return e.builder.For(entityType)
+}
+
+func (e *EntityType) Build() (model.MetadataPolicy, error) {
+	return e.builder.Build()
+}
+
+// Claim moves on to another claim of the same entity type
+func (c *Claim) Claim(name string) *Claim {
+	return c.entityType.Claim(name)
+}
+
+// For moves on to the claims of another entity type
+func (c *Claim) For(entityType string) *EntityType {
+	return c.entityType.For(entityType)
+}
+
+func (c *Claim) Build() (model.MetadataPolicy, error) {
+	return c.entityType.Build()
+}
+
+func (c *Claim) Value(value any) *Claim {
+	operator, err := model.NewValue(value)
+	if err != nil {
+		return c.fail("value", err)
+	}
+	return c.add(*operator)
+}
+
+func (c *Claim) Add(values ...any) *Claim {
+	operator, err := model.NewAdd(values)
+	if err != nil {
+		return c.fail("add", err)
+	}
+	return c.add(*operator)
+}
+
+func (c *Claim) Default(value any) *Claim {
+	operator, err := model.NewDefault(value)
+	if err != nil {
+		return c.fail("default", err)
+	}
+	return c.add(*operator)
+}
+
+func (c *Claim) OneOf(values ...any) *Claim {
+	operator, err := model.NewOneOf(values)
+	if err != nil {
+		return c.fail("one_of", err)
+	}
+	return c.add(*operator)
+}
+
+func (c *Claim) SubsetOf(values ...any) *Claim {
+	operator, err := model.NewSubsetOf(values)
+	if err != nil {
+		return c.fail("subset_of", err)
+	}
+	return c.add(*operator)
+}
+
+func (c *Claim) SupersetOf(values ...any) *Claim {
+	operator, err := model.NewSupersetOf(values)
+	if err != nil {
+		return c.fail("superset_of", err)
+	}
+	return c.add(*operator)
+}
+
+func (c *Claim) Essential(essential bool) *Claim {
+	operator, err := model.NewEssential(essential)
+	if err != nil {
+		return c.fail("essential", err)
+	}
+	return c.add(*operator)
+}
+
+// add sets an operator against the claim, validating it can be combined with the operators already set using the
+// same rules applied when policies are merged
+func (c *Claim) add(operator model.MetadataPolicyOperator) *Claim {
+	b := c.entityType.builder
+	if b.err != nil {
+		return c
+	}
+	claims := policies(&b.policy, c.entityType.name)
+	existing := (*claims)[c.name].Metadata
+	for _, set := range existing {
+		if set.String() == operator.String() {
+			return c.fail(operator.String(), fmt.Errorf("operator already set"))
+		}
+	}
+
+	operators := model.PolicyOperators{Metadata: append(append([]model.MetadataPolicyOperator{}, existing...), operator)}
+	candidate := model.MetadataPolicy{}
+	*policies(&candidate, c.entityType.name) = map[string]model.PolicyOperators{c.name: operators}
+	if err := candidate.Validate(); err != nil {
+		b.err = err
+		return c
+	}
+
+	if *claims == nil {
+		*claims = map[string]model.PolicyOperators{}
+	}
+	(*claims)[c.name] = operators
+	return c
+}
+
+func (c *Claim) fail(operator string, err error) *Claim {
+	if b := c.entityType.builder; b.err == nil {
+		b.err = fmt.Errorf("invalid %s policy for claim '%s': invalid '%s' operator: %w", c.entityType.name, c.name, operator, err)
+	}
+	return c
+}
+
+// policies returns the claims of the given entity type within the policy, or nil if the entity type is not supported
+func policies(policy *model.MetadataPolicy, entityType string) *map[string]model.PolicyOperators {
+	switch entityType {
+	case "federation_entity":
+		return &policy.FederationMetadata
+	case "openid_relying_party":
+		return &policy.OpenIDRelyingPartyMetadata
+	case "openid_provider":
+		return &policy.OpenIDConnectOpenIDProviderMetadata
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,143 @@
+package policy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuilder(t *testing.T) {
+	tests := map[string]struct {
+		build         func() (model.MetadataPolicy, error)
+		expected      string
+		expectedError string
+	}{
+		"operators across claims and entity types": {
+			build: func() (model.MetadataPolicy, error) {
+				return For("openid_relying_party").
+					Claim("grant_types").SubsetOf("authorization_code", "refresh_token").Essential(true).
+					Claim("application_type").OneOf("web", "native").Default("web").
+					For("federation_entity").
+					Claim("contacts").Add("ops@example.com").
+					Claim("organization_name").Value("Example Org").
+					Build()
+			},
+			expected: `{
+				"federation_entity": {
+					"contacts": {"add": ["ops@example.com"]},
+					"organization_name": {"value": "Example Org"}
+				},
+				"openid_relying_party": {
+					"application_type": {"default": "web", "one_of": ["web", "native"]},
+					"grant_types": {"essential": true, "subset_of": ["authorization_code", "refresh_token"]}
+				}
+			}`,
+		},
+		"a claim can be returned to": {
+			build: func() (model.MetadataPolicy, error) {
+				claims := For("openid_provider")
+				claims.Claim("scopes_supported").SupersetOf("openid")
+				return claims.Claim("scopes_supported").SubsetOf("openid", "email").Build()
+			},
+			expected: `{"openid_provider": {"scopes_supported": {"subset_of": ["openid", "email"], "superset_of": ["openid"]}}}`,
+		},
+		"an empty policy": {
+			build: func() (model.MetadataPolicy, error) {
+				return New().Build()
+			},
+			expected: `{}`,
+		},
+		"conflicting operators": {
+			build: func() (model.MetadataPolicy, error) {
+				return For("openid_relying_party").Claim("grant_types").SubsetOf("authorization_code").Value([]string{"implicit"}).Build()
+			},
+			expectedError: "invalid openid_relying_party policy for claim 'grant_types': cannot merge policy of type",
+		},
+		"operators set after a conflict are ignored": {
+			build: func() (model.MetadataPolicy, error) {
+				return For("openid_relying_party").
+					Claim("grant_types").SubsetOf("authorization_code").OneOf("authorization_code").
+					Claim("scope").Value("openid").
+					Build()
+			},
+			expectedError: "invalid openid_relying_party policy for claim 'grant_types': cannot merge policy of type 'subset_of' with policy of type 'one_of'",
+		},
+		"an operator set twice": {
+			build: func() (model.MetadataPolicy, error) {
+				return For("openid_relying_party").Claim("scope").Value("openid").Value("openid").Build()
+			},
+			expectedError: "invalid openid_relying_party policy for claim 'scope': invalid 'value' operator: operator already set",
+		},
+		"an invalid operator value": {
+			build: func() (model.MetadataPolicy, error) {
+				return For("openid_relying_party").Claim("client_name").Default(nil).Build()
+			},
+			expectedError: "invalid openid_relying_party policy for claim 'client_name': invalid 'default' operator: operator value cannot be nil",
+		},
+		"an unsupported entity type": {
+			build: func() (model.MetadataPolicy, error) {
+				return For("oauth_client").Claim("scope").Value("openid").Build()
+			},
+			expectedError: `unsupported entity type "oauth_client"`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			policy, err := tt.build()
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if err = policy.Validate(); err != nil {
+				t.Fatalf("expected the built policy to be valid, got %q", err.Error())
+			}
+
+			var expected model.MetadataPolicy
+			if err = json.Unmarshal([]byte(tt.expected), &expected); err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if diff := cmp.Diff(operatorsByClaim(expected), operatorsByClaim(policy)); diff != "" {
+				t.Errorf("mismatch (-expected +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBuilder_Build(t *testing.T) {
+	claims := For("openid_relying_party").Claim("scope").Value("openid")
+	built, err := claims.Build()
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	claims.Claim("client_name").Value("Example RP")
+	if _, ok := built.OpenIDRelyingPartyMetadata["client_name"]; ok {
+		t.Error("expected a built policy to be unaffected by later changes to the builder")
+	}
+}
+
+// operatorsByClaim flattens a policy into operator values keyed by entity type, claim and operator, as the operators
+// themselves hold unexported fields
+func operatorsByClaim(policy model.MetadataPolicy) map[string]any {
+	flattened := map[string]any{}
+	for entityType, claims := range map[string]map[string]model.PolicyOperators{
+		"federation_entity":    policy.FederationMetadata,
+		"openid_relying_party": policy.OpenIDRelyingPartyMetadata,
+		"openid_provider":      policy.OpenIDConnectOpenIDProviderMetadata,
+	} {
+		for claim, operators := range claims {
+			for _, operator := range operators.Metadata {
+				flattened[entityType+"."+claim+"."+operator.String()] = operator.OperatorValue()
+			}
+		}
+	}
+	return flattened
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/MichaelFraser99/go-openid-federation/internal/entity_configuration"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+// entityConfigurationCache holds the most recently signed Entity Configuration for a Server.
+// The generation counter is incremented on each invalidation so that signing operations started before an
+// invalidation never overwrite the cache with a statement built from outdated configuration
+type entityConfigurationCache struct {
+	mu         sync.Mutex
+	token      *string
+	expiresAt  time.Time
+	refreshAt  time.Time
+	generation uint64
+	refreshing bool
+}
+
+// InvalidateEntityConfiguration
+//
+//	Discards any cached Entity Configuration, forcing the next request to produce a newly signed statement.
+//	Should be called whenever configuration included in the Entity Configuration changes
+func (s *Server) InvalidateEntityConfiguration() {
+	s.entityConfigurationCache.mu.Lock()
+	defer s.entityConfigurationCache.mu.Unlock()
+	s.entityConfigurationCache.token = nil
+	s.entityConfigurationCache.generation++
+}
+
+// entityConfiguration returns a signed Entity Configuration along with the time until which it may be served from cache.
+// Cached statements within their refresh margin are still served while a replacement is signed in the background
+func (s *Server) entityConfiguration(ctx context.Context) (*string, time.Time, error) {
+	cache := &s.entityConfigurationCache
+	now := time.Now()
+
+	cache.mu.Lock()
+	if cache.token != nil && now.Before(cache.expiresAt) {
+		token, refreshAt := cache.token, cache.refreshAt
+		if !now.Before(refreshAt) && !cache.refreshing {
+			cache.refreshing = true
+			go s.refreshEntityConfiguration(context.WithoutCancel(ctx), cache.generation)
+		}
+		cache.mu.Unlock()
+		return token, refreshAt, nil
+	}
+	generation := cache.generation
+	cache.mu.Unlock()
+
+	return s.signEntityConfiguration(ctx, generation)
+}
+
+func (s *Server) refreshEntityConfiguration(ctx context.Context, generation uint64) {
+	cfg := s.configuration()
+	defer func() {
+		s.entityConfigurationCache.mu.Lock()
+		s.entityConfigurationCache.refreshing = false
+		s.entityConfigurationCache.mu.Unlock()
+	}()
+
+	if _, _, err := s.signEntityConfiguration(ctx, generation); err != nil {
+		cfg.LogError(ctx, "error refreshing cached entity configuration", slog.String("error", err.Error()))
+	}
+}
+
+func (s *Server) signEntityConfiguration(ctx context.Context, generation uint64) (*string, time.Time, error) {
+	cfg := s.configuration()
+
+	issuedAt := time.Now()
+	token, err := entity_configuration.New(ctx, cfg)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	expiresAt := time.Unix(issuedAt.Add(cfg.EntityConfigurationLifetime).Unix(), 0) // statement expiry is truncated to the second
+	refreshAt := expiresAt.Add(-entityConfigurationRefreshMargin(cfg))
+
+	cache := &s.entityConfigurationCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.generation == generation && refreshAt.After(issuedAt) {
+		cache.token = token
+		cache.expiresAt = expiresAt
+		cache.refreshAt = refreshAt
+	}
+	return token, refreshAt, nil
+}
+
+func entityConfigurationRefreshMargin(cfg model.ServerConfiguration) time.Duration {
+	if cfg.EntityConfigurationRefreshMargin > 0 {
+		return cfg.EntityConfigurationRefreshMargin
+	}
+	return cfg.EntityConfigurationLifetime / 10
+}
@@ -2,13 +2,16 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/MichaelFraser99/go-openid-federation/internal/subordinate_statement"
+	"github.com/MichaelFraser99/go-openid-federation/internal/extended_listing"
 	"github.com/MichaelFraser99/go-openid-federation/model"
 )
 
@@ -16,50 +19,56 @@ const extendedListingUnavailableError = "unable to list extended subordinate ent
 
 func (s *Server) ExtendedList(w http.ResponseWriter, r *http.Request) ResponseFunc {
 	ctx := r.Context()
+	cfg := s.configuration()
 
-	if !s.cfg.Extensions.ExtendedListing.Enabled {
+	if !cfg.Extensions.ExtendedListing.Enabled {
 		return s.RespondWithError(ctx, w, model.NewServerError("extended subordinate listing not enabled"))
 	}
-	if s.cfg.Extensions.ExtendedListing.MetadataRetriever == nil {
-		return s.RespondWithError(ctx, w, model.NewServerError("extended subordinate listing metadata retriever not configured"))
-	}
-	if s.cfg.Extensions.ExtendedListing.SizeLimit == 0 {
+	if cfg.Extensions.ExtendedListing.SizeLimit == 0 {
 		return s.RespondWithError(ctx, w, model.NewServerError("extended subordinate listing size limit not configured"))
 	}
+	retriever := cfg.Extensions.ExtendedListing.MetadataRetriever
+	if retriever == nil {
+		retriever = extended_listing.New(cfg)
+	}
 
 	err := r.ParseForm()
 	if err != nil {
-		s.cfg.LogInfo(ctx, "error parsing request", slog.String("error", err.Error()))
+		cfg.LogInfo(ctx, "error parsing request", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, model.NewInvalidRequestError("failed to parse request form"))
 	}
 	fromEntityID := r.URL.Query().Get("from_entity_id")
 	limit := r.URL.Query().Get("limit")
-	updatedAfter := r.URL.Query().Get("updated_after")   //todo
-	updatedBefore := r.URL.Query().Get("updated_before") //todo
+	updatedAfter := r.URL.Query().Get("updated_after")
+	updatedBefore := r.URL.Query().Get("updated_before")
 	claims := r.URL.Query().Get("claims")
-	auditTimestamps := r.URL.Query().Get("audit_timestamps") //todo
+	auditTimestamps := r.URL.Query().Get("audit_timestamps")
 
 	var (
 		parsedLimit           int
 		parsedFromEntityID    *model.EntityIdentifier
 		parsedRequestedClaims []string
+		parsedUpdatedAfter    *time.Time
+		parsedUpdatedBefore   *time.Time
+		parsedAuditTimestamps bool
 	)
 
 	if fromEntityID != "" {
 		parsedFromEntityID, err = model.ValidateEntityIdentifier(fromEntityID)
 		if err != nil {
-			s.cfg.LogInfo(ctx, "error parsing 'from_entity_id' parameter", slog.String("error", err.Error()))
+			cfg.LogInfo(ctx, "error parsing 'from_entity_id' parameter", slog.String("error", err.Error()))
 			return s.RespondWithError(ctx, w, model.NewInvalidRequestError("malformed 'from_entity_id' parameter"))
 		}
 	}
 	if limit != "" {
 		parsedLimit, err = strconv.Atoi(limit)
-		if err != nil {
-			s.cfg.LogInfo(ctx, "error parsing 'limit' parameter", slog.String("error", err.Error()))
+		if err != nil || parsedLimit < 1 {
+			cfg.LogInfo(ctx, "error parsing 'limit' parameter", slog.String("limit", limit))
 			return s.RespondWithError(ctx, w, model.NewInvalidRequestError("malformed 'limit' parameter"))
 		}
-	} else {
-		parsedLimit = s.cfg.Extensions.ExtendedListing.SizeLimit
+	}
+	if parsedLimit == 0 || parsedLimit > cfg.Extensions.ExtendedListing.SizeLimit {
+		parsedLimit = cfg.Extensions.ExtendedListing.SizeLimit
 	}
 	if claims != "" {
 		split := strings.Split(claims, ",")
@@ -77,21 +86,67 @@ func (s *Server) ExtendedList(w http.ResponseWriter, r *http.Request) ResponseFu
 	}
 
 	if updatedAfter != "" {
-		s.cfg.LogInfo(ctx, "parameter 'updated_after' is not supported", slog.String("value", updatedAfter))
-		return s.RespondWithError(ctx, w, model.NewUnsupportedParameterError("parameter 'updated_after' is not supported"))
+		parsedUpdatedAfter, err = parseTimestamp(updatedAfter)
+		if err != nil {
+			cfg.LogInfo(ctx, "error parsing 'updated_after' parameter", slog.String("error", err.Error()))
+			return s.RespondWithError(ctx, w, model.NewInvalidRequestError("malformed 'updated_after' parameter"))
+		}
 	}
 	if updatedBefore != "" {
-		s.cfg.LogInfo(ctx, "parameter 'updated_before' is not supported", slog.String("value", updatedBefore))
-		return s.RespondWithError(ctx, w, model.NewUnsupportedParameterError("parameter 'updated_before' is not supported"))
+		parsedUpdatedBefore, err = parseTimestamp(updatedBefore)
+		if err != nil {
+			cfg.LogInfo(ctx, "error parsing 'updated_before' parameter", slog.String("error", err.Error()))
+			return s.RespondWithError(ctx, w, model.NewInvalidRequestError("malformed 'updated_before' parameter"))
+		}
+	}
+	if parsedUpdatedAfter != nil && parsedUpdatedBefore != nil && !parsedUpdatedAfter.Before(*parsedUpdatedBefore) {
+		cfg.LogInfo(ctx, "parameter 'updated_after' is not before 'updated_before'", slog.String("updated_after", updatedAfter), slog.String("updated_before", updatedBefore))
+		return s.RespondWithError(ctx, w, model.NewInvalidRequestError("parameter 'updated_after' must be before 'updated_before'"))
 	}
 	if auditTimestamps != "" {
-		s.cfg.LogInfo(ctx, "parameter 'audit_timestamps' is not supported", slog.String("value", auditTimestamps))
-		return s.RespondWithError(ctx, w, model.NewUnsupportedParameterError("parameter 'audit_timestamps' is not supported"))
+		parsedAuditTimestamps, err = strconv.ParseBool(auditTimestamps)
+		if err != nil {
+			cfg.LogInfo(ctx, "error parsing 'audit_timestamps' parameter", slog.String("error", err.Error()))
+			return s.RespondWithError(ctx, w, model.NewInvalidRequestError("malformed 'audit_timestamps' parameter"))
+		}
 	}
 
-	subordinates, err := s.cfg.Extensions.ExtendedListing.MetadataRetriever.GetExtendedSubordinates(ctx, parsedFromEntityID, parsedLimit, parsedRequestedClaims)
+	var subordinates *model.ExtendedListingResponse
+	if filteringRetriever, ok := retriever.(model.FilteringExtendedListingRetriever); ok {
+		subordinates, err = filteringRetriever.GetFilteredExtendedSubordinates(ctx, model.ExtendedListingRequest{
+			From:            parsedFromEntityID,
+			Size:            parsedLimit,
+			Claims:          parsedRequestedClaims,
+			UpdatedAfter:    parsedUpdatedAfter,
+			UpdatedBefore:   parsedUpdatedBefore,
+			AuditTimestamps: parsedAuditTimestamps,
+		})
+	} else {
+		for _, parameter := range []struct {
+			name      string
+			requested bool
+		}{
+			{"updated_after", parsedUpdatedAfter != nil},
+			{"updated_before", parsedUpdatedBefore != nil},
+			{"audit_timestamps", parsedAuditTimestamps},
+		} {
+			if parameter.requested {
+				cfg.LogInfo(ctx, "parameter not supported by the configured extended listing retriever", slog.String("parameter", parameter.name))
+				return s.RespondWithError(ctx, w, model.NewUnsupportedParameterError(fmt.Sprintf("parameter '%s' is not supported", parameter.name)))
+			}
+		}
+		subordinates, err = retriever.GetExtendedSubordinates(ctx, parsedFromEntityID, parsedLimit, parsedRequestedClaims)
+	}
 	if err != nil {
-		s.cfg.LogError(ctx, "error getting subordinates", slog.String("error", err.Error()))
+		if errors.Is(err, model.ErrNotFound) {
+			cfg.LogInfo(ctx, "requested 'from_entity_id' not found", slog.String("error", err.Error()))
+			return s.RespondWithError(ctx, w, err)
+		}
+		if errors.Is(err, model.ErrUnsupportedParameter) {
+			cfg.LogInfo(ctx, "parameter not supported by the configured extended listing retriever", slog.String("error", err.Error()))
+			return s.RespondWithError(ctx, w, err)
+		}
+		cfg.LogError(ctx, "error getting subordinates", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(extendedListingUnavailableError))
 	}
 
@@ -99,29 +154,28 @@ func (s *Server) ExtendedList(w http.ResponseWriter, r *http.Request) ResponseFu
 		return s.RespondWithJSON(w, []byte(`{"immediate_subordinate_entities":[]}`))
 	}
 
-	if parsedFromEntityID != nil && subordinates.ImmediateSubordinateEntities[0]["id"] != fromEntityID {
-		s.cfg.LogError(ctx, "first entity identifier retrieved from configured metadata retriever does not match the requested value", slog.String("received", subordinates.ImmediateSubordinateEntities[0]["id"].(string)), slog.String("requested", fromEntityID))
+	if parsedFromEntityID != nil && parsedUpdatedAfter == nil && parsedUpdatedBefore == nil && subordinates.ImmediateSubordinateEntities[0]["id"] != fromEntityID {
+		cfg.LogError(ctx, "first entity identifier retrieved from configured metadata retriever does not match the requested value", slog.String("received", subordinates.ImmediateSubordinateEntities[0]["id"].(string)), slog.String("requested", fromEntityID))
 		return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(extendedListingUnavailableError))
 	}
 
 	if subordinateStatement {
 		for i, subordinateEntity := range subordinates.ImmediateSubordinateEntities {
 			if _, ok := subordinateEntity["id"]; !ok {
-				s.cfg.LogError(ctx, "missing 'id' field in one or more subordinate entities")
+				cfg.LogError(ctx, "missing 'id' field in one or more subordinate entities")
 				return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(extendedListingUnavailableError))
 			}
 
 			parsedIdentifier, err := model.ValidateEntityIdentifier(subordinateEntity["id"].(string))
 			if err != nil {
-				s.cfg.LogError(ctx, "invalid 'id' parameter included in retrieved list response", slog.String("error", err.Error()))
+				cfg.LogError(ctx, "invalid 'id' parameter included in retrieved list response", slog.String("error", err.Error()))
 				return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(extendedListingUnavailableError))
 			}
 
-			//todo: this is going to need some form of caching mechanism to not be extremely expensive to run - we might want to drop this parameter from the draft
-			token, err := subordinate_statement.New(ctx, *parsedIdentifier, s.loadSubordinate(ctx, *parsedIdentifier), s.cfg)
+			token, err := s.subordinateStatement(ctx, *parsedIdentifier)
 			if err != nil {
 				if err.Error() != "unknown entity identifier" {
-					s.cfg.LogError(ctx, "error creating subordinate statement", slog.String("error", err.Error()))
+					cfg.LogError(ctx, "error creating subordinate statement", slog.String("error", err.Error()))
 					return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(extendedListingUnavailableError))
 				}
 			} else {
@@ -132,8 +186,21 @@ func (s *Server) ExtendedList(w http.ResponseWriter, r *http.Request) ResponseFu
 
 	entitiesJSON, err := json.Marshal(*subordinates)
 	if err != nil {
-		s.cfg.LogError(ctx, "error marshalling subordinate entities", slog.String("error", err.Error()))
+		cfg.LogError(ctx, "error marshalling subordinate entities", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(extendedListingUnavailableError))
 	}
 	return s.RespondWithJSON(w, entitiesJSON)
 }
+
+// parseTimestamp parses a timestamp expressed in seconds since the epoch
+func parseTimestamp(value string) (*time.Time, error) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if seconds < 0 {
+		return nil, fmt.Errorf("timestamp cannot be negative")
+	}
+	timestamp := time.Unix(seconds, 0).UTC()
+	return &timestamp, nil
+}
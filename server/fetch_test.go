@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -42,7 +43,7 @@ func TestServer_Fetch(t *testing.T) {
 				testConfiguration := &model.IntermediateConfiguration{
 					SubordinateCacheTime: 5 * time.Minute,
 				}
-				testConfiguration.AddSubordinate("https://some-federation.com/some-path", &model.SubordinateConfiguration{
+				_ = testConfiguration.AddSubordinate("https://some-federation.com/some-path", &model.SubordinateConfiguration{
 					Policies: model.MetadataPolicy{
 						OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
 							"contacts": {
@@ -179,3 +180,220 @@ func TestServer_Fetch(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_Fetch_Caching(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	signerPublicJWK, err := jwk.PublicJwk(signer.Public())
+	if err != nil {
+		t.Fatalf("expected no error creating public JWK, got %q", err.Error())
+	}
+	subordinateSigner, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating subordinate signer, got %q", err.Error())
+	}
+	subordinateJWK, err := jwk.PublicJwk(subordinateSigner.Public())
+	if err != nil {
+		t.Fatalf("expected no error creating subordinate JWK, got %q", err.Error())
+	}
+	subordinateIdentifier := model.EntityIdentifier("https://a-some-fourth-federation.com/some-path")
+
+	tests := map[string]struct {
+		statementLifetime time.Duration
+		validate          func(t *testing.T, configuration *model.IntermediateConfiguration, fetch func(t *testing.T, path string) string)
+	}{
+		"the signed subordinate statement is served from cache": {
+			statementLifetime: 1 * time.Hour,
+			validate: func(t *testing.T, configuration *model.IntermediateConfiguration, fetch func(t *testing.T, path string) string) {
+				first := fetch(t, "/fetch?sub="+url.QueryEscape(string(subordinateIdentifier)))
+				second := fetch(t, "/fetch?sub="+url.QueryEscape(string(subordinateIdentifier)))
+				if first != second {
+					t.Error("expected the cached subordinate statement to be served on the second request")
+				}
+			},
+		},
+		"the cache is shared with the extended list endpoint": {
+			statementLifetime: 1 * time.Hour,
+			validate: func(t *testing.T, configuration *model.IntermediateConfiguration, fetch func(t *testing.T, path string) string) {
+				statement := fetch(t, "/fetch?sub="+url.QueryEscape(string(subordinateIdentifier)))
+				var listing model.ExtendedListingResponse
+				if err := json.Unmarshal([]byte(fetch(t, "/extended-list?claims=subordinate_statement&limit=1")), &listing); err != nil {
+					t.Fatalf("expected no error unmarshalling extended listing, got %q", err.Error())
+				}
+				if len(listing.ImmediateSubordinateEntities) != 1 {
+					t.Fatalf("expected 1 entity in extended listing, got %d", len(listing.ImmediateSubordinateEntities))
+				}
+				if listing.ImmediateSubordinateEntities[0]["subordinate_statement"] != statement {
+					t.Error("expected the extended listing to include the cached subordinate statement")
+				}
+			},
+		},
+		"updating a subordinate invalidates its cached statement": {
+			statementLifetime: 1 * time.Hour,
+			validate: func(t *testing.T, configuration *model.IntermediateConfiguration, fetch func(t *testing.T, path string) string) {
+				first := fetch(t, "/fetch?sub="+url.QueryEscape(string(subordinateIdentifier)))
+				_ = configuration.AddSubordinate(subordinateIdentifier, &model.SubordinateConfiguration{
+					JWKs: josemodel.Jwks{Keys: []map[string]any{*subordinateJWK}},
+					Policies: model.MetadataPolicy{
+						OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+							"contacts": {Metadata: []model.MetadataPolicyOperator{model_test.NewAdd(t, []any{"ops@some-federation.com"})}},
+						},
+					},
+				})
+				second := fetch(t, "/fetch?sub="+url.QueryEscape(string(subordinateIdentifier)))
+				if first == second {
+					t.Fatal("expected a new subordinate statement after the subordinate was updated")
+				}
+				body := decodeEntityConfigurationBody(t, second)
+				if _, ok := body["metadata_policy"].(map[string]any)["openid_relying_party"]; !ok {
+					t.Errorf("expected the new subordinate statement to contain the updated policy, got %v", body["metadata_policy"])
+				}
+			},
+		},
+		"statements without a usable lifetime are not cached": {
+			validate: func(t *testing.T, configuration *model.IntermediateConfiguration, fetch func(t *testing.T, path string) string) {
+				first := fetch(t, "/fetch?sub="+url.QueryEscape(string(subordinateIdentifier)))
+				second := fetch(t, "/fetch?sub="+url.QueryEscape(string(subordinateIdentifier)))
+				if first == second {
+					t.Error("expected a newly signed subordinate statement for each request")
+				}
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			configuration := &model.IntermediateConfiguration{
+				SubordinateStatementLifetime: tt.statementLifetime,
+				SubordinateCacheTime:         5 * time.Minute,
+			}
+			_ = configuration.AddSubordinate(subordinateIdentifier, &model.SubordinateConfiguration{
+				JWKs: josemodel.Jwks{Keys: []map[string]any{*subordinateJWK}},
+			})
+			server := NewServer(model.ServerConfiguration{
+				SignerConfiguration: model.SignerConfiguration{
+					Algorithm: "ES256",
+					Signer:    signer,
+					KeyID:     (*signerPublicJWK)["kid"].(string),
+				},
+				EntityIdentifier:          "https://some-trust-anchor.com/",
+				IntermediateConfiguration: configuration,
+				Extensions: model.Extensions{
+					ExtendedListing: model.ExtendedListingConfiguration{
+						Enabled:           true,
+						SizeLimit:         50,
+						MetadataRetriever: TestExtendedRetriever{},
+					},
+				},
+			})
+			m := http.NewServeMux()
+			server.Configure(m)
+			s := httptest.NewServer(m)
+			t.Cleanup(s.Close)
+
+			tt.validate(t, configuration, func(t *testing.T, path string) string {
+				t.Helper()
+				response, err := s.Client().Get(s.URL + path)
+				if err != nil {
+					t.Fatalf("expected no error making request, got %q", err.Error())
+				}
+				defer response.Body.Close() //nolint:errcheck
+				responseBytes, err := io.ReadAll(response.Body)
+				if err != nil {
+					t.Fatalf("failed to read response body: %v", err)
+				}
+				if response.StatusCode != http.StatusOK {
+					t.Fatalf("expected status code 200, got %d (response: %s)", response.StatusCode, responseBytes)
+				}
+				return string(responseBytes)
+			})
+		})
+	}
+}
+
+// copyingRetriever returns a new copy of each subordinate on every call, as retrievers backed by a database do
+type copyingRetriever struct {
+	TestRetriever
+}
+
+func (c *copyingRetriever) GetSubordinate(ctx context.Context, identifier model.EntityIdentifier) (*model.SubordinateConfiguration, error) {
+	subordinate, err := c.TestRetriever.GetSubordinate(ctx, identifier)
+	if err != nil {
+		return nil, err
+	}
+	copied := *subordinate
+	return &copied, nil
+}
+
+func TestServer_Fetch_CachingWithCopyingRetriever(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	subordinateKey := func(t *testing.T, keyID string) map[string]any {
+		subordinateSigner, err := jws.GetSigner(josemodel.ES256, nil)
+		if err != nil {
+			t.Fatalf("expected no error creating subordinate signer, got %q", err.Error())
+		}
+		subordinateJWK, err := jwk.PublicJwk(subordinateSigner.Public())
+		if err != nil {
+			t.Fatalf("expected no error creating subordinate JWK, got %q", err.Error())
+		}
+		(*subordinateJWK)["kid"] = keyID
+		return *subordinateJWK
+	}
+	subordinateIdentifier := "https://some-federation.com/some-path"
+
+	retriever := &copyingRetriever{}
+	retriever.Configure(map[string]*model.SubordinateConfiguration{
+		subordinateIdentifier: {JWKs: josemodel.Jwks{Keys: []map[string]any{subordinateKey(t, "first-key")}}},
+	})
+	server := NewServer(model.ServerConfiguration{
+		SignerConfiguration:       model.SignerConfiguration{Algorithm: "ES256", Signer: signer, KeyID: "some-key-id"},
+		EntityIdentifier:          "https://some-trust-anchor.com/",
+		IntermediateConfiguration: &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour},
+		MetadataRetriever:         retriever,
+	})
+	m := http.NewServeMux()
+	server.Configure(m)
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+
+	fetch := func(t *testing.T) string {
+		t.Helper()
+		response, err := s.Client().Get(s.URL + "/fetch?sub=" + url.QueryEscape(subordinateIdentifier))
+		if err != nil {
+			t.Fatalf("expected no error making request, got %q", err.Error())
+		}
+		defer response.Body.Close() //nolint:errcheck
+		responseBytes, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("expected status code 200, got %d (response: %s)", response.StatusCode, responseBytes)
+		}
+		return string(responseBytes)
+	}
+
+	first := fetch(t)
+	if fetch(t) != first {
+		t.Error("expected the cached subordinate statement to be served although the retriever returned a new configuration")
+	}
+
+	retriever.Configure(map[string]*model.SubordinateConfiguration{
+		subordinateIdentifier: {JWKs: josemodel.Jwks{Keys: []map[string]any{subordinateKey(t, "second-key")}}},
+	})
+	second := fetch(t)
+	if second == first {
+		t.Fatal("expected a new subordinate statement once the retrieved configuration changed")
+	}
+	if keys := decodeEntityConfigurationBody(t, second)["jwks"].(map[string]any)["keys"].([]any); keys[0].(map[string]any)["kid"] != "second-key" {
+		t.Errorf("expected the new subordinate statement to contain the changed key, got %v", keys)
+	}
+	if fetch(t) != second {
+		t.Error("expected the new subordinate statement to be cached")
+	}
+}
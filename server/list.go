@@ -2,57 +2,123 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
 
 	"github.com/MichaelFraser99/go-openid-federation/model"
 )
 
 const listingUnavailableError = "unable to list subordinate entities at this time"
 
+// listingPageSize is the number of subordinates buffered before a listing response starts to be streamed
+const listingPageSize = 1000
+
+// List
+//
+//	Handles the subordinate listing endpoint. Subordinates are retrieved as the returned ResponseFunc runs, and
+//	listings larger than a single page are streamed. Once streaming has begun a retrieval failure can no longer be
+//	reported through the status, so it is returned by the ResponseFunc and the response must be treated as failed -
+//	the handlers installed by Configure abort the response
 func (s *Server) List(w http.ResponseWriter, r *http.Request) ResponseFunc {
 	ctx := r.Context()
+	cfg := s.configuration()
 
-	entityType := r.URL.Query().Get("entity_type")        //todo
-	trustMarked := r.URL.Query().Get("trust_marked")      //todo
-	trustMarkType := r.URL.Query().Get("trust_mark_type") //todo
-	intermediate := r.URL.Query().Get("intermediate")     //todo: consider supporting - we likely won't - stupid parameter
-
-	if entityType != "" {
-		s.cfg.LogInfo(ctx, "received list request with unsupported parameter 'entity_type'", slog.String("entity_type", entityType))
-		return s.RespondWithError(ctx, w, model.NewUnsupportedParameterError("parameter 'entity_type' is not supported"))
+	query := r.URL.Query()
+	filter := model.ListingFilter{
+		EntityTypes:   query["entity_type"],
+		TrustMarkType: query.Get("trust_mark_type"),
 	}
-	if trustMarked != "" {
-		s.cfg.LogInfo(ctx, "received list request with unsupported parameter 'trust_marked'", slog.String("trust_marked", trustMarked))
-		return s.RespondWithError(ctx, w, model.NewUnsupportedParameterError("parameter 'trust_marked' is not supported"))
-	}
-	if trustMarkType != "" {
-		s.cfg.LogInfo(ctx, "received list request with unsupported parameter 'trust_mark_type'", slog.String("trust_mark_type", trustMarkType))
-		return s.RespondWithError(ctx, w, model.NewUnsupportedParameterError("parameter 'trust_mark_type' is not supported"))
+
+	if trustMarked := query.Get("trust_marked"); trustMarked != "" {
+		parsed, err := strconv.ParseBool(trustMarked)
+		if err != nil {
+			cfg.LogInfo(ctx, "received list request with malformed parameter 'trust_marked'", slog.String("trust_marked", trustMarked))
+			return s.RespondWithError(ctx, w, model.NewInvalidRequestError("malformed 'trust_marked' parameter"))
+		}
+		filter.TrustMarked = parsed
 	}
-	if intermediate != "" {
-		s.cfg.LogInfo(ctx, "received list request with unsupported parameter 'intermediate'", slog.String("intermediate", intermediate))
-		return s.RespondWithError(ctx, w, model.NewUnsupportedParameterError("parameter 'intermediate' is not supported"))
+	if intermediate := query.Get("intermediate"); intermediate != "" {
+		parsed, err := strconv.ParseBool(intermediate)
+		if err != nil {
+			cfg.LogInfo(ctx, "received list request with malformed parameter 'intermediate'", slog.String("intermediate", intermediate))
+			return s.RespondWithError(ctx, w, model.NewInvalidRequestError("malformed 'intermediate' parameter"))
+		}
+		filter.Intermediate = &parsed
 	}
 
-	if s.cfg.IntermediateConfiguration == nil {
+	if cfg.IntermediateConfiguration == nil {
 		return s.RespondWithJSON(w, []byte(`[]`))
 	}
-	var entities []string
 
-	subordinates, err := s.cfg.GetSubordinates(ctx)
-	if err != nil {
-		s.cfg.LogError(ctx, "error retrieving subordinates", slog.String("error", err.Error()))
-		return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(listingUnavailableError))
+	// subordinates are only retrieved once the response is written, so nothing is held open if it never is. The first
+	// page is buffered so that listings which fit within it always end in either a complete array or an error response
+	return func() error {
+		page := make([]model.EntityIdentifier, 0, listingPageSize)
+		var stream *listingStream
+		for identifier, err := range cfg.StreamListing(ctx, filter) {
+			if err != nil {
+				if stream == nil {
+					cfg.LogError(ctx, "error retrieving subordinates", slog.String("error", err.Error()))
+					return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(listingUnavailableError))()
+				}
+				// the status has already been sent, so the failure is returned rather than serving a truncated
+				// listing as complete
+				cfg.LogError(ctx, "error retrieving subordinates during listing", slog.String("error", err.Error()))
+				return fmt.Errorf("listing failed after the response began: %w", err)
+			}
+
+			if stream == nil {
+				if len(page) < listingPageSize {
+					page = append(page, identifier)
+					continue
+				}
+				stream = startListing(w, page)
+			}
+			if err = stream.write(identifier); err != nil {
+				cfg.LogInfo(ctx, "error writing listing response", slog.String("error", err.Error()))
+				return nil
+			}
+		}
+
+		if stream == nil {
+			stream = startListing(w, page)
+		}
+		stream.end()
+		return nil
 	}
-	for identifier := range subordinates {
-		entities = append(entities, string(identifier))
+}
+
+// listingStream writes a listing response as a JSON array, one identifier at a time
+type listingStream struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+// startListing writes the response status and opens the array with the given identifiers
+func startListing(w http.ResponseWriter, identifiers []model.EntityIdentifier) *listingStream {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, "[")
+	stream := &listingStream{w: w}
+	for _, identifier := range identifiers {
+		_ = stream.write(identifier)
 	}
+	return stream
+}
 
-	entitiesJSON, err := json.Marshal(entities)
-	if err != nil {
-		s.cfg.LogError(ctx, "error marshalling entities", slog.String("error", err.Error()))
-		return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(listingUnavailableError))
+func (l *listingStream) write(identifier model.EntityIdentifier) error {
+	if l.written {
+		_, _ = io.WriteString(l.w, ",")
 	}
-	return s.RespondWithJSON(w, entitiesJSON)
+	l.written = true
+	identifierJSON, _ := json.Marshal(identifier)
+	_, err := l.w.Write(identifierJSON)
+	return err
+}
+
+func (l *listingStream) end() {
+	_, _ = io.WriteString(l.w, "]")
 }
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+// PolicyCompatibilityReport describes whether the metadata policies the server publishes for its subordinates can be
+// combined with the policies its superiors publish for the server
+type PolicyCompatibilityReport struct {
+	Compatible         bool                              `json:"compatible"`
+	Conflicts          []SubordinatePolicyConflict       `json:"conflicts,omitempty"`
+	UncheckedSuperiors map[model.EntityIdentifier]string `json:"unchecked_superiors,omitempty"` // UncheckedSuperiors maps superiors whose subordinate statement could not be retrieved to the reason
+}
+
+// SubordinatePolicyConflict is a metadata parameter for which the policy published for a subordinate cannot be
+// combined with a superior's policy. Every trust chain for the subordinate through that superior will fail to resolve
+type SubordinatePolicyConflict struct {
+	Subordinate model.EntityIdentifier `json:"subordinate"`
+	Superior    model.EntityIdentifier `json:"superior"`
+	PolicyConflict
+}
+
+// CheckPolicyCompatibility
+//
+//	Retrieves the subordinate statement each configured authority hint issues about the server and combines its
+//	metadata policy with the policy configured for every subordinate, reporting each parameter that cannot be
+//	combined. Conflicts are logged. Superiors that cannot be reached are listed within the report rather than failing
+//	the check - an error is only returned if the server's subordinates cannot be listed
+func (s *Server) CheckPolicyCompatibility(ctx context.Context) (*PolicyCompatibilityReport, error) {
+	cfg := s.configuration()
+	policies := map[model.EntityIdentifier]model.MetadataPolicy{}
+	if cfg.IntermediateConfiguration != nil {
+		subordinates, err := cfg.GetSubordinates(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for identifier, subordinate := range subordinates {
+			policies[identifier] = subordinate.Policies
+		}
+	}
+	return s.checkPolicies(ctx, cfg, policies), nil
+}
+
+// CheckSubordinatePolicy
+//
+//	Combines a candidate metadata policy for the given subordinate with the policies the server's superiors publish
+//	for the server, as CheckPolicyCompatibility does for configured subordinates. Intended for validating a change to
+//	a subordinate's policy before it is published
+func (s *Server) CheckSubordinatePolicy(ctx context.Context, subordinate model.EntityIdentifier, policy model.MetadataPolicy) *PolicyCompatibilityReport {
+	return s.checkPolicies(ctx, s.configuration(), map[model.EntityIdentifier]model.MetadataPolicy{subordinate: policy})
+}
+
+// NewSubordinateValidator
+//
+//	Retrieves the server's superior statements once and returns a validator combining each subordinate's metadata
+//	policy with them. Conflicts are always logged, and are only returned as an error when the IntermediateConfiguration
+//	sets RefusePolicyConflicts. The server installs the same check on its IntermediateConfiguration, retrieving the
+//	superior statements for each change
+func (s *Server) NewSubordinateValidator(ctx context.Context) model.SubordinateValidator {
+	retrieveCtx, cancel := context.WithTimeout(ctx, superiorRetrievalTimeout)
+	defer cancel()
+	superiors := s.RetrieveSuperiorPolicies(retrieveCtx)
+
+	return func(ctx context.Context, identifier model.EntityIdentifier, subordinate *model.SubordinateConfiguration) error {
+		report := superiors.CheckSubordinatePolicy(ctx, identifier, subordinate.Policies)
+		if intermediate := superiors.cfg.IntermediateConfiguration; intermediate == nil || !intermediate.RefusePolicyConflicts {
+			return nil
+		}
+		return report.Err()
+	}
+}
+
+// validateSubordinate is the validator installed on the server's IntermediateConfiguration
+func (s *Server) validateSubordinate(ctx context.Context, identifier model.EntityIdentifier, subordinate *model.SubordinateConfiguration) error {
+	return s.NewSubordinateValidator(ctx)(ctx, identifier, subordinate)
+}
+
+func (s *Server) checkPolicies(ctx context.Context, cfg model.ServerConfiguration, policies map[model.EntityIdentifier]model.MetadataPolicy) *PolicyCompatibilityReport {
+	return retrieveSuperiorPolicies(ctx, cfg).check(ctx, policies)
+}
+
+// superiorRetrievalTimeout bounds the retrieval of superior statements when validating subordinate changes, as
+// registrations made through the IntermediateConfiguration carry no request context
+const superiorRetrievalTimeout = 15 * time.Second
+
+// SuperiorPolicies holds the subordinate statements the server's authority hints issue about it, so that any number of
+// subordinate policies can be checked against them without the statements being retrieved again
+type SuperiorPolicies struct {
+	cfg        model.ServerConfiguration
+	statements []superiorStatement
+	unchecked  map[model.EntityIdentifier]string
+}
+
+type superiorStatement struct {
+	superior  model.EntityIdentifier
+	statement model.EntityStatement
+}
+
+// RetrieveSuperiorPolicies
+//
+//	Retrieves the subordinate statement each configured authority hint issues about the server once, for checking
+//	several subordinate policies against. Superiors that cannot be reached are listed as unchecked in every report
+//	produced from the result
+func (s *Server) RetrieveSuperiorPolicies(ctx context.Context) *SuperiorPolicies {
+	return retrieveSuperiorPolicies(ctx, s.configuration())
+}
+
+// CheckSubordinatePolicy combines a candidate metadata policy for the given subordinate with the retrieved superior
+// statements, as Server.CheckSubordinatePolicy does
+func (p *SuperiorPolicies) CheckSubordinatePolicy(ctx context.Context, subordinate model.EntityIdentifier, policy model.MetadataPolicy) *PolicyCompatibilityReport {
+	return p.check(ctx, map[model.EntityIdentifier]model.MetadataPolicy{subordinate: policy})
+}
+
+func retrieveSuperiorPolicies(ctx context.Context, cfg model.ServerConfiguration) *SuperiorPolicies {
+	if cfg.HttpClient == nil {
+		cfg.HttpClient = http.DefaultClient
+	}
+
+	superiors := &SuperiorPolicies{cfg: cfg}
+	for _, superior := range cfg.AuthorityHints {
+		statement, err := retrieveSuperiorStatement(ctx, cfg, superior)
+		if err != nil {
+			if superiors.unchecked == nil {
+				superiors.unchecked = map[model.EntityIdentifier]string{}
+			}
+			superiors.unchecked[superior] = err.Error()
+			continue
+		}
+		if statement.MetadataPolicy != nil {
+			superiors.statements = append(superiors.statements, superiorStatement{superior: superior, statement: *statement})
+		}
+	}
+	return superiors
+}
+
+func (p *SuperiorPolicies) check(ctx context.Context, policies map[model.EntityIdentifier]model.MetadataPolicy) *PolicyCompatibilityReport {
+	report := &PolicyCompatibilityReport{Compatible: true, UncheckedSuperiors: maps.Clone(p.unchecked)}
+	for _, superior := range p.statements {
+		for _, subordinate := range slices.Sorted(maps.Keys(policies)) {
+			for _, conflict := range policyConflicts(model.EntityStatement{Sub: subordinate}, p.cfg.EntityIdentifier, []model.EntityStatement{superior.statement}, policies[subordinate]) {
+				report.Compatible = false
+				report.Conflicts = append(report.Conflicts, SubordinatePolicyConflict{Subordinate: subordinate, Superior: superior.superior, PolicyConflict: conflict})
+				p.cfg.LogError(ctx, "subordinate metadata policy conflicts with a superior's policy",
+					slog.String("subordinate", string(subordinate)),
+					slog.String("superior", string(superior.superior)),
+					slog.String("entity_type", conflict.EntityType),
+					slog.String("parameter", conflict.Parameter),
+					slog.String("error", conflict.Message),
+				)
+			}
+		}
+	}
+	return report
+}
+
+// Err returns an error summarising the conflicts within the report, or nil when the policies are compatible
+func (r *PolicyCompatibilityReport) Err() error {
+	switch len(r.Conflicts) {
+	case 0:
+		return nil
+	case 1:
+		conflict := r.Conflicts[0]
+		return fmt.Errorf("metadata policy for %s conflicts with the policy of %s for %s parameter %s: %s", conflict.Subordinate, conflict.Superior, conflict.EntityType, conflict.Parameter, conflict.Message)
+	default:
+		return fmt.Errorf("%d metadata policy parameters conflict with the policies of superiors", len(r.Conflicts))
+	}
+}
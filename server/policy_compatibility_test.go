@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/MichaelFraser99/go-openid-federation/model_test"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestServer_CheckPolicyCompatibility(t *testing.T) {
+	newKey := func(t *testing.T, keyID string) (josemodel.Jwks, model.SignerConfiguration) {
+		signer, err := jws.GetSigner(josemodel.ES256, nil)
+		if err != nil {
+			t.Fatalf("expected no error creating signer, got %q", err.Error())
+		}
+		publicJWK, err := jwk.PublicJwk(signer.Public())
+		if err != nil {
+			t.Fatalf("expected no error creating JWK, got %q", err.Error())
+		}
+		(*publicJWK)["kid"] = keyID
+		return josemodel.Jwks{Keys: []map[string]any{*publicJWK}}, model.SignerConfiguration{Signer: signer, KeyID: keyID, Algorithm: "ES256"}
+	}
+	grantTypes := func(t *testing.T, operator model.MetadataPolicyOperator) model.MetadataPolicy {
+		return model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+			"grant_types": {Metadata: []model.MetadataPolicyOperator{operator}},
+		}}
+	}
+
+	tests := map[string]struct {
+		authorityHints     []string
+		subordinates       func(t *testing.T) map[string]model.MetadataPolicy
+		expectedConflicts  []SubordinatePolicyConflict
+		expectedUnchecked  []string
+		expectedCompatible bool
+	}{
+		"compatible policies": {
+			authorityHints: []string{"/ta"},
+			subordinates: func(t *testing.T) map[string]model.MetadataPolicy {
+				return map[string]model.MetadataPolicy{
+					"/leaf":  grantTypes(t, model_test.NewSubsetOf(t, []any{"authorization_code"})),
+					"/other": {},
+				}
+			},
+			expectedCompatible: true,
+		},
+		"a conflicting subordinate policy is reported": {
+			authorityHints: []string{"/ta"},
+			subordinates: func(t *testing.T) map[string]model.MetadataPolicy {
+				return map[string]model.MetadataPolicy{
+					"/leaf":  grantTypes(t, model_test.NewValue(t, []any{"implicit"})),
+					"/other": grantTypes(t, model_test.NewSubsetOf(t, []any{"refresh_token"})),
+				}
+			},
+			expectedConflicts: []SubordinatePolicyConflict{{
+				Subordinate:    "/leaf",
+				Superior:       "/ta",
+				PolicyConflict: PolicyConflict{EntityType: "openid_relying_party", Parameter: "grant_types"},
+			}},
+		},
+		"an unreachable superior is reported as unchecked": {
+			authorityHints: []string{"/ta", "/missing"},
+			subordinates: func(t *testing.T) map[string]model.MetadataPolicy {
+				return map[string]model.MetadataPolicy{"/leaf": {}}
+			},
+			expectedUnchecked:  []string{"/missing"},
+			expectedCompatible: true,
+		},
+		"no authority hints": {
+			subordinates: func(t *testing.T) map[string]model.MetadataPolicy {
+				return map[string]model.MetadataPolicy{"/leaf": grantTypes(t, model_test.NewValue(t, []any{"implicit"}))}
+			},
+			expectedCompatible: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			s := httptest.NewTLSServer(mux)
+			defer s.Close()
+
+			intJWKs, intSigner := newKey(t, "int-key")
+			_, taSigner := newKey(t, "ta-key")
+			taConfiguration := &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour}
+			_ = taConfiguration.AddSubordinate(model.EntityIdentifier(s.URL+"/int"), &model.SubordinateConfiguration{
+				JWKs:     intJWKs,
+				Policies: grantTypes(t, model_test.NewSubsetOf(t, []any{"authorization_code", "refresh_token"})),
+			})
+			intConfiguration := &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour}
+			for path, policy := range tt.subordinates(t) {
+				jwks, _ := newKey(t, strings.TrimPrefix(path, "/")+"-key")
+				_ = intConfiguration.AddSubordinate(model.EntityIdentifier(s.URL+path), &model.SubordinateConfiguration{JWKs: jwks, Policies: policy})
+			}
+			var authorityHints []model.EntityIdentifier
+			for _, hint := range tt.authorityHints {
+				authorityHints = append(authorityHints, model.EntityIdentifier(s.URL+hint))
+			}
+
+			ta := NewServer(model.ServerConfiguration{
+				EntityIdentifier:            model.EntityIdentifier(s.URL + "/ta"),
+				SignerConfiguration:         taSigner,
+				EntityConfigurationLifetime: time.Hour,
+				IntermediateConfiguration:   taConfiguration,
+			})
+			intermediate := NewServer(model.ServerConfiguration{
+				Configuration:               model.Configuration{HttpClient: s.Client()},
+				EntityIdentifier:            model.EntityIdentifier(s.URL + "/int"),
+				SignerConfiguration:         intSigner,
+				EntityConfigurationLifetime: time.Hour,
+				AuthorityHints:              authorityHints,
+				IntermediateConfiguration:   intConfiguration,
+			})
+			for prefix, entity := range map[string]*Server{"/ta": ta, "/int": intermediate} {
+				entityMux := http.NewServeMux()
+				entity.Configure(entityMux)
+				mux.Handle(prefix+"/", http.StripPrefix(prefix, entityMux))
+			}
+
+			report, err := intermediate.CheckPolicyCompatibility(context.Background())
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if report.Compatible != tt.expectedCompatible {
+				t.Errorf("expected compatible to be %t, got %t", tt.expectedCompatible, report.Compatible)
+			}
+			if (report.Err() == nil) != tt.expectedCompatible {
+				t.Errorf("expected an error only when incompatible, got %v", report.Err())
+			}
+
+			var conflicts []SubordinatePolicyConflict
+			for _, conflict := range report.Conflicts {
+				if !strings.Contains(conflict.Message, "cannot merge") {
+					t.Errorf("expected the conflict for %s to be described, got %q", conflict.Parameter, conflict.Message)
+				}
+				conflict.Subordinate = model.EntityIdentifier(strings.TrimPrefix(string(conflict.Subordinate), s.URL))
+				conflict.Superior = model.EntityIdentifier(strings.TrimPrefix(string(conflict.Superior), s.URL))
+				conflict.Message = ""
+				conflicts = append(conflicts, conflict)
+			}
+			if diff := cmp.Diff(tt.expectedConflicts, conflicts); diff != "" {
+				t.Errorf("conflicts mismatch (-expected +got):\n%s", diff)
+			}
+
+			var unchecked []string
+			for superior := range report.UncheckedSuperiors {
+				unchecked = append(unchecked, strings.TrimPrefix(string(superior), s.URL))
+			}
+			if diff := cmp.Diff(tt.expectedUnchecked, unchecked); diff != "" {
+				t.Errorf("unchecked superiors mismatch (-expected +got):\n%s", diff)
+			}
+
+			if len(tt.authorityHints) > 0 {
+				candidate := intermediate.CheckSubordinatePolicy(context.Background(), model.EntityIdentifier(s.URL+"/new"), grantTypes(t, model_test.NewValue(t, []any{"implicit"})))
+				if candidate.Compatible || len(candidate.Conflicts) != 1 || candidate.Conflicts[0].Subordinate != model.EntityIdentifier(s.URL+"/new") {
+					t.Errorf("expected the candidate policy to conflict, got %+v", candidate)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_SubordinateValidator(t *testing.T) {
+	newKey := func(t *testing.T, keyID string) (josemodel.Jwks, model.SignerConfiguration) {
+		signer, err := jws.GetSigner(josemodel.ES256, nil)
+		if err != nil {
+			t.Fatalf("expected no error creating signer, got %q", err.Error())
+		}
+		publicJWK, err := jwk.PublicJwk(signer.Public())
+		if err != nil {
+			t.Fatalf("expected no error creating JWK, got %q", err.Error())
+		}
+		(*publicJWK)["kid"] = keyID
+		return josemodel.Jwks{Keys: []map[string]any{*publicJWK}}, model.SignerConfiguration{Signer: signer, KeyID: keyID, Algorithm: "ES256"}
+	}
+	grantTypes := func(t *testing.T, operator model.MetadataPolicyOperator) *model.SubordinateConfiguration {
+		jwks, _ := newKey(t, "leaf-key")
+		return &model.SubordinateConfiguration{JWKs: jwks, Policies: model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+			"grant_types": {Metadata: []model.MetadataPolicyOperator{operator}},
+		}}}
+	}
+
+	tests := map[string]struct {
+		refuse        bool
+		expectRefused bool
+	}{
+		"conflicting changes are refused": {
+			refuse:        true,
+			expectRefused: true,
+		},
+		"conflicting changes are only reported when not refused": {},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			s := httptest.NewTLSServer(mux)
+			defer s.Close()
+
+			intJWKs, intSigner := newKey(t, "int-key")
+			_, taSigner := newKey(t, "ta-key")
+			taConfiguration := &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour}
+			_ = taConfiguration.AddSubordinate(model.EntityIdentifier(s.URL+"/int"), &model.SubordinateConfiguration{
+				JWKs: intJWKs,
+				Policies: model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+					"grant_types": {Metadata: []model.MetadataPolicyOperator{model_test.NewSubsetOf(t, []any{"authorization_code", "refresh_token"})}},
+				}},
+			})
+			ta := NewServer(model.ServerConfiguration{
+				EntityIdentifier:            model.EntityIdentifier(s.URL + "/ta"),
+				SignerConfiguration:         taSigner,
+				EntityConfigurationLifetime: time.Hour,
+				IntermediateConfiguration:   taConfiguration,
+			})
+			taMux := http.NewServeMux()
+			ta.Configure(taMux)
+			mux.Handle("/ta/", http.StripPrefix("/ta", taMux))
+
+			intConfiguration := &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour, RefusePolicyConflicts: tt.refuse}
+			intermediate := NewServer(model.ServerConfiguration{
+				Configuration:               model.Configuration{HttpClient: s.Client()},
+				EntityIdentifier:            model.EntityIdentifier(s.URL + "/int"),
+				SignerConfiguration:         intSigner,
+				EntityConfigurationLifetime: time.Hour,
+				AuthorityHints:              []model.EntityIdentifier{model.EntityIdentifier(s.URL + "/ta")},
+				IntermediateConfiguration:   intConfiguration,
+			})
+			leaf := model.EntityIdentifier(s.URL + "/leaf")
+
+			if err := intConfiguration.AddSubordinate(leaf, grantTypes(t, model_test.NewSubsetOf(t, []any{"authorization_code"}))); err != nil {
+				t.Fatalf("expected a compatible subordinate to be added, got %q", err.Error())
+			}
+			err := intConfiguration.UpdateSubordinate(leaf, grantTypes(t, model_test.NewValue(t, []any{"implicit"})))
+			if refused := err != nil; refused != tt.expectRefused {
+				t.Fatalf("expected the conflicting update to be refused: %t, got %v", tt.expectRefused, err)
+			}
+			if tt.expectRefused && !strings.Contains(err.Error(), "grant_types") {
+				t.Errorf("expected the conflict to be described, got %q", err.Error())
+			}
+
+			intermediate.Close()
+			if err = intConfiguration.UpdateSubordinate(leaf, grantTypes(t, model_test.NewValue(t, []any{"implicit"}))); err != nil {
+				t.Errorf("expected no validation once the server was closed, got %q", err.Error())
+			}
+		})
+	}
+}
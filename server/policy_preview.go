@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/MichaelFraser99/go-openid-federation/internal/entity_configuration"
+	"github.com/MichaelFraser99/go-openid-federation/internal/trust_chain"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+// PolicyPreview describes the effect publishing a candidate metadata policy for a subordinate would have on the
+// subordinate's resolved metadata
+type PolicyPreview struct {
+	Subordinate     model.EntityIdentifier `json:"subordinate"`
+	TrustAnchor     model.EntityIdentifier `json:"trust_anchor"`
+	Metadata        *model.Metadata        `json:"metadata,omitempty"`         // Metadata is resolved under the candidate policy, absent if it cannot be resolved
+	CurrentMetadata *model.Metadata        `json:"current_metadata,omitempty"` // CurrentMetadata is resolved under the subordinate's current policy, absent if it is not yet registered or cannot be resolved
+	Conflicts       []PolicyConflict       `json:"conflicts,omitempty"`
+	Error           string                 `json:"error,omitempty"`         // Error is set when metadata cannot be resolved under the candidate policy
+	CurrentError    string                 `json:"current_error,omitempty"` // CurrentError is set when metadata cannot be resolved under the subordinate's current policy, in which case no changes are reported
+	Changes         []MetadataChange       `json:"changes,omitempty"`
+	Trace           *model.PolicyTrace     `json:"policy_trace,omitempty"`
+}
+
+// PolicyConflict is a metadata parameter for which the candidate policy cannot be combined with the policies of the
+// superiors, or for which the candidate's own operators cannot be combined
+type PolicyConflict struct {
+	EntityType string `json:"entity_type"`
+	Parameter  string `json:"parameter"`
+	Message    string `json:"message"`
+}
+
+// MetadataChange is a metadata parameter whose resolved value differs under the candidate policy. A nil value
+// indicates the parameter is absent
+type MetadataChange struct {
+	EntityType string `json:"entity_type"`
+	Parameter  string `json:"parameter"`
+	Before     any    `json:"before,omitempty"`
+	After      any    `json:"after,omitempty"`
+}
+
+// PreviewPolicy
+//
+//	Previews the effect of publishing candidate as the metadata policy for the given subordinate, without signing or
+//	publishing anything. The subordinate's Entity Configuration and the statements above the server in a trust chain
+//	to trustAnchor are retrieved, and their metadata policies combined with the candidate as they would be when
+//	resolving the subordinate's metadata. Conflicts are reported for each parameter and the result is compared against
+//	the metadata resolved under the subordinate's current policy - a subordinate that is not yet registered has every
+//	resolved parameter reported as a change, while no changes are reported when the current policy cannot be
+//	resolved. An error is only returned if the subordinate's Entity Configuration or the server's trust chain cannot
+//	be retrieved
+func (s *Server) PreviewPolicy(ctx context.Context, subordinate, trustAnchor model.EntityIdentifier, candidate model.MetadataPolicy) (*PolicyPreview, error) {
+	cfg := s.configuration()
+	if cfg.HttpClient == nil {
+		cfg.HttpClient = http.DefaultClient
+	}
+
+	_, subject, err := entity_configuration.Retrieve(ctx, cfg.Configuration, subordinate)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the subordinate's entity configuration: %w", err)
+	}
+	superiors, err := s.superiorChain(ctx, cfg, trustAnchor)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &PolicyPreview{
+		Subordinate: subordinate,
+		TrustAnchor: trustAnchor,
+		Conflicts:   policyConflicts(*subject, cfg.EntityIdentifier, superiors, candidate),
+		Trace:       &model.PolicyTrace{},
+	}
+	current, err := cfg.GetSubordinate(ctx, subordinate)
+	switch {
+	case err == nil:
+		if preview.CurrentMetadata, err = previewMetadata(*subject, cfg.EntityIdentifier, &current.Policies, superiors, nil); err != nil {
+			preview.CurrentError = err.Error()
+		}
+	case !errors.Is(err, model.ErrNotFound):
+		preview.CurrentError = fmt.Sprintf("unable to retrieve the subordinate's current policy: %s", err.Error())
+	}
+	preview.Metadata, err = previewMetadata(*subject, cfg.EntityIdentifier, &candidate, superiors, preview.Trace)
+	if err != nil {
+		preview.Error = err.Error()
+		return preview, nil
+	}
+	if preview.CurrentError == "" {
+		preview.Changes = diffMetadata(preview.CurrentMetadata, preview.Metadata)
+	}
+	return preview, nil
+}
+
+// superiorChain returns the statements above the server in a trust chain to the given trust anchor, from the
+// statement its immediate superior issues about it through to the trust anchor's Entity Configuration
+func (s *Server) superiorChain(ctx context.Context, cfg model.ServerConfiguration, trustAnchor model.EntityIdentifier) ([]model.EntityStatement, error) {
+	if trustAnchor == cfg.EntityIdentifier {
+		token, err := s.EntityConfiguration(ctx)
+		if err != nil {
+			return nil, err
+		}
+		entityConfiguration, err := entity_configuration.Validate(ctx, cfg.EntityIdentifier, *token)
+		if err != nil {
+			return nil, err
+		}
+		return []model.EntityStatement{*entityConfiguration}, nil
+	}
+
+	_, chain, _, err := trust_chain.BuildTrustChain(ctx, cfg.Configuration, cfg.EntityIdentifier, trustAnchor)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build a trust chain to %s: %w", trustAnchor, err)
+	}
+	return chain[1:], nil
+}
+
+// previewMetadata resolves the subject's metadata through a chain in which issuer publishes the given policy for it
+// beneath the given superiors
+func previewMetadata(subject model.EntityStatement, issuer model.EntityIdentifier, policy *model.MetadataPolicy, superiors []model.EntityStatement, trace *model.PolicyTrace) (*model.Metadata, error) {
+	chain := append([]model.EntityStatement{subject, {Iss: issuer, Sub: subject.Sub, MetadataPolicy: policy}}, superiors...)
+
+	combined, err := model.ProcessAndExtractPolicyWithTrace(chain, trace)
+	if err != nil {
+		return nil, err
+	}
+	if combined == nil {
+		return chain[0].Metadata, nil
+	}
+	applied, err := model.ApplyPolicyWithTrace(chain[0], *combined, trace)
+	if err != nil {
+		return nil, err
+	}
+	return applied.Metadata, nil
+}
+
+// policyEntityTypes provides access to the policy for each entity type, in the order conflicts are reported
+var policyEntityTypes = []struct {
+	name     string
+	policies func(policy *model.MetadataPolicy) *map[string]model.PolicyOperators
+}{
+	{"federation_entity", func(policy *model.MetadataPolicy) *map[string]model.PolicyOperators {
+		return &policy.FederationMetadata
+	}},
+	{"openid_provider", func(policy *model.MetadataPolicy) *map[string]model.PolicyOperators {
+		return &policy.OpenIDConnectOpenIDProviderMetadata
+	}},
+	{"openid_relying_party", func(policy *model.MetadataPolicy) *map[string]model.PolicyOperators {
+		return &policy.OpenIDRelyingPartyMetadata
+	}},
+}
+
+// policyConflicts combines the candidate with the other policies in the chain one parameter at a time, so that every
+// conflicting parameter is reported rather than only the first encountered
+func policyConflicts(subject model.EntityStatement, issuer model.EntityIdentifier, superiors []model.EntityStatement, candidate model.MetadataPolicy) []PolicyConflict {
+	var conflicts []PolicyConflict
+	for _, entityType := range policyEntityTypes {
+		for _, parameter := range slices.Sorted(maps.Keys(*entityType.policies(&candidate))) {
+			restrict := func(policy *model.MetadataPolicy) *model.MetadataPolicy {
+				if policy == nil {
+					return nil
+				}
+				operators, ok := (*entityType.policies(policy))[parameter]
+				if !ok {
+					return nil
+				}
+				restricted := &model.MetadataPolicy{}
+				*entityType.policies(restricted) = map[string]model.PolicyOperators{parameter: operators}
+				return restricted
+			}
+
+			candidateParameter := restrict(&candidate)
+			err := candidateParameter.Validate()
+			if err != nil {
+				err = errors.Unwrap(err)
+			} else {
+				chain := []model.EntityStatement{
+					{MetadataPolicy: restrict(subject.MetadataPolicy)},
+					{Iss: issuer, Sub: subject.Sub, MetadataPolicy: candidateParameter},
+				}
+				for _, superior := range superiors {
+					chain = append(chain, model.EntityStatement{MetadataPolicy: restrict(superior.MetadataPolicy)})
+				}
+				_, err = model.ProcessAndExtractPolicy(chain)
+			}
+			if err != nil {
+				conflicts = append(conflicts, PolicyConflict{EntityType: entityType.name, Parameter: parameter, Message: err.Error()})
+			}
+		}
+	}
+	return conflicts
+}
+
+// diffMetadata lists the parameters whose values differ between two sets of metadata, ordered by entity type and then
+// parameter
+func diffMetadata(before, after *model.Metadata) []MetadataChange {
+	var changes []MetadataChange
+	beforeTypes, afterTypes := metadataByEntityType(before), metadataByEntityType(after)
+	for _, entityType := range policyEntityTypes {
+		beforeValues, afterValues := beforeTypes[entityType.name], afterTypes[entityType.name]
+		parameters := slices.Sorted(maps.Keys(beforeValues))
+		for parameter := range afterValues {
+			if _, ok := beforeValues[parameter]; !ok {
+				parameters = append(parameters, parameter)
+			}
+		}
+		slices.Sort(parameters)
+
+		for _, parameter := range parameters {
+			beforeValue, afterValue := beforeValues[parameter], afterValues[parameter]
+			if !sameJSON(beforeValue, afterValue) {
+				changes = append(changes, MetadataChange{EntityType: entityType.name, Parameter: parameter, Before: beforeValue, After: afterValue})
+			}
+		}
+	}
+	return changes
+}
+
+func metadataByEntityType(metadata *model.Metadata) map[string]map[string]any {
+	values := map[string]map[string]any{}
+	if metadata == nil {
+		return values
+	}
+	if metadata.FederationMetadata != nil {
+		values["federation_entity"] = *metadata.FederationMetadata
+	}
+	if metadata.OpenIDConnectOpenIDProviderMetadata != nil {
+		values["openid_provider"] = *metadata.OpenIDConnectOpenIDProviderMetadata
+	}
+	if metadata.OpenIDRelyingPartyMetadata != nil {
+		values["openid_relying_party"] = *metadata.OpenIDRelyingPartyMetadata
+	}
+	return values
+}
+
+// sameJSON compares values by their JSON encoding, as resolved values may hold equivalent slices of differing types
+func sameJSON(a, b any) bool {
+	aJSON, aErr := json.Marshal(a)
+	bJSON, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && string(aJSON) == string(bJSON)
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/MichaelFraser99/go-openid-federation/model_test"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestServer_PreviewPolicy(t *testing.T) {
+	newKey := func(t *testing.T, keyID string) (josemodel.Jwks, model.SignerConfiguration) {
+		signer, err := jws.GetSigner(josemodel.ES256, nil)
+		if err != nil {
+			t.Fatalf("expected no error creating signer, got %q", err.Error())
+		}
+		publicJWK, err := jwk.PublicJwk(signer.Public())
+		if err != nil {
+			t.Fatalf("expected no error creating JWK, got %q", err.Error())
+		}
+		(*publicJWK)["kid"] = keyID
+		return josemodel.Jwks{Keys: []map[string]any{*publicJWK}}, model.SignerConfiguration{Signer: signer, KeyID: keyID, Algorithm: "ES256"}
+	}
+
+	tests := map[string]struct {
+		candidate         func(t *testing.T) model.MetadataPolicy
+		currentPolicy     func(t *testing.T) model.MetadataPolicy
+		subordinate       string
+		trustAnchor       string
+		expectedError     string
+		expectedConflicts []PolicyConflict
+		expectedChanges   []MetadataChange
+		expectedProblem   string
+		expectedCurrent   string
+	}{
+		"changes to the resolved metadata are reported": {
+			candidate: func(t *testing.T) model.MetadataPolicy {
+				return model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+					"application_type": {Metadata: []model.MetadataPolicyOperator{model_test.NewValue(t, "web")}},
+					"contacts":         {Metadata: []model.MetadataPolicyOperator{model_test.NewAdd(t, []any{"ops@int.example.com"})}},
+				}}
+			},
+			expectedChanges: []MetadataChange{
+				{EntityType: "openid_relying_party", Parameter: "application_type", Before: "native", After: "web"},
+				{EntityType: "openid_relying_party", Parameter: "contacts", After: []any{"ops@int.example.com"}},
+			},
+		},
+		"the candidate is combined with the trust anchor's policy": {
+			candidate: func(t *testing.T) model.MetadataPolicy {
+				return model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+					"grant_types": {Metadata: []model.MetadataPolicyOperator{model_test.NewDefault(t, []any{"refresh_token"})}},
+				}}
+			},
+		},
+		"an unchanged policy reports no changes": {
+			candidate: func(t *testing.T) model.MetadataPolicy { return model.MetadataPolicy{} },
+		},
+		"every conflicting parameter is reported": {
+			candidate: func(t *testing.T) model.MetadataPolicy {
+				return model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+					"grant_types": {Metadata: []model.MetadataPolicyOperator{model_test.NewValue(t, []any{"implicit"})}},
+					"application_type": {Metadata: []model.MetadataPolicyOperator{
+						model_test.NewOneOf(t, []any{"web"}),
+						model_test.NewValue(t, "native"),
+					}},
+					"client_name": {Metadata: []model.MetadataPolicyOperator{model_test.NewValue(t, "Example RP")}},
+				}}
+			},
+			expectedConflicts: []PolicyConflict{
+				{EntityType: "openid_relying_party", Parameter: "application_type"},
+				{EntityType: "openid_relying_party", Parameter: "grant_types"},
+			},
+			expectedProblem: "cannot merge",
+		},
+		"a candidate the subordinate's metadata does not satisfy is reported": {
+			candidate: func(t *testing.T) model.MetadataPolicy {
+				return model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+					"application_type": {Metadata: []model.MetadataPolicyOperator{model_test.NewOneOf(t, []any{"web"})}},
+				}}
+			},
+			expectedProblem: "is not one of the allowed values",
+		},
+		"a current policy that cannot be resolved is reported rather than compared against": {
+			candidate: func(t *testing.T) model.MetadataPolicy {
+				return model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+					"application_type": {Metadata: []model.MetadataPolicyOperator{model_test.NewValue(t, "web")}},
+				}}
+			},
+			currentPolicy: func(t *testing.T) model.MetadataPolicy {
+				return model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+					"application_type": {Metadata: []model.MetadataPolicyOperator{model_test.NewOneOf(t, []any{"web"})}},
+				}}
+			},
+			expectedCurrent: "is not one of the allowed values",
+		},
+		"a trust anchor can preview policies for its own subordinates": {
+			candidate: func(t *testing.T) model.MetadataPolicy {
+				return model.MetadataPolicy{FederationMetadata: map[string]model.PolicyOperators{
+					"organization_name": {Metadata: []model.MetadataPolicyOperator{model_test.NewValue(t, "Renamed")}},
+				}}
+			},
+			subordinate: "/int",
+			expectedChanges: []MetadataChange{
+				{EntityType: "federation_entity", Parameter: "organization_name", Before: "Intermediate", After: "Renamed"},
+			},
+		},
+		"an unknown trust anchor is an error": {
+			candidate:     func(t *testing.T) model.MetadataPolicy { return model.MetadataPolicy{} },
+			trustAnchor:   "/other",
+			expectedError: "unable to build a trust chain",
+		},
+		"an unreachable subordinate is an error": {
+			candidate:     func(t *testing.T) model.MetadataPolicy { return model.MetadataPolicy{} },
+			subordinate:   "/missing",
+			expectedError: "unable to retrieve the subordinate's entity configuration",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			s := httptest.NewTLSServer(mux)
+			defer s.Close()
+
+			leafJWKs, leafSigner := newKey(t, "leaf-key")
+			intJWKs, intSigner := newKey(t, "int-key")
+			_, taSigner := newKey(t, "ta-key")
+
+			taConfiguration := &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour}
+			_ = taConfiguration.AddSubordinate(model.EntityIdentifier(s.URL+"/int"), &model.SubordinateConfiguration{
+				JWKs: intJWKs,
+				Policies: model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+					"grant_types": {Metadata: []model.MetadataPolicyOperator{model_test.NewSubsetOf(t, []any{"authorization_code", "refresh_token"})}},
+				}},
+			})
+			intConfiguration := &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour}
+			var currentPolicy model.MetadataPolicy
+			if tt.currentPolicy != nil {
+				currentPolicy = tt.currentPolicy(t)
+			}
+			_ = intConfiguration.AddSubordinate(model.EntityIdentifier(s.URL+"/leaf"), &model.SubordinateConfiguration{JWKs: leafJWKs, Policies: currentPolicy})
+
+			entities := map[string]*Server{
+				"/ta": NewServer(model.ServerConfiguration{
+					EntityIdentifier:            model.EntityIdentifier(s.URL + "/ta"),
+					SignerConfiguration:         taSigner,
+					EntityConfigurationLifetime: time.Hour,
+					IntermediateConfiguration:   taConfiguration,
+				}),
+				"/int": NewServer(model.ServerConfiguration{
+					Configuration:               model.Configuration{HttpClient: s.Client()},
+					EntityIdentifier:            model.EntityIdentifier(s.URL + "/int"),
+					SignerConfiguration:         intSigner,
+					EntityConfigurationLifetime: time.Hour,
+					AuthorityHints:              []model.EntityIdentifier{model.EntityIdentifier(s.URL + "/ta")},
+					IntermediateConfiguration:   intConfiguration,
+					EntityConfiguration: model.EntityStatement{Metadata: &model.Metadata{FederationMetadata: &model.FederationMetadata{
+						"organization_name": "Intermediate",
+					}}},
+				}),
+				"/leaf": NewServer(model.ServerConfiguration{
+					EntityIdentifier:            model.EntityIdentifier(s.URL + "/leaf"),
+					SignerConfiguration:         leafSigner,
+					EntityConfigurationLifetime: time.Hour,
+					AuthorityHints:              []model.EntityIdentifier{model.EntityIdentifier(s.URL + "/int")},
+					EntityConfiguration: model.EntityStatement{Metadata: &model.Metadata{OpenIDRelyingPartyMetadata: &model.OpenIDRelyingPartyMetadata{
+						"redirect_uris":             []any{"https://rp.example.com/callback"},
+						"client_registration_types": []any{"automatic"},
+						"application_type":          "native",
+						"grant_types":               []any{"authorization_code", "implicit"},
+					}}},
+				}),
+			}
+			for prefix, entity := range entities {
+				entityMux := http.NewServeMux()
+				entity.Configure(entityMux)
+				mux.Handle(prefix+"/", http.StripPrefix(prefix, entityMux))
+			}
+
+			subordinate, trustAnchor := "/leaf", "/ta"
+			if tt.subordinate != "" {
+				subordinate = tt.subordinate
+			}
+			if tt.trustAnchor != "" {
+				trustAnchor = tt.trustAnchor
+			}
+			issuer := entities["/int"]
+			if subordinate == "/int" {
+				issuer = entities["/ta"]
+				issuer.SetHttpClient(s.Client())
+			}
+
+			preview, err := issuer.PreviewPolicy(context.Background(), model.EntityIdentifier(s.URL+subordinate), model.EntityIdentifier(s.URL+trustAnchor), tt.candidate(t))
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+
+			if diff := cmp.Diff(tt.expectedChanges, preview.Changes); diff != "" {
+				t.Errorf("changes mismatch (-expected +got):\n%s", diff)
+			}
+			if tt.expectedCurrent == "" && preview.CurrentError != "" {
+				t.Errorf("expected the current policy to resolve, got error %q", preview.CurrentError)
+			} else if !strings.Contains(preview.CurrentError, tt.expectedCurrent) || (tt.expectedCurrent != "" && preview.CurrentMetadata != nil) {
+				t.Errorf("expected current policy error containing %q, got %q", tt.expectedCurrent, preview.CurrentError)
+			}
+			var conflicts []PolicyConflict
+			for _, conflict := range preview.Conflicts {
+				if conflict.Message == "" {
+					t.Errorf("expected the conflict for %s to be described", conflict.Parameter)
+				}
+				conflicts = append(conflicts, PolicyConflict{EntityType: conflict.EntityType, Parameter: conflict.Parameter})
+			}
+			if diff := cmp.Diff(tt.expectedConflicts, conflicts); diff != "" {
+				t.Errorf("conflicts mismatch (-expected +got):\n%s", diff)
+			}
+			if tt.expectedProblem == "" {
+				if preview.Error != "" || preview.Metadata == nil {
+					t.Fatalf("expected metadata to be resolved, got error %q", preview.Error)
+				}
+				if subordinate == "/leaf" {
+					if diff := cmp.Diff([]any{"authorization_code"}, (*preview.Metadata.OpenIDRelyingPartyMetadata)["grant_types"]); diff != "" {
+						t.Errorf("expected the trust anchor's policy to apply (-expected +got):\n%s", diff)
+					}
+				}
+			} else if preview.Metadata != nil || !strings.Contains(preview.Error, tt.expectedProblem) {
+				t.Errorf("expected error containing %q, got %q", tt.expectedProblem, preview.Error)
+			}
+
+			if subordinate == "/leaf" {
+				current, err := entities["/int"].cfg.GetSubordinate(context.Background(), model.EntityIdentifier(s.URL+"/leaf"))
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				if len(current.Policies.OpenIDRelyingPartyMetadata) != len(currentPolicy.OpenIDRelyingPartyMetadata) {
+					t.Errorf("expected the subordinate's policy to be left unchanged, got %v", current.Policies)
+				}
+			}
+		})
+	}
+}
@@ -1,13 +1,13 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"log/slog"
 	"net/http"
 	"slices"
 
-	"github.com/MichaelFraser99/go-jose/jwt"
-	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/internal/signing"
 	"github.com/MichaelFraser99/go-openid-federation/internal/trust_chain"
 	"github.com/MichaelFraser99/go-openid-federation/internal/trust_marks"
 	"github.com/MichaelFraser99/go-openid-federation/model"
@@ -20,47 +20,56 @@ const resolveUnavailableError = "unable to resolve entities at this time"
 
 func (s *Server) Resolve(w http.ResponseWriter, r *http.Request) ResponseFunc {
 	ctx := r.Context()
+	cfg := s.configuration()
 	sub := r.URL.Query().Get("sub")
 	trustAnchor := r.URL.Query().Get("trust_anchor")
 	entityTypes := r.URL.Query()["entity_type"]
 
-	s.cfg.LogInfo(ctx, "received resolve request", slog.String("sub", sub), slog.String("trust_anchor", trustAnchor))
+	cfg.LogInfo(ctx, "received resolve request", slog.String("sub", sub), slog.String("trust_anchor", trustAnchor))
 
 	if sub == "" {
-		s.cfg.LogInfo(ctx, "received resolve request with missing parameter 'sub'")
+		cfg.LogInfo(ctx, "received resolve request with missing parameter 'sub'")
 		return s.RespondWithError(ctx, w, model.NewInvalidRequestError("request missing required parameter 'sub'"))
 	}
 	if trustAnchor == "" {
-		s.cfg.LogInfo(ctx, "received resolve request with missing parameter 'trust_anchor'")
+		cfg.LogInfo(ctx, "received resolve request with missing parameter 'trust_anchor'")
 		return s.RespondWithError(ctx, w, model.NewInvalidRequestError("missing required parameter 'trust_anchor'"))
 	}
 
 	parsedSub, err := model.ValidateEntityIdentifier(sub)
 	if err != nil {
-		s.cfg.LogInfo(ctx, "invalid 'sub' parameter", slog.String("error", err.Error()))
+		cfg.LogInfo(ctx, "invalid 'sub' parameter", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, model.NewInvalidRequestError("malformed 'sub' parameter"))
 	}
 
 	parsedTrustAnchor, err := model.ValidateEntityIdentifier(trustAnchor)
 	if err != nil {
-		s.cfg.LogInfo(ctx, "invalid 'trust_anchor' parameter", slog.String("error", err.Error()))
+		cfg.LogInfo(ctx, "invalid 'trust_anchor' parameter", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, model.NewInvalidRequestError("malformed 'trust_anchor' parameter"))
 	}
 
-	trustChain, parsedTrustChain, _, err := trust_chain.BuildTrustChain(ctx, s.cfg.Configuration, *parsedSub, *parsedTrustAnchor)
+	trustChain, parsedTrustChain, _, err := trust_chain.BuildTrustChain(ctx, cfg.Configuration, *parsedSub, *parsedTrustAnchor)
 	if err != nil {
-		s.cfg.LogInfo(ctx, "error building trust chain", slog.String("error", err.Error()))
+		cfg.LogInfo(ctx, "error building trust chain", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, err)
 	}
 
-	resolved, err := trust_chain.ResolveMetadata(ctx, s.cfg.Configuration, s.cfg.EntityIdentifier, trustChain)
+	var trace *model.PolicyTrace
+	if cfg.Extensions.ResolveTrace.Enabled && r.URL.Query().Get("trace") == "true" {
+		trace = &model.PolicyTrace{}
+	}
+
+	resolved, err := trust_chain.ResolveMetadataWithTrace(ctx, cfg.Configuration, cfg.EntityIdentifier, trustChain, trace)
 	if err != nil {
-		s.cfg.LogInfo(ctx, "error resolving trust chain", slog.String("error", err.Error()))
+		cfg.LogInfo(ctx, "error resolving trust chain", slog.String("error", err.Error()))
+		if trace != nil {
+			return s.respondWithTrace(ctx, w, nil, trace, err)
+		}
 		return s.RespondWithError(ctx, w, err)
 	}
 
-	if err = trust_marks.FilterByTrusted(ctx, s.cfg.Configuration, resolved, parsedTrustChain[len(parsedTrustChain)-1]); err != nil {
-		s.cfg.LogInfo(ctx, "error filtering trust marks", slog.String("error", err.Error()))
+	if err = trust_marks.FilterByTrusted(ctx, cfg.Configuration, resolved, parsedTrustChain[len(parsedTrustChain)-1]); err != nil {
+		cfg.LogInfo(ctx, "error filtering trust marks", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, err)
 	}
 
@@ -78,25 +87,48 @@ func (s *Server) Resolve(w http.ResponseWriter, r *http.Request) ResponseFunc {
 
 	resolvedBytes, err := json.Marshal(resolved)
 	if err != nil {
-		s.cfg.LogError(ctx, "error marshalling resolved metadata", slog.String("error", err.Error()))
+		cfg.LogError(ctx, "error marshalling resolved metadata", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(resolveUnavailableError))
 	}
 
 	var resolvedMap map[string]any
 	if err = json.Unmarshal(resolvedBytes, &resolvedMap); err != nil {
-		s.cfg.LogError(ctx, "error unmarshalling resolved metadata", slog.String("error", err.Error()))
+		cfg.LogError(ctx, "error unmarshalling resolved metadata", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(resolveUnavailableError))
 	}
 
-	token, err := jwt.New(s.cfg.SignerConfiguration.Signer, map[string]any{
-		"kid": s.cfg.SignerConfiguration.KeyID,
-		"typ": "resolve-response+jwt",
-		"alg": s.cfg.SignerConfiguration.Algorithm,
-	}, resolvedMap, jwt.Opts{Algorithm: josemodel.GetAlgorithm(s.cfg.SignerConfiguration.Algorithm)})
+	if trace != nil {
+		return s.respondWithTrace(ctx, w, resolvedMap, trace, nil)
+	}
+
+	token, err := signing.New(ctx, cfg.SignerConfiguration, "resolve-response+jwt", resolvedMap)
 	if err != nil {
-		s.cfg.LogError(ctx, "error creating resolve response", slog.String("error", err.Error()))
+		cfg.LogError(ctx, "error creating resolve response", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(resolveUnavailableError))
 	}
 
 	return s.RespondWithResolveResponse(w, []byte(*token))
 }
+
+// respondWithTrace writes the debug form of a resolve response - the unsigned resolve response claims, or the error
+// that prevented resolution, alongside the trace of the metadata policies applied
+func (s *Server) respondWithTrace(ctx context.Context, w http.ResponseWriter, claims map[string]any, trace *model.PolicyTrace, resolveErr error) ResponseFunc {
+	cfg := s.configuration()
+	status := http.StatusOK
+	if resolveErr != nil {
+		var errorResponse string
+		status, errorResponse = s.parseError(resolveErr)
+		if err := json.Unmarshal([]byte(errorResponse), &claims); err != nil {
+			cfg.LogError(ctx, "error parsing resolve error response", slog.String("error", err.Error()))
+			return s.RespondWithError(ctx, w, resolveErr)
+		}
+	}
+	claims["policy_trace"] = trace
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		cfg.LogError(ctx, "error marshalling resolve trace", slog.String("error", err.Error()))
+		return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(resolveUnavailableError))
+	}
+	return s.respondWith(w, status, "application/json", data)
+}
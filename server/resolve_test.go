@@ -1,6 +1,7 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -106,6 +107,8 @@ func TestServer_Resolve(t *testing.T) {
 		trustAnchor       *string
 		trustAnchorPolicy model.MetadataPolicy
 		entityTypes       []string
+		resolveTrace      bool // resolveTrace enables the debug mode of the resolving server
+		trace             bool // trace requests a trace from the resolving server
 		validate          func(t *testing.T, response *http.Response, err error)
 	}{
 		"we can resolve a valid entity": {
@@ -171,6 +174,66 @@ func TestServer_Resolve(t *testing.T) {
 				validateErrorResponse(t, response, err, http.StatusBadRequest, "invalid_metadata", "unresolvable metadata policy encountered")
 			},
 		},
+		"a trace is returned alongside the error when resolving fails in debug mode": {
+			requestSub:  validEntityIdentifier,
+			trustAnchor: &validTrustAnchor,
+			trustAnchorPolicy: model.MetadataPolicy{
+				OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+					"scope": {
+						Metadata: []model.MetadataPolicyOperator{
+							model_test.NewSupersetOf(t, []any{"foo", "bar"}),
+						},
+					},
+				},
+			},
+			resolveTrace: true,
+			trace:        true,
+			validate: func(t *testing.T, response *http.Response, err error) {
+				body := validateTraceResponse(t, response, err, http.StatusBadRequest)
+				if body.Error != "invalid_metadata" {
+					t.Errorf("expected error invalid_metadata, got %q", body.Error)
+				}
+				scope := body.PolicyTrace.Parameter("openid_relying_party", "scope")
+				if scope == nil || len(scope.Steps) != 1 {
+					t.Fatalf("expected the scope parameter to be traced with a single step, got %+v", scope)
+				}
+				if step := scope.Steps[0]; step.Operator != "superset_of" || step.Before != "openid address" || step.Error == "" {
+					t.Errorf("expected superset_of to fail against 'openid address', got %+v", step)
+				}
+			},
+		},
+		"a trace is returned alongside the resolved claims in debug mode": {
+			requestSub:  validEntityIdentifier,
+			trustAnchor: &validTrustAnchor,
+			trustAnchorPolicy: model.MetadataPolicy{
+				OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+					"scope": {
+						Metadata: []model.MetadataPolicyOperator{
+							model_test.NewAdd(t, []any{"phone_number"}),
+						},
+					},
+				},
+			},
+			resolveTrace: true,
+			trace:        true,
+			validate: func(t *testing.T, response *http.Response, err error) {
+				body := validateTraceResponse(t, response, err, http.StatusOK)
+				if body.Sub != validEntityIdentifier {
+					t.Errorf("expected sub %s, got %s", validEntityIdentifier, body.Sub)
+				}
+				scope := body.PolicyTrace.Parameter("openid_relying_party", "scope")
+				if scope == nil || len(scope.Steps) != 1 || scope.Steps[0].After != "openid address phone_number" {
+					t.Errorf("expected the scope parameter to be traced through to 'openid address phone_number', got %+v", scope)
+				}
+			},
+		},
+		"the trace parameter is ignored unless debug mode is enabled": {
+			requestSub: dcs.URL,
+			trace:      true,
+			validate: func(t *testing.T, response *http.Response, err error) {
+				validateFetchResponse(t, response, err, http.StatusOK)
+			},
+		},
 		"valid trust chain with more complex metadata": {
 			requestSub:  validEntityIdentifier,
 			trustAnchor: &validTrustAnchor,
@@ -197,7 +260,6 @@ func TestServer_Resolve(t *testing.T) {
 			intermediateConfigurations := &model.IntermediateConfiguration{
 				SubordinateStatementLifetime: 1 * time.Minute,
 			}
-			intermediateConfigurations.AddSubordinate(model.EntityIdentifier(dcs.URL), &model.SubordinateConfiguration{})
 
 			tr := TestRetriever{}
 			tr.Configure(map[string]*model.SubordinateConfiguration{
@@ -221,6 +283,7 @@ func TestServer_Resolve(t *testing.T) {
 				EntityConfiguration:         model.EntityStatement{},
 				EntityConfigurationLifetime: 10 * time.Minute,
 				MetadataRetriever:           tr,
+				Extensions:                  model.Extensions{ResolveTrace: model.ResolveTraceConfiguration{Enabled: tt.resolveTrace}},
 				Configuration: model.Configuration{
 					Logger: slog.New(slog.NewJSONHandler(os.Stdout, nil)),
 				},
@@ -236,7 +299,7 @@ func TestServer_Resolve(t *testing.T) {
 			server.SetEntityIdentifier(model.EntityIdentifier(s.URL))
 			server.SetHttpClient(testClient)
 
-			trustAnchorServer.cfg.IntermediateConfiguration.AddSubordinate(model.EntityIdentifier(s.URL), &model.SubordinateConfiguration{
+			_ = trustAnchorServer.cfg.IntermediateConfiguration.AddSubordinate(model.EntityIdentifier(s.URL), &model.SubordinateConfiguration{
 				CachedAt: time.Now().UTC().Unix(),
 				JWKs: josemodel.Jwks{
 					Keys: []map[string]any{*signerPublicJWK},
@@ -260,6 +323,9 @@ func TestServer_Resolve(t *testing.T) {
 			for _, entityType := range tt.entityTypes {
 				params.Add("entity_type", entityType)
 			}
+			if tt.trace {
+				params.Add("trace", "true")
+			}
 			if len(params) > 0 {
 				requestURL = fmt.Sprintf("%s?%s", requestURL, params.Encode())
 			}
@@ -275,3 +341,28 @@ func TestServer_Resolve(t *testing.T) {
 		})
 	}
 }
+
+type traceResponse struct {
+	Error       string            `json:"error"`
+	Sub         string            `json:"sub"`
+	PolicyTrace model.PolicyTrace `json:"policy_trace"`
+}
+
+func validateTraceResponse(t *testing.T, response *http.Response, err error, expectedStatusCode int) traceResponse {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("expected no error making request, got %q", err.Error())
+	}
+	defer response.Body.Close() //nolint:errcheck
+	if response.StatusCode != expectedStatusCode {
+		t.Errorf("expected status code %d, got %d", expectedStatusCode, response.StatusCode)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected response type 'application/json', got %s", contentType)
+	}
+	var body traceResponse
+	if err = json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatalf("expected no error decoding response, got %q", err.Error())
+	}
+	return body
+}
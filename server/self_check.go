@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/MichaelFraser99/go-openid-federation/internal/entity_configuration"
+	"github.com/MichaelFraser99/go-openid-federation/internal/signing"
+	"github.com/MichaelFraser99/go-openid-federation/internal/subordinate_statement"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+// SelfCheckReport describes whether each of the server's superiors vouches for it
+type SelfCheckReport struct {
+	Healthy   bool            `json:"healthy"`
+	Superiors []SuperiorCheck `json:"superiors"`
+}
+
+// SuperiorCheck is the outcome of checking the subordinate statement a single superior issues about the server
+type SuperiorCheck struct {
+	Superior model.EntityIdentifier `json:"superior"`
+	Healthy  bool                   `json:"healthy"`
+	Problems []string               `json:"problems,omitempty"`
+}
+
+// SelfCheck
+//
+//	Retrieves the subordinate statement each configured authority hint issues about the server, checking that it has
+//	not expired, that it registers the key the server signs its Entity Configuration with and that the server's
+//	metadata satisfies the superior's metadata policy. Problems with individual superiors are reported within the
+//	returned report - an error is only returned if the server's own Entity Configuration cannot be produced
+func (s *Server) SelfCheck(ctx context.Context) (*SelfCheckReport, error) {
+	cfg := s.configuration()
+	if cfg.HttpClient == nil {
+		cfg.HttpClient = http.DefaultClient
+	}
+
+	token, err := s.EntityConfiguration(ctx)
+	if err != nil {
+		return nil, err
+	}
+	subject, err := entity_configuration.Validate(ctx, cfg.EntityIdentifier, *token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid entity configuration: %w", err)
+	}
+	signerJWK, err := signing.PublicJWK(ctx, cfg.SignerConfiguration)
+	if err != nil {
+		return nil, err
+	}
+	signerThumbprint, err := signing.Thumbprint(signerJWK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute signing key thumbprint: %w", err)
+	}
+
+	report := &SelfCheckReport{Healthy: true, Superiors: []SuperiorCheck{}}
+	for _, superior := range cfg.AuthorityHints {
+		check := SuperiorCheck{Superior: superior}
+		for _, problem := range checkSuperior(ctx, cfg, *subject, signerJWK["kid"].(string), signerThumbprint, superior) {
+			check.Problems = append(check.Problems, problem.Error())
+		}
+		check.Healthy = len(check.Problems) == 0
+		if !check.Healthy {
+			report.Healthy = false
+			cfg.LogError(ctx, "superior does not vouch for this entity", slog.String("superior", string(superior)), slog.Any("problems", check.Problems))
+		}
+		report.Superiors = append(report.Superiors, check)
+	}
+	return report, nil
+}
+
+func checkSuperior(ctx context.Context, cfg model.ServerConfiguration, subject model.EntityStatement, keyID, thumbprint string, superior model.EntityIdentifier) []error {
+	statement, err := retrieveSuperiorStatement(ctx, cfg, superior)
+	if err != nil {
+		return []error{err}
+	}
+
+	var problems []error
+	if exp := time.Unix(statement.Exp, 0); !time.Now().Before(exp) {
+		problems = append(problems, fmt.Errorf("subordinate statement expired at %s", exp.UTC().Format(time.RFC3339)))
+	}
+
+	registered := false
+	for _, key := range statement.JWKs.Keys {
+		if key["kid"] != keyID {
+			continue
+		}
+		registeredThumbprint, err := signing.Thumbprint(key)
+		if err != nil || registeredThumbprint != thumbprint {
+			problems = append(problems, fmt.Errorf("subordinate statement registers a different key under the signing key ID %q", keyID))
+		}
+		registered = true
+	}
+	if !registered {
+		problems = append(problems, fmt.Errorf("subordinate statement does not register the signing key %q", keyID))
+	}
+
+	if statement.MetadataPolicy != nil {
+		policy, err := model.ProcessAndExtractPolicy([]model.EntityStatement{subject, *statement})
+		if err != nil {
+			return append(problems, fmt.Errorf("invalid metadata policy: %w", err))
+		}
+		if _, err = model.ApplyPolicy(subject, *policy); err != nil {
+			problems = append(problems, fmt.Errorf("metadata does not satisfy the superior's metadata policy: %w", err))
+		}
+	}
+	return problems
+}
+
+// retrieveSuperiorStatement retrieves the subordinate statement the given superior issues about the server
+func retrieveSuperiorStatement(ctx context.Context, cfg model.ServerConfiguration, superior model.EntityIdentifier) (*model.EntityStatement, error) {
+	_, superiorConfiguration, err := entity_configuration.Retrieve(ctx, cfg.Configuration, superior)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the superior's entity configuration: %w", err)
+	}
+	_, statement, err := subordinate_statement.Retrieve(ctx, cfg.Configuration, *superiorConfiguration, cfg.EntityIdentifier)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the superior's subordinate statement: %w", err)
+	}
+	return statement, nil
+}
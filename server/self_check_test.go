@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+func TestServer_SelfCheck(t *testing.T) {
+	newKey := func(t *testing.T, keyID string) (josemodel.Jwks, model.SignerConfiguration) {
+		signer, err := jws.GetSigner(josemodel.ES256, nil)
+		if err != nil {
+			t.Fatalf("expected no error creating signer, got %q", err.Error())
+		}
+		publicJWK, err := jwk.PublicJwk(signer.Public())
+		if err != nil {
+			t.Fatalf("expected no error creating JWK, got %q", err.Error())
+		}
+		(*publicJWK)["kid"] = keyID
+		return josemodel.Jwks{Keys: []map[string]any{*publicJWK}}, model.SignerConfiguration{Signer: signer, KeyID: keyID, Algorithm: "ES256"}
+	}
+	oneOf, err := model.NewOneOf([]any{"web"})
+	if err != nil {
+		t.Fatalf("expected no error creating policy operator, got %q", err.Error())
+	}
+
+	tests := map[string]struct {
+		authorityHints   []string
+		subordinate      func(leafJWKs josemodel.Jwks) *model.SubordinateConfiguration // subordinate is the trust anchor's configuration for the leaf, nil when unregistered
+		expectedHealthy  bool
+		expectedProblems map[string]string // expectedProblems maps superior paths to the expected problem, empty when healthy
+	}{
+		"registered with the signing key": {
+			authorityHints: []string{"/ta"},
+			subordinate: func(leafJWKs josemodel.Jwks) *model.SubordinateConfiguration {
+				return &model.SubordinateConfiguration{JWKs: leafJWKs}
+			},
+			expectedHealthy:  true,
+			expectedProblems: map[string]string{"/ta": ""},
+		},
+		"signing key not registered": {
+			authorityHints: []string{"/ta"},
+			subordinate: func(josemodel.Jwks) *model.SubordinateConfiguration {
+				otherJWKs, _ := newKey(t, "old-leaf-key")
+				return &model.SubordinateConfiguration{JWKs: otherJWKs}
+			},
+			expectedProblems: map[string]string{"/ta": `subordinate statement does not register the signing key "leaf-key"`},
+		},
+		"different key registered under the signing key ID": {
+			authorityHints: []string{"/ta"},
+			subordinate: func(josemodel.Jwks) *model.SubordinateConfiguration {
+				otherJWKs, _ := newKey(t, "leaf-key")
+				return &model.SubordinateConfiguration{JWKs: otherJWKs}
+			},
+			expectedProblems: map[string]string{"/ta": `subordinate statement registers a different key under the signing key ID "leaf-key"`},
+		},
+		"metadata rejected by policy": {
+			authorityHints: []string{"/ta"},
+			subordinate: func(leafJWKs josemodel.Jwks) *model.SubordinateConfiguration {
+				return &model.SubordinateConfiguration{
+					JWKs: leafJWKs,
+					Policies: model.MetadataPolicy{OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+						"application_type": {Metadata: []model.MetadataPolicyOperator{oneOf}},
+					}},
+				}
+			},
+			expectedProblems: map[string]string{"/ta": "metadata does not satisfy the superior's metadata policy: metadata parameter value native is not one of the allowed values"},
+		},
+		"not registered": {
+			authorityHints:   []string{"/ta"},
+			expectedProblems: map[string]string{"/ta": "unable to retrieve the superior's subordinate statement: non-200 response"},
+		},
+		"unreachable superior": {
+			authorityHints: []string{"/ta", "/missing"},
+			subordinate: func(leafJWKs josemodel.Jwks) *model.SubordinateConfiguration {
+				return &model.SubordinateConfiguration{JWKs: leafJWKs}
+			},
+			expectedProblems: map[string]string{
+				"/ta":      "",
+				"/missing": "unable to retrieve the superior's entity configuration: non-200 response",
+			},
+		},
+		"no authority hints": {
+			expectedHealthy:  true,
+			expectedProblems: map[string]string{},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			s := httptest.NewTLSServer(mux)
+			defer s.Close()
+
+			leafJWKs, leafSigner := newKey(t, "leaf-key")
+			_, taSigner := newKey(t, "ta-key")
+			intermediateConfiguration := &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour}
+			if tt.subordinate != nil {
+				_ = intermediateConfiguration.AddSubordinate(model.EntityIdentifier(s.URL+"/leaf"), tt.subordinate(leafJWKs))
+			}
+			var authorityHints []model.EntityIdentifier
+			for _, hint := range tt.authorityHints {
+				authorityHints = append(authorityHints, model.EntityIdentifier(s.URL+hint))
+			}
+
+			leaf := NewServer(model.ServerConfiguration{
+				Configuration:               model.Configuration{HttpClient: s.Client()},
+				EntityIdentifier:            model.EntityIdentifier(s.URL + "/leaf"),
+				SignerConfiguration:         leafSigner,
+				EntityConfigurationLifetime: time.Hour,
+				AuthorityHints:              authorityHints,
+				EntityConfiguration: model.EntityStatement{Metadata: &model.Metadata{OpenIDRelyingPartyMetadata: &model.OpenIDRelyingPartyMetadata{
+					"redirect_uris":             []any{"https://rp.example.com/callback"},
+					"client_registration_types": []any{"automatic"},
+					"application_type":          "native",
+				}}},
+			})
+			ta := NewServer(model.ServerConfiguration{
+				EntityIdentifier:            model.EntityIdentifier(s.URL + "/ta"),
+				SignerConfiguration:         taSigner,
+				EntityConfigurationLifetime: time.Hour,
+				IntermediateConfiguration:   intermediateConfiguration,
+			})
+			for prefix, entity := range map[string]*Server{"/leaf": leaf, "/ta": ta} {
+				entityMux := http.NewServeMux()
+				entity.Configure(entityMux)
+				mux.Handle(prefix+"/", http.StripPrefix(prefix, entityMux))
+			}
+
+			report, err := leaf.SelfCheck(context.Background())
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if report.Healthy != tt.expectedHealthy {
+				t.Errorf("expected healthy to be %t, got %t", tt.expectedHealthy, report.Healthy)
+			}
+			if len(report.Superiors) != len(tt.expectedProblems) {
+				t.Fatalf("expected %d superiors to be checked, got %d", len(tt.expectedProblems), len(report.Superiors))
+			}
+			for _, check := range report.Superiors {
+				expectedProblem, ok := tt.expectedProblems[strings.TrimPrefix(string(check.Superior), s.URL)]
+				if !ok {
+					t.Errorf("unexpected superior %s checked", check.Superior)
+					continue
+				}
+				if expectedProblem == "" {
+					if !check.Healthy || len(check.Problems) != 0 {
+						t.Errorf("expected %s to vouch for the leaf, got %v", check.Superior, check.Problems)
+					}
+				} else if check.Healthy || len(check.Problems) != 1 || !strings.Contains(check.Problems[0], expectedProblem) {
+					t.Errorf("expected %s to report %q, got %v", check.Superior, expectedProblem, check.Problems)
+				}
+			}
+		})
+	}
+}
@@ -2,21 +2,51 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"sync"
+	"time"
 
+	"github.com/MichaelFraser99/go-openid-federation/internal/signing"
 	"github.com/MichaelFraser99/go-openid-federation/model"
 )
 
 type Server struct {
-	cfg model.ServerConfiguration
+	cfg                       model.ServerConfiguration
+	cfgMu                     sync.RWMutex
+	entityConfigurationCache  entityConfigurationCache
+	subordinateStatementCache subordinateStatementCache
+	unsubscribe               func()
+	removeValidator           func()
 }
 
+// NewServer creates a server for the given configuration. When the configuration holds an IntermediateConfiguration,
+// the server listens for subordinate changes on it and installs its policy validator on it until Close is called
 func NewServer(configuration model.ServerConfiguration) *Server {
 	configuration.EntityConfiguration.JWKs.Opts.EnforceUniqueKIDs = true
-	return &Server{cfg: configuration}
+	s := &Server{cfg: configuration, unsubscribe: func() {}, removeValidator: func() {}}
+	if configuration.IntermediateConfiguration != nil {
+		s.unsubscribe = configuration.IntermediateConfiguration.OnSubordinateChange(func(identifier *model.EntityIdentifier) {
+			s.InvalidateSubordinateStatement(identifier)
+			s.InvalidateEntityConfiguration() // subordinate signer overrides are published in the entity configuration jwks
+		})
+		s.removeValidator = configuration.IntermediateConfiguration.SetSubordinateValidator(s.validateSubordinate)
+	}
+	return s
+}
+
+// Close
+//
+//	Stops the server listening for changes to its IntermediateConfiguration and removes its policy validator,
+//	allowing the server and its caches to be released while the configuration remains in use elsewhere. Safe to call
+//	more than once
+func (s *Server) Close() {
+	s.unsubscribe()
+	s.removeValidator()
 }
 
 // SetEntityIdentifier
@@ -25,7 +55,10 @@ func NewServer(configuration model.ServerConfiguration) *Server {
 //	is not known to the user beforehand, such as during test server scenarios. Should not be called during
 //	production operations
 func (s *Server) SetEntityIdentifier(identifier model.EntityIdentifier) {
+	s.cfgMu.Lock()
 	s.cfg.EntityIdentifier = identifier
+	s.cfgMu.Unlock()
+	s.InvalidateEntityConfiguration()
 }
 
 // SetHttpClient
@@ -33,6 +66,8 @@ func (s *Server) SetEntityIdentifier(identifier model.EntityIdentifier) {
 //	Overrides the server's configured HTTP Client. Intended for test server scenarios.
 //	Should not be called during	production operations
 func (s *Server) SetHttpClient(client *http.Client) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
 	s.cfg.HttpClient = client
 }
 
@@ -40,30 +75,44 @@ func (s *Server) SetHttpClient(client *http.Client) {
 //
 //	Adds an authority hint to a running server
 func (s *Server) AddAuthorityHint(entityIdentifier model.EntityIdentifier) {
-	s.cfg.AuthorityHints = append(s.cfg.AuthorityHints, entityIdentifier)
+	s.cfgMu.Lock()
+	s.cfg.AuthorityHints = append(slices.Clone(s.cfg.AuthorityHints), entityIdentifier)
+	s.cfgMu.Unlock()
+	s.InvalidateEntityConfiguration()
+}
+
+// configuration returns a snapshot of the server configuration that is safe to use alongside concurrent updates
+func (s *Server) configuration() model.ServerConfiguration {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.cfg
 }
 
 func (s *Server) Configure(h *http.ServeMux) {
+	s.cfgMu.Lock()
 	if s.cfg.HttpClient == nil {
 		s.cfg.HttpClient = http.DefaultClient
 	}
-	h.HandleFunc("GET /.well-known/openid-federation", func(w http.ResponseWriter, r *http.Request) { s.HandleWellKnown(w, r)() })
-	if s.cfg.IntermediateConfiguration != nil {
-		h.HandleFunc("GET /list", func(w http.ResponseWriter, r *http.Request) { s.List(w, r)() })
-		h.HandleFunc("GET /fetch", func(w http.ResponseWriter, r *http.Request) { s.Fetch(w, r)() })
-		h.HandleFunc("GET /resolve", func(w http.ResponseWriter, r *http.Request) { s.Resolve(w, r)() })
-
-		if s.cfg.Extensions.ExtendedListing.Enabled {
-			h.HandleFunc("GET /extended-list", func(w http.ResponseWriter, r *http.Request) { s.ExtendedList(w, r)() })
+	s.cfgMu.Unlock()
+	cfg := s.configuration()
+
+	h.HandleFunc("GET /.well-known/openid-federation", s.handle(s.HandleWellKnown))
+	if cfg.IntermediateConfiguration != nil {
+		h.HandleFunc("GET /list", s.handle(s.List))
+		h.HandleFunc("GET /fetch", s.handle(s.Fetch))
+		h.HandleFunc("GET /resolve", s.handle(s.Resolve))
+
+		if cfg.Extensions.ExtendedListing.Enabled {
+			h.HandleFunc("GET /extended-list", s.handle(s.ExtendedList))
 		}
-		if s.cfg.Extensions.SubordinateStatus.Enabled {
-			h.HandleFunc("GET /subordinate-status", func(w http.ResponseWriter, r *http.Request) { s.SubordinateStatus(w, r)() })
+		if cfg.Extensions.SubordinateStatus.Enabled {
+			h.HandleFunc("GET /subordinate-status", s.handle(s.SubordinateStatus))
 		}
 	}
-	if s.cfg.TrustMarkRetriever != nil {
-		h.HandleFunc("GET /trust-mark-status", func(w http.ResponseWriter, r *http.Request) { s.TrustMarkStatus(w, r)() })
-		h.HandleFunc("GET /trust-mark-list", func(w http.ResponseWriter, r *http.Request) { s.TrustMarkList(w, r)() })
-		h.HandleFunc("GET /trust-mark", func(w http.ResponseWriter, r *http.Request) { s.TrustMark(w, r)() })
+	if cfg.TrustMarkRetriever != nil {
+		h.HandleFunc("GET /trust-mark-status", s.handle(s.TrustMarkStatus))
+		h.HandleFunc("GET /trust-mark-list", s.handle(s.TrustMarkList))
+		h.HandleFunc("GET /trust-mark", s.handle(s.TrustMark))
 	}
 }
 
@@ -71,31 +120,62 @@ func (s *Server) Configure(h *http.ServeMux) {
 
 func (s *Server) loadSubordinate(ctx context.Context, identifier model.EntityIdentifier) func() (*model.SubordinateConfiguration, *model.SignerConfiguration, error) {
 	return func() (*model.SubordinateConfiguration, *model.SignerConfiguration, error) {
-		subordinate, err := s.cfg.GetSubordinate(ctx, identifier)
+		cfg := s.configuration()
+		subordinate, err := cfg.GetSubordinate(ctx, identifier)
 		if err != nil {
-			s.cfg.LogInfo(ctx, "error retrieving subordinate cfg", slog.String("error", err.Error()))
-			return nil, &s.cfg.SignerConfiguration, nil
+			cfg.LogInfo(ctx, "error retrieving subordinate cfg", slog.String("error", err.Error()))
+			return nil, &cfg.SignerConfiguration, nil
 		}
 		if subordinate.SignerConfiguration != nil {
 			return subordinate, subordinate.SignerConfiguration, nil
 		} else {
-			return subordinate, &s.cfg.SignerConfiguration, nil
+			return subordinate, &cfg.SignerConfiguration, nil
 		}
 	}
 }
 
-type ResponseFunc func()
+// ResponseFunc writes a handler's response. An error is only returned when the response could not be completed after
+// its status was sent, in which case the partially written response must be treated as failed
+type ResponseFunc func() error
+
+// handle adapts a handler to net/http. A response which fails part way through is aborted by panicking with
+// http.ErrAbortHandler, so the connection is closed rather than a truncated response being served as complete
+func (s *Server) handle(handler func(w http.ResponseWriter, r *http.Request) ResponseFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := handler(w, r)(); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
 
 func (s *Server) respondWith(w http.ResponseWriter, status int, contentType string, data []byte) ResponseFunc {
-	return func() {
+	return func() error {
 		w.Header().Set("Content-Type", contentType)
 		w.WriteHeader(status)
 		_, _ = w.Write(data)
+		return nil
+	}
+}
+
+// withCacheControl sets a Cache-Control header permitting the response to be cached until the provided time
+func (s *Server) withCacheControl(w http.ResponseWriter, until time.Time, response ResponseFunc) ResponseFunc {
+	return func() error {
+		if maxAge := int(time.Until(until).Seconds()); maxAge > 0 {
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		return response()
 	}
 }
 
 func (s *Server) RespondWithError(ctx context.Context, w http.ResponseWriter, err error) ResponseFunc {
-	s.cfg.LogInfo(ctx, "handling error response", slog.String("error", err.Error()))
+	cfg := s.configuration()
+	var signerErr *signing.Error
+	if errors.As(err, &signerErr) {
+		cfg.LogError(ctx, "signer failure", slog.String("error", signerErr.Cause().Error()))
+	}
+	cfg.LogInfo(ctx, "handling error response", slog.String("error", err.Error()))
 	code, response := s.parseError(err)
 	return s.respondWith(w, code, "application/json", []byte(response))
 }
@@ -124,29 +204,38 @@ func (s *Server) RespondWithSubordinateStatementResponse(w http.ResponseWriter,
 	return s.respondWith(w, http.StatusOK, "application/entity-events-statement+jwt", data)
 }
 
+// errorResponse is the body of an error response, as defined by section 8.9 of the OpenID Federation specification
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
 func (s *Server) parseError(err error) (statusCode int, message string) {
+	var code string
 	switch {
 	case errors.Is(err, model.ErrInvalidRequest):
-		return http.StatusBadRequest, fmt.Sprintf(`{"error":"%s","error_description":"%s"}`, model.InvalidRequest, err.Error())
+		statusCode, code = http.StatusBadRequest, model.InvalidRequest
 	case errors.Is(err, model.ErrInvalidClient):
-		return http.StatusUnauthorized, fmt.Sprintf(`{"error":"%s","error_description":"%s"}`, model.InvalidClient, err.Error())
+		statusCode, code = http.StatusUnauthorized, model.InvalidClient
 	case errors.Is(err, model.ErrInvalidIssuer):
-		return http.StatusNotFound, fmt.Sprintf(`{"error":"%s","error_description":"%s"}`, model.InvalidIssuer, err.Error())
+		statusCode, code = http.StatusNotFound, model.InvalidIssuer
 	case errors.Is(err, model.ErrInvalidSubject):
-		return http.StatusNotFound, fmt.Sprintf(`{"error":"%s","error_description":"%s"}`, model.InvalidSubject, err.Error())
+		statusCode, code = http.StatusNotFound, model.InvalidSubject
 	case errors.Is(err, model.ErrInvalidTrustAnchor):
-		return http.StatusNotFound, fmt.Sprintf(`{"error":"%s","error_description":"%s"}`, model.InvalidTrustAnchor, err.Error())
+		statusCode, code = http.StatusNotFound, model.InvalidTrustAnchor
 	case errors.Is(err, model.ErrInvalidTrustChain):
-		return http.StatusBadRequest, fmt.Sprintf(`{"error":"%s","error_description":"%s"}`, model.InvalidTrustChain, err.Error())
+		statusCode, code = http.StatusBadRequest, model.InvalidTrustChain
 	case errors.Is(err, model.ErrInvalidMetadata):
-		return http.StatusBadRequest, fmt.Sprintf(`{"error":"%s","error_description":"%s"}`, model.InvalidMetadata, err.Error())
+		statusCode, code = http.StatusBadRequest, model.InvalidMetadata
 	case errors.Is(err, model.ErrNotFound):
-		return http.StatusNotFound, fmt.Sprintf(`{"error":"%s","error_description":"%s"}`, model.NotFound, err.Error())
+		statusCode, code = http.StatusNotFound, model.NotFound
 	case errors.Is(err, model.ErrTemporarilyUnavailable):
-		return http.StatusServiceUnavailable, fmt.Sprintf(`{"error":"%s","error_description":"%s"}`, model.TemporarilyUnavailable, err.Error())
+		statusCode, code = http.StatusServiceUnavailable, model.TemporarilyUnavailable
 	case errors.Is(err, model.ErrUnsupportedParameter):
-		return http.StatusBadRequest, fmt.Sprintf(`{"error":"%s","error_description":"%s"}`, model.UnsupportedParameter, err.Error())
+		statusCode, code = http.StatusBadRequest, model.UnsupportedParameter
 	default:
-		return http.StatusInternalServerError, fmt.Sprintf(`{"error":"%s","error_description":"%s"}`, model.ServerError, err.Error())
+		statusCode, code = http.StatusInternalServerError, model.ServerError
 	}
+	response, _ := json.Marshal(errorResponse{Error: code, ErrorDescription: err.Error()})
+	return statusCode, string(response)
 }
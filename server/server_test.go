@@ -16,6 +16,7 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 
 	"io"
+	"iter"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -24,14 +25,16 @@ import (
 	"reflect"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 var (
-	_ model.Retriever                  = TestRetriever{}
-	_ model.ExtendedListingRetriever   = TestExtendedRetriever{}
-	_ model.SubordinateStatusRetriever = TestSubordinateStatusRetriever{}
+	_ model.Retriever                         = TestRetriever{}
+	_ model.ExtendedListingRetriever          = TestExtendedRetriever{}
+	_ model.FilteringExtendedListingRetriever = TestFilteringExtendedRetriever{}
+	_ model.SubordinateStatusRetriever        = TestSubordinateStatusRetriever{}
 )
 
 type TestRetriever struct {
@@ -68,6 +71,20 @@ func (t TestRetriever) GetSubordinateSigners(ctx context.Context) ([]model.Signe
 type TestExtendedRetriever struct{}
 
 func (r TestExtendedRetriever) GetExtendedSubordinates(ctx context.Context, from *model.EntityIdentifier, size int, claims []string) (*model.ExtendedListingResponse, error) {
+	return r.listSubordinates(model.ExtendedListingRequest{From: from, Size: size, Claims: claims})
+}
+
+// TestFilteringExtendedRetriever extends TestExtendedRetriever with support for update ranges and audit timestamps
+type TestFilteringExtendedRetriever struct {
+	TestExtendedRetriever
+}
+
+func (r TestFilteringExtendedRetriever) GetFilteredExtendedSubordinates(ctx context.Context, request model.ExtendedListingRequest) (*model.ExtendedListingResponse, error) {
+	return r.listSubordinates(request)
+}
+
+func (r TestExtendedRetriever) listSubordinates(request model.ExtendedListingRequest) (*model.ExtendedListingResponse, error) {
+	from, size := request.From, request.Size
 	identifiers := []string{
 		"https://a-some-fourth-federation.com/some-path",
 		"https://b-some-other-federation.com/some-path",
@@ -86,9 +103,18 @@ func (r TestExtendedRetriever) GetExtendedSubordinates(ctx context.Context, from
 	response := model.ExtendedListingResponse{}
 
 	for _, identifier := range identifiers {
-		response.ImmediateSubordinateEntities = append(response.ImmediateSubordinateEntities, map[string]any{
+		updated := testExtendedRetrieverUpdated(identifier)
+		if !request.UpdatedWithin(updated) {
+			continue
+		}
+		entity := map[string]any{
 			"id": identifier,
-		})
+		}
+		if request.AuditTimestamps {
+			entity["registered"] = updated.Add(-time.Hour).Unix()
+			entity["updated"] = updated.Unix()
+		}
+		response.ImmediateSubordinateEntities = append(response.ImmediateSubordinateEntities, entity)
 	}
 
 	if len(response.ImmediateSubordinateEntities) > size {
@@ -100,6 +126,11 @@ func (r TestExtendedRetriever) GetExtendedSubordinates(ctx context.Context, from
 	return &response, nil
 }
 
+// testExtendedRetrieverUpdated returns a fixed update time for each test identifier, one day apart in listing order
+func testExtendedRetrieverUpdated(identifier string) time.Time {
+	return time.Unix(1700000000, 0).Add(time.Duration(identifier[8]-'a') * 24 * time.Hour)
+}
+
 type TestSubordinateStatusRetriever struct{}
 
 func (t TestSubordinateStatusRetriever) GetSubordinateStatus(ctx context.Context, sub model.EntityIdentifier) (*model.SubordinateStatusResponse, error) {
@@ -298,19 +329,139 @@ func TestServer_HandleWellKnown(t *testing.T) {
 	}
 }
 
+type TestUnavailableRemoteSigner struct {
+	model.RemoteSigner
+}
+
+func (t TestUnavailableRemoteSigner) Sign(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return nil, fmt.Errorf("signing service unavailable")
+}
+
+func TestServer_HandleWellKnown_SignerUnavailable(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+
+	server := NewServer(model.ServerConfiguration{
+		SignerConfiguration: model.SignerConfiguration{
+			RemoteSigner: TestUnavailableRemoteSigner{RemoteSigner: model.NewCryptoRemoteSigner(signer, "some-key-id", "ES256")},
+		},
+		EntityIdentifier: "https://some-federation.com/some-path",
+	})
+
+	m := http.NewServeMux()
+	server.Configure(m)
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+
+	resp, err := s.Client().Get(s.URL + "/.well-known/openid-federation")
+	validateErrorResponse(t, resp, err, http.StatusServiceUnavailable, "temporarily_unavailable", "unable to sign statement at this time")
+}
+
+func TestServer_parseError(t *testing.T) {
+	statusCode, message := NewServer(model.ServerConfiguration{}).parseError(model.NewInvalidRequestError(`unexpected "sub" \ parameter`))
+	if statusCode != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, statusCode)
+	}
+	var response map[string]string
+	if err := json.Unmarshal([]byte(message), &response); err != nil {
+		t.Fatalf("expected a valid JSON error response, got %q: %s", message, err.Error())
+	}
+	if diff := cmp.Diff(map[string]string{"error": "invalid_request", "error_description": `unexpected "sub" \ parameter`}, response); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+}
+
 func TestServer_List(t *testing.T) {
+	filterableConfiguration := func() *model.IntermediateConfiguration {
+		testConfiguration := &model.IntermediateConfiguration{}
+		_ = testConfiguration.AddSubordinate("https://some-provider.com/some-path", &model.SubordinateConfiguration{
+			Metadata: &model.Metadata{OpenIDConnectOpenIDProviderMetadata: &model.OpenIDConnectOpenIDProviderMetadata{}},
+			TrustMarks: []model.TrustMarkHolder{{
+				TrustMarkType: "https://some-trust-mark.com/certified",
+				TrustMark:     "eyJhbGciOiJFUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, `{"exp":%d}`, time.Now().Add(time.Hour).Unix())) + ".c2lnbmF0dXJl",
+			}},
+		})
+		_ = testConfiguration.AddSubordinate("https://some-relying-party.com/some-path", &model.SubordinateConfiguration{
+			Metadata: &model.Metadata{OpenIDRelyingPartyMetadata: &model.OpenIDRelyingPartyMetadata{}},
+		})
+		_ = testConfiguration.AddSubordinate("https://some-intermediate.com/some-path", &model.SubordinateConfiguration{
+			Metadata: &model.Metadata{FederationMetadata: &model.FederationMetadata{"federation_fetch_endpoint": "https://some-intermediate.com/some-path/fetch"}},
+		})
+		return testConfiguration
+	}
+	validateListing := func(expected ...string) func(t *testing.T, response *http.Response, err error) {
+		return func(t *testing.T, response *http.Response, err error) {
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("expected status code 200, got %d", response.StatusCode)
+			}
+			defer response.Body.Close() //nolint:errcheck
+			var responseList []string
+			if err := json.NewDecoder(response.Body).Decode(&responseList); err != nil {
+				t.Fatalf("failed to unmarshal response body: %v", err)
+			}
+			if diff := cmp.Diff(expected, responseList, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("mismatch (-expected +got):\n%s", diff)
+			}
+		}
+	}
+
 	tests := map[string]struct {
 		configuration func() *model.IntermediateConfiguration
+		query         string
 		validate      func(t *testing.T, response *http.Response, err error)
 	}{
+		"we can filter entities by entity type": {
+			configuration: filterableConfiguration,
+			query:         "?entity_type=openid_provider&entity_type=openid_relying_party",
+			validate:      validateListing("https://some-provider.com/some-path", "https://some-relying-party.com/some-path"),
+		},
+		"we can filter entities holding trust marks": {
+			configuration: filterableConfiguration,
+			query:         "?trust_marked=true",
+			validate:      validateListing("https://some-provider.com/some-path"),
+		},
+		"we can filter entities by trust mark type": {
+			configuration: filterableConfiguration,
+			query:         "?trust_mark_type=" + url.QueryEscape("https://some-trust-mark.com/other"),
+			validate:      validateListing(),
+		},
+		"we can filter intermediate entities": {
+			configuration: filterableConfiguration,
+			query:         "?intermediate=true",
+			validate:      validateListing("https://some-intermediate.com/some-path"),
+		},
+		"we can filter leaf entities": {
+			configuration: filterableConfiguration,
+			query:         "?intermediate=false&entity_type=openid_provider",
+			validate:      validateListing("https://some-provider.com/some-path"),
+		},
+		"a malformed intermediate parameter is rejected": {
+			configuration: filterableConfiguration,
+			query:         "?intermediate=maybe",
+			validate: func(t *testing.T, response *http.Response, err error) {
+				validateErrorResponse(t, response, err, http.StatusBadRequest, "invalid_request", "malformed 'intermediate' parameter")
+			},
+		},
+		"a malformed trust_marked parameter is rejected": {
+			configuration: filterableConfiguration,
+			query:         "?trust_marked=yes",
+			validate: func(t *testing.T, response *http.Response, err error) {
+				validateErrorResponse(t, response, err, http.StatusBadRequest, "invalid_request", "malformed 'trust_marked' parameter")
+			},
+		},
 		"we can list entities": {
 			configuration: func() *model.IntermediateConfiguration {
 				testConfiguration := &model.IntermediateConfiguration{}
-				testConfiguration.AddSubordinate("https://some-federation.com/some-path", &model.SubordinateConfiguration{})
-				testConfiguration.AddSubordinate("https://some-other-federation.com/some-path", &model.SubordinateConfiguration{})
-				testConfiguration.AddSubordinate("https://some-third-federation.com/some-path", &model.SubordinateConfiguration{})
-				testConfiguration.AddSubordinate("https://some-fourth-federation.com/some-path", &model.SubordinateConfiguration{})
-				testConfiguration.AddSubordinate("https://some-fifth-federation.com/some-path", &model.SubordinateConfiguration{})
+				_ = testConfiguration.AddSubordinate("https://some-federation.com/some-path", &model.SubordinateConfiguration{})
+				_ = testConfiguration.AddSubordinate("https://some-other-federation.com/some-path", &model.SubordinateConfiguration{})
+				_ = testConfiguration.AddSubordinate("https://some-third-federation.com/some-path", &model.SubordinateConfiguration{})
+				_ = testConfiguration.AddSubordinate("https://some-fourth-federation.com/some-path", &model.SubordinateConfiguration{})
+				_ = testConfiguration.AddSubordinate("https://some-fifth-federation.com/some-path", &model.SubordinateConfiguration{})
 
 				return testConfiguration
 			},
@@ -365,7 +516,7 @@ func TestServer_List(t *testing.T) {
 			s := httptest.NewServer(m)
 			testClient := s.Client()
 
-			req, err := http.NewRequest("GET", s.URL+"/list", nil)
+			req, err := http.NewRequest("GET", s.URL+"/list"+tt.query, nil)
 			if err != nil {
 				t.Fatalf("expected no error creating request, got %q", err.Error())
 			}
@@ -377,9 +528,220 @@ func TestServer_List(t *testing.T) {
 	}
 }
 
+type TestStreamingRetriever struct {
+	TestRetriever
+	count, failAt int
+	signer        crypto.Signer
+	streams       *atomic.Int64 // streams counts the streams started, when set
+}
+
+func (t TestStreamingRetriever) StreamSubordinates(ctx context.Context, cursor *model.EntityIdentifier) iter.Seq2[model.Subordinate, error] {
+	return func(yield func(model.Subordinate, error) bool) {
+		if t.streams != nil {
+			t.streams.Add(1)
+		}
+		for i := range t.count {
+			if t.failAt >= 0 && i == t.failAt {
+				yield(model.Subordinate{}, fmt.Errorf("retriever unavailable"))
+				return
+			}
+			identifier := model.EntityIdentifier(fmt.Sprintf("https://some-federation.com/%06d", i))
+			if cursor != nil && identifier < *cursor {
+				continue
+			}
+			subordinate := &model.SubordinateConfiguration{}
+			if i == 0 {
+				subordinate.SignerConfiguration = &model.SignerConfiguration{Signer: t.signer, KeyID: "some-streamed-key", Algorithm: "ES256"}
+			}
+			if !yield(model.Subordinate{Identifier: identifier, Configuration: subordinate}, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestServer_List_Streaming(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	signerPublicJWK, err := jwk.PublicJwk(signer.Public())
+	if err != nil {
+		t.Fatalf("expected no error creating public JWK, got %q", err.Error())
+	}
+
+	tests := map[string]struct {
+		retriever TestStreamingRetriever
+		path      string
+		validate  func(t *testing.T, response *http.Response, err error)
+	}{
+		"subordinates are streamed as a json array": {
+			retriever: TestStreamingRetriever{count: 5000, failAt: -1},
+			path:      "/list",
+			validate: func(t *testing.T, response *http.Response, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				defer response.Body.Close() //nolint:errcheck
+				if response.StatusCode != http.StatusOK {
+					t.Fatalf("expected status code 200, got %d", response.StatusCode)
+				}
+				var responseList []string
+				if err := json.NewDecoder(response.Body).Decode(&responseList); err != nil {
+					t.Fatalf("failed to unmarshal response body: %v", err)
+				}
+				if len(responseList) != 5000 {
+					t.Fatalf("expected 5000 entities in response, got %d", len(responseList))
+				}
+				if !slices.IsSorted(responseList) {
+					t.Error("expected entities to be listed in order")
+				}
+			},
+		},
+		"an empty listing is an empty json array": {
+			retriever: TestStreamingRetriever{failAt: -1},
+			path:      "/list",
+			validate: func(t *testing.T, response *http.Response, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				defer response.Body.Close() //nolint:errcheck
+				responseBytes, err := io.ReadAll(response.Body)
+				if err != nil {
+					t.Fatalf("failed to read response body: %v", err)
+				}
+				if string(responseBytes) != "[]" {
+					t.Errorf("expected an empty json array, got %q", responseBytes)
+				}
+			},
+		},
+		"a retriever failing before streaming starts results in an error response": {
+			retriever: TestStreamingRetriever{count: 10, failAt: 0},
+			path:      "/list",
+			validate: func(t *testing.T, response *http.Response, err error) {
+				validateErrorResponse(t, response, err, http.StatusServiceUnavailable, "temporarily_unavailable", listingUnavailableError)
+			},
+		},
+		"a retriever failing within the first page results in an error response": {
+			retriever: TestStreamingRetriever{count: 5000, failAt: listingPageSize - 1},
+			path:      "/list",
+			validate: func(t *testing.T, response *http.Response, err error) {
+				validateErrorResponse(t, response, err, http.StatusServiceUnavailable, "temporarily_unavailable", listingUnavailableError)
+			},
+		},
+		"a retriever failing mid-stream aborts the response": {
+			retriever: TestStreamingRetriever{count: 5000, failAt: 4000},
+			path:      "/list",
+			validate: func(t *testing.T, response *http.Response, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				defer response.Body.Close() //nolint:errcheck
+				var responseList []string
+				if err := json.NewDecoder(response.Body).Decode(&responseList); err == nil {
+					t.Fatal("expected the truncated listing not to be a valid json array")
+				}
+			},
+		},
+		"subordinate signer keys are streamed into the entity configuration": {
+			retriever: TestStreamingRetriever{count: 10, failAt: -1, signer: signer},
+			path:      "/.well-known/openid-federation",
+			validate: func(t *testing.T, response *http.Response, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				defer response.Body.Close() //nolint:errcheck
+				responseBytes, err := io.ReadAll(response.Body)
+				if err != nil {
+					t.Fatalf("failed to read response body: %v", err)
+				}
+				if response.StatusCode != http.StatusOK {
+					t.Fatalf("expected status code 200, got %d (response: %s)", response.StatusCode, responseBytes)
+				}
+				var keyIDs []any
+				for _, key := range decodeEntityConfigurationBody(t, string(responseBytes))["jwks"].(map[string]any)["keys"].([]any) {
+					keyIDs = append(keyIDs, key.(map[string]any)["kid"])
+				}
+				if !slices.Contains(keyIDs, any("some-streamed-key")) {
+					t.Errorf("expected the streamed subordinate signer key to be published, got %v", keyIDs)
+				}
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := NewServer(model.ServerConfiguration{
+				EntityIdentifier:          "https://some-trust-source.com",
+				IntermediateConfiguration: &model.IntermediateConfiguration{},
+				MetadataRetriever:         tt.retriever,
+				SignerConfiguration: model.SignerConfiguration{
+					Signer:    signer,
+					KeyID:     (*signerPublicJWK)["kid"].(string),
+					Algorithm: "ES256",
+				},
+			})
+			m := http.NewServeMux()
+			server.Configure(m)
+			s := httptest.NewServer(m)
+			t.Cleanup(s.Close)
+
+			resp, err := s.Client().Get(s.URL + tt.path)
+
+			tt.validate(t, resp, err)
+		})
+	}
+}
+
+func TestServer_List_Deferred(t *testing.T) {
+	var streams atomic.Int64
+	server := NewServer(model.ServerConfiguration{
+		EntityIdentifier:          "https://some-trust-source.com",
+		IntermediateConfiguration: &model.IntermediateConfiguration{},
+		MetadataRetriever:         TestStreamingRetriever{count: 10, failAt: -1, streams: &streams},
+	})
+
+	_ = server.List(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/list", nil))
+	if streams.Load() != 0 {
+		t.Fatal("expected subordinates not to be retrieved until the response is written")
+	}
+
+	recorder := httptest.NewRecorder()
+	server.List(recorder, httptest.NewRequest(http.MethodGet, "/list", nil))()
+	if streams.Load() != 1 {
+		t.Errorf("expected a single stream once the response was written, got %d", streams.Load())
+	}
+	var responseList []string
+	if err := json.Unmarshal(recorder.Body.Bytes(), &responseList); err != nil || len(responseList) != 10 {
+		t.Errorf("expected 10 listed entities, got %q", recorder.Body.String())
+	}
+}
+
+func TestServer_List_FailureAfterStreaming(t *testing.T) {
+	server := NewServer(model.ServerConfiguration{
+		EntityIdentifier:          "https://some-trust-source.com",
+		IntermediateConfiguration: &model.IntermediateConfiguration{},
+		MetadataRetriever:         TestStreamingRetriever{count: 5000, failAt: 4000},
+	})
+
+	recorder := httptest.NewRecorder()
+	if err := server.List(recorder, httptest.NewRequest(http.MethodGet, "/list", nil))(); err == nil {
+		t.Fatal("expected the failure to be returned once the response had begun")
+	}
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected the status to have been sent before the failure, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	if err := server.List(recorder, httptest.NewRequest(http.MethodGet, "/list?trust_marked=sometimes", nil))(); err != nil {
+		t.Errorf("expected error responses to complete, got %q", err.Error())
+	}
+}
+
 func TestServer_ExtendedList(t *testing.T) {
 	tests := map[string]struct {
 		extraQueryParameters map[string]string
+		retriever            model.ExtendedListingRetriever
 		validate             func(t *testing.T, response *http.Response, err error)
 	}{
 		"we can list entities": {
@@ -492,6 +854,76 @@ func TestServer_ExtendedList(t *testing.T) {
 				}
 			},
 		},
+		"we can list entities updated within a time range": {
+			extraQueryParameters: map[string]string{"updated_after": "1700086400", "updated_before": "1700345600"},
+			retriever:            TestFilteringExtendedRetriever{},
+			validate: func(t *testing.T, response *http.Response, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				defer response.Body.Close() //nolint:errcheck
+				responseBytes, err := io.ReadAll(response.Body)
+				if err != nil {
+					t.Fatalf("failed to read response body: %v", err)
+				}
+				if response.StatusCode != http.StatusOK {
+					t.Fatalf("expected status code 200, got %d (response: %s)", response.StatusCode, responseBytes)
+				}
+				if string(responseBytes) != `{"immediate_subordinate_entities":[{"id":"https://c-some-federation.com/some-path"},{"id":"https://d-some-third-federation.com/some-path"}]}` {
+					t.Errorf("unexpected response', got %q", responseBytes)
+				}
+			},
+		},
+		"we can list entities with audit timestamps": {
+			extraQueryParameters: map[string]string{"audit_timestamps": "true", "limit": "1"},
+			retriever:            TestFilteringExtendedRetriever{},
+			validate: func(t *testing.T, response *http.Response, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				defer response.Body.Close() //nolint:errcheck
+				responseBytes, err := io.ReadAll(response.Body)
+				if err != nil {
+					t.Fatalf("failed to read response body: %v", err)
+				}
+				if response.StatusCode != http.StatusOK {
+					t.Fatalf("expected status code 200, got %d (response: %s)", response.StatusCode, responseBytes)
+				}
+				if string(responseBytes) != `{"immediate_subordinate_entities":[{"id":"https://a-some-fourth-federation.com/some-path","registered":1699996400,"updated":1700000000}],"next_entity_id":"https://b-some-other-federation.com/some-path"}` {
+					t.Errorf("unexpected response', got %q", responseBytes)
+				}
+			},
+		},
+		"update ranges are rejected when the retriever does not support filtering": {
+			extraQueryParameters: map[string]string{"updated_after": "1700086400"},
+			validate: func(t *testing.T, response *http.Response, err error) {
+				validateErrorResponse(t, response, err, http.StatusBadRequest, "unsupported_parameter", "parameter 'updated_after' is not supported")
+			},
+		},
+		"audit timestamps are rejected when the retriever does not support filtering": {
+			extraQueryParameters: map[string]string{"audit_timestamps": "true"},
+			validate: func(t *testing.T, response *http.Response, err error) {
+				validateErrorResponse(t, response, err, http.StatusBadRequest, "unsupported_parameter", "parameter 'audit_timestamps' is not supported")
+			},
+		},
+		"a malformed updated_after parameter is rejected": {
+			extraQueryParameters: map[string]string{"updated_after": "yesterday"},
+			validate: func(t *testing.T, response *http.Response, err error) {
+				validateErrorResponse(t, response, err, http.StatusBadRequest, "invalid_request", "malformed 'updated_after' parameter")
+			},
+		},
+		"a malformed audit_timestamps parameter is rejected": {
+			extraQueryParameters: map[string]string{"audit_timestamps": "sometimes"},
+			validate: func(t *testing.T, response *http.Response, err error) {
+				validateErrorResponse(t, response, err, http.StatusBadRequest, "invalid_request", "malformed 'audit_timestamps' parameter")
+			},
+		},
+		"an empty update range is rejected": {
+			extraQueryParameters: map[string]string{"updated_after": "1700345600", "updated_before": "1700086400"},
+			validate: func(t *testing.T, response *http.Response, err error) {
+				validateErrorResponse(t, response, err, http.StatusBadRequest, "invalid_request", "parameter 'updated_after' must be before 'updated_before'")
+			},
+		},
 	}
 
 	for name, tt := range tests {
@@ -517,10 +949,14 @@ func TestServer_ExtendedList(t *testing.T) {
 		}
 		(*leafSignerPublicJWK)["alg"] = "ES256"
 		t.Run(name, func(t *testing.T) {
+			var retriever model.ExtendedListingRetriever = TestExtendedRetriever{}
+			if tt.retriever != nil {
+				retriever = tt.retriever
+			}
 			testIntermediateConfiguration := &model.IntermediateConfiguration{
 				SubordinateCacheTime: 5 * time.Minute,
 			}
-			testIntermediateConfiguration.AddSubordinate(*identifier, &model.SubordinateConfiguration{
+			_ = testIntermediateConfiguration.AddSubordinate(*identifier, &model.SubordinateConfiguration{
 				JWKs: josemodel.Jwks{Keys: []map[string]any{*leafSignerPublicJWK}},
 				Policies: model.MetadataPolicy{
 					OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
@@ -539,7 +975,7 @@ func TestServer_ExtendedList(t *testing.T) {
 					ExtendedListing: model.ExtendedListingConfiguration{
 						Enabled:           true,
 						SizeLimit:         50,
-						MetadataRetriever: TestExtendedRetriever{},
+						MetadataRetriever: retriever,
 					},
 				},
 				SignerConfiguration: model.SignerConfiguration{
@@ -570,6 +1006,95 @@ func TestServer_ExtendedList(t *testing.T) {
 	}
 }
 
+func TestServer_ExtendedList_DefaultRetriever(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	signerPublicJWK, err := jwk.PublicJwk(signer.Public())
+	if err != nil {
+		t.Fatalf("expected no error creating public JWK, got %q", err.Error())
+	}
+
+	configuration := &model.IntermediateConfiguration{SubordinateStatementLifetime: 1 * time.Hour}
+	for _, identifier := range []model.EntityIdentifier{"https://c-federation.com", "https://a-federation.com", "https://b-federation.com"} {
+		_ = configuration.AddSubordinate(identifier, &model.SubordinateConfiguration{
+			JWKs: josemodel.Jwks{Keys: []map[string]any{*signerPublicJWK}},
+		})
+	}
+	server := NewServer(model.ServerConfiguration{
+		EntityIdentifier:          "https://some-trust-source.com",
+		IntermediateConfiguration: configuration,
+		Extensions: model.Extensions{
+			ExtendedListing: model.ExtendedListingConfiguration{Enabled: true, SizeLimit: 2},
+		},
+		SignerConfiguration: model.SignerConfiguration{
+			Signer:    signer,
+			KeyID:     (*signerPublicJWK)["kid"].(string),
+			Algorithm: "ES256",
+		},
+	})
+	m := http.NewServeMux()
+	server.Configure(m)
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+
+	get := func(t *testing.T, query string) (*http.Response, model.ExtendedListingResponse) {
+		t.Helper()
+		response, err := s.Client().Get(s.URL + "/extended-list" + query)
+		if err != nil {
+			t.Fatalf("expected no error making request, got %q", err.Error())
+		}
+		var listing model.ExtendedListingResponse
+		if response.StatusCode == http.StatusOK {
+			defer response.Body.Close() //nolint:errcheck
+			if err = json.NewDecoder(response.Body).Decode(&listing); err != nil {
+				t.Fatalf("failed to unmarshal response body: %v", err)
+			}
+		}
+		return response, listing
+	}
+
+	_, firstPage := get(t, "?limit=10")
+	if len(firstPage.ImmediateSubordinateEntities) != 2 || firstPage.ImmediateSubordinateEntities[0]["id"] != "https://a-federation.com" {
+		t.Fatalf("expected the first 2 entities in order, got %v", firstPage.ImmediateSubordinateEntities)
+	}
+	if firstPage.NextEntityID == nil || *firstPage.NextEntityID != "https://c-federation.com" {
+		t.Fatalf("expected next entity 'https://c-federation.com', got %v", firstPage.NextEntityID)
+	}
+
+	_, secondPage := get(t, "?claims=subordinate_statement&from_entity_id="+url.QueryEscape(string(*firstPage.NextEntityID)))
+	if len(secondPage.ImmediateSubordinateEntities) != 1 || secondPage.NextEntityID != nil {
+		t.Fatalf("expected a final page holding 1 entity, got %v", secondPage)
+	}
+	if _, ok := secondPage.ImmediateSubordinateEntities[0]["subordinate_statement"].(string); !ok {
+		t.Errorf("expected a subordinate statement, got %v", secondPage.ImmediateSubordinateEntities[0])
+	}
+
+	response, _ := get(t, "?from_entity_id="+url.QueryEscape("https://d-federation.com"))
+	validateErrorResponse(t, response, nil, http.StatusNotFound, "not_found", "unknown entity identifier: https://d-federation.com")
+
+	retrieverServer := NewServer(model.ServerConfiguration{
+		EntityIdentifier:          "https://some-trust-source.com",
+		IntermediateConfiguration: configuration,
+		MetadataRetriever:         TestRetriever{},
+		Extensions: model.Extensions{
+			ExtendedListing: model.ExtendedListingConfiguration{Enabled: true, SizeLimit: 2},
+		},
+		SignerConfiguration: model.SignerConfiguration{
+			Signer:    signer,
+			KeyID:     (*signerPublicJWK)["kid"].(string),
+			Algorithm: "ES256",
+		},
+	})
+	retrieverMux := http.NewServeMux()
+	retrieverServer.Configure(retrieverMux)
+	rs := httptest.NewServer(retrieverMux)
+	t.Cleanup(rs.Close)
+	response, err = rs.Client().Get(rs.URL + "/extended-list?updated_after=1700086400")
+	validateErrorResponse(t, response, err, http.StatusBadRequest, "unsupported_parameter", "parameter 'updated_after' is not supported for the configured subordinates")
+}
+
 func TestServer_SubordinateStatus(t *testing.T) {
 	tests := map[string]struct {
 		sub      string
@@ -711,6 +1236,133 @@ func TestServer_SubordinateStatus(t *testing.T) {
 	}
 }
 
+func TestServer_SubordinateLifecycle(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	signerPublicJWK, err := jwk.PublicJwk(signer.Public())
+	if err != nil {
+		t.Fatalf("expected no error creating public JWK, got %q", err.Error())
+	}
+	overrideSigner, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating override signer, got %q", err.Error())
+	}
+	subordinateJWK, err := jwk.PublicJwk(overrideSigner.Public())
+	if err != nil {
+		t.Fatalf("expected no error creating subordinate JWK, got %q", err.Error())
+	}
+	subordinateIdentifier := model.EntityIdentifier("https://some-subordinate.com/some-path")
+
+	configuration := &model.IntermediateConfiguration{SubordinateStatementLifetime: 1 * time.Hour}
+	_ = configuration.AddSubordinate(subordinateIdentifier, &model.SubordinateConfiguration{
+		JWKs: josemodel.Jwks{Keys: []map[string]any{*subordinateJWK}},
+		SignerConfiguration: &model.SignerConfiguration{
+			Signer:    overrideSigner,
+			KeyID:     "some-override-key",
+			Algorithm: "ES256",
+		},
+	})
+
+	server := NewServer(model.ServerConfiguration{
+		EntityIdentifier:            "https://some-trust-source.com",
+		EntityConfigurationLifetime: 1 * time.Hour,
+		IntermediateConfiguration:   configuration,
+		Extensions: model.Extensions{
+			SubordinateStatus: model.SubordinateStatusConfiguration{Enabled: true},
+		},
+		SignerConfiguration: model.SignerConfiguration{
+			Signer:    signer,
+			KeyID:     (*signerPublicJWK)["kid"].(string),
+			Algorithm: "ES256",
+		},
+	})
+	m := http.NewServeMux()
+	server.Configure(m)
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+
+	get := func(t *testing.T, path string) (int, string) {
+		t.Helper()
+		response, err := s.Client().Get(s.URL + path)
+		if err != nil {
+			t.Fatalf("expected no error making request, got %q", err.Error())
+		}
+		defer response.Body.Close() //nolint:errcheck
+		responseBytes, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+		return response.StatusCode, string(responseBytes)
+	}
+	publishedKeyIDs := func(t *testing.T) []any {
+		t.Helper()
+		_, entityConfiguration := get(t, "/.well-known/openid-federation")
+		var keyIDs []any
+		for _, key := range decodeEntityConfigurationBody(t, entityConfiguration)["jwks"].(map[string]any)["keys"].([]any) {
+			keyIDs = append(keyIDs, key.(map[string]any)["kid"])
+		}
+		return keyIDs
+	}
+	fetchPath := "/fetch?sub=" + url.QueryEscape(string(subordinateIdentifier))
+
+	if !slices.Contains(publishedKeyIDs(t), any("some-override-key")) {
+		t.Fatal("expected the subordinate override key to be published in the entity configuration")
+	}
+	if status, _ := get(t, fetchPath); status != http.StatusOK {
+		t.Fatalf("expected status code 200 fetching the subordinate statement, got %d", status)
+	}
+
+	if err = configuration.UpdateSubordinate(subordinateIdentifier, &model.SubordinateConfiguration{
+		JWKs: josemodel.Jwks{Keys: []map[string]any{*subordinateJWK}},
+	}); err != nil {
+		t.Fatalf("expected no error updating subordinate, got %q", err.Error())
+	}
+	if slices.Contains(publishedKeyIDs(t), any("some-override-key")) {
+		t.Error("expected the subordinate override key to be withdrawn from the entity configuration after an update")
+	}
+	_, statement := get(t, fetchPath)
+	if header := decodeJWTHeader(t, statement); header["kid"] != (*signerPublicJWK)["kid"] {
+		t.Errorf("expected the updated subordinate statement to be signed with the server key, got kid %v", header["kid"])
+	}
+
+	if err = configuration.RemoveSubordinate(subordinateIdentifier); err != nil {
+		t.Fatalf("expected no error removing subordinate, got %q", err.Error())
+	}
+	if status, _ := get(t, fetchPath); status != http.StatusNotFound {
+		t.Errorf("expected status code 404 fetching a removed subordinate, got %d", status)
+	}
+	if _, list := get(t, "/list"); list != "null" && list != "[]" {
+		t.Errorf("expected no subordinates to be listed, got %s", list)
+	}
+
+	status, events := get(t, "/subordinate-status?sub="+url.QueryEscape(string(subordinateIdentifier)))
+	if status != http.StatusOK {
+		t.Fatalf("expected status code 200 retrieving subordinate status, got %d (response: %s)", status, events)
+	}
+	var recorded []any
+	for _, event := range decodeEntityConfigurationBody(t, events)["federation_registration_events"].([]any) {
+		recorded = append(recorded, event.(map[string]any)["event"])
+	}
+	if diff := cmp.Diff([]any{"registration", "metadata_update", "revocation"}, recorded); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+}
+
+func decodeJWTHeader(t *testing.T, token string) map[string]any {
+	t.Helper()
+	decodedHeader, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	if err != nil {
+		t.Fatalf("failed to decode JWT header: %v", err)
+	}
+	var header map[string]any
+	if err = json.Unmarshal(decodedHeader, &header); err != nil {
+		t.Fatalf("failed to unmarshal JWT header: %v", err)
+	}
+	return header
+}
+
 func validateSubordinateStatusJWT(t *testing.T, responseBytes []byte, expectedSub string, expectedEvents []map[string]any) {
 	parts := strings.Split(string(responseBytes), ".")
 	if len(parts) != 3 {
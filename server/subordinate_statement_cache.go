@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/internal/subordinate_statement"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+// subordinateStatementCache holds signed Subordinate Statements keyed by subject.
+// Each entry records a digest of the subordinate configuration it was signed from so that a statement is re-signed as
+// soon as the configuration served for the subject changes, even when the change did not pass through
+// IntermediateConfiguration. As the digest covers content rather than identity, retrievers returning a new
+// configuration on each call still benefit from the cache. A change to a subordinate's signer override is picked up
+// once the subordinate is invalidated or its statement is due to be refreshed
+type subordinateStatementCache struct {
+	mu         sync.Mutex
+	entries    map[model.EntityIdentifier]cachedSubordinateStatement
+	generation uint64
+}
+
+type cachedSubordinateStatement struct {
+	token     *string
+	digest    [sha256.Size]byte
+	refreshAt time.Time
+}
+
+// subordinateDigest summarises the parts of a subordinate configuration published in its Subordinate Statement
+func subordinateDigest(subordinate *model.SubordinateConfiguration) ([sha256.Size]byte, error) {
+	published, err := json.Marshal(struct {
+		JWKs        josemodel.Jwks       `json:"jwks"`
+		Policies    model.MetadataPolicy `json:"metadata_policy"`
+		Constraints map[string]any       `json:"constraints"`
+	}{subordinate.JWKs, subordinate.Policies, subordinate.Constraints})
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(published), nil
+}
+
+// InvalidateSubordinateStatement
+//
+//	Discards any cached Subordinate Statement for the given subject. A nil identifier discards all cached statements
+func (s *Server) InvalidateSubordinateStatement(identifier *model.EntityIdentifier) {
+	cache := &s.subordinateStatementCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if identifier == nil {
+		cache.entries = nil
+	} else {
+		delete(cache.entries, *identifier)
+	}
+	cache.generation++
+}
+
+// subordinateStatement returns a signed Subordinate Statement for the given subject, re-using a previously signed
+// statement until it enters the final tenth of SubordinateStatementLifetime
+func (s *Server) subordinateStatement(ctx context.Context, identifier model.EntityIdentifier) (*string, error) {
+	cfg := s.configuration()
+
+	subordinate, signerConfiguration, err := s.loadSubordinate(ctx, identifier)()
+	if err != nil {
+		return nil, err
+	}
+
+	var digest [sha256.Size]byte
+	cacheable := subordinate != nil
+	if cacheable {
+		digest, err = subordinateDigest(subordinate)
+		cacheable = err == nil
+	}
+
+	cache := &s.subordinateStatementCache
+	now := time.Now()
+
+	cache.mu.Lock()
+	if entry, ok := cache.entries[identifier]; ok && cacheable && entry.digest == digest && now.Before(entry.refreshAt) {
+		cache.mu.Unlock()
+		return entry.token, nil
+	}
+	generation := cache.generation
+	cache.mu.Unlock()
+
+	token, err := subordinate_statement.New(ctx, identifier, func() (*model.SubordinateConfiguration, *model.SignerConfiguration, error) {
+		return subordinate, signerConfiguration, nil
+	}, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	lifetime := cfg.IntermediateConfiguration.SubordinateStatementLifetime
+	expiresAt := time.Unix(now.Add(lifetime).Unix(), 0) // statement expiry is truncated to the second
+	refreshAt := expiresAt.Add(-lifetime / 10)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cacheable && cache.generation == generation && refreshAt.After(now) {
+		if cache.entries == nil {
+			cache.entries = map[model.EntityIdentifier]cachedSubordinateStatement{}
+		}
+		cache.entries[identifier] = cachedSubordinateStatement{
+			token:     token,
+			digest:    digest,
+			refreshAt: refreshAt,
+		}
+	}
+	return token, nil
+}
@@ -10,6 +10,7 @@ import (
 
 func (s *Server) TrustMark(w http.ResponseWriter, r *http.Request) ResponseFunc {
 	ctx := r.Context()
+	cfg := s.configuration()
 	sub := r.URL.Query().Get("sub")
 	trustMarkType := r.URL.Query().Get("trust_mark_type")
 
@@ -19,7 +20,7 @@ func (s *Server) TrustMark(w http.ResponseWriter, r *http.Request) ResponseFunc
 
 	parsedSub, err := model.ValidateEntityIdentifier(sub)
 	if err != nil {
-		s.cfg.LogInfo(ctx, "invalid 'sub' parameter", slog.String("error", err.Error()))
+		cfg.LogInfo(ctx, "invalid 'sub' parameter", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, model.NewInvalidRequestError("malformed 'sub' parameter"))
 	}
 
@@ -27,9 +28,9 @@ func (s *Server) TrustMark(w http.ResponseWriter, r *http.Request) ResponseFunc
 		return s.RespondWithError(ctx, w, model.NewInvalidRequestError("request missing required parameter 'trust_mark_type'"))
 	}
 
-	trustMark, err := trust_marks.Issue(ctx, s.cfg, trustMarkType, *parsedSub)
+	trustMark, err := trust_marks.Issue(ctx, cfg, trustMarkType, *parsedSub)
 	if err != nil {
-		s.cfg.LogInfo(ctx, "error listing trust marks", slog.String("error", err.Error()))
+		cfg.LogInfo(ctx, "error listing trust marks", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, err)
 	}
 
@@ -13,6 +13,7 @@ const trustMarkListingUnavailableError = "unable to list trust marked entities a
 
 func (s *Server) TrustMarkList(w http.ResponseWriter, r *http.Request) ResponseFunc {
 	ctx := r.Context()
+	cfg := s.configuration()
 	sub := r.URL.Query().Get("sub")
 	trustMarkType := r.URL.Query().Get("trust_mark_type")
 
@@ -22,7 +23,7 @@ func (s *Server) TrustMarkList(w http.ResponseWriter, r *http.Request) ResponseF
 	if sub != "" {
 		parsedSub, err = model.ValidateEntityIdentifier(sub)
 		if err != nil {
-			s.cfg.LogInfo(ctx, "invalid 'sub' parameter", slog.String("error", err.Error()))
+			cfg.LogInfo(ctx, "invalid 'sub' parameter", slog.String("error", err.Error()))
 			return s.RespondWithError(ctx, w, model.NewInvalidRequestError("malformed 'sub' parameter"))
 		}
 	}
@@ -31,15 +32,15 @@ func (s *Server) TrustMarkList(w http.ResponseWriter, r *http.Request) ResponseF
 		return s.RespondWithError(ctx, w, model.NewInvalidRequestError("request missing required parameter 'trust_mark_type'"))
 	}
 
-	status, err := trust_marks.List(ctx, s.cfg, trustMarkType, parsedSub)
+	status, err := trust_marks.List(ctx, cfg, trustMarkType, parsedSub)
 	if err != nil {
-		s.cfg.LogInfo(ctx, "error listing trust marks", slog.String("error", err.Error()))
+		cfg.LogInfo(ctx, "error listing trust marks", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, err)
 	}
 
 	statusBytes, err := json.Marshal(status)
 	if err != nil {
-		s.cfg.LogInfo(ctx, "error marshalling trust mark status", slog.String("error", err.Error()))
+		cfg.LogInfo(ctx, "error marshalling trust mark status", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, model.NewTemporarilyUnavailableError(trustMarkListingUnavailableError))
 	}
 
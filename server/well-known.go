@@ -1,19 +1,28 @@
 package server
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
-
-	"github.com/MichaelFraser99/go-openid-federation/internal/entity_configuration"
 )
 
 func (s *Server) HandleWellKnown(w http.ResponseWriter, r *http.Request) ResponseFunc {
 	ctx := r.Context()
+	cfg := s.configuration()
 
-	entityConfiguration, err := entity_configuration.New(ctx, s.cfg)
+	entityConfiguration, cacheableUntil, err := s.entityConfiguration(ctx)
 	if err != nil {
-		s.cfg.LogInfo(ctx, "error generating entity cfg", slog.String("error", err.Error()))
+		cfg.LogInfo(ctx, "error generating entity cfg", slog.String("error", err.Error()))
 		return s.RespondWithError(ctx, w, err)
 	}
-	return s.RespondWithEntityStatement(w, []byte(*entityConfiguration))
+	return s.withCacheControl(w, cacheableUntil, s.RespondWithEntityStatement(w, []byte(*entityConfiguration)))
+}
+
+// EntityConfiguration
+//
+//	Returns the server's signed Entity Configuration, as served from the well-known endpoint. Allows callers such as
+//	readiness checks to confirm that the entity's signer is available
+func (s *Server) EntityConfiguration(ctx context.Context) (*string, error) {
+	entityConfiguration, _, err := s.entityConfiguration(ctx)
+	return entityConfiguration, err
 }
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+func TestServer_HandleWellKnown_Caching(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	signerPublicJWK, err := jwk.PublicJwk(signer.Public())
+	if err != nil {
+		t.Fatalf("expected no error creating public JWK, got %q", err.Error())
+	}
+
+	tests := map[string]struct {
+		lifetime, refreshMargin time.Duration
+		validate                func(t *testing.T, server *Server, get func(t *testing.T) (string, *http.Response))
+	}{
+		"the signed entity configuration is served from cache": {
+			lifetime: 1 * time.Hour,
+			validate: func(t *testing.T, server *Server, get func(t *testing.T) (string, *http.Response)) {
+				first, firstResponse := get(t)
+				second, _ := get(t)
+				if first != second {
+					t.Error("expected the cached entity configuration to be served on the second request")
+				}
+				cacheControl := firstResponse.Header.Get("Cache-Control")
+				if !strings.HasPrefix(cacheControl, "public, max-age=") {
+					t.Fatalf("expected a public Cache-Control header with a max-age, got %q", cacheControl)
+				}
+				if cacheControl == "public, max-age=3600" {
+					t.Errorf("expected max-age to account for the refresh margin, got %q", cacheControl)
+				}
+			},
+		},
+		"adding an authority hint invalidates the cached entity configuration": {
+			lifetime: 1 * time.Hour,
+			validate: func(t *testing.T, server *Server, get func(t *testing.T) (string, *http.Response)) {
+				first, _ := get(t)
+				server.AddAuthorityHint("https://some-authority.com/some-path")
+				second, _ := get(t)
+				if first == second {
+					t.Fatal("expected a new entity configuration after adding an authority hint")
+				}
+				body := decodeEntityConfigurationBody(t, second)
+				hints, ok := body["authority_hints"].([]any)
+				if !ok || !slices.Contains(hints, any("https://some-authority.com/some-path")) {
+					t.Errorf("expected authority hints to contain the added authority, got %v", body["authority_hints"])
+				}
+			},
+		},
+		"configuration can be changed while requests are served": {
+			lifetime: 1 * time.Hour,
+			validate: func(t *testing.T, server *Server, get func(t *testing.T) (string, *http.Response)) {
+				var wg sync.WaitGroup
+				for i := range 10 {
+					wg.Add(2)
+					go func() {
+						defer wg.Done()
+						server.AddAuthorityHint(model.EntityIdentifier(fmt.Sprintf("https://some-authority.com/%d", i)))
+						server.SetHttpClient(http.DefaultClient)
+						server.SetEntityIdentifier("https://some-federation.com/some-path")
+					}()
+					go func() {
+						defer wg.Done()
+						server.HandleWellKnown(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/.well-known/openid-federation", nil))()
+						server.Fetch(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fetch", nil))()
+					}()
+				}
+				wg.Wait()
+
+				token, _ := get(t)
+				hints, _ := decodeEntityConfigurationBody(t, token)["authority_hints"].([]any)
+				if len(hints) != 10 {
+					t.Errorf("expected every added authority hint to be published, got %v", hints)
+				}
+			},
+		},
+		"an entity configuration within its refresh margin is replaced in the background": {
+			lifetime:      10 * time.Second,
+			refreshMargin: 9 * time.Second,
+			validate: func(t *testing.T, server *Server, get func(t *testing.T) (string, *http.Response)) {
+				first, _ := get(t)
+				time.Sleep(1500 * time.Millisecond)
+				stale, _ := get(t)
+				if stale != first {
+					t.Fatal("expected the still valid entity configuration to be served while it is refreshed")
+				}
+				deadline := time.Now().Add(5 * time.Second)
+				for time.Now().Before(deadline) {
+					if next, _ := get(t); next != first {
+						return
+					}
+					time.Sleep(50 * time.Millisecond)
+				}
+				t.Error("expected the entity configuration to be refreshed in the background")
+			},
+		},
+		"an entity configuration without a usable lifetime is not cached": {
+			validate: func(t *testing.T, server *Server, get func(t *testing.T) (string, *http.Response)) {
+				first, firstResponse := get(t)
+				second, _ := get(t)
+				if first == second {
+					t.Error("expected a newly signed entity configuration for each request")
+				}
+				if cacheControl := firstResponse.Header.Get("Cache-Control"); cacheControl != "no-cache" {
+					t.Errorf("expected Cache-Control 'no-cache', got %q", cacheControl)
+				}
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := NewServer(model.ServerConfiguration{
+				SignerConfiguration: model.SignerConfiguration{
+					Signer:    signer,
+					KeyID:     (*signerPublicJWK)["kid"].(string),
+					Algorithm: "ES256",
+				},
+				EntityIdentifier:                 "https://some-federation.com/some-path",
+				EntityConfigurationLifetime:      tt.lifetime,
+				EntityConfigurationRefreshMargin: tt.refreshMargin,
+			})
+			m := http.NewServeMux()
+			server.Configure(m)
+			s := httptest.NewServer(m)
+			t.Cleanup(s.Close)
+
+			tt.validate(t, server, func(t *testing.T) (string, *http.Response) {
+				t.Helper()
+				response, err := s.Client().Get(s.URL + "/.well-known/openid-federation")
+				if err != nil {
+					t.Fatalf("expected no error requesting entity configuration, got %q", err.Error())
+				}
+				defer response.Body.Close() //nolint:errcheck
+				responseBytes, err := io.ReadAll(response.Body)
+				if err != nil {
+					t.Fatalf("failed to read response body: %v", err)
+				}
+				if response.StatusCode != http.StatusOK {
+					t.Fatalf("expected status code 200, got %d (response: %s)", response.StatusCode, responseBytes)
+				}
+				return string(responseBytes), response
+			})
+		})
+	}
+}
+
+func decodeEntityConfigurationBody(t *testing.T, token string) map[string]any {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected JWT to have 3 parts, got %d parts", len(parts))
+	}
+	decodedBody, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("failed to decode JWT body: %v", err)
+	}
+	var body map[string]any
+	if err = json.Unmarshal(decodedBody, &body); err != nil {
+		t.Fatalf("failed to unmarshal JWT body: %v", err)
+	}
+	return body
+}
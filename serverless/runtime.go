@@ -0,0 +1,101 @@
+package serverless
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const runtimeAPIVersion = "2018-06-01"
+
+// Runtime serves invocations received from an AWS Lambda custom runtime API, as run for a 'bootstrap' executable.
+// It speaks the runtime API's HTTP protocol directly so no SDK is required
+type Runtime struct {
+	API        string       // API is the host and port of the runtime API, provided to the function as AWS_LAMBDA_RUNTIME_API
+	Adapter    *Adapter     // Adapter serves each invocation's proxy request event
+	HttpClient *http.Client // HttpClient is used to call the runtime API - defaults to a client without a timeout, as fetching the next invocation blocks until one arrives
+}
+
+// Run serves invocations until the context is cancelled or the runtime API cannot be reached
+func (r *Runtime) Run(ctx context.Context) error {
+	if r.API == "" {
+		return fmt.Errorf("runtime API address not configured")
+	}
+	if r.HttpClient == nil {
+		r.HttpClient = &http.Client{}
+	}
+	for {
+		if err := r.next(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (r *Runtime) next(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url("invocation/next"), nil)
+	if err != nil {
+		return err
+	}
+	response, err := r.HttpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to fetch next invocation: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch next invocation: unexpected status %d", response.StatusCode)
+	}
+	event, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read invocation event: %w", err)
+	}
+
+	requestID := response.Header.Get("Lambda-Runtime-Aws-Request-Id")
+	invocationCtx := ctx
+	if deadline, err := strconv.ParseInt(response.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+		var cancel context.CancelFunc
+		invocationCtx, cancel = context.WithDeadline(ctx, time.UnixMilli(deadline))
+		defer cancel()
+	}
+
+	result, err := r.Adapter.Handle(invocationCtx, event)
+	if err != nil {
+		errorType := "InvalidEvent"
+		if errors.Is(err, ErrResponseAborted) {
+			errorType = "AbortedResponse"
+		}
+		body, _ := json.Marshal(map[string]string{"errorMessage": err.Error(), "errorType": errorType})
+		return r.post(ctx, "invocation/"+requestID+"/error", body)
+	}
+	return r.post(ctx, "invocation/"+requestID+"/response", result)
+}
+
+func (r *Runtime) post(ctx context.Context, path string, body []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url(path), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := r.HttpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to report invocation result: %w", err)
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+	if response.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to report invocation result: unexpected status %d", response.StatusCode)
+	}
+	return nil
+}
+
+func (r *Runtime) url(path string) string {
+	return fmt.Sprintf("http://%s/%s/runtime/%s", r.API, runtimeAPIVersion, path)
+}
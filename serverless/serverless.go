@@ -0,0 +1,260 @@
+// Package serverless adapts an http.Handler, such as the mux configured by server.Server.Configure, to API gateway
+// proxy integrations. Both the REST API (payload format 1.0) and HTTP API (payload format 2.0) events are supported.
+// The package performs a pure translation between events and HTTP requests and responses, and depends on no cloud SDK
+package serverless
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Request is an API gateway proxy request event. Fields used only by one payload format are noted as such
+type Request struct {
+	Version                         string              `json:"version"`                         // Version is '2.0' for HTTP API events and '1.0' or absent for REST API events
+	HTTPMethod                      string              `json:"httpMethod"`                      // HTTPMethod is only set on REST API events
+	Path                            string              `json:"path"`                            // Path is only set on REST API events
+	RawPath                         string              `json:"rawPath"`                         // RawPath is only set on HTTP API events
+	RawQueryString                  string              `json:"rawQueryString"`                  // RawQueryString is only set on HTTP API events
+	QueryStringParameters           map[string]string   `json:"queryStringParameters"`           // QueryStringParameters holds the last value of each parameter on REST API events
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"` // MultiValueQueryStringParameters is only set on REST API events
+	Headers                         map[string]string   `json:"headers"`
+	MultiValueHeaders               map[string][]string `json:"multiValueHeaders"` // MultiValueHeaders is only set on REST API events
+	Cookies                         []string            `json:"cookies"`           // Cookies is only set on HTTP API events
+	Body                            string              `json:"body"`
+	IsBase64Encoded                 bool                `json:"isBase64Encoded"`
+	RequestContext                  RequestContext      `json:"requestContext"`
+}
+
+type RequestContext struct {
+	RequestID string              `json:"requestId"`
+	Identity  *RequestIdentity    `json:"identity"` // Identity is only set on REST API events
+	HTTP      *RequestContextHTTP `json:"http"`     // HTTP is only set on HTTP API events
+}
+
+type RequestIdentity struct {
+	SourceIP string `json:"sourceIp"`
+}
+
+type RequestContextHTTP struct {
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	SourceIP string `json:"sourceIp"`
+}
+
+// Response is an API gateway proxy response event
+type Response struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"` // MultiValueHeaders is only set in response to REST API events
+	Cookies           []string            `json:"cookies,omitempty"`           // Cookies is only set in response to HTTP API events
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// ErrResponseAborted is returned when the handler aborts its response by panicking with http.ErrAbortHandler
+var ErrResponseAborted = errors.New("handler aborted the response")
+
+type Adapter struct {
+	handler http.Handler
+}
+
+func New(handler http.Handler) *Adapter {
+	return &Adapter{handler: handler}
+}
+
+// Handle decodes a proxy request event, serves it with the adapter's handler and encodes the proxy response event.
+// An error is only returned when the event cannot be decoded or the handler aborts its response; errors reported by the
+// handler are returned as responses
+func (a *Adapter) Handle(ctx context.Context, event []byte) ([]byte, error) {
+	var request Request
+	if err := json.Unmarshal(event, &request); err != nil {
+		return nil, fmt.Errorf("failed to decode proxy request event: %w", err)
+	}
+	response, err := a.Serve(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(response)
+}
+
+// Serve serves a decoded proxy request event. A handler aborting its response by panicking with http.ErrAbortHandler,
+// as the handlers installed by the federation server do when a listing fails part way through, is returned as an error so that the gateway
+// reports a server error rather than the invocation crashing or a truncated response being returned
+func (a *Adapter) Serve(ctx context.Context, event Request) (*Response, error) {
+	r, err := NewHTTPRequest(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	w := &responseWriter{header: http.Header{}}
+	if err = serve(a.handler, w, r); err != nil {
+		return nil, err
+	}
+	return w.response(event.Version == "2.0"), nil
+}
+
+// serve calls the handler, recovering a panic with http.ErrAbortHandler as net/http does. Any other panic is re-raised
+func serve(handler http.Handler, w http.ResponseWriter, r *http.Request) (err error) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		if abort, ok := recovered.(error); !ok || !errors.Is(abort, http.ErrAbortHandler) {
+			panic(recovered)
+		}
+		err = fmt.Errorf("%w to %s %s", ErrResponseAborted, r.Method, r.URL.Path)
+	}()
+	handler.ServeHTTP(w, r)
+	return nil
+}
+
+// NewHTTPRequest translates a proxy request event into an HTTP request
+func NewHTTPRequest(ctx context.Context, event Request) (*http.Request, error) {
+	var (
+		method, rawQuery, remoteAddr string
+		requestURL                   = &url.URL{}
+		header                       = http.Header{}
+	)
+	if event.Version == "2.0" {
+		if event.RequestContext.HTTP == nil {
+			return nil, fmt.Errorf("invalid proxy request event: missing 'requestContext.http'")
+		}
+		method = event.RequestContext.HTTP.Method
+		if event.RawPath != "" {
+			parsed, err := url.ParseRequestURI(event.RawPath)
+			if err != nil || parsed.RawQuery != "" {
+				return nil, fmt.Errorf("invalid proxy request event: malformed 'rawPath'")
+			}
+			requestURL = parsed
+		}
+		rawQuery = event.RawQueryString
+		remoteAddr = event.RequestContext.HTTP.SourceIP
+		for name, value := range event.Headers {
+			header.Set(name, value) // repeated headers are comma separated by the gateway
+		}
+		if len(event.Cookies) > 0 {
+			header.Set("Cookie", strings.Join(event.Cookies, "; "))
+		}
+	} else {
+		method = event.HTTPMethod
+		requestURL.Path = event.Path // REST API events carry the decoded path, so it is not parsed as a request target
+		query := url.Values{}
+		if event.MultiValueQueryStringParameters != nil {
+			for name, values := range event.MultiValueQueryStringParameters {
+				query[name] = slices.Clone(values)
+			}
+		} else {
+			for name, value := range event.QueryStringParameters {
+				query.Set(name, value)
+			}
+		}
+		rawQuery = query.Encode()
+		if event.RequestContext.Identity != nil {
+			remoteAddr = event.RequestContext.Identity.SourceIP
+		}
+		if event.MultiValueHeaders != nil {
+			for name, values := range event.MultiValueHeaders {
+				for _, value := range values {
+					header.Add(name, value)
+				}
+			}
+		} else {
+			for name, value := range event.Headers {
+				header.Set(name, value)
+			}
+		}
+	}
+	if method == "" {
+		return nil, fmt.Errorf("invalid proxy request event: missing HTTP method")
+	}
+	if requestURL.Path == "" {
+		requestURL.Path = "/"
+	}
+	requestURL.RawQuery = rawQuery
+
+	body := []byte(event.Body)
+	if event.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(event.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy request event: failed to decode body: %w", err)
+		}
+		body = decoded
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, "/", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy request event: %w", err)
+	}
+	r.URL = requestURL
+	r.Header = header
+	r.Host = header.Get("Host")
+	r.RequestURI = requestURL.RequestURI()
+	r.RemoteAddr = remoteAddr
+	return r, nil
+}
+
+// responseWriter buffers a handler's response so it can be returned as a single event
+type responseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.body.Len() == 0 && len(b) > 0 && w.header.Get("Content-Type") == "" {
+		w.header.Set("Content-Type", http.DetectContentType(b)) // as net/http does for responses without a content type
+	}
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+func (w *responseWriter) response(v2 bool) *Response {
+	response := &Response{StatusCode: w.status, Headers: map[string]string{}}
+	if response.StatusCode == 0 {
+		response.StatusCode = http.StatusOK
+	}
+
+	if utf8.Valid(w.body.Bytes()) {
+		response.Body = w.body.String()
+	} else {
+		response.Body = base64.StdEncoding.EncodeToString(w.body.Bytes())
+		response.IsBase64Encoded = true
+	}
+
+	if v2 {
+		for name, values := range w.header {
+			if name == "Set-Cookie" {
+				response.Cookies = slices.Clone(values)
+				continue
+			}
+			response.Headers[name] = strings.Join(values, ",")
+		}
+		return response
+	}
+
+	response.MultiValueHeaders = map[string][]string{}
+	for name, values := range w.header {
+		response.Headers[name] = values[len(values)-1]
+		response.MultiValueHeaders[name] = slices.Clone(values)
+	}
+	return response
+}
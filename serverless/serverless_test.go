@@ -0,0 +1,403 @@
+package serverless
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/MichaelFraser99/go-openid-federation/server"
+	"github.com/google/go-cmp/cmp"
+)
+
+func readEvent(t *testing.T, name string) []byte {
+	t.Helper()
+	event, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("expected no error reading event, got %q", err.Error())
+	}
+	return event
+}
+
+type capturedRequest struct {
+	Method     string
+	Path       string
+	RequestURI string
+	Query      map[string][]string
+	Host       string
+	RemoteAddr string
+	Cookie     string
+	Accept     string
+	Body       string
+}
+
+func TestNewHTTPRequest(t *testing.T) {
+	tests := map[string]struct {
+		event    string
+		expected capturedRequest
+	}{
+		"REST API event with multi-value query parameters": {
+			event: "rest_api_list.json",
+			expected: capturedRequest{
+				Method:     http.MethodGet,
+				Path:       "/list",
+				RequestURI: "/list?entity_type=openid_relying_party&entity_type=openid_provider",
+				Query:      map[string][]string{"entity_type": {"openid_relying_party", "openid_provider"}},
+				Host:       "federation.example.com",
+				RemoteAddr: "203.0.113.24",
+				Accept:     "application/json",
+			},
+		},
+		"REST API event with a decoded path holding reserved characters": {
+			event: "rest_api_encoded_path.json",
+			expected: capturedRequest{
+				Method:     http.MethodGet,
+				Path:       "/documents/annual report 100%?.pdf",
+				RequestURI: "/documents/annual%20report%20100%25%3F.pdf?version=2",
+				Query:      map[string][]string{"version": {"2"}},
+				Host:       "federation.example.com",
+				RemoteAddr: "203.0.113.24",
+				Accept:     "application/json",
+			},
+		},
+		"REST API event with a base64 encoded body": {
+			event: "rest_api_base64_body.json",
+			expected: capturedRequest{
+				Method:     http.MethodPost,
+				Path:       "/trust-mark-status",
+				RequestURI: "/trust-mark-status",
+				Query:      map[string][]string{},
+				Host:       "federation.example.com",
+				RemoteAddr: "203.0.113.24",
+				Body:       "trust_mark=eyJhbGciOiJFUzI1NiJ9.e.s",
+			},
+		},
+		"HTTP API event with cookies": {
+			event: "http_api_fetch.json",
+			expected: capturedRequest{
+				Method:     http.MethodGet,
+				Path:       "/fetch",
+				RequestURI: "/fetch?sub=https%3A%2F%2Fleaf.example.com",
+				Query:      map[string][]string{"sub": {"https://leaf.example.com"}},
+				Host:       "federation.example.com",
+				RemoteAddr: "203.0.113.24",
+				Cookie:     "session=abc; theme=dark",
+				Accept:     "application/entity-statement+jwt",
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var request Request
+			if err := json.Unmarshal(readEvent(t, tt.event), &request); err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			r, err := NewHTTPRequest(context.Background(), request)
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			got := capturedRequest{
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				RequestURI: r.RequestURI,
+				Query:      r.URL.Query(),
+				Host:       r.Host,
+				RemoteAddr: r.RemoteAddr,
+				Cookie:     r.Header.Get("Cookie"),
+				Accept:     r.Header.Get("Accept"),
+				Body:       string(body),
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("request mismatch (-expected +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAdapter_Handle(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/binary":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write([]byte{0xff, 0xfe, 0x00})
+		default:
+			w.Header().Add("Set-Cookie", "a=1")
+			w.Header().Add("Set-Cookie", "b=2")
+			w.Header().Add("Vary", "Accept")
+			w.Header().Add("Vary", "Origin")
+			w.WriteHeader(http.StatusTeapot)
+			_, _ = w.Write([]byte("short and stout"))
+		}
+	})
+
+	tests := map[string]struct {
+		event    string
+		expected Response
+	}{
+		"REST API response": {
+			event: `{"httpMethod": "GET", "path": "/"}`,
+			expected: Response{
+				StatusCode: http.StatusTeapot,
+				Headers: map[string]string{
+					"Content-Type": "text/plain; charset=utf-8",
+					"Set-Cookie":   "b=2",
+					"Vary":         "Origin",
+				},
+				MultiValueHeaders: map[string][]string{
+					"Content-Type": {"text/plain; charset=utf-8"},
+					"Set-Cookie":   {"a=1", "b=2"},
+					"Vary":         {"Accept", "Origin"},
+				},
+				Body: "short and stout",
+			},
+		},
+		"HTTP API response": {
+			event: `{"version": "2.0", "rawPath": "/", "requestContext": {"http": {"method": "GET"}}}`,
+			expected: Response{
+				StatusCode: http.StatusTeapot,
+				Headers: map[string]string{
+					"Content-Type": "text/plain; charset=utf-8",
+					"Vary":         "Accept,Origin",
+				},
+				Cookies: []string{"a=1", "b=2"},
+				Body:    "short and stout",
+			},
+		},
+		"binary response": {
+			event: `{"version": "2.0", "rawPath": "/binary", "requestContext": {"http": {"method": "GET"}}}`,
+			expected: Response{
+				StatusCode:      http.StatusOK,
+				Headers:         map[string]string{"Content-Type": "application/octet-stream"},
+				Body:            base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe, 0x00}),
+				IsBase64Encoded: true,
+			},
+		},
+	}
+
+	adapter := New(handler)
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			result, err := adapter.Handle(context.Background(), []byte(tt.event))
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			var got Response
+			if err = json.Unmarshal(result, &got); err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("response mismatch (-expected +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAdapter_Handle_InvalidEvents(t *testing.T) {
+	tests := map[string]struct {
+		event         string
+		expectedError string
+	}{
+		"not json":              {event: `not json`, expectedError: "failed to decode proxy request event"},
+		"missing method":        {event: `{"path": "/list"}`, expectedError: "missing HTTP method"},
+		"missing v2 context":    {event: `{"version": "2.0", "rawPath": "/list"}`, expectedError: "missing 'requestContext.http'"},
+		"malformed v2 raw path": {event: `{"version": "2.0", "rawPath": "/list%zz", "requestContext": {"http": {"method": "GET"}}}`, expectedError: "malformed 'rawPath'"},
+		"invalid base64 body":   {event: `{"httpMethod": "POST", "path": "/", "body": "!!", "isBase64Encoded": true}`, expectedError: "failed to decode body"},
+	}
+
+	adapter := New(http.NotFoundHandler())
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := adapter.Handle(context.Background(), []byte(tt.event))
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Fatalf("expected error containing %q, got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestAdapter_Handle_FederationServer(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	mux := http.NewServeMux()
+	server.NewServer(model.ServerConfiguration{
+		EntityIdentifier:            "https://federation.example.com",
+		SignerConfiguration:         model.SignerConfiguration{Signer: signer, KeyID: "key-1", Algorithm: "ES256"},
+		EntityConfigurationLifetime: time.Hour,
+		IntermediateConfiguration:   &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour},
+	}).Configure(mux)
+	adapter := New(mux)
+
+	tests := map[string]struct {
+		event               string
+		expectedStatus      int
+		expectedContentType string
+	}{
+		"entity configuration": {
+			event:               "http_api_well_known.json",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/entity-statement+jwt",
+		},
+		"list": {
+			event:               "rest_api_list.json",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/json",
+		},
+		"fetch of an unknown subordinate": {
+			event:               "http_api_fetch.json",
+			expectedStatus:      http.StatusNotFound,
+			expectedContentType: "application/json",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			result, err := adapter.Handle(context.Background(), readEvent(t, tt.event))
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			var response Response
+			if err = json.Unmarshal(result, &response); err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if response.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d with body %s", tt.expectedStatus, response.StatusCode, response.Body)
+			}
+			if response.Headers["Content-Type"] != tt.expectedContentType {
+				t.Errorf("expected content type %q, got %q", tt.expectedContentType, response.Headers["Content-Type"])
+			}
+		})
+	}
+}
+
+// failingStreamingRetriever streams count subordinates, failing once failAt subordinates have been streamed
+type failingStreamingRetriever struct {
+	count, failAt int
+}
+
+func (f failingStreamingRetriever) GetSubordinate(_ context.Context, identifier model.EntityIdentifier) (*model.SubordinateConfiguration, error) {
+	return nil, model.NewNotFoundError(fmt.Sprintf("subordinate cfg not found: %s", identifier))
+}
+
+func (f failingStreamingRetriever) GetSubordinates(_ context.Context) (map[model.EntityIdentifier]*model.SubordinateConfiguration, error) {
+	return nil, fmt.Errorf("subordinates must be streamed")
+}
+
+func (f failingStreamingRetriever) GetSubordinateSigners(_ context.Context) ([]model.SignerConfiguration, error) {
+	return nil, nil
+}
+
+func (f failingStreamingRetriever) StreamSubordinates(_ context.Context, cursor *model.EntityIdentifier) iter.Seq2[model.Subordinate, error] {
+	return func(yield func(model.Subordinate, error) bool) {
+		for i := range f.count {
+			if i == f.failAt {
+				yield(model.Subordinate{}, fmt.Errorf("retriever unavailable"))
+				return
+			}
+			identifier := model.EntityIdentifier(fmt.Sprintf("https://federation.example.com/%06d", i))
+			if cursor != nil && identifier < *cursor {
+				continue
+			}
+			if !yield(model.Subordinate{Identifier: identifier, Configuration: &model.SubordinateConfiguration{}}, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestAdapter_Handle_AbortedResponse(t *testing.T) {
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	mux := http.NewServeMux()
+	server.NewServer(model.ServerConfiguration{
+		EntityIdentifier:            "https://federation.example.com",
+		SignerConfiguration:         model.SignerConfiguration{Signer: signer, KeyID: "key-1", Algorithm: "ES256"},
+		EntityConfigurationLifetime: time.Hour,
+		IntermediateConfiguration:   &model.IntermediateConfiguration{SubordinateStatementLifetime: time.Hour},
+		MetadataRetriever:           failingStreamingRetriever{count: 5000, failAt: 4000},
+	}).Configure(mux)
+
+	list := Request{HTTPMethod: http.MethodGet, Path: "/list"}
+	if _, err = New(mux).Serve(context.Background(), list); !errors.Is(err, ErrResponseAborted) {
+		t.Fatalf("expected the aborted listing to fail the invocation, got %v", err)
+	}
+
+	other := New(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("unexpected failure")
+	}))
+	defer func() {
+		if recovered := recover(); recovered != "unexpected failure" {
+			t.Errorf("expected other panics to be re-raised, got %v", recovered)
+		}
+	}()
+	_, _ = other.Serve(context.Background(), list)
+}
+
+func TestRuntime_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := [][]byte{readEvent(t, "http_api_well_known.json"), []byte(`not json`)}
+	invocations := 0
+	responses := map[string]string{}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/2018-06-01/runtime/invocation/next":
+			if len(events) == 0 {
+				cancel() // no more invocations, so stop the runtime
+				<-r.Context().Done()
+				return
+			}
+			invocations++
+			w.Header().Set("Lambda-Runtime-Aws-Request-Id", fmt.Sprintf("request-%d", invocations))
+			w.Header().Set("Lambda-Runtime-Deadline-Ms", "4102444800000")
+			_, _ = w.Write(events[0])
+			events = events[1:]
+		case r.Method == http.MethodPost:
+			body, _ := io.ReadAll(r.Body)
+			responses[strings.TrimPrefix(r.URL.Path, "/2018-06-01/runtime/invocation/")] = string(body)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer api.Close()
+
+	runtime := &Runtime{
+		API: strings.TrimPrefix(api.URL, "http://"),
+		Adapter: New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.URL.Path))
+		})),
+	}
+	if err := runtime.Run(ctx); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+
+	if !strings.Contains(responses["request-1/response"], `"body":"/.well-known/openid-federation"`) {
+		t.Errorf("expected a response for request-1, got %v", responses)
+	}
+	if !strings.Contains(responses["request-2/error"], "failed to decode proxy request event") {
+		t.Errorf("expected an error for request-2, got %v", responses)
+	}
+}
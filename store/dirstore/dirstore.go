@@ -0,0 +1,355 @@
+// Package dirstore provides a Retriever reading one JSON or YAML file per subordinate from a directory, suited to
+// federations whose subordinates are managed through version control
+package dirstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/internal/documents"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+var _ model.Retriever = &Store{}
+
+const defaultPollInterval = 5 * time.Second
+
+type Configuration struct {
+	PollInterval   time.Duration                                                               // PollInterval determines how often Watch checks the directory for changes - defaults to 5 seconds
+	SignerResolver func(ctx context.Context, keyID string) (*model.SignerConfiguration, error) // SignerResolver resolves the signer override named by a subordinate file's signer_key_id, as private keys are never read from the directory
+	OnChange       func(identifiers []model.EntityIdentifier)                                  // OnChange is called after a reload with the identifiers of every subordinate added, changed or removed
+	Logger         *slog.Logger
+}
+
+// Store serves the subordinates described by the files of a single directory. Files ending in .json, .yaml or .yml are
+// read, while hidden files are ignored so editors' temporary files are never picked up.
+//
+// Each reload validates every file and replaces the served subordinates in a single step. A file which fails
+// validation is rejected: any subordinate previously loaded from it continues to be served, and the failure is logged
+// and reported through Rejected
+type Store struct {
+	directory    string
+	cfg          Configuration
+	reloadMu     sync.Mutex
+	newValidator func(ctx context.Context) Validator // newValidator is guarded by reloadMu
+	validator    Validator                           // validator is created by newValidator for the reload in progress, and is guarded by reloadMu
+	state        atomic.Pointer[state]
+}
+
+// Validator checks a single subordinate read from the directory. A subordinate for which it returns an error is rejected
+// in the same way as an invalid file
+type Validator = model.SubordinateValidator
+
+type state struct {
+	subordinates map[model.EntityIdentifier]*model.SubordinateConfiguration
+	files        map[string]loadedFile
+	rejected     map[string]error
+}
+
+// loadedFile records the last version of a file which passed validation
+type loadedFile struct {
+	digest      [sha256.Size]byte
+	identifier  model.EntityIdentifier
+	subordinate *model.SubordinateConfiguration
+}
+
+// subordinateFile is the structure of a single subordinate file
+type subordinateFile struct {
+	EntityID       string                  `json:"entity_id"`
+	JWKs           *josemodel.Jwks         `json:"jwks"`
+	MetadataPolicy *model.MetadataPolicy   `json:"metadata_policy,omitempty"`
+	Metadata       *model.Metadata         `json:"metadata,omitempty"`
+	TrustMarks     []model.TrustMarkHolder `json:"trust_marks,omitempty"`
+	Constraints    map[string]any          `json:"constraints,omitempty"`
+	SignerKeyID    string                  `json:"signer_key_id,omitempty"`
+}
+
+// New creates a Store serving the subordinates held in the given directory, performing an initial load. An error is
+// only returned when the directory itself cannot be read; rejected files are reported through Rejected
+func New(ctx context.Context, directory string, configuration Configuration) (*Store, error) {
+	if configuration.PollInterval <= 0 {
+		configuration.PollInterval = defaultPollInterval
+	}
+	s := &Store{directory: directory, cfg: configuration}
+	s.state.Store(&state{
+		subordinates: map[model.EntityIdentifier]*model.SubordinateConfiguration{},
+		files:        map[string]loadedFile{},
+		rejected:     map[string]error{},
+	})
+	if err := s.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) GetSubordinate(_ context.Context, identifier model.EntityIdentifier) (*model.SubordinateConfiguration, error) {
+	subordinate, ok := s.state.Load().subordinates[identifier]
+	if !ok {
+		return nil, model.NewNotFoundError(fmt.Sprintf("subordinate cfg not found: %s", identifier))
+	}
+	return subordinate, nil
+}
+
+func (s *Store) GetSubordinates(_ context.Context) (map[model.EntityIdentifier]*model.SubordinateConfiguration, error) {
+	return maps.Clone(s.state.Load().subordinates), nil
+}
+
+func (s *Store) GetSubordinateSigners(_ context.Context) ([]model.SignerConfiguration, error) {
+	var signers []model.SignerConfiguration
+	for _, subordinate := range s.state.Load().subordinates {
+		if subordinate.SignerConfiguration != nil {
+			signers = append(signers, *subordinate.SignerConfiguration)
+		}
+	}
+	return signers, nil
+}
+
+// Rejected returns the reason each currently rejected file failed validation, keyed by file name
+func (s *Store) Rejected() map[string]error {
+	return maps.Clone(s.state.Load().rejected)
+}
+
+// SetValidator registers an additional check applied to each subordinate read from the directory. newValidator is
+// called at most once per reload, before the first file needing validation, so that work shared between files - such
+// as retrieving documents from other entities - is done once and only when a file has changed. The validator only
+// applies to files read after it is set - files unchanged since they were last loaded are not checked again
+func (s *Store) SetValidator(newValidator func(ctx context.Context) Validator) {
+	s.reloadMu.Lock()
+	s.newValidator = newValidator
+	s.reloadMu.Unlock()
+}
+
+// Watch reloads the directory every PollInterval until the context is cancelled
+func (s *Store) Watch(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(ctx); err != nil {
+				s.logError(ctx, "failed to reload subordinate directory", slog.String("directory", s.directory), slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// Reload reads the directory and atomically replaces the served subordinates. Files unchanged since the previous
+// reload are not parsed again
+func (s *Store) Reload(ctx context.Context) error {
+	s.reloadMu.Lock()
+	defer func() {
+		s.validator = nil
+		s.reloadMu.Unlock()
+	}()
+
+	entries, err := os.ReadDir(s.directory)
+	if err != nil {
+		return fmt.Errorf("failed to read subordinate directory: %w", err)
+	}
+
+	previous := s.state.Load()
+	next := &state{
+		subordinates: map[model.EntityIdentifier]*model.SubordinateConfiguration{},
+		files:        map[string]loadedFile{},
+		rejected:     map[string]error{},
+	}
+	owners := map[model.EntityIdentifier]string{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !slices.Contains([]string{".json", ".yaml", ".yml"}, filepath.Ext(name)) {
+			continue
+		}
+
+		loaded, err := s.load(ctx, name, previous.files[name])
+		if err != nil {
+			s.reject(ctx, previous, next, name, err)
+			// continue serving the last valid version of the file, if there is one
+			var ok bool
+			if loaded, ok = previous.files[name]; !ok {
+				continue
+			}
+		}
+
+		if owner, ok := owners[loaded.identifier]; ok {
+			s.reject(ctx, previous, next, name, fmt.Errorf("entity identifier %s is already defined in %s", loaded.identifier, owner))
+			continue
+		}
+		owners[loaded.identifier] = name
+		next.files[name] = loaded
+		next.subordinates[loaded.identifier] = loaded.subordinate
+	}
+
+	s.state.Store(next)
+
+	var changed []model.EntityIdentifier
+	for identifier, subordinate := range next.subordinates {
+		if previous.subordinates[identifier] != subordinate {
+			changed = append(changed, identifier)
+		}
+	}
+	for identifier := range previous.subordinates {
+		if _, ok := next.subordinates[identifier]; !ok {
+			changed = append(changed, identifier)
+		}
+	}
+	if len(changed) > 0 {
+		slices.Sort(changed)
+		s.logInfo(ctx, "reloaded subordinate directory", slog.String("directory", s.directory), slog.Int("subordinates", len(next.subordinates)), slog.Int("changed", len(changed)))
+		if s.cfg.OnChange != nil {
+			s.cfg.OnChange(changed)
+		}
+	}
+	return nil
+}
+
+// reject records a file as rejected, logging the failure unless the file was already rejected for the same reason
+func (s *Store) reject(ctx context.Context, previous, next *state, name string, err error) {
+	next.rejected[name] = err
+	if previousErr, ok := previous.rejected[name]; ok && previousErr.Error() == err.Error() {
+		return
+	}
+	s.logError(ctx, "rejected subordinate file", slog.String("directory", s.directory), slog.String("file", name), slog.String("error", err.Error()))
+}
+
+// load reads and validates a single file, reusing the previously loaded version when the file's contents are unchanged
+func (s *Store) load(ctx context.Context, name string, previous loadedFile) (loadedFile, error) {
+	data, err := os.ReadFile(filepath.Join(s.directory, name))
+	if err != nil {
+		return loadedFile{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	digest := sha256.Sum256(data)
+	if previous.subordinate != nil && previous.digest == digest {
+		return previous, nil
+	}
+
+	if filepath.Ext(name) != ".json" {
+		if data, err = yamlToJSON(data); err != nil {
+			return loadedFile{}, err
+		}
+	}
+
+	var file subordinateFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&file); err != nil {
+		return loadedFile{}, fmt.Errorf("failed to parse file: %w", err)
+	}
+
+	identifier, subordinate, err := s.validate(ctx, file)
+	if err != nil {
+		return loadedFile{}, err
+	}
+	return loadedFile{digest: digest, identifier: identifier, subordinate: subordinate}, nil
+}
+
+func (s *Store) validate(ctx context.Context, file subordinateFile) (model.EntityIdentifier, *model.SubordinateConfiguration, error) {
+	if file.EntityID == "" {
+		return "", nil, fmt.Errorf("missing required field 'entity_id'")
+	}
+	identifier, err := model.ValidateEntityIdentifier(file.EntityID)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid 'entity_id': %w", err)
+	}
+
+	if file.JWKs == nil || len(file.JWKs.Keys) == 0 {
+		return "", nil, fmt.Errorf("missing required field 'jwks'")
+	}
+	subordinate := &model.SubordinateConfiguration{
+		Metadata:    file.Metadata,
+		TrustMarks:  file.TrustMarks,
+		Constraints: file.Constraints,
+	}
+	subordinate.JWKs.Opts.EnforceUniqueKIDs = true
+	for i, key := range file.JWKs.Keys {
+		if kid, ok := key["kid"].(string); !ok || kid == "" {
+			return "", nil, fmt.Errorf("invalid 'jwks': key %d is missing the mandatory field 'kid'", i)
+		}
+		if err = validatePublicKey(key); err != nil {
+			return "", nil, fmt.Errorf("invalid 'jwks': key %d is not a valid public key: %w", i, err)
+		}
+		if err = subordinate.JWKs.Add(key); err != nil {
+			return "", nil, fmt.Errorf("invalid 'jwks': key %d: %w", i, err)
+		}
+	}
+
+	if file.MetadataPolicy != nil {
+		if err = file.MetadataPolicy.Validate(); err != nil {
+			return "", nil, fmt.Errorf("invalid 'metadata_policy': %w", err)
+		}
+		subordinate.Policies = *file.MetadataPolicy
+	}
+
+	if file.SignerKeyID != "" {
+		if s.cfg.SignerResolver == nil {
+			return "", nil, fmt.Errorf("'signer_key_id' set but no signer resolver is configured")
+		}
+		if subordinate.SignerConfiguration, err = s.cfg.SignerResolver(ctx, file.SignerKeyID); err != nil {
+			return "", nil, fmt.Errorf("failed to resolve 'signer_key_id' %q: %w", file.SignerKeyID, err)
+		}
+		if keyID, err := subordinate.SignerConfiguration.AsRemoteSigner().KeyID(ctx); err != nil {
+			return "", nil, fmt.Errorf("failed to determine the key ID of 'signer_key_id' %q: %w", file.SignerKeyID, err)
+		} else if keyID == "" {
+			return "", nil, fmt.Errorf("'signer_key_id' %q resolved to a signer without a key ID", file.SignerKeyID)
+		}
+	}
+
+	if s.newValidator != nil {
+		if s.validator == nil {
+			s.validator = s.newValidator(ctx)
+		}
+		if err = s.validator(ctx, *identifier, subordinate); err != nil {
+			return "", nil, err
+		}
+	}
+	return *identifier, subordinate, nil
+}
+
+// validatePublicKey checks a JWK describes a valid public key. The JWK parser can panic on malformed keys, which is
+// recovered from so a single bad file cannot bring down the process
+func validatePublicKey(key map[string]any) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed key: %v", r)
+		}
+	}()
+	_, err = jwk.PublicFromJwk(key)
+	return err
+}
+
+// yamlToJSON converts a YAML document to JSON, so YAML files are validated by exactly the same parsing as JSON files
+func yamlToJSON(data []byte) ([]byte, error) {
+	document, err := documents.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file: %w", err)
+	}
+	return json.Marshal(document)
+}
+
+func (s *Store) logInfo(ctx context.Context, msg string, args ...any) {
+	if s.cfg.Logger != nil {
+		s.cfg.Logger.InfoContext(ctx, msg, args...)
+	}
+}
+
+func (s *Store) logError(ctx context.Context, msg string, args ...any) {
+	if s.cfg.Logger != nil {
+		s.cfg.Logger.ErrorContext(ctx, msg, args...)
+	}
+}
@@ -0,0 +1,436 @@
+package dirstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/go-jose/jwk"
+	"github.com/MichaelFraser99/go-jose/jws"
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+func testJWK(t *testing.T, kid string) map[string]any {
+	t.Helper()
+	signer, err := jws.GetSigner(josemodel.ES256, nil)
+	if err != nil {
+		t.Fatalf("expected no error creating signer, got %q", err.Error())
+	}
+	publicJWK, err := jwk.PublicJwk(signer.Public())
+	if err != nil {
+		t.Fatalf("expected no error creating public JWK, got %q", err.Error())
+	}
+	(*publicJWK)["kid"] = kid
+	return *publicJWK
+}
+
+func jsonFile(t *testing.T, entityID string, kid string) string {
+	t.Helper()
+	b, err := json.Marshal(map[string]any{
+		"entity_id": entityID,
+		"jwks":      map[string]any{"keys": []any{testJWK(t, kid)}},
+		"metadata_policy": map[string]any{
+			"openid_relying_party": map[string]any{
+				"grant_types": map[string]any{"subset_of": []any{"authorization_code"}},
+			},
+		},
+		"constraints": map[string]any{"max_path_length": 1},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	return string(b)
+}
+
+func yamlFile(t *testing.T, entityID string, kid string) string {
+	t.Helper()
+	key := testJWK(t, kid)
+	return fmt.Sprintf(`entity_id: %s
+jwks:
+  keys:
+    - kty: EC
+      crv: P-256
+      kid: %s
+      x: %s
+      y: %s
+metadata_policy:
+  openid_relying_party:
+    scope:
+      value: openid
+`, entityID, kid, key["x"], key["y"])
+}
+
+func writeFile(t *testing.T, directory, name, contents string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(directory, name), []byte(contents), 0o600); err != nil {
+		t.Fatalf("expected no error writing %s, got %q", name, err.Error())
+	}
+}
+
+func identifiers(t *testing.T, s *Store) []model.EntityIdentifier {
+	t.Helper()
+	subordinates, err := s.GetSubordinates(t.Context())
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	return slices.Sorted(maps.Keys(subordinates))
+}
+
+func TestNew(t *testing.T) {
+	directory := t.TempDir()
+	writeFile(t, directory, "a.json", jsonFile(t, "https://a-federation.com", "a-key"))
+	writeFile(t, directory, "b.yaml", yamlFile(t, "https://b-federation.com", "b-key"))
+	writeFile(t, directory, ".c.json.swp", "not a subordinate")
+	writeFile(t, directory, "README.md", "not a subordinate")
+
+	s, err := New(t.Context(), directory, Configuration{})
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if diff := cmp.Diff([]model.EntityIdentifier{"https://a-federation.com", "https://b-federation.com"}, identifiers(t, s)); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+	if rejected := s.Rejected(); len(rejected) != 0 {
+		t.Errorf("expected no rejected files, got %v", rejected)
+	}
+
+	a, err := s.GetSubordinate(t.Context(), "https://a-federation.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if len(a.JWKs.Keys) != 1 || a.JWKs.Keys[0]["kid"] != "a-key" || !a.JWKs.Opts.EnforceUniqueKIDs {
+		t.Errorf("expected the file's jwks, got %v", a.JWKs)
+	}
+	if len(a.Policies.OpenIDRelyingPartyMetadata["grant_types"].Metadata) != 1 {
+		t.Errorf("expected the file's metadata policy, got %v", a.Policies)
+	}
+	if a.Constraints["max_path_length"] != float64(1) {
+		t.Errorf("expected the file's constraints, got %v", a.Constraints)
+	}
+
+	b, err := s.GetSubordinate(t.Context(), "https://b-federation.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if operators := b.Policies.OpenIDRelyingPartyMetadata["scope"].Metadata; len(operators) != 1 || operators[0].OperatorValue() != "openid" {
+		t.Errorf("expected the file's metadata policy, got %v", b.Policies)
+	}
+
+	if _, err = s.GetSubordinate(t.Context(), "https://c-federation.com"); !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	if _, err = New(t.Context(), filepath.Join(directory, "missing"), Configuration{}); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+func TestNew_RejectsInvalidFiles(t *testing.T) {
+	tests := map[string]struct {
+		name     string
+		contents func(t *testing.T) string
+		cfg      Configuration
+		expected string
+	}{
+		"malformed json": {
+			name:     "invalid.json",
+			contents: func(t *testing.T) string { return `{"entity_id": ` },
+			expected: "failed to parse file",
+		},
+		"malformed yaml": {
+			name:     "invalid.yaml",
+			contents: func(t *testing.T) string { return "entity_id: [" },
+			expected: "failed to parse file",
+		},
+		"unknown fields": {
+			name: "invalid.json",
+			contents: func(t *testing.T) string {
+				return strings.Replace(jsonFile(t, "https://invalid.com", "key"), `"jwks"`, `"jwks_uri":"https://invalid.com/jwks","jwks"`, 1)
+			},
+			expected: `unknown field "jwks_uri"`,
+		},
+		"missing entity identifier": {
+			name:     "invalid.json",
+			contents: func(t *testing.T) string { return `{"jwks": {"keys": []}}` },
+			expected: "missing required field 'entity_id'",
+		},
+		"invalid entity identifier": {
+			name:     "invalid.json",
+			contents: func(t *testing.T) string { return jsonFile(t, "http://invalid.com", "key") },
+			expected: "invalid 'entity_id'",
+		},
+		"missing jwks": {
+			name:     "invalid.json",
+			contents: func(t *testing.T) string { return `{"entity_id": "https://invalid.com"}` },
+			expected: "missing required field 'jwks'",
+		},
+		"jwks missing a key ID": {
+			name: "invalid.json",
+			contents: func(t *testing.T) string {
+				return `{"entity_id": "https://invalid.com", "jwks": {"keys": [{"kty": "EC"}]}}`
+			},
+			expected: "key 0 is missing the mandatory field 'kid'",
+		},
+		"jwks holding an invalid key": {
+			name: "invalid.json",
+			contents: func(t *testing.T) string {
+				return `{"entity_id": "https://invalid.com", "jwks": {"keys": [{"kty": "EC", "kid": "key"}]}}`
+			},
+			expected: "key 0 is not a valid public key",
+		},
+		"jwks with duplicate key IDs": {
+			name: "invalid.json",
+			contents: func(t *testing.T) string {
+				b, _ := json.Marshal(map[string]any{
+					"entity_id": "https://invalid.com",
+					"jwks":      map[string]any{"keys": []any{testJWK(t, "key"), testJWK(t, "key")}},
+				})
+				return string(b)
+			},
+			expected: "invalid 'jwks': key 1",
+		},
+		"unknown metadata policy operator": {
+			name: "invalid.json",
+			contents: func(t *testing.T) string {
+				return strings.Replace(jsonFile(t, "https://invalid.com", "key"), `"subset_of"`, `"subset"`, 1)
+			},
+			expected: "unknown policy operator: subset",
+		},
+		"conflicting metadata policy operators": {
+			name: "invalid.json",
+			contents: func(t *testing.T) string {
+				return strings.Replace(jsonFile(t, "https://invalid.com", "key"), `"subset_of":["authorization_code"]`, `"add":["refresh_token"],"one_of":["refresh_token"]`, 1)
+			},
+			expected: "invalid 'metadata_policy'",
+		},
+		"signer override without a resolver": {
+			name: "invalid.json",
+			contents: func(t *testing.T) string {
+				return strings.Replace(jsonFile(t, "https://invalid.com", "key"), `"jwks"`, `"signer_key_id":"override","jwks"`, 1)
+			},
+			expected: "no signer resolver is configured",
+		},
+		"unresolvable signer override": {
+			name: "invalid.json",
+			contents: func(t *testing.T) string {
+				return strings.Replace(jsonFile(t, "https://invalid.com", "key"), `"jwks"`, `"signer_key_id":"override","jwks"`, 1)
+			},
+			cfg: Configuration{SignerResolver: func(ctx context.Context, keyID string) (*model.SignerConfiguration, error) {
+				return nil, fmt.Errorf("unknown key")
+			}},
+			expected: `failed to resolve 'signer_key_id' "override": unknown key`,
+		},
+		"signer override without a key ID": {
+			name: "invalid.json",
+			contents: func(t *testing.T) string {
+				return strings.Replace(jsonFile(t, "https://invalid.com", "key"), `"jwks"`, `"signer_key_id":"override","jwks"`, 1)
+			},
+			cfg: Configuration{SignerResolver: func(ctx context.Context, keyID string) (*model.SignerConfiguration, error) {
+				return &model.SignerConfiguration{Algorithm: "ES256"}, nil
+			}},
+			expected: `'signer_key_id' "override" resolved to a signer without a key ID`,
+		},
+		"duplicate entity identifier": {
+			name:     "z-duplicate.json",
+			contents: func(t *testing.T) string { return jsonFile(t, "https://valid.com", "other-key") },
+			expected: "entity identifier https://valid.com is already defined in valid.json",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			directory := t.TempDir()
+			writeFile(t, directory, "valid.json", jsonFile(t, "https://valid.com", "valid-key"))
+			writeFile(t, directory, tt.name, tt.contents(t))
+
+			s, err := New(t.Context(), directory, tt.cfg)
+			if err != nil {
+				t.Fatalf("expected no error, got %q", err.Error())
+			}
+			if diff := cmp.Diff([]model.EntityIdentifier{"https://valid.com"}, identifiers(t, s)); diff != "" {
+				t.Errorf("mismatch (-expected +got):\n%s", diff)
+			}
+			rejected := s.Rejected()
+			if len(rejected) != 1 || rejected[tt.name] == nil {
+				t.Fatalf("expected %s to be rejected, got %v", tt.name, rejected)
+			}
+			if !strings.Contains(rejected[tt.name].Error(), tt.expected) {
+				t.Errorf("expected error containing %q, got %q", tt.expected, rejected[tt.name].Error())
+			}
+		})
+	}
+}
+
+func TestStore_Reload(t *testing.T) {
+	directory := t.TempDir()
+	writeFile(t, directory, "a.json", jsonFile(t, "https://a-federation.com", "a-key"))
+	writeFile(t, directory, "b.json", jsonFile(t, "https://b-federation.com", "b-key"))
+
+	var changes [][]model.EntityIdentifier
+	s, err := New(t.Context(), directory, Configuration{OnChange: func(identifiers []model.EntityIdentifier) {
+		changes = append(changes, identifiers)
+	}})
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	changes = nil
+
+	reload := func() {
+		t.Helper()
+		if err := s.Reload(t.Context()); err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+	}
+
+	reload()
+	if len(changes) != 0 {
+		t.Errorf("expected no changes when no files changed, got %v", changes)
+	}
+
+	writeFile(t, directory, "a.json", jsonFile(t, "https://a-federation.com", "a-key-2"))
+	writeFile(t, directory, "c.yml", yamlFile(t, "https://c-federation.com", "c-key"))
+	reload()
+	if diff := cmp.Diff([][]model.EntityIdentifier{{"https://a-federation.com", "https://c-federation.com"}}, changes); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+	a, err := s.GetSubordinate(t.Context(), "https://a-federation.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if a.JWKs.Keys[0]["kid"] != "a-key-2" {
+		t.Errorf("expected the updated jwks, got %v", a.JWKs)
+	}
+
+	changes = nil
+	writeFile(t, directory, "a.json", `{"entity_id": "https://a-federation.com", "jwks": `)
+	reload()
+	if len(changes) != 0 {
+		t.Errorf("expected no changes when a file is rejected, got %v", changes)
+	}
+	if retained, err := s.GetSubordinate(t.Context(), "https://a-federation.com"); err != nil || retained != a {
+		t.Errorf("expected the last valid version of a rejected file to be retained, got %v, %v", retained, err)
+	}
+	if _, ok := s.Rejected()["a.json"]; !ok {
+		t.Errorf("expected a.json to be rejected, got %v", s.Rejected())
+	}
+
+	if err = os.Remove(filepath.Join(directory, "b.json")); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	reload()
+	if diff := cmp.Diff([][]model.EntityIdentifier{{"https://b-federation.com"}}, changes); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]model.EntityIdentifier{"https://a-federation.com", "https://c-federation.com"}, identifiers(t, s)); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+
+	writeFile(t, directory, "a.json", jsonFile(t, "https://a-federation.com", "a-key-3"))
+	reload()
+	if len(s.Rejected()) != 0 {
+		t.Errorf("expected a corrected file to no longer be rejected, got %v", s.Rejected())
+	}
+}
+
+func TestStore_SetValidator(t *testing.T) {
+	directory := t.TempDir()
+	writeFile(t, directory, "a.json", jsonFile(t, "https://a-federation.com", "a-key"))
+	s, err := New(t.Context(), directory, Configuration{})
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	a, err := s.GetSubordinate(t.Context(), "https://a-federation.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+
+	var created int
+	var validated []model.EntityIdentifier
+	s.SetValidator(func(context.Context) Validator {
+		created++
+		return func(_ context.Context, identifier model.EntityIdentifier, subordinate *model.SubordinateConfiguration) error {
+			validated = append(validated, identifier)
+			if subordinate.JWKs.Keys[0]["kid"] == "refused-key" {
+				return errors.New("refused by validator")
+			}
+			return nil
+		}
+	})
+	if err = s.Reload(t.Context()); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if created != 0 {
+		t.Errorf("expected no validator to be created for a reload without changed files, got %d", created)
+	}
+
+	writeFile(t, directory, "a.json", jsonFile(t, "https://a-federation.com", "refused-key"))
+	writeFile(t, directory, "b.json", jsonFile(t, "https://b-federation.com", "b-key"))
+	if err = s.Reload(t.Context()); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if diff := cmp.Diff([]model.EntityIdentifier{"https://a-federation.com", "https://b-federation.com"}, validated); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+	if created != 1 {
+		t.Errorf("expected a single validator to be shared by every file of a reload, got %d", created)
+	}
+	if rejected := s.Rejected(); len(rejected) != 1 || rejected["a.json"] == nil || rejected["a.json"].Error() != "refused by validator" {
+		t.Errorf("expected a.json to be rejected by the validator, got %v", rejected)
+	}
+	if retained, err := s.GetSubordinate(t.Context(), "https://a-federation.com"); err != nil || retained != a {
+		t.Errorf("expected the last valid version of a refused file to be retained, got %v, %v", retained, err)
+	}
+	if diff := cmp.Diff([]model.EntityIdentifier{"https://a-federation.com", "https://b-federation.com"}, identifiers(t, s)); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+}
+
+func TestStore_Watch(t *testing.T) {
+	directory := t.TempDir()
+	writeFile(t, directory, "a.json", jsonFile(t, "https://a-federation.com", "a-key"))
+
+	changed := make(chan struct{}, 1)
+	s, err := New(t.Context(), directory, Configuration{
+		PollInterval: 10 * time.Millisecond,
+		OnChange: func(identifiers []model.EntityIdentifier) {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	<-changed
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		s.Watch(ctx)
+		close(done)
+	}()
+
+	writeFile(t, directory, "b.json", jsonFile(t, "https://b-federation.com", "b-key"))
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the new file to be picked up")
+	}
+	if _, err = s.GetSubordinate(t.Context(), "https://b-federation.com"); err != nil {
+		t.Errorf("expected no error, got %q", err.Error())
+	}
+
+	cancel()
+	<-done
+}
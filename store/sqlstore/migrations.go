@@ -0,0 +1,95 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxIdentifierLength bounds the entity identifiers and trust mark types stored, keeping every key within the index
+// size limits of each supported database - including MySQL's 3072 byte limit for utf8mb4 composite keys
+const maxIdentifierLength = 255
+
+// identityColumn is replaced within migrations by the auto-incrementing primary key definition of the configured dialect
+const identityColumn = "{{identity}}"
+
+// migrations holds the statements making up each schema version, in order. Applied migrations must never be modified,
+// schema changes are made by appending a new migration
+var migrations = [][]string{
+	{
+		`CREATE TABLE federation_subordinates (
+	entity_id VARCHAR(255) NOT NULL PRIMARY KEY,
+	jwks TEXT NOT NULL,
+	metadata_policy TEXT,
+	metadata TEXT,
+	trust_marks TEXT,
+	signer_key_id VARCHAR(255),
+	registered_at BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL
+)`,
+		`CREATE TABLE federation_subordinate_events (
+	id ` + identityColumn + `,
+	entity_id VARCHAR(255) NOT NULL,
+	iat BIGINT NOT NULL,
+	event VARCHAR(64) NOT NULL,
+	event_description TEXT
+)`,
+		`CREATE INDEX federation_subordinate_events_entity_id ON federation_subordinate_events (entity_id)`,
+		`CREATE TABLE federation_trust_mark_entitlements (
+	trust_mark_type VARCHAR(255) NOT NULL,
+	entity_id VARCHAR(255) NOT NULL,
+	PRIMARY KEY (trust_mark_type, entity_id)
+)`,
+		`CREATE TABLE federation_issued_trust_marks (
+	trust_mark_hash VARCHAR(64) NOT NULL PRIMARY KEY,
+	trust_mark_type VARCHAR(255) NOT NULL,
+	entity_id VARCHAR(255) NOT NULL,
+	issued_at BIGINT NOT NULL,
+	expires_at BIGINT,
+	revoked BOOLEAN NOT NULL
+)`,
+	},
+	{
+		`ALTER TABLE federation_subordinates ADD COLUMN constraints TEXT`,
+	},
+}
+
+const (
+	queryCreateMigrationsTable = `CREATE TABLE IF NOT EXISTS federation_schema_migrations (version INTEGER NOT NULL PRIMARY KEY, applied_at BIGINT NOT NULL)`
+	querySchemaVersion         = `SELECT COALESCE(MAX(version), 0) FROM federation_schema_migrations`
+	queryRecordMigration       = `INSERT INTO federation_schema_migrations (version, applied_at) VALUES (?, ?)`
+)
+
+// Migrate brings the database schema up to date, applying each outstanding migration in its own transaction.
+// Safe to call on every start up
+func (s *Store) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, queryCreateMigrationsTable); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var version int
+	if err := s.db.QueryRowContext(ctx, s.rebind(querySchemaVersion)).Scan(&version); err != nil {
+		return fmt.Errorf("failed to determine schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than the latest known version %d", version, len(migrations))
+	}
+
+	for i, statements := range migrations[version:] {
+		target := version + i + 1
+		if err := s.inTransaction(ctx, func(tx *sql.Tx) error {
+			for _, statement := range statements {
+				if _, err := tx.ExecContext(ctx, strings.ReplaceAll(statement, identityColumn, s.cfg.Dialect.identity())); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx, s.rebind(queryRecordMigration), target, time.Now().UTC().Unix())
+			return err
+		}); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", target, err)
+		}
+	}
+	return nil
+}
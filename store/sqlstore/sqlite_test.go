@@ -0,0 +1,114 @@
+//go:build cgo
+
+package sqlstore
+
+import (
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/google/go-cmp/cmp"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TestStore_SQLite runs the store against a real database engine, so the schema and queries are checked beyond the
+// statements understood by the fake driver
+func TestStore_SQLite(t *testing.T) {
+	ctx := t.Context()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "federation.db"))
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	store := New(db, Configuration{
+		Dialect:             SQLite,
+		BatchSize:           2,
+		Issuer:              "https://trust-mark-issuer.com",
+		SignerConfiguration: testSigner(t),
+		TrustMarkLifetime:   time.Hour,
+	})
+	for range 2 {
+		if err = store.Migrate(ctx); err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+	}
+
+	identifiers := []model.EntityIdentifier{"https://c-federation.com", "https://a-federation.com", "https://b-federation.com"}
+	for _, identifier := range identifiers {
+		if err = store.PutSubordinate(ctx, identifier, testSubordinate(t, string(identifier))); err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+	}
+	if err = store.PutSubordinate(ctx, "https://a-federation.com", testSubordinate(t, "a-key-2")); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if err = store.RemoveSubordinate(ctx, "https://a-federation.com"); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	constrained := testSubordinate(t, "a-key-3")
+	constrained.Constraints = map[string]any{"max_path_length": float64(1)}
+	if err = store.PutSubordinate(ctx, "https://a-federation.com", constrained); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	retrieved, err := store.GetSubordinate(ctx, "https://a-federation.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if diff := cmp.Diff(constrained.Constraints, retrieved.Constraints); diff != "" {
+		t.Errorf("constraints mismatch (-expected +got):\n%s", diff)
+	}
+
+	var streamed []model.EntityIdentifier
+	for subordinate, err := range store.StreamSubordinates(ctx, nil) {
+		if err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+		streamed = append(streamed, subordinate.Identifier)
+	}
+	if diff := cmp.Diff(slices.Sorted(slices.Values(identifiers)), streamed); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+
+	status, err := store.GetSubordinateStatus(ctx, "https://a-federation.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	var events []string
+	for _, event := range status.Events {
+		events = append(events, event.Event)
+	}
+	if diff := cmp.Diff([]string{model.SubordinateEventRegistration, model.SubordinateEventMetadataUpdate, model.SubordinateEventRevocation, model.SubordinateEventRegistration}, events); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+
+	if err = store.GrantTrustMark(ctx, "https://trust-mark.com", "https://a-federation.com"); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	trustMark, err := store.IssueTrustMark(ctx, "https://trust-mark.com", "https://a-federation.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if err = store.RevokeTrustMark(ctx, "https://trust-mark.com", "https://a-federation.com"); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	trustMarkStatus, err := store.GetTrustMarkStatus(ctx, *trustMark)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if *trustMarkStatus != TrustMarkStatusRevoked {
+		t.Errorf("expected status %q, got %q", TrustMarkStatusRevoked, *trustMarkStatus)
+	}
+
+	tooLong := model.EntityIdentifier("https://" + strings.Repeat("a", maxIdentifierLength) + ".com")
+	if err = store.PutSubordinate(ctx, tooLong, testSubordinate(t, "too-long")); err == nil {
+		t.Error("expected an error storing an overlong entity identifier")
+	}
+	if err = store.GrantTrustMark(ctx, "https://trust-mark.com", tooLong); err == nil {
+		t.Error("expected an error granting a trust mark to an overlong entity identifier")
+	}
+}
@@ -0,0 +1,593 @@
+// Package sqlstore provides a reference subordinate store built on database/sql, implementing the Retriever,
+// StreamingRetriever, TrustMarkRetriever and SubordinateStatusRetriever interfaces
+package sqlstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MichaelFraser99/go-openid-federation/internal/signing"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+)
+
+var (
+	_ model.Retriever                  = &Store{}
+	_ model.StreamingRetriever         = &Store{}
+	_ model.TrustMarkRetriever         = &Store{}
+	_ model.SubordinateStatusRetriever = &Store{}
+)
+
+// Dialect identifies the underlying database, determining its bind parameter syntax and auto-incrementing columns
+type Dialect int
+
+const (
+	SQLite     Dialect = iota // SQLite uses '?' bind parameters
+	MySQL                     // MySQL uses '?' bind parameters
+	PostgreSQL                // PostgreSQL uses '$1' style bind parameters
+)
+
+// identity returns the definition of an auto-incrementing primary key column
+func (d Dialect) identity() string {
+	switch d {
+	case MySQL:
+		return "BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY"
+	case PostgreSQL:
+		return "BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY"
+	default:
+		return "INTEGER PRIMARY KEY AUTOINCREMENT"
+	}
+}
+
+const (
+	TrustMarkStatusActive  = "active"
+	TrustMarkStatusExpired = "expired"
+	TrustMarkStatusRevoked = "revoked"
+	TrustMarkStatusInvalid = "invalid"
+)
+
+const defaultBatchSize = 500
+
+type Configuration struct {
+	Dialect             Dialect                                                                     // Dialect is the underlying database - defaults to SQLite
+	BatchSize           int                                                                         // BatchSize is the number of subordinates read per query when streaming - defaults to 500
+	SignerResolver      func(ctx context.Context, keyID string) (*model.SignerConfiguration, error) // SignerResolver resolves the signer override recorded against a subordinate by key ID, as private keys are never persisted
+	Issuer              model.EntityIdentifier                                                      // Issuer is the entity identifier used when issuing trust marks
+	SignerConfiguration model.SignerConfiguration                                                   // SignerConfiguration is used to sign issued trust marks
+	TrustMarkLifetime   time.Duration                                                               // TrustMarkLifetime determines the expiry of issued trust marks - issued trust marks do not expire when zero
+	Validator           model.SubordinateValidator                                                  // Validator is applied to each subordinate before PutSubordinate stores it - set it to the served IntermediateConfiguration's ValidateSubordinate to apply the policy checks installed by the server
+}
+
+type Store struct {
+	db  *sql.DB
+	cfg Configuration
+}
+
+func New(db *sql.DB, configuration Configuration) *Store {
+	if configuration.BatchSize <= 0 {
+		configuration.BatchSize = defaultBatchSize
+	}
+	return &Store{db: db, cfg: configuration}
+}
+
+const (
+	subordinateColumns           = `entity_id, jwks, metadata_policy, metadata, trust_marks, signer_key_id, constraints`
+	querySelectSubordinate       = `SELECT ` + subordinateColumns + ` FROM federation_subordinates WHERE entity_id = ?`
+	querySelectSubordinates      = `SELECT ` + subordinateColumns + ` FROM federation_subordinates ORDER BY entity_id`
+	querySelectSubordinatesBatch = `SELECT ` + subordinateColumns + ` FROM federation_subordinates WHERE entity_id >= ? ORDER BY entity_id LIMIT ?`
+	querySelectSignerKeyIDs      = `SELECT signer_key_id FROM federation_subordinates WHERE signer_key_id IS NOT NULL ORDER BY entity_id`
+	querySubordinateExists       = `SELECT COUNT(*) FROM federation_subordinates WHERE entity_id = ?`
+	queryInsertSubordinate       = `INSERT INTO federation_subordinates (entity_id, jwks, metadata_policy, metadata, trust_marks, signer_key_id, constraints, registered_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	queryUpdateSubordinate       = `UPDATE federation_subordinates SET jwks = ?, metadata_policy = ?, metadata = ?, trust_marks = ?, signer_key_id = ?, constraints = ?, updated_at = ? WHERE entity_id = ?`
+	queryDeleteSubordinate       = `DELETE FROM federation_subordinates WHERE entity_id = ?`
+	queryInsertEvent             = `INSERT INTO federation_subordinate_events (entity_id, iat, event, event_description) VALUES (?, ?, ?, ?)`
+	querySelectEvents            = `SELECT iat, event, event_description FROM federation_subordinate_events WHERE entity_id = ? ORDER BY id`
+	queryInsertEntitlement       = `INSERT INTO federation_trust_mark_entitlements (trust_mark_type, entity_id) VALUES (?, ?)`
+	queryEntitlementExists       = `SELECT COUNT(*) FROM federation_trust_mark_entitlements WHERE trust_mark_type = ? AND entity_id = ?`
+	queryDeleteEntitlement       = `DELETE FROM federation_trust_mark_entitlements WHERE trust_mark_type = ? AND entity_id = ?`
+	querySelectEntitlements      = `SELECT entity_id FROM federation_trust_mark_entitlements WHERE trust_mark_type = ? ORDER BY entity_id`
+	queryInsertIssuedTrustMark   = `INSERT INTO federation_issued_trust_marks (trust_mark_hash, trust_mark_type, entity_id, issued_at, expires_at, revoked) VALUES (?, ?, ?, ?, ?, ?)`
+	querySelectIssuedTrustMark   = `SELECT expires_at, revoked FROM federation_issued_trust_marks WHERE trust_mark_hash = ?`
+	queryRevokeIssuedTrustMarks  = `UPDATE federation_issued_trust_marks SET revoked = ? WHERE trust_mark_type = ? AND entity_id = ?`
+)
+
+// rebind rewrites '?' bind parameters to the bind parameter syntax of the configured dialect
+func (s *Store) rebind(query string) string {
+	if s.cfg.Dialect != PostgreSQL {
+		return query
+	}
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+func (s *Store) inTransaction(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = f(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanSubordinate reads a row selected with subordinateColumns
+func (s *Store) scanSubordinate(ctx context.Context, row rowScanner) (model.EntityIdentifier, *model.SubordinateConfiguration, error) {
+	var (
+		identifier                                string
+		jwks                                      string
+		metadataPolicy, metadata, trustMarks, kid sql.NullString
+		constraints                               sql.NullString
+	)
+	if err := row.Scan(&identifier, &jwks, &metadataPolicy, &metadata, &trustMarks, &kid, &constraints); err != nil {
+		return "", nil, err
+	}
+
+	subordinate := &model.SubordinateConfiguration{}
+	if err := json.Unmarshal([]byte(jwks), &subordinate.JWKs); err != nil {
+		return "", nil, fmt.Errorf("invalid jwks stored for subordinate %s: %w", identifier, err)
+	}
+	subordinate.JWKs.Opts.EnforceUniqueKIDs = true
+	if metadataPolicy.Valid {
+		if err := json.Unmarshal([]byte(metadataPolicy.String), &subordinate.Policies); err != nil {
+			return "", nil, fmt.Errorf("invalid metadata policy stored for subordinate %s: %w", identifier, err)
+		}
+	}
+	if metadata.Valid {
+		subordinate.Metadata = &model.Metadata{}
+		if err := json.Unmarshal([]byte(metadata.String), subordinate.Metadata); err != nil {
+			return "", nil, fmt.Errorf("invalid metadata stored for subordinate %s: %w", identifier, err)
+		}
+	}
+	if trustMarks.Valid {
+		if err := json.Unmarshal([]byte(trustMarks.String), &subordinate.TrustMarks); err != nil {
+			return "", nil, fmt.Errorf("invalid trust marks stored for subordinate %s: %w", identifier, err)
+		}
+	}
+	if constraints.Valid {
+		if err := json.Unmarshal([]byte(constraints.String), &subordinate.Constraints); err != nil {
+			return "", nil, fmt.Errorf("invalid constraints stored for subordinate %s: %w", identifier, err)
+		}
+	}
+	if kid.Valid {
+		signerConfiguration, err := s.resolveSigner(ctx, kid.String)
+		if err != nil {
+			return "", nil, err
+		}
+		subordinate.SignerConfiguration = signerConfiguration
+	}
+	return model.EntityIdentifier(identifier), subordinate, nil
+}
+
+func (s *Store) resolveSigner(ctx context.Context, keyID string) (*model.SignerConfiguration, error) {
+	if s.cfg.SignerResolver == nil {
+		return nil, fmt.Errorf("no signer resolver configured for subordinate signer override %q", keyID)
+	}
+	signerConfiguration, err := s.cfg.SignerResolver(ctx, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve subordinate signer override %q: %w", keyID, err)
+	}
+	return signerConfiguration, nil
+}
+
+func (s *Store) GetSubordinate(ctx context.Context, identifier model.EntityIdentifier) (*model.SubordinateConfiguration, error) {
+	_, subordinate, err := s.scanSubordinate(ctx, s.db.QueryRowContext(ctx, s.rebind(querySelectSubordinate), string(identifier)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.NewNotFoundError(fmt.Sprintf("subordinate cfg not found: %s", identifier))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return subordinate, nil
+}
+
+func (s *Store) GetSubordinates(ctx context.Context) (map[model.EntityIdentifier]*model.SubordinateConfiguration, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(querySelectSubordinates))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	subordinates := map[model.EntityIdentifier]*model.SubordinateConfiguration{}
+	for rows.Next() {
+		identifier, subordinate, err := s.scanSubordinate(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+		subordinates[identifier] = subordinate
+	}
+	return subordinates, rows.Err()
+}
+
+func (s *Store) GetSubordinateSigners(ctx context.Context) ([]model.SignerConfiguration, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(querySelectSignerKeyIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var keyIDs []string
+	for rows.Next() {
+		var keyID string
+		if err = rows.Scan(&keyID); err != nil {
+			return nil, err
+		}
+		keyIDs = append(keyIDs, keyID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var signers []model.SignerConfiguration
+	for _, keyID := range keyIDs {
+		signerConfiguration, err := s.resolveSigner(ctx, keyID)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, *signerConfiguration)
+	}
+	return signers, nil
+}
+
+// StreamSubordinates reads subordinates in batches of BatchSize, so only a single batch is held in memory at a time
+func (s *Store) StreamSubordinates(ctx context.Context, cursor *model.EntityIdentifier) iter.Seq2[model.Subordinate, error] {
+	return func(yield func(model.Subordinate, error) bool) {
+		var from string
+		if cursor != nil {
+			from = string(*cursor)
+		}
+		for {
+			batch, next, err := s.subordinateBatch(ctx, from)
+			if err != nil {
+				yield(model.Subordinate{}, err)
+				return
+			}
+			for _, subordinate := range batch {
+				if !yield(subordinate, nil) {
+					return
+				}
+			}
+			if next == nil {
+				return
+			}
+			from = string(*next)
+		}
+	}
+}
+
+// subordinateBatch reads up to BatchSize subordinates starting at the given identifier inclusive, along with the
+// identifier the following batch starts from when further subordinates exist
+func (s *Store) subordinateBatch(ctx context.Context, from string) ([]model.Subordinate, *model.EntityIdentifier, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(querySelectSubordinatesBatch), from, s.cfg.BatchSize+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var batch []model.Subordinate
+	for rows.Next() {
+		identifier, subordinate, err := s.scanSubordinate(ctx, rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		batch = append(batch, model.Subordinate{Identifier: identifier, Configuration: subordinate})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(batch) > s.cfg.BatchSize {
+		return batch[:s.cfg.BatchSize], &batch[s.cfg.BatchSize].Identifier, nil
+	}
+	return batch, nil, nil
+}
+
+func (s *Store) GetSubordinateStatus(ctx context.Context, sub model.EntityIdentifier) (*model.SubordinateStatusResponse, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(querySelectEvents), string(sub))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	response := &model.SubordinateStatusResponse{}
+	for rows.Next() {
+		var (
+			event       model.SubordinateStatusEvent
+			description sql.NullString
+		)
+		if err = rows.Scan(&event.Iat, &event.Event, &description); err != nil {
+			return nil, err
+		}
+		if description.Valid {
+			event.EventDescription = &description.String
+		}
+		response.Events = append(response.Events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(response.Events) == 0 {
+		return nil, model.NewNotFoundError(fmt.Sprintf("unknown entity identifier: %s", sub))
+	}
+	return response, nil
+}
+
+// PutSubordinate registers a subordinate, or replaces the configuration of an existing one, recording the matching
+// registration or metadata update event in the same transaction. A subordinate's signer override is persisted by key
+// ID only and resolved through the configured SignerResolver when read. Returns the configured Validator's error if it
+// rejects the subordinate, in which case nothing is stored
+func (s *Store) PutSubordinate(ctx context.Context, identifier model.EntityIdentifier, subordinate *model.SubordinateConfiguration) error {
+	if subordinate == nil {
+		return fmt.Errorf("subordinate configuration cannot be nil")
+	}
+	if err := validateIdentifier("entity identifier", string(identifier)); err != nil {
+		return err
+	}
+	if s.cfg.Validator != nil {
+		if err := s.cfg.Validator(ctx, identifier, subordinate); err != nil {
+			return err
+		}
+	}
+	jwks, err := json.Marshal(subordinate.JWKs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal subordinate jwks: %w", err)
+	}
+	metadataPolicy, err := marshalNullable(subordinate.Policies, len(subordinate.Policies.FederationMetadata)+len(subordinate.Policies.OpenIDRelyingPartyMetadata)+len(subordinate.Policies.OpenIDConnectOpenIDProviderMetadata) == 0)
+	if err != nil {
+		return fmt.Errorf("failed to marshal subordinate metadata policy: %w", err)
+	}
+	metadata, err := marshalNullable(subordinate.Metadata, subordinate.Metadata == nil)
+	if err != nil {
+		return fmt.Errorf("failed to marshal subordinate metadata: %w", err)
+	}
+	trustMarks, err := marshalNullable(subordinate.TrustMarks, len(subordinate.TrustMarks) == 0)
+	if err != nil {
+		return fmt.Errorf("failed to marshal subordinate trust marks: %w", err)
+	}
+	constraints, err := marshalNullable(subordinate.Constraints, len(subordinate.Constraints) == 0)
+	if err != nil {
+		return fmt.Errorf("failed to marshal subordinate constraints: %w", err)
+	}
+	var keyID sql.NullString
+	if subordinate.SignerConfiguration != nil {
+		kid, err := subordinate.SignerConfiguration.AsRemoteSigner().KeyID(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to determine subordinate signer key ID: %w", err)
+		}
+		if kid == "" {
+			return fmt.Errorf("subordinate signer key ID cannot be empty")
+		}
+		keyID = sql.NullString{String: kid, Valid: true}
+	}
+
+	now := time.Now().UTC().Unix()
+	return s.inTransaction(ctx, func(tx *sql.Tx) error {
+		exists, err := s.exists(ctx, tx, querySubordinateExists, string(identifier))
+		if err != nil {
+			return err
+		}
+		event := model.SubordinateEventRegistration
+		if exists {
+			event = model.SubordinateEventMetadataUpdate
+			_, err = tx.ExecContext(ctx, s.rebind(queryUpdateSubordinate), string(jwks), metadataPolicy, metadata, trustMarks, keyID, constraints, now, string(identifier))
+		} else {
+			_, err = tx.ExecContext(ctx, s.rebind(queryInsertSubordinate), string(identifier), string(jwks), metadataPolicy, metadata, trustMarks, keyID, constraints, now, now)
+		}
+		if err != nil {
+			return err
+		}
+		return s.recordEvent(ctx, tx, identifier, now, event)
+	})
+}
+
+// RemoveSubordinate removes a subordinate, recording a revocation event so its status remains available
+func (s *Store) RemoveSubordinate(ctx context.Context, identifier model.EntityIdentifier) error {
+	return s.inTransaction(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, s.rebind(queryDeleteSubordinate), string(identifier))
+		if err != nil {
+			return err
+		}
+		removed, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			return model.NewNotFoundError(fmt.Sprintf("unknown entity identifier: %s", identifier))
+		}
+		return s.recordEvent(ctx, tx, identifier, time.Now().UTC().Unix(), model.SubordinateEventRevocation)
+	})
+}
+
+func (s *Store) recordEvent(ctx context.Context, tx *sql.Tx, identifier model.EntityIdentifier, iat int64, event string) error {
+	_, err := tx.ExecContext(ctx, s.rebind(queryInsertEvent), string(identifier), iat, event, nil)
+	return err
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *Store) exists(ctx context.Context, db rowQuerier, query string, args ...any) (bool, error) {
+	var count int64
+	if err := db.QueryRowContext(ctx, s.rebind(query), args...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// validateIdentifier rejects values too long to be stored within a key column
+func validateIdentifier(kind, value string) error {
+	if utf8.RuneCountInString(value) > maxIdentifierLength {
+		return fmt.Errorf("%s cannot exceed %d characters", kind, maxIdentifierLength)
+	}
+	return nil
+}
+
+func marshalNullable(value any, empty bool) (sql.NullString, error) {
+	if empty {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// GrantTrustMark entitles an entity to be issued trust marks of the given type
+func (s *Store) GrantTrustMark(ctx context.Context, trustMarkType string, identifier model.EntityIdentifier) error {
+	if err := validateIdentifier("trust mark type", trustMarkType); err != nil {
+		return err
+	}
+	if err := validateIdentifier("entity identifier", string(identifier)); err != nil {
+		return err
+	}
+	return s.inTransaction(ctx, func(tx *sql.Tx) error {
+		exists, err := s.exists(ctx, tx, queryEntitlementExists, trustMarkType, string(identifier))
+		if err != nil || exists {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s.rebind(queryInsertEntitlement), trustMarkType, string(identifier))
+		return err
+	})
+}
+
+// RevokeTrustMark withdraws an entity's entitlement to trust marks of the given type, and revokes every trust mark of
+// that type previously issued to it
+func (s *Store) RevokeTrustMark(ctx context.Context, trustMarkType string, identifier model.EntityIdentifier) error {
+	return s.inTransaction(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, s.rebind(queryDeleteEntitlement), trustMarkType, string(identifier))
+		if err != nil {
+			return err
+		}
+		revoked, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if revoked == 0 {
+			return model.NewNotFoundError(fmt.Sprintf("entity %s holds no trust mark of type %s", identifier, trustMarkType))
+		}
+		_, err = tx.ExecContext(ctx, s.rebind(queryRevokeIssuedTrustMarks), true, trustMarkType, string(identifier))
+		return err
+	})
+}
+
+// IssueTrustMark signs a trust mark for an entitled entity and records it so its status can later be reported. The trust
+// mark is signed before the transaction recording it is opened, so a slow signer never holds a database transaction,
+// and the entitlement is checked again within the transaction in case it was revoked while signing
+func (s *Store) IssueTrustMark(ctx context.Context, trustMarkIdentifier string, entityIdentifier model.EntityIdentifier) (*string, error) {
+	notEntitled := model.NewNotFoundError(fmt.Sprintf("entity %s is not entitled to trust mark type %s", entityIdentifier, trustMarkIdentifier))
+	entitled, err := s.exists(ctx, s.db, queryEntitlementExists, trustMarkIdentifier, string(entityIdentifier))
+	if err != nil {
+		return nil, err
+	}
+	if !entitled {
+		return nil, notEntitled
+	}
+
+	iat := time.Now().UTC()
+	body := map[string]any{
+		"iss":             s.cfg.Issuer,
+		"sub":             entityIdentifier,
+		"trust_mark_type": trustMarkIdentifier,
+		"iat":             iat.Unix(),
+	}
+	var exp sql.NullInt64
+	if s.cfg.TrustMarkLifetime > 0 {
+		exp = sql.NullInt64{Int64: iat.Add(s.cfg.TrustMarkLifetime).Unix(), Valid: true}
+		body["exp"] = exp.Int64
+	}
+	trustMark, err := signing.New(ctx, s.cfg.SignerConfiguration, "trust-mark+jwt", body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.inTransaction(ctx, func(tx *sql.Tx) error {
+		entitled, err := s.exists(ctx, tx, queryEntitlementExists, trustMarkIdentifier, string(entityIdentifier))
+		if err != nil {
+			return err
+		}
+		if !entitled {
+			return notEntitled
+		}
+		_, err = tx.ExecContext(ctx, s.rebind(queryInsertIssuedTrustMark), hash(*trustMark), trustMarkIdentifier, string(entityIdentifier), iat.Unix(), exp, false)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return trustMark, nil
+}
+
+// GetTrustMarkStatus reports the status of a trust mark issued by this store. Trust marks the store has no record of
+// are reported as invalid
+func (s *Store) GetTrustMarkStatus(ctx context.Context, trustMark string) (*string, error) {
+	var (
+		exp     sql.NullInt64
+		revoked bool
+	)
+	err := s.db.QueryRowContext(ctx, s.rebind(querySelectIssuedTrustMark), hash(trustMark)).Scan(&exp, &revoked)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return model.Pointer(TrustMarkStatusInvalid), nil
+	case err != nil:
+		return nil, err
+	case revoked:
+		return model.Pointer(TrustMarkStatusRevoked), nil
+	case exp.Valid && exp.Int64 <= time.Now().UTC().Unix():
+		return model.Pointer(TrustMarkStatusExpired), nil
+	default:
+		return model.Pointer(TrustMarkStatusActive), nil
+	}
+}
+
+func (s *Store) ListTrustMarks(ctx context.Context, trustMarkIdentifier string, identifier *model.EntityIdentifier) ([]model.EntityIdentifier, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(querySelectEntitlements), trustMarkIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	entities := []model.EntityIdentifier{}
+	for rows.Next() {
+		var entity string
+		if err = rows.Scan(&entity); err != nil {
+			return nil, err
+		}
+		if identifier != nil && model.EntityIdentifier(entity) != *identifier {
+			continue
+		}
+		entities = append(entities, model.EntityIdentifier(entity))
+	}
+	return entities, rows.Err()
+}
+
+func hash(trustMark string) string {
+	digest := sha256.Sum256([]byte(trustMark))
+	return hex.EncodeToString(digest[:])
+}
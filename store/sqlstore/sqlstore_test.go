@@ -0,0 +1,613 @@
+package sqlstore
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	josemodel "github.com/MichaelFraser99/go-jose/model"
+	"github.com/MichaelFraser99/go-openid-federation/model"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeDriver is an in-memory database/sql driver understanding exactly the statements issued by the store. Each
+// connection opened against a DSN shares that DSN's state, and transactions snapshot the state so it can be restored on
+// rollback
+type fakeDriver struct {
+	mu        sync.Mutex
+	databases map[string]*fakeDatabase
+}
+
+type fakeDatabase struct {
+	mu           sync.Mutex
+	failOn       string
+	migrations   map[int64]int64
+	subordinates map[string][]driver.Value
+	events       map[string][][]driver.Value
+	entitlements map[[2]string]bool
+	issued       map[string][]driver.Value
+	snapshot     *fakeDatabase
+}
+
+func (d *fakeDatabase) clone() *fakeDatabase {
+	clone := &fakeDatabase{
+		migrations:   maps.Clone(d.migrations),
+		subordinates: maps.Clone(d.subordinates),
+		events:       map[string][][]driver.Value{},
+		entitlements: maps.Clone(d.entitlements),
+		issued:       map[string][]driver.Value{},
+	}
+	for k, v := range d.events {
+		clone.events[k] = slices.Clone(v)
+	}
+	for k, v := range d.issued {
+		clone.issued[k] = slices.Clone(v)
+	}
+	return clone
+}
+
+var (
+	testDriver  = &fakeDriver{databases: map[string]*fakeDatabase{}}
+	testDSNSeed atomic.Int64
+)
+
+func init() {
+	sql.Register("sqlstore-fake", testDriver)
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	database, ok := d.databases[name]
+	if !ok {
+		database = &fakeDatabase{
+			migrations:   map[int64]int64{},
+			subordinates: map[string][]driver.Value{},
+			events:       map[string][][]driver.Value{},
+			entitlements: map[[2]string]bool{},
+			issued:       map[string][]driver.Value{},
+		}
+		d.databases[name] = database
+	}
+	return &fakeConn{database: database}, nil
+}
+
+type fakeConn struct {
+	database *fakeDatabase
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.database.mu.Lock()
+	defer c.database.mu.Unlock()
+	c.database.snapshot = c.database.clone()
+	return &fakeTx{database: c.database}, nil
+}
+
+type fakeTx struct {
+	database *fakeDatabase
+}
+
+func (t *fakeTx) Commit() error {
+	t.database.mu.Lock()
+	defer t.database.mu.Unlock()
+	t.database.snapshot = nil
+	return nil
+}
+
+func (t *fakeTx) Rollback() error {
+	t.database.mu.Lock()
+	defer t.database.mu.Unlock()
+	snapshot := t.database.snapshot
+	t.database.migrations = snapshot.migrations
+	t.database.subordinates = snapshot.subordinates
+	t.database.events = snapshot.events
+	t.database.entitlements = snapshot.entitlements
+	t.database.issued = snapshot.issued
+	t.database.snapshot = nil
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	d := s.conn.database
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failOn == s.query {
+		return nil, fmt.Errorf("injected failure")
+	}
+
+	var affected int64
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE"), strings.HasPrefix(s.query, "CREATE INDEX"), strings.HasPrefix(s.query, "ALTER TABLE"):
+	case s.query == queryRecordMigration:
+		d.migrations[args[0].(int64)] = args[1].(int64)
+	case s.query == queryInsertSubordinate:
+		d.subordinates[args[0].(string)] = slices.Clone(args)
+	case s.query == queryUpdateSubordinate:
+		row := d.subordinates[args[7].(string)]
+		d.subordinates[args[7].(string)] = []driver.Value{row[0], args[0], args[1], args[2], args[3], args[4], args[5], row[7], args[6]}
+	case s.query == queryDeleteSubordinate:
+		if _, ok := d.subordinates[args[0].(string)]; ok {
+			delete(d.subordinates, args[0].(string))
+			affected = 1
+		}
+	case s.query == queryInsertEvent:
+		d.events[args[0].(string)] = append(d.events[args[0].(string)], []driver.Value{args[1], args[2], args[3]})
+	case s.query == queryInsertEntitlement:
+		d.entitlements[[2]string{args[0].(string), args[1].(string)}] = true
+	case s.query == queryDeleteEntitlement:
+		key := [2]string{args[0].(string), args[1].(string)}
+		if d.entitlements[key] {
+			delete(d.entitlements, key)
+			affected = 1
+		}
+	case s.query == queryInsertIssuedTrustMark:
+		d.issued[args[0].(string)] = slices.Clone(args)
+	case s.query == queryRevokeIssuedTrustMarks:
+		for _, row := range d.issued {
+			if row[1] == args[1] && row[2] == args[2] {
+				row[5] = args[0]
+				affected++
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected statement: %s", s.query)
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	d := s.conn.database
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failOn == s.query {
+		return nil, fmt.Errorf("injected failure")
+	}
+
+	subordinateRows := func(from string, limit int64) [][]driver.Value {
+		var rows [][]driver.Value
+		for _, identifier := range slices.Sorted(maps.Keys(d.subordinates)) {
+			if identifier < from || (limit > 0 && int64(len(rows)) == limit) {
+				continue
+			}
+			rows = append(rows, d.subordinates[identifier][:7])
+		}
+		return rows
+	}
+	count := func(exists bool) [][]driver.Value {
+		if exists {
+			return [][]driver.Value{{int64(1)}}
+		}
+		return [][]driver.Value{{int64(0)}}
+	}
+
+	var rows [][]driver.Value
+	switch s.query {
+	case querySchemaVersion:
+		var version int64
+		for v := range d.migrations {
+			version = max(version, v)
+		}
+		rows = [][]driver.Value{{version}}
+	case querySelectSubordinate:
+		if row, ok := d.subordinates[args[0].(string)]; ok {
+			rows = [][]driver.Value{row[:7]}
+		}
+	case querySelectSubordinates:
+		rows = subordinateRows("", 0)
+	case querySelectSubordinatesBatch:
+		rows = subordinateRows(args[0].(string), args[1].(int64))
+	case querySelectSignerKeyIDs:
+		for _, row := range subordinateRows("", 0) {
+			if row[5] != nil {
+				rows = append(rows, []driver.Value{row[5]})
+			}
+		}
+	case querySubordinateExists:
+		_, ok := d.subordinates[args[0].(string)]
+		rows = count(ok)
+	case querySelectEvents:
+		rows = d.events[args[0].(string)]
+	case queryEntitlementExists:
+		rows = count(d.entitlements[[2]string{args[0].(string), args[1].(string)}])
+	case querySelectEntitlements:
+		for key := range d.entitlements {
+			if key[0] == args[0] {
+				rows = append(rows, []driver.Value{key[1]})
+			}
+		}
+		slices.SortFunc(rows, func(a, b []driver.Value) int { return strings.Compare(a[0].(string), b[0].(string)) })
+	case querySelectIssuedTrustMark:
+		if row, ok := d.issued[args[0].(string)]; ok {
+			rows = [][]driver.Value{{row[4], row[5]}}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected query: %s", s.query)
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return make([]string, 7)
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func newTestStore(t *testing.T, configuration Configuration) (*Store, *fakeDatabase) {
+	t.Helper()
+	dsn := fmt.Sprintf("test-%d", testDSNSeed.Add(1))
+	db, err := sql.Open("sqlstore-fake", dsn)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	store := New(db, configuration)
+	if err = store.Migrate(t.Context()); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+
+	testDriver.mu.Lock()
+	defer testDriver.mu.Unlock()
+	return store, testDriver.databases[dsn]
+}
+
+func testSigner(t *testing.T) model.SignerConfiguration {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	return model.SignerConfiguration{Signer: key, KeyID: "signing-key", Algorithm: "ES256"}
+}
+
+func testSubordinate(t *testing.T, kid string) *model.SubordinateConfiguration {
+	t.Helper()
+	essential, err := model.NewEssential(true)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	return &model.SubordinateConfiguration{
+		JWKs: josemodel.Jwks{Keys: []map[string]any{{"kty": "EC", "kid": kid}}},
+		Policies: model.MetadataPolicy{
+			OpenIDRelyingPartyMetadata: map[string]model.PolicyOperators{
+				"grant_types": {Metadata: []model.MetadataPolicyOperator{*essential}},
+			},
+		},
+		Metadata: &model.Metadata{
+			OpenIDRelyingPartyMetadata: &model.OpenIDRelyingPartyMetadata{"client_name": kid, "redirect_uris": []any{"https://rp.example.com/callback"}, "client_registration_types": []any{"automatic"}},
+		},
+	}
+}
+
+func TestStore_Migrate(t *testing.T) {
+	store, database := newTestStore(t, Configuration{})
+	if len(database.migrations) != len(migrations) {
+		t.Fatalf("expected %d applied migrations, got %d", len(migrations), len(database.migrations))
+	}
+
+	if err := store.Migrate(t.Context()); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if len(database.migrations) != len(migrations) {
+		t.Errorf("expected migrations to be applied once, got %d", len(database.migrations))
+	}
+
+	database.migrations[int64(len(migrations)+1)] = 0
+	if err := store.Migrate(t.Context()); err == nil {
+		t.Error("expected an error migrating a newer schema")
+	}
+}
+
+func TestStore_Subordinates(t *testing.T) {
+	ctx := t.Context()
+	signer := testSigner(t)
+	store, _ := newTestStore(t, Configuration{
+		SignerResolver: func(ctx context.Context, keyID string) (*model.SignerConfiguration, error) {
+			if keyID != signer.KeyID {
+				return nil, fmt.Errorf("unknown key %s", keyID)
+			}
+			return &signer, nil
+		},
+	})
+
+	if _, err := store.GetSubordinate(ctx, "https://a-federation.com"); !errors.Is(err, model.ErrNotFound) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	subordinate := testSubordinate(t, "a-key")
+	subordinate.SignerConfiguration = &signer
+	subordinate.Constraints = map[string]any{"max_path_length": float64(1)}
+	if err := store.PutSubordinate(ctx, "https://a-federation.com", subordinate); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if err := store.PutSubordinate(ctx, "https://b-federation.com", testSubordinate(t, "b-key")); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+
+	retrieved, err := store.GetSubordinate(ctx, "https://a-federation.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if diff := cmp.Diff(subordinate.JWKs.Keys, retrieved.JWKs.Keys); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+	if !retrieved.JWKs.Opts.EnforceUniqueKIDs {
+		t.Error("expected unique key IDs to be enforced")
+	}
+	if operators := retrieved.Policies.OpenIDRelyingPartyMetadata["grant_types"].Metadata; len(operators) != 1 || operators[0].OperatorValue() != true {
+		t.Errorf("expected the stored metadata policy, got %v", retrieved.Policies)
+	}
+	if name := (*retrieved.Metadata.OpenIDRelyingPartyMetadata)["client_name"]; name != "a-key" {
+		t.Errorf("expected the stored metadata, got %v", name)
+	}
+	if diff := cmp.Diff(subordinate.Constraints, retrieved.Constraints); diff != "" {
+		t.Errorf("constraints mismatch (-expected +got):\n%s", diff)
+	}
+	if retrieved.SignerConfiguration == nil || retrieved.SignerConfiguration.KeyID != signer.KeyID {
+		t.Errorf("expected the signer override to be resolved, got %v", retrieved.SignerConfiguration)
+	}
+
+	subordinates, err := store.GetSubordinates(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if diff := cmp.Diff([]model.EntityIdentifier{"https://a-federation.com", "https://b-federation.com"}, slices.Sorted(maps.Keys(subordinates))); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+
+	signers, err := store.GetSubordinateSigners(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if len(signers) != 1 || signers[0].KeyID != signer.KeyID {
+		t.Errorf("expected a single subordinate signer, got %v", signers)
+	}
+
+	if err = store.PutSubordinate(ctx, "https://b-federation.com", testSubordinate(t, "b-key-2")); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if err = store.RemoveSubordinate(ctx, "https://b-federation.com"); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if err = store.RemoveSubordinate(ctx, "https://b-federation.com"); !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	status, err := store.GetSubordinateStatus(ctx, "https://b-federation.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	var events []string
+	for _, event := range status.Events {
+		events = append(events, event.Event)
+	}
+	if diff := cmp.Diff([]string{model.SubordinateEventRegistration, model.SubordinateEventMetadataUpdate, model.SubordinateEventRevocation}, events); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+	if _, err = store.GetSubordinateStatus(ctx, "https://c-federation.com"); !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestStore_PutSubordinate_RollsBack(t *testing.T) {
+	store, database := newTestStore(t, Configuration{})
+	database.failOn = queryInsertEvent
+
+	if err := store.PutSubordinate(t.Context(), "https://a-federation.com", testSubordinate(t, "a-key")); err == nil {
+		t.Fatal("expected an error")
+	}
+	database.failOn = ""
+	if _, err := store.GetSubordinate(t.Context(), "https://a-federation.com"); !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("expected the subordinate not to be stored, got %v", err)
+	}
+}
+
+func TestStore_PutSubordinate_Validator(t *testing.T) {
+	intermediate := &model.IntermediateConfiguration{}
+	intermediate.SetSubordinateValidator(func(ctx context.Context, identifier model.EntityIdentifier, subordinate *model.SubordinateConfiguration) error {
+		if identifier == "https://b-federation.com" {
+			return errors.New("conflicting policy")
+		}
+		return nil
+	})
+	store, _ := newTestStore(t, Configuration{Validator: intermediate.ValidateSubordinate})
+
+	if err := store.PutSubordinate(t.Context(), "https://a-federation.com", testSubordinate(t, "a-key")); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if err := store.PutSubordinate(t.Context(), "https://b-federation.com", testSubordinate(t, "b-key")); err == nil || !strings.Contains(err.Error(), "conflicting policy") {
+		t.Fatalf("expected the validator's error, got %v", err)
+	}
+	if _, err := store.GetSubordinateStatus(t.Context(), "https://b-federation.com"); !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("expected nothing to be recorded for the rejected subordinate, got %v", err)
+	}
+}
+
+func TestStore_StreamSubordinates(t *testing.T) {
+	store, _ := newTestStore(t, Configuration{BatchSize: 2})
+	for _, identifier := range []model.EntityIdentifier{
+		"https://e-federation.com",
+		"https://c-federation.com",
+		"https://a-federation.com",
+		"https://d-federation.com",
+		"https://b-federation.com",
+	} {
+		if err := store.PutSubordinate(t.Context(), identifier, testSubordinate(t, string(identifier))); err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+	}
+
+	tests := map[string]struct {
+		cursor   *model.EntityIdentifier
+		expected []model.EntityIdentifier
+	}{
+		"every subordinate is streamed across batches": {
+			expected: []model.EntityIdentifier{"https://a-federation.com", "https://b-federation.com", "https://c-federation.com", "https://d-federation.com", "https://e-federation.com"},
+		},
+		"streaming resumes from the cursor": {
+			cursor:   model.Pointer(model.EntityIdentifier("https://c-federation.com")),
+			expected: []model.EntityIdentifier{"https://c-federation.com", "https://d-federation.com", "https://e-federation.com"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var identifiers []model.EntityIdentifier
+			for subordinate, err := range store.StreamSubordinates(t.Context(), tt.cursor) {
+				if err != nil {
+					t.Fatalf("expected no error, got %q", err.Error())
+				}
+				identifiers = append(identifiers, subordinate.Identifier)
+			}
+			if diff := cmp.Diff(tt.expected, identifiers); diff != "" {
+				t.Errorf("mismatch (-expected +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStore_TrustMarks(t *testing.T) {
+	ctx := t.Context()
+	store, database := newTestStore(t, Configuration{
+		Issuer:              "https://trust-mark-issuer.com",
+		SignerConfiguration: testSigner(t),
+		TrustMarkLifetime:   time.Hour,
+	})
+
+	if _, err := store.IssueTrustMark(ctx, "https://trust-mark.com", "https://a-federation.com"); !errors.Is(err, model.ErrNotFound) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	for _, identifier := range []model.EntityIdentifier{"https://b-federation.com", "https://a-federation.com"} {
+		if err := store.GrantTrustMark(ctx, "https://trust-mark.com", identifier); err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+	}
+	if err := store.GrantTrustMark(ctx, "https://trust-mark.com", "https://a-federation.com"); err != nil {
+		t.Fatalf("expected granting twice to succeed, got %q", err.Error())
+	}
+
+	listed, err := store.ListTrustMarks(ctx, "https://trust-mark.com", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if diff := cmp.Diff([]model.EntityIdentifier{"https://a-federation.com", "https://b-federation.com"}, listed); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+	listed, err = store.ListTrustMarks(ctx, "https://trust-mark.com", model.Pointer(model.EntityIdentifier("https://b-federation.com")))
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if diff := cmp.Diff([]model.EntityIdentifier{"https://b-federation.com"}, listed); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+
+	trustMark, err := store.IssueTrustMark(ctx, "https://trust-mark.com", "https://a-federation.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	status := func(trustMark string) string {
+		t.Helper()
+		status, err := store.GetTrustMarkStatus(ctx, trustMark)
+		if err != nil {
+			t.Fatalf("expected no error, got %q", err.Error())
+		}
+		return *status
+	}
+	if got := status(*trustMark); got != TrustMarkStatusActive {
+		t.Errorf("expected status %q, got %q", TrustMarkStatusActive, got)
+	}
+	if got := status("not-a-trust-mark"); got != TrustMarkStatusInvalid {
+		t.Errorf("expected status %q, got %q", TrustMarkStatusInvalid, got)
+	}
+
+	expired, err := store.IssueTrustMark(ctx, "https://trust-mark.com", "https://b-federation.com")
+	if err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	database.issued[hash(*expired)][4] = time.Now().Add(-time.Minute).Unix()
+	if got := status(*expired); got != TrustMarkStatusExpired {
+		t.Errorf("expected status %q, got %q", TrustMarkStatusExpired, got)
+	}
+
+	if err = store.RevokeTrustMark(ctx, "https://trust-mark.com", "https://a-federation.com"); err != nil {
+		t.Fatalf("expected no error, got %q", err.Error())
+	}
+	if got := status(*trustMark); got != TrustMarkStatusRevoked {
+		t.Errorf("expected status %q, got %q", TrustMarkStatusRevoked, got)
+	}
+	if _, err = store.IssueTrustMark(ctx, "https://trust-mark.com", "https://a-federation.com"); !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+	if err = store.RevokeTrustMark(ctx, "https://trust-mark.com", "https://a-federation.com"); !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestStore_Rebind(t *testing.T) {
+	tests := map[string]struct {
+		dialect  Dialect
+		expected string
+	}{
+		"question placeholders are left as is": {
+			dialect:  MySQL,
+			expected: queryUpdateSubordinate,
+		},
+		"dollar placeholders are numbered": {
+			dialect:  PostgreSQL,
+			expected: `UPDATE federation_subordinates SET jwks = $1, metadata_policy = $2, metadata = $3, trust_marks = $4, signer_key_id = $5, constraints = $6, updated_at = $7 WHERE entity_id = $8`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := New(nil, Configuration{Dialect: tt.dialect})
+			if got := store.rebind(queryUpdateSubordinate); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}